@@ -0,0 +1,35 @@
+package main
+
+import (
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/handlers"
+	"github.com/yj-matmul/bookings/internal/repository"
+)
+
+// expireOffers hands waitlist offers which ran out unused to the next guests in the background every interval
+func expireOffers(repo repository.DatabaseRepo, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			expireWaitlistOffers(repo)
+		}
+	}()
+}
+
+// expireWaitlistOffers marks the offers whose booking link ran out as expired and offers the rooms again,
+// which also reaches guests whose rooms were freed without anyone notifying the waitlist
+func expireWaitlistOffers(repo repository.DatabaseRepo) {
+	n, err := repo.ExpireWaitlistOffers()
+	if err != nil {
+		app.ErrorLog.Println(err)
+		return
+	}
+
+	if n > 0 {
+		app.InfoLog.Printf("expired %d waitlist offer(s)", n)
+	}
+	handlers.Repo.NotifyWaitlist()
+}
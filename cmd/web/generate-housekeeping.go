@@ -0,0 +1,33 @@
+package main
+
+import (
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/handlers"
+)
+
+// generateHousekeeping creates the cleaning tasks of the day in the background every interval,
+// so housekeepers find them whether or not anyone opened the board or the day sheet
+func generateHousekeeping(interval time.Duration) {
+	go func() {
+		generateTodaysTasks()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			generateTodaysTasks()
+		}
+	}()
+}
+
+// generateTodaysTasks creates today's departure and service cleans which do not exist yet
+func generateTodaysTasks() {
+	now := time.Now()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	err := handlers.Repo.GenerateHousekeepingTasks(day)
+	if err != nil {
+		app.ErrorLog.Println(err)
+	}
+}
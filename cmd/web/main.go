@@ -15,7 +15,10 @@ import (
 	"github.com/yj-matmul/bookings/internal/handlers"
 	"github.com/yj-matmul/bookings/internal/helpers"
 	"github.com/yj-matmul/bookings/internal/models"
+	"github.com/yj-matmul/bookings/internal/payment"
 	"github.com/yj-matmul/bookings/internal/render"
+	"github.com/yj-matmul/bookings/internal/repository/dbrepo"
+	"github.com/yj-matmul/bookings/internal/sms"
 )
 
 const portNumber = ":8080"
@@ -39,10 +42,37 @@ func main() {
 	defer db.SQL.Close()
 	defer logFile.Close()
 	defer close(app.MailChan)
+	defer close(app.SMSChan)
 
 	fmt.Println("Starting mail listener...")
 	listenForMail()
 
+	fmt.Println("Starting sms listener...")
+	listenForSMS()
+
+	fmt.Println("Starting hold sweeper...")
+	sweepHolds(dbrepo.NewPostgresRepo(db.SQL, &app), time.Minute)
+
+	fmt.Println("Starting waitlist offers...")
+	expireOffers(dbrepo.NewPostgresRepo(db.SQL, &app), 10*time.Minute)
+
+	fmt.Println("Starting trash purge...")
+	purgeTrash(dbrepo.NewPostgresRepo(db.SQL, &app), time.Hour)
+
+	fmt.Println("Starting housekeeping tasks...")
+	generateHousekeeping(time.Hour)
+
+	fmt.Println("Starting arrival reminders...")
+	sendReminders(time.Hour)
+
+	fmt.Println("Starting refund retries...")
+	retryRefunds(time.Hour)
+
+	if app.MailDrop != "" {
+		fmt.Println("Starting mail drop reader...")
+		readMailDrop(app.MailDrop, time.Minute)
+	}
+
 	fmt.Println(fmt.Sprintf("Starting application on port %s", portNumber))
 
 	srv := &http.Server{
@@ -59,6 +89,8 @@ func run() (*driver.DB, error) {
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
+	gob.Register(models.Cart{})
+	gob.Register(models.Booking{})
 	gob.Register(map[string]int{})
 
 	// parsing flags
@@ -71,6 +103,17 @@ func run() (*driver.DB, error) {
 	dbPort := flag.String("dbport", "5001", "database port")
 	dbSSL := flag.String("dbssl", "disable", "database ssl settings (disable, prefer, require")
 	logPath := flag.String("logpath", "", "set application log file path")
+	baseURL := flag.String("baseurl", "http://localhost:8080", "public url of the application, used in emails")
+	paymentURL := flag.String("paymenturl", "", "payment gateway url (starts a local fake gateway if empty)")
+	paymentSecret := flag.String("paymentsecret", "fake-secret", "payment gateway webhook secret")
+	holdMinutes := flag.Int("holdminutes", 15, "minutes a room is held while the reservation form is filled in")
+	offerHours := flag.Int("offerhours", 24, "hours a waitlist booking link stays valid")
+	trashDays := flag.Int("trashdays", 30, "days deleted reservations and blocks can be restored from the trash")
+	mailDrop := flag.String("maildrop", "", "maildir or mbox file guest replies are delivered to (empty disables reading them)")
+	smsURL := flag.String("smsurl", "", "sms provider url (text messages are only logged if empty)")
+	smsSecret := flag.String("smssecret", "fake-secret", "sms provider delivery report secret")
+	phoneCountry := flag.String("phonecountry", "1", "calling code of phone numbers entered without one")
+	reminderHours := flag.Int("reminderhours", 48, "hours before arrival guests get a reminder by text message")
 
 	flag.Parse()
 
@@ -81,13 +124,25 @@ func run() (*driver.DB, error) {
 
 	infoLog, logFile = config.CustomLogger(*logPath, logPrefix)
 	app.InfoLog = infoLog
+	app.ErrorLog = log.New(os.Stdout, "[ERROR] ", log.LstdFlags|log.Lshortfile)
 
 	mailChan := make(chan models.MailData)
 	app.MailChan = mailChan
 
+	// a burst of bookings should not wait for the sms provider
+	smsChan := make(chan models.SMSMessage, 100)
+	app.SMSChan = smsChan
+
 	// change this to true when in production
 	app.InProduction = *inProduction
 	app.UseCache = *useCache
+	app.BaseURL = *baseURL
+	app.HoldDuration = time.Duration(*holdMinutes) * time.Minute
+	app.OfferDuration = time.Duration(*offerHours) * time.Hour
+	app.TrashRetention = time.Duration(*trashDays) * 24 * time.Hour
+	app.MailDrop = *mailDrop
+	app.PhoneCountryCode = *phoneCountry
+	app.ReminderLead = time.Duration(*reminderHours) * time.Hour
 
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour // session의 유지 시간
@@ -106,6 +161,26 @@ func run() (*driver.DB, error) {
 	}
 	app.InfoLog.Println("connect to database!")
 
+	// set up payment gateway
+	if *paymentURL == "" {
+		url, err := startFakePaymentServer(*paymentSecret)
+		if err != nil {
+			log.Fatal("cannot start fake payment gateway")
+		}
+		*paymentURL = url
+	}
+	app.Payments = payment.NewFakeGateway(*paymentURL, *paymentSecret)
+	app.InfoLog.Println("payment gateway at", *paymentURL)
+
+	// set up sms provider
+	if *smsURL == "" {
+		app.SMS = sms.NewLogSender(app.InfoLog)
+		app.InfoLog.Println("text messages are logged, not sent")
+	} else {
+		app.SMS = sms.NewFakeProvider(*smsURL, *smsSecret, app.BaseURL+"/sms/reports")
+		app.InfoLog.Println("sms provider at", *smsURL)
+	}
+
 	// create template cache
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
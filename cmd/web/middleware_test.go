@@ -2,8 +2,12 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/yj-matmul/bookings/internal/models"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -29,3 +33,62 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.Handler, but type is %T", v))
 	}
 }
+
+func TestAdmin(t *testing.T) {
+	var myH myHandler
+	h := Admin(&myH)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Sprintf("type is not http.Handler, but type is %T", v))
+	}
+}
+
+var adminAccessTests = []struct {
+	name             string
+	loggedIn         bool
+	accessLevel      int
+	expectedLocation string
+}{
+	{"logged-out", false, 0, "/user/login"},
+	{"logged-out-with-level", false, models.AccessLevelAdmin, "/user/login"},
+	{"staff", true, models.AccessLevelStaff, ""},
+	{"admin", true, models.AccessLevelAdmin, ""},
+	{"housekeeper", true, models.AccessLevelHousekeeper, "/housekeeping"},
+	{"unknown-level", true, 0, "/user/login"},
+}
+
+func TestAdminAccess(t *testing.T) {
+	h := Admin(&myHandler{})
+
+	for _, e := range adminAccessTests {
+		req := httptest.NewRequest("GET", "/admin/dashboard", nil)
+		ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
+		if err != nil {
+			log.Println(err)
+		}
+		req = req.WithContext(ctx)
+		if e.loggedIn {
+			session.Put(ctx, "user_id", 1)
+		}
+		if e.accessLevel > 0 {
+			session.Put(ctx, "access_level", e.accessLevel)
+		}
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if e.expectedLocation == "" {
+			if rr.Code != http.StatusOK {
+				t.Errorf("failed %s: expected to be let through, got %d", e.name, rr.Code)
+			}
+			continue
+		}
+		loc, _ := rr.Result().Location()
+		if rr.Code != http.StatusSeeOther || loc == nil || loc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected a redirect to %s, got %d %v", e.name, e.expectedLocation, rr.Code, loc)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/yj-matmul/bookings/internal/payment"
+)
+
+// startFakePaymentServer runs a local payment gateway stand-in and returns its url
+func startFakePaymentServer(secret string) (string, error) {
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return "", err
+	}
+
+	webhookURL := fmt.Sprintf("http://localhost%s/payments/webhook", portNumber)
+	srv := payment.NewFakeServer(secret, webhookURL)
+
+	go func() {
+		err := http.Serve(listener, srv)
+		if err != nil {
+			infoLog.Println(err)
+		}
+	}()
+
+	return fmt.Sprintf("http://%s", listener.Addr().String()), nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/repository"
+)
+
+// purgeTrash deletes the reservations and blocks kept in the trash longer than the retention in the background every interval
+func purgeTrash(repo repository.DatabaseRepo, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			purgeOldTrash(repo)
+		}
+	}()
+}
+
+// purgeOldTrash deletes for good what went to the trash more than the retention ago
+func purgeOldTrash(repo repository.DatabaseRepo) {
+	n, err := repo.PurgeTrash(time.Now().Add(-app.TrashRetention))
+	if err != nil {
+		app.ErrorLog.Println(err)
+		return
+	}
+
+	if n > 0 {
+		app.InfoLog.Printf("purged %d item(s) from the trash", n)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/handlers"
+	"github.com/yj-matmul/bookings/internal/inbox"
+)
+
+// readMailDrop adds the guest emails delivered to a local maildir or mbox file to the guest inbox in the background every interval
+func readMailDrop(path string, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			readInboundMail(path)
+		}
+	}()
+}
+
+// readInboundMail takes the waiting emails out of the mail drop and files them in the guest inbox
+func readInboundMail(path string) {
+	n := 0
+	err := inbox.Each(path, func(msg inbox.Message) error {
+		err := handlers.Repo.ReceiveInboundMail(msg)
+		if err == nil {
+			n++
+		}
+		return err
+	})
+	if err != nil {
+		app.ErrorLog.Println(err)
+	}
+
+	if n > 0 {
+		app.InfoLog.Printf("read %d email(s) from the mail drop", n)
+	}
+}
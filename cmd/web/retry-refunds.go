@@ -0,0 +1,32 @@
+package main
+
+import (
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/handlers"
+)
+
+// retryRefunds asks the payment gateway again for the refunds it failed to make in the background every interval
+func retryRefunds(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			retryFailedRefunds()
+		}
+	}()
+}
+
+// retryFailedRefunds retries the failed refunds once
+func retryFailedRefunds() {
+	n, err := handlers.Repo.RetryFailedRefunds()
+	if err != nil {
+		app.ErrorLog.Println(err)
+		return
+	}
+
+	if n > 0 {
+		app.InfoLog.Printf("made %d failed refund(s)", n)
+	}
+}
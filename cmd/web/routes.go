@@ -30,13 +30,37 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
 	mux.Get("/book-room", handlers.Repo.BookRoom)
 
+	mux.Get("/waitlist", handlers.Repo.Waitlist)
+	mux.Post("/waitlist", handlers.Repo.PostWaitlist)
+	mux.Get("/waitlist/{token}", handlers.Repo.WaitlistOffer)
+
 	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
 
+	mux.Get("/cart", handlers.Repo.Cart)
+	mux.Post("/cart", handlers.Repo.PostCart)
+	mux.Get("/cart/add/{id}", handlers.Repo.AddToCart)
+	mux.Post("/cart/remove/{index}", handlers.Repo.PostRemoveFromCart)
+	mux.Get("/booking-summary", handlers.Repo.BookingSummary)
+
 	mux.Get("/make-reservation", handlers.Repo.Reservation)
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 
+	mux.Get("/payment", handlers.Repo.Payment)
+	mux.Post("/payment", handlers.Repo.PostPayment)
+	mux.Post("/payments/webhook", handlers.Repo.PaymentWebhook)
+	mux.Post("/sms/reports", handlers.Repo.SMSReport)
+
+	mux.Get("/bookings/{code}", handlers.Repo.Booking)
+	mux.Post("/bookings/{code}/cancel", handlers.Repo.PostCancelBooking)
+	mux.Get("/bookings/{code}/invoices/{id}", handlers.Repo.BookingInvoice)
+
+	mux.Get("/group-bookings/{code}", handlers.Repo.GroupBooking)
+	mux.Post("/group-bookings/{code}/cancel", handlers.Repo.PostCancelGroupBooking)
+	mux.Post("/group-bookings/{code}/cancel/{id}", handlers.Repo.PostCancelGroupBooking)
+
 	mux.Get("/contact", handlers.Repo.Contact)
+	mux.Post("/contact", handlers.Repo.PostContact)
 
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
@@ -45,20 +69,79 @@ func routes(app *config.AppConfig) http.Handler {
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
+	mux.Route("/housekeeping", func(mux chi.Router) {
+		mux.Use(Auth)
+
+		mux.Get("/", handlers.Repo.Housekeeping)
+		mux.Post("/rooms/{id}", handlers.Repo.PostHousekeepingRoom)
+	})
+
 	mux.Route("/admin", func(mux chi.Router) {
-		// mux.Use(Auth)
+		mux.Use(Auth)
+		mux.Use(Admin)
 
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+		mux.Get("/today", handlers.Repo.AdminToday)
+		mux.Post("/today/check-in/{id}", handlers.Repo.AdminPostCheckIn)
+		mux.Post("/today/check-out/{id}", handlers.Repo.AdminPostCheckOut)
 
 		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
 		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
 		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+		mux.Post("/reservations-bulk", handlers.Repo.AdminPostBulkReservations)
+		mux.Get("/bulk-actions", handlers.Repo.AdminBulkActions)
+		mux.Get("/bulk-actions/{id}", handlers.Repo.AdminBulkAction)
+		mux.Get("/trash", handlers.Repo.AdminTrash)
+		mux.Post("/trash/reservations/{id}/restore", handlers.Repo.AdminPostRestoreReservation)
+		mux.Post("/trash/blocks/{id}/restore", handlers.Repo.AdminPostRestoreBlock)
+		mux.Get("/timeline", handlers.Repo.AdminTimeline)
+		mux.Get("/api/timeline", handlers.Repo.AdminTimelineJSON)
+		mux.Post("/api/reservations/{id}/move", handlers.Repo.AdminPostTimelineMove)
+		mux.Post("/api/blocks", handlers.Repo.AdminPostTimelineBlock)
 		mux.Get("/process-reservation/{src}/{id}/do", handlers.Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/{id}/do", handlers.Repo.AdminDeleteReservation)
 
 		mux.Get("/reservations/{src}/{id}/show", handlers.Repo.AdminShowReservation)
 		mux.Post("/reservations/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		mux.Post("/reservations/{src}/{id}/delete", handlers.Repo.AdminPostDeleteReservation)
+		mux.Get("/reservations/{src}/{id}/cancel", handlers.Repo.AdminCancelReservation)
+		mux.Post("/reservations/{src}/{id}/cancel", handlers.Repo.AdminPostCancelReservation)
+		mux.Post("/reservations/{src}/{id}/invoice", handlers.Repo.AdminPostIssueInvoice)
+		mux.Post("/reservations/{src}/{id}/notes", handlers.Repo.AdminPostReservationNote)
+
+		mux.Get("/invoices/{id}", handlers.Repo.AdminInvoice)
+		mux.Post("/invoices/{id}/credit-note", handlers.Repo.AdminPostCreditNote)
+
+		mux.Get("/out-of-order", handlers.Repo.AdminOutOfOrder)
+		mux.Get("/out-of-order/{id}", handlers.Repo.AdminOutOfOrderPeriod)
+		mux.Post("/out-of-order/{id}", handlers.Repo.AdminPostOutOfOrderPeriod)
+		mux.Post("/out-of-order/{id}/delete", handlers.Repo.AdminPostDeleteOutOfOrderPeriod)
+
+		mux.Get("/import", handlers.Repo.AdminImport)
+		mux.Post("/import", handlers.Repo.AdminPostImport)
+
+		mux.Get("/export/reservations/{format}", handlers.Repo.AdminExportReservations)
+		mux.Get("/export/guests/{format}", handlers.Repo.AdminExportGuests)
+		mux.Get("/export/movements/{format}", handlers.Repo.AdminExportMovements)
+
+		mux.Get("/guests", handlers.Repo.AdminGuests)
+		mux.Get("/guests/{id}", handlers.Repo.AdminGuest)
+		mux.Post("/guests/{id}", handlers.Repo.AdminPostGuest)
+
+		mux.Get("/inbox", handlers.Repo.AdminInbox)
+		mux.Get("/inbox/{id}", handlers.Repo.AdminConversation)
+		mux.Post("/inbox/{id}/reply", handlers.Repo.AdminPostConversationReply)
+
+		mux.Get("/messages/new", handlers.Repo.AdminComposeMessage)
+		mux.Post("/messages/new", handlers.Repo.AdminPostComposeMessage)
+		mux.Get("/message-templates", handlers.Repo.AdminMessageTemplates)
+		mux.Get("/message-templates/{id}", handlers.Repo.AdminMessageTemplate)
+		mux.Post("/message-templates/{id}", handlers.Repo.AdminPostMessageTemplate)
+		mux.Post("/message-templates/{id}/delete", handlers.Repo.AdminPostDeleteMessageTemplate)
+		mux.Get("/tax-fees", handlers.Repo.AdminTaxFees)
+		mux.Get("/tax-fees/{id}", handlers.Repo.AdminTaxFee)
+		mux.Post("/tax-fees/{id}", handlers.Repo.AdminPostTaxFee)
+		mux.Post("/tax-fees/{id}/delete", handlers.Repo.AdminPostDeleteTaxFee)
 	})
 
 	return mux
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/handlers"
+)
+
+// sendReminders texts the guests who arrive soon a reminder in the background every interval
+func sendReminders(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sendArrivalReminders()
+		}
+	}()
+}
+
+// sendArrivalReminders sends the reminders which are due
+func sendArrivalReminders() {
+	n, err := handlers.Repo.SendArrivalReminders()
+	if err != nil {
+		app.ErrorLog.Println(err)
+		return
+	}
+
+	if n > 0 {
+		app.InfoLog.Printf("sent %d arrival reminder(s)", n)
+	}
+}
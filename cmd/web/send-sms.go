@@ -0,0 +1,17 @@
+package main
+
+import (
+	"github.com/yj-matmul/bookings/internal/handlers"
+)
+
+// listenForSMS sends the queued text messages in the background, so guests never wait for the provider
+func listenForSMS() {
+	go func() {
+		for msg := range app.SMSChan {
+			err := handlers.Repo.SendSMS(msg)
+			if err != nil {
+				app.ErrorLog.Println(err)
+			}
+		}
+	}()
+}
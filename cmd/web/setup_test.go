@@ -4,9 +4,16 @@ import (
 	"net/http"
 	"os"
 	"testing"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/yj-matmul/bookings/internal/helpers"
 )
 
 func TestMain(m *testing.M) {
+	session = scs.New()
+	app.Session = session
+	helpers.NewHelpers(&app)
+
 	os.Exit(m.Run())
 }
 
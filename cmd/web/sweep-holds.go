@@ -0,0 +1,34 @@
+package main
+
+import (
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/handlers"
+	"github.com/yj-matmul/bookings/internal/repository"
+)
+
+// sweepHolds deletes expired holds in the background every interval
+func sweepHolds(repo repository.DatabaseRepo, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			sweepExpiredHolds(repo)
+		}
+	}()
+}
+
+// sweepExpiredHolds deletes the holds which have expired so far and offers the freed rooms to the waitlist
+func sweepExpiredHolds(repo repository.DatabaseRepo) {
+	n, err := repo.DeleteExpiredHolds()
+	if err != nil {
+		app.ErrorLog.Println(err)
+		return
+	}
+
+	if n > 0 {
+		app.InfoLog.Printf("swept %d expired hold(s)", n)
+		handlers.Repo.NotifyWaitlist()
+	}
+}
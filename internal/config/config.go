@@ -5,20 +5,33 @@ import (
 	"io"
 	"log"
 	"os"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/yj-matmul/bookings/internal/models"
+	"github.com/yj-matmul/bookings/internal/payment"
+	"github.com/yj-matmul/bookings/internal/sms"
 )
 
 // AppConfig holds the application configuration
 type AppConfig struct {
-	UseCache      bool
-	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
-	InProduction  bool
-	Session       *scs.SessionManager
-	MailChan      chan models.MailData
+	UseCache         bool
+	TemplateCache    map[string]*template.Template
+	InfoLog          *log.Logger
+	ErrorLog         *log.Logger
+	InProduction     bool
+	BaseURL          string
+	Session          *scs.SessionManager
+	MailChan         chan models.MailData
+	Payments         payment.PaymentGateway
+	HoldDuration     time.Duration
+	OfferDuration    time.Duration
+	TrashRetention   time.Duration
+	MailDrop         string
+	SMS              sms.SMSSender
+	SMSChan          chan models.SMSMessage
+	PhoneCountryCode string
+	ReminderLead     time.Duration
 }
 
 // CustomLogger wirtes log to txt file and os standard out
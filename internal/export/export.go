@@ -0,0 +1,120 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Money is an amount in cents which spreadsheets show as a decimal number
+type Money int
+
+// Writer writes a table row by row, so large exports never have to be held in memory
+type Writer interface {
+	// Header writes the column names
+	Header(columns ...string) error
+	// Row writes one row; cells are strings, ints, Money or time.Time, which is written as a date
+	Row(cells ...interface{}) error
+	// Close finishes the file
+	Close() error
+}
+
+// formats which can be exported, with their content types
+var contentTypes = map[string]string{
+	"csv":  "text/csv; charset=utf-8",
+	"xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// ContentType returns the content type of a format and whether the format is known
+func ContentType(format string) (string, bool) {
+	ct, ok := contentTypes[format]
+	return ct, ok
+}
+
+// New returns a writer for a format, csv or xlsx, which writes to w
+func New(format string, w io.Writer, sheet string) (Writer, error) {
+	switch format {
+	case "csv":
+		return NewCSV(w), nil
+	case "xlsx":
+		return NewXLSX(w, sheet)
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+// NewCSV returns a writer for comma separated values
+func NewCSV(w io.Writer) Writer {
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) Header(columns ...string) error {
+	return c.w.Write(columns)
+}
+
+func (c *csvWriter) Row(cells ...interface{}) error {
+	record := make([]string, len(cells))
+	for i, cell := range cells {
+		switch v := cell.(type) {
+		case string:
+			if isFormula(v) {
+				// keep spreadsheet programs from running guest input as a formula
+				v = "'" + v
+			}
+			record[i] = v
+		case int:
+			record[i] = fmt.Sprintf("%d", v)
+		case Money:
+			record[i] = formatMoney(v)
+		case time.Time:
+			if !v.IsZero() {
+				record[i] = v.Format("2006-01-02")
+			}
+		default:
+			return fmt.Errorf("cannot export %T", cell)
+		}
+	}
+
+	err := c.w.Write(record)
+	if err != nil {
+		return err
+	}
+
+	// send every row on its way instead of buffering the whole export
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// isFormula reports whether spreadsheet programs would evaluate a value; phone numbers starting with + are fine
+func isFormula(s string) bool {
+	if s == "" {
+		return false
+	}
+	switch s[0] {
+	case '=', '@', '\t', '\r':
+		return true
+	case '+', '-':
+		return strings.TrimLeft(s[1:], "0123456789 ()-") != ""
+	}
+	return false
+}
+
+// formatMoney formats cents as a plain decimal number
+func formatMoney(m Money) string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, m/100, m%100)
+}
@@ -0,0 +1,131 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewCSV(&buf)
+
+	_ = w.Header("Name", "Phone", "Arrival", "Guests", "Total")
+	err := w.Row("=HYPERLINK(\"x\")", "+1 555-0100", time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC), 2, Money(-15005))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = w.Row("Smith, John", "", time.Time{}, 0, Money(7))
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Name,Phone,Arrival,Guests,Total\n" +
+		"\"'=HYPERLINK(\"\"x\"\")\",+1 555-0100,2050-01-02,2,-150.05\n" +
+		"\"Smith, John\",,,0,0.07\n"
+	if buf.String() != expected {
+		t.Errorf("expected\n%s\nbut got\n%s", expected, buf.String())
+	}
+
+	if err := w.Row(1.5); err == nil {
+		t.Error("expected an error for an unsupported cell")
+	}
+}
+
+func TestIsFormula(t *testing.T) {
+	tests := map[string]bool{
+		"=1+1":        true,
+		"@SUM(A1)":    true,
+		"-2+3":        true,
+		"+cmd":        true,
+		"+1 (555) 01": false,
+		"555-0100":    false,
+		"John":        false,
+		"":            false,
+	}
+
+	for s, expected := range tests {
+		if isFormula(s) != expected {
+			t.Errorf("isFormula(%q): expected %v", s, expected)
+		}
+	}
+}
+
+func TestXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := New("xlsx", &buf, "Guests & Stays")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = w.Header("Name", "Arrival", "Guests", "Total")
+	err = w.Row("<Smith>", time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC), 2, Money(15000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := make(map[string]string)
+	for _, f := range z.File {
+		r, _ := f.Open()
+		b, _ := ioutil.ReadAll(r)
+		r.Close()
+		parts[f.Name] = string(b)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/styles.xml", "xl/worksheets/sheet1.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("missing part %s", name)
+		}
+	}
+
+	if !strings.Contains(parts["xl/workbook.xml"], `name="Guests &amp; Stays"`) {
+		t.Error("sheet name was not escaped")
+	}
+
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	for _, expected := range []string{
+		`<c r="A1" s="3" t="inlineStr"><is><t xml:space="preserve">Name</t></is></c>`,
+		`<t xml:space="preserve">&lt;Smith&gt;</t>`,
+		`<c r="B2" s="1"><v>44440</v></c>`,
+		`<c r="C2"><v>2</v></c>`,
+		`<c r="D2" s="2"><v>150.00</v></c>`,
+	} {
+		if !strings.Contains(sheet, expected) {
+			t.Errorf("expected sheet to contain %s", expected)
+		}
+	}
+	if !strings.HasSuffix(sheet, sheetEnd) {
+		t.Error("sheet was not closed")
+	}
+}
+
+func TestCellRef(t *testing.T) {
+	tests := map[int]string{0: "A1", 25: "Z1", 26: "AA1", 27: "AB1", 701: "ZZ1", 702: "AAA1"}
+	for col, expected := range tests {
+		if ref := cellRef(col, 1); ref != expected {
+			t.Errorf("column %d: expected %s but got %s", col, expected, ref)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New("pdf", &bytes.Buffer{}, "x"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if ct, ok := ContentType("csv"); !ok || !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("unexpected content type %s", ct)
+	}
+}
@@ -0,0 +1,200 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// styles of cells, as indexes into cellXfs of styles.xml
+const (
+	styleDefault = 0
+	styleDate    = 1
+	styleMoney   = 2
+	styleHeader  = 3
+)
+
+// excelEpoch is day zero of spreadsheet date serial numbers
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+const contentTypesXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>
+<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>
+</Types>`
+
+const relsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+const workbookXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>
+</workbook>`
+
+const workbookRelsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>
+</Relationships>`
+
+const stylesXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<numFmts count="2"><numFmt numFmtId="164" formatCode="yyyy-mm-dd"/><numFmt numFmtId="165" formatCode="#,##0.00"/></numFmts>
+<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>
+<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>
+<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>
+<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>
+<cellXfs count="4">
+<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>
+<xf numFmtId="164" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="165" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/>
+<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/>
+</cellXfs>
+</styleSheet>`
+
+const sheetStart = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+
+const sheetEnd = `</sheetData></worksheet>`
+
+type xlsxWriter struct {
+	zip   *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+// NewXLSX returns a writer for an Excel workbook with a single sheet; the sheet is written while rows arrive
+func NewXLSX(w io.Writer, sheet string) (Writer, error) {
+	z := zip.NewWriter(w)
+
+	var name bytes.Buffer
+	err := xml.EscapeText(&name, []byte(sheet))
+	if err != nil {
+		return nil, err
+	}
+
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", contentTypesXML},
+		{"_rels/.rels", relsXML},
+		{"xl/workbook.xml", fmt.Sprintf(workbookXML, name.String())},
+		{"xl/_rels/workbook.xml.rels", workbookRelsXML},
+		{"xl/styles.xml", stylesXML},
+	}
+
+	for _, p := range parts {
+		f, err := z.Create(p.name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.WriteString(f, p.content)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the sheet is the last part, so rows can be streamed into it until Close
+	s, err := z.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.WriteString(s, sheetStart)
+	if err != nil {
+		return nil, err
+	}
+
+	return &xlsxWriter{zip: z, sheet: s}, nil
+}
+
+func (x *xlsxWriter) Header(columns ...string) error {
+	var buf bytes.Buffer
+	x.row++
+	fmt.Fprintf(&buf, `<row r="%d">`, x.row)
+	for i, c := range columns {
+		err := inlineString(&buf, cellRef(i, x.row), c, styleHeader)
+		if err != nil {
+			return err
+		}
+	}
+	buf.WriteString(`</row>`)
+
+	_, err := x.sheet.Write(buf.Bytes())
+	return err
+}
+
+func (x *xlsxWriter) Row(cells ...interface{}) error {
+	var buf bytes.Buffer
+	x.row++
+	fmt.Fprintf(&buf, `<row r="%d">`, x.row)
+	for i, cell := range cells {
+		ref := cellRef(i, x.row)
+		switch v := cell.(type) {
+		case string:
+			err := inlineString(&buf, ref, v, styleDefault)
+			if err != nil {
+				return err
+			}
+		case int:
+			fmt.Fprintf(&buf, `<c r="%s"><v>%d</v></c>`, ref, v)
+		case Money:
+			fmt.Fprintf(&buf, `<c r="%s" s="%d"><v>%s</v></c>`, ref, styleMoney, formatMoney(v))
+		case time.Time:
+			if v.IsZero() {
+				continue
+			}
+			day := time.Date(v.Year(), v.Month(), v.Day(), 0, 0, 0, 0, time.UTC)
+			serial := int(day.Sub(excelEpoch).Hours() / 24)
+			fmt.Fprintf(&buf, `<c r="%s" s="%d"><v>%d</v></c>`, ref, styleDate, serial)
+		default:
+			return fmt.Errorf("cannot export %T", cell)
+		}
+	}
+	buf.WriteString(`</row>`)
+
+	_, err := x.sheet.Write(buf.Bytes())
+	return err
+}
+
+func (x *xlsxWriter) Close() error {
+	_, err := io.WriteString(x.sheet, sheetEnd)
+	if err != nil {
+		return err
+	}
+	return x.zip.Close()
+}
+
+// inlineString writes a text cell; inline strings avoid a shared string table, which would have to be written last
+func inlineString(buf *bytes.Buffer, ref, s string, style int) error {
+	if style == styleDefault {
+		fmt.Fprintf(buf, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+	} else {
+		fmt.Fprintf(buf, `<c r="%s" s="%d" t="inlineStr"><is><t xml:space="preserve">`, ref, style)
+	}
+	err := xml.EscapeText(buf, []byte(s))
+	if err != nil {
+		return err
+	}
+	buf.WriteString(`</t></is></c>`)
+	return nil
+}
+
+// cellRef returns the A1 style reference of a zero based column in a row
+func cellRef(col, row int) string {
+	name := ""
+	for col >= 0 {
+		name = string(rune('A'+col%26)) + name
+		col = col/26 - 1
+	}
+	return name + strconv.Itoa(row)
+}
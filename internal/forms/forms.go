@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/asaskevich/govalidator"
+	"github.com/yj-matmul/bookings/internal/sms"
 )
 
 // Form creates a custom form struct, embeds a url.Values object
@@ -63,3 +64,30 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address")
 	}
 }
+
+// IsOneOf checks that a field holds one of the allowed values
+func (f *Form) IsOneOf(field string, allowed ...string) {
+	value := f.Get(field)
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	f.Errors.Add(field, "Invalid choice")
+}
+
+// IsPhone checks that a phone number, if one was given, can be texted and rewrites it in E.164 format.
+// Numbers without an international prefix are taken to be in the country with the calling code countryCode
+func (f *Form) IsPhone(field, countryCode string) {
+	value := strings.TrimSpace(f.Get(field))
+	if value == "" {
+		return
+	}
+
+	phone, err := sms.E164(value, countryCode)
+	if err != nil {
+		f.Errors.Add(field, "Invalid phone number")
+		return
+	}
+	f.Set(field, phone)
+}
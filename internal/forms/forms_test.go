@@ -123,3 +123,48 @@ func TestForm_IsEmail(t *testing.T) {
 		t.Error("form shows invalid email when field has valid email")
 	}
 }
+
+func TestForm_IsOneOf(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("kind", "tax")
+	form := New(postedData)
+
+	form.IsOneOf("kind", "tax", "fee")
+	if !form.Valid() {
+		t.Error("form shows invalid choice for allowed value")
+	}
+
+	form.IsOneOf("kind", "fee")
+	if form.Valid() {
+		t.Error("form shows valid choice for value which is not allowed")
+	}
+
+	if form.Errors.Get("kind") == "" {
+		t.Error("should have an error, but did not get one")
+	}
+}
+
+func TestForm_IsPhone(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("phone", "(555) 555-0111")
+	postedData.Add("blank", "")
+	form := New(postedData)
+
+	form.IsPhone("phone", "1")
+	form.IsPhone("blank", "1")
+	if !form.Valid() {
+		t.Error("form shows invalid phone number when field has valid one")
+	}
+	if form.Get("phone") != "+15555550111" {
+		t.Errorf("expected the phone number in E.164 format, got %s", form.Get("phone"))
+	}
+
+	postedData = url.Values{}
+	postedData.Add("phone", "555-0111")
+	form = New(postedData)
+
+	form.IsPhone("phone", "")
+	if form.Valid() {
+		t.Error("form shows valid phone number for a number without its country")
+	}
+}
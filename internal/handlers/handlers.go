@@ -1,22 +1,36 @@
 package handlers
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/yj-matmul/bookings/internal/config"
 	"github.com/yj-matmul/bookings/internal/driver"
+	"github.com/yj-matmul/bookings/internal/export"
 	"github.com/yj-matmul/bookings/internal/forms"
 	"github.com/yj-matmul/bookings/internal/helpers"
+	"github.com/yj-matmul/bookings/internal/inbox"
+	"github.com/yj-matmul/bookings/internal/invoice"
+	"github.com/yj-matmul/bookings/internal/messages"
 	"github.com/yj-matmul/bookings/internal/models"
+	"github.com/yj-matmul/bookings/internal/payment"
+	"github.com/yj-matmul/bookings/internal/pricing"
 	"github.com/yj-matmul/bookings/internal/render"
+	"github.com/yj-matmul/bookings/internal/reports"
 	"github.com/yj-matmul/bookings/internal/repository"
 	"github.com/yj-matmul/bookings/internal/repository/dbrepo"
+	"github.com/yj-matmul/bookings/internal/sms"
 )
 
 // Repository is the repository type
@@ -80,6 +94,17 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	res.Room.RoomName = room.RoomName
 
+	rules, err := m.DB.AllTaxFeeRules()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get taxes and fees!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if res.Guests < 1 {
+		res.Guests = 1
+	}
+
 	sd := res.StartDate.Format("2006-01-02")
 	ed := res.EndDate.Format("2006-01-02")
 
@@ -89,6 +114,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["quote"] = pricing.NewQuote(room, res.StartDate, res.EndDate, res.Guests, rules)
 
 	m.App.Session.Put(r.Context(), "reservation", res)
 
@@ -99,6 +125,15 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// checkReservationForm applies the rules every new reservation has to pass to the guest's details; the phone
+// number is rewritten in E.164 format so text messages can reach it
+func checkReservationForm(form *forms.Form, countryCode string) {
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+	form.IsPhone("phone", countryCode)
+}
+
 // PostReservation handles the posting of a reservation form
 func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	m.App.InfoLog.Print("PostReservation")
@@ -145,26 +180,54 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reservation := models.Reservation{
-		FirstName: r.Form.Get("first_name"),
-		LastName:  r.Form.Get("last_name"),
-		Email:     r.Form.Get("email"),
-		Phone:     r.Form.Get("phone"),
-		StartDate: startDate,
-		EndDate:   endDate,
-		RoomID:    roomID,
-		Room:      room,
+	rules, err := m.DB.AllTaxFeeRules()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get taxes and fees!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
 	}
 
 	form := forms.New(r.PostForm)
 
-	form.Required("first_name", "last_name", "email")
-	form.MinLength("first_name", 3)
-	form.IsEmail("email")
+	guests := 1
+	if form.Has("guests") {
+		guests, err = strconv.Atoi(r.Form.Get("guests"))
+		if err != nil || guests < 1 {
+			form.Errors.Add("guests", "There has to be at least one guest")
+			guests = 1
+		}
+	}
+
+	quote := pricing.NewQuote(room, startDate, endDate, guests, rules)
+
+	reservation := models.Reservation{
+		FirstName:   r.Form.Get("first_name"),
+		LastName:    r.Form.Get("last_name"),
+		Email:       r.Form.Get("email"),
+		Phone:       r.Form.Get("phone"),
+		StartDate:   startDate,
+		EndDate:     endDate,
+		RoomID:      roomID,
+		Room:        room,
+		Status:      models.ReservationStatusConfirmed,
+		TotalAmount: quote.Total,
+		AccessCode:  helpers.NewAccessCode(),
+		Guests:      guests,
+		Charges:     quote.Charges,
+	}
+
+	// the room stays pending until the guest has paid
+	if pricing.RequiresPayment(room) {
+		reservation.Status = models.ReservationStatusPendingPayment
+	}
+
+	checkReservationForm(form, m.App.PhoneCountryCode)
+	reservation.Phone = form.Get("phone")
 
 	if !form.Valid() {
 		data := make(map[string]interface{})
 		data["reservation"] = reservation
+		data["quote"] = quote
 		render.Template(w, r, "make-reservation.page.html", &models.TemplateData{
 			Form:      form,
 			Data:      data,
@@ -179,42 +242,105 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
-
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
+	reservation.ID = newReservationID
+
+	// the hold placed when the room was chosen becomes the reservation's restriction
+	err = repository.ErrHoldExpired
+	if holdID := m.App.Session.PopInt(r.Context(), "hold_id"); holdID > 0 {
+		err = m.DB.ConvertHold(holdID, reservation)
+		if err == repository.ErrHoldExpired {
+			// the hold expired or was for another room or other dates, e.g. chosen in a second tab
+			derr := m.DB.DeleteHoldByID(holdID)
+			if derr != nil {
+				m.App.ErrorLog.Println(derr)
+			}
+		}
 	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	if err == repository.ErrHoldExpired {
+		restriction := models.RoomRestriction{
+			StartDate:     reservation.StartDate,
+			EndDate:       reservation.EndDate,
+			RoomID:        reservation.RoomID,
+			ReservationID: newReservationID,
+			RestrictionID: models.RestrictionReservation,
+		}
+
+		// the room was only held for a while; check it is still free before it becomes the reservation's
+		err = m.DB.InsertRoomRestriction(restriction)
+	}
+	if err == repository.ErrRoomNotAvailable {
+		err = m.DB.DiscardReservation(newReservationID)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room was taken while you filled in the form, please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't insert room restriction into database!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
+	if waitlistID := m.App.Session.PopInt(r.Context(), "waitlist_id"); waitlistID > 0 {
+		err = m.DB.UpdateWaitlistEntryStatus(waitlistID, models.WaitlistStatusBooked)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
+
+	m.App.Session.Remove(r.Context(), "booking")
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+
+	if reservation.Status == models.ReservationStatusPendingPayment {
+		http.Redirect(w, r, "/payment", http.StatusSeeOther)
+		return
+	}
+
+	m.sendReservationMails(reservation)
+
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
+
+// sendReservationMails issues the invoice and sends the confirmation to the guest and the notification to the owner
+func (m *Repository) sendReservationMails(reservation models.Reservation) {
+	// the confirmation still goes out when the invoice can't be issued; it can be issued from the admin tool later
+	var attachments []models.MailAttachment
+	inv, err := m.issueInvoice(reservation)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	} else {
+		attachments = append(attachments, invoiceAttachment(inv, reservation))
+	}
+
 	// send mail notification - first to guest
 	htmlMessage := fmt.Sprintf(`
 		<strong>Reservation Confirmation</strong><br>
 		Dear: %s <br>
-		This is confirm your reservation from %s to %s.`,
-		reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
+		This is confirm your reservation from %s to %s.<br>
+		%s
+		You can view or cancel your booking at <a href="%s/bookings/%s">%s/bookings/%s</a>.`,
+		reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"),
+		priceBreakdown(reservation),
+		m.App.BaseURL, reservation.AccessCode, m.App.BaseURL, reservation.AccessCode)
 
 	msg := models.MailData{
-		To:       reservation.Email,
-		From:     "me@here.com",
-		Subject:  "Reservation Confirmation",
-		Content:  htmlMessage,
-		Template: "basic.html",
+		To:          reservation.Email,
+		From:        "me@here.com",
+		Subject:     "Reservation Confirmation",
+		Content:     htmlMessage,
+		Template:    "basic.html",
+		Attachments: attachments,
 	}
 	m.App.MailChan <- msg
 
 	// send mail notification to property owner
 	htmlMessage = fmt.Sprintf(`
 		<strong>Reservation Notification</strong><br>
-		A reservation has been made for %s from %s to %s.`,
+		%sA reservation has been made for %s from %s to %s.`,
+		m.guestWarning(reservation.Email),
 		reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"))
 
 	msg = models.MailData{
@@ -225,371 +351,4938 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	}
 	m.App.MailChan <- msg
 
-	m.App.Session.Put(r.Context(), "reservation", reservation)
-
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	m.textConfirmation(reservation)
 }
 
-// ReservationSummary displays the reservation summary page
-func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("ReservationSummary")
-	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
-		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+// sendBookingMails sends one confirmation with all invoices for a booking and one notification to the owner
+func (m *Repository) sendBookingMails(b models.Booking) {
+	var attachments []models.MailAttachment
+	var rooms strings.Builder
+
+	for _, res := range b.Reservations {
+		inv, err := m.issueInvoice(res)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		} else {
+			attachments = append(attachments, invoiceAttachment(inv, res))
+		}
+
+		fmt.Fprintf(&rooms, "<p><strong>%s</strong> from %s to %s<br>\n%s</p>\n",
+			template.HTMLEscapeString(res.Room.RoomName), res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"),
+			priceBreakdown(res))
 	}
 
-	data := make(map[string]interface{})
-	data["reservation"] = reservation
+	htmlMessage := fmt.Sprintf(`
+		<strong>Booking Confirmation</strong><br>
+		Dear: %s <br>
+		This is confirm your booking of %d rooms.<br>
+		%s
+		You can view or cancel your rooms at <a href="%s/group-bookings/%s">%s/group-bookings/%s</a>.`,
+		b.FirstName, len(b.Reservations), rooms.String(),
+		m.App.BaseURL, b.AccessCode, m.App.BaseURL, b.AccessCode)
 
-	sd := reservation.StartDate.Format("2006-01-02")
-	ed := reservation.EndDate.Format("2006-01-02")
+	msg := models.MailData{
+		To:          b.Email,
+		From:        "me@here.com",
+		Subject:     "Booking Confirmation",
+		Content:     htmlMessage,
+		Template:    "basic.html",
+		Attachments: attachments,
+	}
+	m.App.MailChan <- msg
 
-	stringMap := make(map[string]string)
-	stringMap["start_date"] = sd
-	stringMap["end_date"] = ed
+	htmlMessage = fmt.Sprintf(`
+		<strong>Booking Notification</strong><br>
+		%sA booking of %d rooms has been made by %s %s.<br>
+		%s`,
+		m.guestWarning(b.Email), len(b.Reservations), b.FirstName, b.LastName, rooms.String())
 
-	render.Template(w, r, "reservation-summary.page.html", &models.TemplateData{
-		Data:      data,
-		StringMap: stringMap,
-	})
-}
+	msg = models.MailData{
+		To:      "me@here.com",
+		From:    "me@here.com",
+		Subject: "Booking Notification",
+		Content: htmlMessage,
+	}
+	m.App.MailChan <- msg
 
-// Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("Generals")
-	render.Template(w, r, "generals.page.html", &models.TemplateData{})
+	for _, res := range b.Reservations {
+		m.textConfirmation(res)
+	}
 }
 
-// Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("Majors")
-	render.Template(w, r, "majors.page.html", &models.TemplateData{})
-}
+// sendReservationMovedMail tells a guest their reservation now has different dates or a different room
+func (m *Repository) sendReservationMovedMail(previous, reservation models.Reservation) {
+	htmlMessage := fmt.Sprintf(`
+		<strong>Reservation Changed</strong><br>
+		Dear: %s <br>
+		Your reservation for %s from %s to %s has been changed.<br>
+		You are now staying in %s from %s to %s.<br>
+		You can view your reservation at <a href="%s/bookings/%s">%s/bookings/%s</a>.`,
+		reservation.FirstName,
+		previous.Room.RoomName, previous.StartDate.Format("2006-01-02"), previous.EndDate.Format("2006-01-02"),
+		reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"),
+		m.App.BaseURL, reservation.AccessCode, m.App.BaseURL, reservation.AccessCode)
 
-// Availability renders the search availability page
-func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("Availability")
-	render.Template(w, r, "search-availability.page.html", &models.TemplateData{})
+	msg := models.MailData{
+		To:       reservation.Email,
+		From:     "me@here.com",
+		Subject:  "Reservation Changed",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+	m.App.MailChan <- msg
 }
 
-// PostAvailability renders the search availability page
-func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("PostAvailability")
-	err := r.ParseForm()
+// guestWarning returns a line for staff when the guest with an email is flagged, and nothing otherwise
+func (m *Repository) guestWarning(email string) string {
+	g, err := m.DB.GetGuestByEmail(email)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't parse form!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+		return ""
 	}
 
-	start := r.Form.Get("start")
-	end := r.Form.Get("end")
+	switch g.Flag {
+	case models.GuestFlagVIP:
+		return fmt.Sprintf(`<strong>VIP guest</strong>, see <a href="%s/admin/guests/%d">their profile</a>.<br>`, m.App.BaseURL, g.ID)
+	case models.GuestFlagDoNotRent:
+		return fmt.Sprintf(`<strong style="color: red">Warning: this guest is flagged as do not rent</strong>, see <a href="%s/admin/guests/%d">their profile</a>.<br>`,
+			m.App.BaseURL, g.ID)
+	}
+	return ""
+}
 
-	layout := "2006-01-02"
-	startDate, err := time.Parse(layout, start)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't parse start date!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+// textConfirmation queues the confirmation of a reservation for texting to the guest, so the guest does not
+// wait for the provider; the email stays the confirmation that counts
+func (m *Repository) textConfirmation(res models.Reservation) {
+	body := fmt.Sprintf("Hi %s, your stay in %s from %s to %s is confirmed.",
+		res.FirstName, res.Room.RoomName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02"))
+
+	msg, ok := m.reservationSMS(res, models.SMSKindConfirmation, body)
+	if !ok {
 		return
 	}
 
-	endDate, err := time.Parse(layout, end)
+	m.App.SMSChan <- msg
+}
+
+// SendArrivalReminders texts the guests arriving within the reminder lead who were not reminded yet, and returns
+// how many reminders went out
+func (m *Repository) SendArrivalReminders() (int, error) {
+	today := time.Now().Truncate(24 * time.Hour)
+	reservations, err := m.DB.ReservationsDueForReminder(today, time.Now().Add(m.App.ReminderLead))
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't parse end date!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+		return 0, err
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	n := 0
+	for _, res := range reservations {
+		body := fmt.Sprintf("Hi %s, we look forward to welcoming you to %s on %s.",
+			res.FirstName, res.Room.RoomName, res.StartDate.Format("Monday 2006-01-02"))
+		if res.AccessCode != "" {
+			body += fmt.Sprintf(" Your booking: %s/bookings/%s", m.App.BaseURL, res.AccessCode)
+		}
+
+		err = m.sendReservationSMS(res, models.SMSKindReminder, body)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+			continue
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// sendReservationSMS texts the guest of a reservation and records what the provider reported. Nothing is sent
+// without a phone number or to a guest who opted out of text messages
+func (m *Repository) sendReservationSMS(res models.Reservation, kind, body string) error {
+	msg, ok := m.reservationSMS(res, kind, body)
+	if !ok {
+		return nil
+	}
+
+	return m.SendSMS(msg)
+}
+
+// SMSReport records the delivery reports the sms provider posts some time after it took a text message
+func (m *Repository) SMSReport(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("SMSReport")
+	report, err := m.App.SMS.VerifyReport(r)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "end date is earlier than start date!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		helpers.ClientError(w, http.StatusBadRequest)
 		return
 	}
 
-	if len(rooms) == 0 {
-		m.App.Session.Put(r.Context(), "error", "No Availability")
-		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+	err = m.DB.UpdateSMSStatus(m.App.SMS.Name(), report.Ref, report.Status, report.Message)
+	if err != nil {
+		helpers.ServerError(w, err)
 		return
 	}
 
-	data := make(map[string]interface{})
-	data["rooms"] = rooms
+	w.WriteHeader(http.StatusOK)
+}
 
-	res := models.Reservation{
-		StartDate: startDate,
-		EndDate:   endDate,
+// reservationSMS returns the text message for the guest of a reservation, and false when the guest gets none
+// because there is no phone number or they opted out of text messages
+func (m *Repository) reservationSMS(res models.Reservation, kind, body string) (models.SMSMessage, bool) {
+	if m.App.SMS == nil || res.Phone == "" {
+		return models.SMSMessage{}, false
 	}
 
-	m.App.Session.Put(r.Context(), "reservation", res)
+	guest, err := m.DB.GetGuestByEmail(res.Email)
+	if err == nil && guest.SMSOptOut {
+		return models.SMSMessage{}, false
+	}
 
-	render.Template(w, r, "choose-room.page.html", &models.TemplateData{
-		Data: data,
-	})
+	return models.SMSMessage{
+		ReservationID: res.ID,
+		Kind:          kind,
+		Phone:         res.Phone,
+		Body:          body,
+	}, true
 }
 
-type jsonResponse struct {
-	OK        bool   `json:"ok"`
-	Message   string `json:"message"`
-	RoomID    string `json:"room_id"`
-	StartDate string `json:"start_date"`
-	EndDate   string `json:"end_date"`
-}
+// SendSMS sends a text message through the provider and records what the provider reported. The number is written
+// in E.164 first, as numbers saved before they were checked on entry may still be in a local format
+func (m *Repository) SendSMS(msg models.SMSMessage) error {
+	msg.Provider = m.App.SMS.Name()
 
-// AvailabilityJSON handles request for availability and send JSON response
-func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("AvailabilityJSON")
-	err := r.ParseForm()
-	if err != nil {
-		resp := jsonResponse{
-			OK:      false,
-			Message: "Internal server error",
-		}
+	phone, sendErr := sms.E164(msg.Phone, m.App.PhoneCountryCode)
+	if sendErr == nil {
+		msg.Phone = phone
 
-		out, _ := json.MarshalIndent(resp, "", "    ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
-		return
+		var result sms.Result
+		result, sendErr = m.App.SMS.Send(phone, msg.Body)
+		msg.Ref = result.Ref
 	}
 
-	sd := r.Form.Get("start")
-	ed := r.Form.Get("end")
+	msg.Status = sms.StatusSent
+	if sendErr != nil {
+		msg.Status = sms.StatusFailed
+		msg.Error = sendErr.Error()
+	}
 
-	layout := "2006-01-02"
-	startDate, _ := time.Parse(layout, sd)
-	endDate, _ := time.Parse(layout, ed)
+	_, err := m.DB.InsertSMSMessage(msg)
+	if err != nil {
+		return err
+	}
 
-	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+	return sendErr
+}
 
-	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
-	if err != nil {
-		resp := jsonResponse{
-			OK:      false,
-			Message: "Error connecting to database",
-		}
+// priceBreakdown lists the room, taxes and fees of a reservation for an email
+func priceBreakdown(res models.Reservation) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Room: %s<br>\n", render.Money(res.TotalAmount-pricing.ChargesTotal(res.Charges)))
+	for _, c := range res.Charges {
+		fmt.Fprintf(&b, "%s: %s<br>\n", template.HTMLEscapeString(c.Description), render.Money(c.Amount))
+	}
+	fmt.Fprintf(&b, "<strong>Total: %s</strong><br>\n", render.Money(res.TotalAmount))
+	return b.String()
+}
 
-		out, _ := json.MarshalIndent(resp, "", "    ")
-		w.Header().Set("Content-Type", "application/json")
-		w.Write(out)
-		return
+// sendCancellationMails tells the guest and the property owner about a cancellation; pending is the part of the
+// refund the gateway could not make yet
+func (m *Repository) sendCancellationMails(reservation models.Reservation, refund, pending int) {
+	refundNote := render.Money(refund)
+	ownerNote := render.Money(refund)
+	if pending > 0 {
+		refundNote += fmt.Sprintf(" (%s of it could not be refunded right away and will follow in the next days)",
+			render.Money(pending))
+		ownerNote += fmt.Sprintf(" (%s failed and will be retried)", render.Money(pending))
 	}
 
-	resp := jsonResponse{
-		OK:        available,
-		Message:   "",
-		StartDate: sd,
-		EndDate:   ed,
-		RoomID:    strconv.Itoa(roomID),
+	htmlMessage := fmt.Sprintf(`
+		<strong>Reservation Cancelled</strong><br>
+		Dear: %s <br>
+		Your reservation from %s to %s has been cancelled.<br>
+		Refund: %s`,
+		reservation.FirstName, reservation.StartDate.Format("2006-01-02"), reservation.EndDate.Format("2006-01-02"),
+		refundNote)
+
+	msg := models.MailData{
+		To:       reservation.Email,
+		From:     "me@here.com",
+		Subject:  "Reservation Cancelled",
+		Content:  htmlMessage,
+		Template: "basic.html",
 	}
+	m.App.MailChan <- msg
 
-	out, _ := json.MarshalIndent(resp, "", "  ")
+	htmlMessage = fmt.Sprintf(`
+		<strong>Cancellation Notification</strong><br>
+		The reservation %d for %s from %s to %s has been cancelled. Refund: %s`,
+		reservation.ID, reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"),
+		reservation.EndDate.Format("2006-01-02"), ownerNote)
 
-	log.Println(string(out))
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(out)
+	msg = models.MailData{
+		To:      "me@here.com",
+		From:    "me@here.com",
+		Subject: "Cancellation Notification",
+		Content: htmlMessage,
+	}
+	m.App.MailChan <- msg
 }
 
-// Majors renders the room page
-func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("Contact")
-	render.Template(w, r, "contact.page.html", &models.TemplateData{})
+// issueInvoice numbers and stores the invoice of a reservation
+func (m *Repository) issueInvoice(res models.Reservation) (models.Invoice, error) {
+	return m.DB.InsertInvoice(invoice.New(res))
 }
 
-// ChooseRoom displays list of available rooms
-func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("ChooseRoom")
-	// roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+// invoiceAttachment renders an invoice as a mail attachment
+func invoiceAttachment(inv models.Invoice, res models.Reservation) models.MailAttachment {
+	return models.MailAttachment{
+		Name:     invoice.Filename(inv),
+		MimeType: "application/pdf",
+		Data:     invoice.PDF(inv, res),
+	}
+}
+
+// writeInvoice sends an invoice as a PDF download
+func writeInvoice(w http.ResponseWriter, inv models.Invoice, res models.Reservation) {
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, invoice.Filename(inv)))
+	w.Write(invoice.PDF(inv, res))
+}
+
+// cancellationQuote returns the policy of a reservation with the amounts paid and refundable right now
+func (m *Repository) cancellationQuote(res models.Reservation) (models.CancellationPolicy, int, int, error) {
+	var policy models.CancellationPolicy
+
+	if res.Room.CancellationPolicyID > 0 {
+		p, err := m.DB.GetCancellationPolicyByID(res.Room.CancellationPolicyID)
+		if err != nil {
+			return policy, 0, 0, err
+		}
+		policy = p
+	}
+
+	payments, err := m.DB.GetPaymentsByReservationID(res.ID)
+	if err != nil {
+		return policy, 0, 0, err
+	}
+
+	paid := pricing.PaidAmount(payments)
+	refund := pricing.RefundAmount(policy, paid, res.StartDate, time.Now())
+
+	return policy, paid, refund, nil
+}
+
+// errRefundFailed is returned when a reservation was cancelled but the gateway did not make its refund
+var errRefundFailed = errors.New("reservation cancelled, but the refund failed")
+
+// cancelReservation cancels the reservation and refunds what the guest is owed. The cancellation is recorded with
+// its refund before the gateway is asked for it, so a reservation cancelled twice is not refunded twice. A refund
+// the gateway fails is kept as a failed refund for RetryFailedRefunds and returns errRefundFailed; the guest is
+// told about the cancellation either way
+func (m *Repository) cancelReservation(res models.Reservation, refund int) error {
+	payments, err := m.DB.GetPaymentsByReservationID(res.ID)
+	if err != nil {
+		return err
+	}
+
+	err = m.DB.CancelReservation(res.ID, refund)
+	if err != nil {
+		return err
+	}
+
+	remaining := refund
+	pending := 0
+	for _, p := range pricing.RefundablePayments(payments) {
+		if remaining == 0 {
+			break
+		}
+
+		amount := p.Amount
+		if amount > remaining {
+			amount = remaining
+		}
+
+		refunded := models.Payment{
+			ReservationID: res.ID,
+			Provider:      p.Provider,
+			ProviderRef:   p.ProviderRef,
+			Amount:        amount,
+			Status:        payment.StatusRefunded,
+		}
+
+		tx, refundErr := m.App.Payments.Refund(p.ProviderRef, amount)
+		if refundErr != nil {
+			m.App.ErrorLog.Println(refundErr)
+			refunded.Status = payment.StatusRefundFailed
+			refunded.Message = refundErr.Error()
+			pending += amount
+		} else {
+			refunded.Message = tx.Message
+		}
+
+		// the reservation is cancelled already, so a refund which can't be recorded must not stop the mails
+		_, err = m.DB.InsertPayment(refunded)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+
+		remaining -= amount
+	}
+
+	m.sendCancellationMails(res, refund, pending)
+	m.NotifyWaitlist()
+
+	if pending > 0 {
+		return errRefundFailed
+	}
+
+	return nil
+}
+
+// RetryFailedRefunds asks the gateway again for the refunds it failed to make, and returns how many went through
+func (m *Repository) RetryFailedRefunds() (int, error) {
+	payments, err := m.DB.FailedRefunds()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, p := range payments {
+		tx, refundErr := m.App.Payments.Refund(p.ProviderRef, p.Amount)
+		if refundErr != nil {
+			m.App.ErrorLog.Println(refundErr)
+			err = m.DB.UpdatePaymentByID(p.ID, payment.StatusRefundFailed, refundErr.Error())
+			if err != nil {
+				m.App.ErrorLog.Println(err)
+			}
+			continue
+		}
+
+		err = m.DB.UpdatePaymentByID(p.ID, payment.StatusRefunded, tx.Message)
+		if err != nil {
+			// the gateway made the refund, so it must not be asked again
+			return n, err
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+// Booking shows a guest their reservation and what they would get back when cancelling
+func (m *Repository) Booking(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("Booking")
 	exploded := strings.Split(r.RequestURI, "/")
-	roomID, err := strconv.Atoi(exploded[2])
+	code := exploded[2]
+
+	res, err := m.DB.GetReservationByAccessCode(code)
 	if err != nil {
-		log.Println(1)
-		m.App.Session.Put(r.Context(), "error", "missing url parameter!")
+		m.App.Session.Put(r.Context(), "error", "can't find reservation!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
-		m.App.Session.Put(r.Context(), "error", "can't get reservation from session!")
+	policy, paid, refund, err := m.cancellationQuote(res)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't calculate refund!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	res.RoomID = roomID
+	invoices, err := m.DB.GetInvoicesByReservationID(res.ID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get invoices!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
-	m.App.Session.Put(r.Context(), "reservation", res)
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["policy"] = policy
+	data["invoices"] = invoices
 
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+	intMap := make(map[string]int)
+	intMap["paid"] = paid
+	intMap["refund"] = refund
+
+	render.Template(w, r, "booking.page.html", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
 }
 
-// BookRoom takes URL parameters, builds a session variable, and takes user to make res screen
-func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("BookRoom")
-	roomID, _ := strconv.Atoi(r.URL.Query().Get("id"))
-	sd := r.URL.Query().Get("s")
-	ed := r.URL.Query().Get("e")
+// PostCancelBooking cancels a reservation on behalf of the guest
+func (m *Repository) PostCancelBooking(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("PostCancelBooking")
+	exploded := strings.Split(r.RequestURI, "/")
+	code := exploded[2]
 
-	layout := "2006-01-02"
-	startDate, _ := time.Parse(layout, sd)
-	endDate, _ := time.Parse(layout, ed)
+	res, err := m.DB.GetReservationByAccessCode(code)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find reservation!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
-	room, err := m.DB.GetRoomByID(roomID)
+	if res.Status == models.ReservationStatusCancelled {
+		m.App.Session.Put(r.Context(), "warning", "Reservation is already cancelled")
+		http.Redirect(w, r, fmt.Sprintf("/bookings/%s", code), http.StatusSeeOther)
+		return
+	}
+
+	_, _, refund, err := m.cancellationQuote(res)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't get room from DB!")
+		m.App.Session.Put(r.Context(), "error", "can't calculate refund!")
+		http.Redirect(w, r, fmt.Sprintf("/bookings/%s", code), http.StatusSeeOther)
+		return
+	}
+
+	err = m.cancelReservation(res, refund)
+	if err == repository.ErrAlreadyCancelled {
+		m.App.Session.Put(r.Context(), "warning", "Reservation is already cancelled")
+		http.Redirect(w, r, fmt.Sprintf("/bookings/%s", code), http.StatusSeeOther)
+		return
+	}
+	if err == errRefundFailed {
+		m.App.Session.Put(r.Context(), "error", "Reservation cancelled, but the refund could not be made yet; we will be in touch")
+		http.Redirect(w, r, fmt.Sprintf("/bookings/%s", code), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't cancel reservation!")
+		http.Redirect(w, r, fmt.Sprintf("/bookings/%s", code), http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation cancelled")
+	http.Redirect(w, r, fmt.Sprintf("/bookings/%s", code), http.StatusSeeOther)
+}
+
+// BookingInvoice lets a guest download an invoice of their reservation
+func (m *Repository) BookingInvoice(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("BookingInvoice")
+	exploded := strings.Split(r.RequestURI, "/")
+	code := exploded[2]
+
+	res, err := m.DB.GetReservationByAccessCode(code)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find reservation!")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	var res models.Reservation
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "invalid invoice!")
+		http.Redirect(w, r, fmt.Sprintf("/bookings/%s", code), http.StatusSeeOther)
+		return
+	}
 
-	res.Room.RoomName = room.RoomName
-	res.RoomID = roomID
-	res.StartDate = startDate
-	res.EndDate = endDate
+	inv, err := m.DB.GetInvoiceByID(id)
+	if err != nil || inv.ReservationID != res.ID {
+		m.App.Session.Put(r.Context(), "error", "can't find invoice!")
+		http.Redirect(w, r, fmt.Sprintf("/bookings/%s", code), http.StatusSeeOther)
+		return
+	}
 
-	m.App.Session.Put(r.Context(), "reservation", res)
+	writeInvoice(w, inv, res)
+}
 
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+// checkoutReservations returns the reservations being checked out: those of a booking, or the single reservation
+func (m *Repository) checkoutReservations(r *http.Request) ([]models.Reservation, bool) {
+	if b, ok := m.App.Session.Get(r.Context(), "booking").(models.Booking); ok {
+		return b.Reservations, true
+	}
+
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		return nil, false
+	}
+	return []models.Reservation{res}, false
 }
 
-// ShowLogin shows the login screen
-func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("ShowLogin")
-	render.Template(w, r, "login.page.html", &models.TemplateData{
-		Form: forms.New(nil),
+// summaryURL returns the page a guest sees after checking out
+func summaryURL(isBooking bool) string {
+	if isBooking {
+		return "/booking-summary"
+	}
+	return "/reservation-summary"
+}
+
+// pendingPayment returns the reservations which still have to be paid and the amount due for them
+func pendingPayment(reservations []models.Reservation) ([]models.Reservation, int) {
+	var pending []models.Reservation
+	amount := 0
+	for _, res := range reservations {
+		if res.Status == models.ReservationStatusPendingPayment {
+			pending = append(pending, res)
+			amount += pricing.AmountDue(res.Room, res.TotalAmount)
+		}
+	}
+	return pending, amount
+}
+
+// Payment renders the payment page for reservations which are pending payment
+func (m *Repository) Payment(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("Payment")
+	reservations, isBooking := m.checkoutReservations(r)
+	if reservations == nil {
+		m.App.Session.Put(r.Context(), "error", "can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	pending, amount := pendingPayment(reservations)
+	if len(pending) == 0 {
+		http.Redirect(w, r, summaryURL(isBooking), http.StatusSeeOther)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = pending
+
+	intMap := make(map[string]int)
+	intMap["amount_due"] = amount
+
+	render.Template(w, r, "payment.page.html", &models.TemplateData{
+		Form:   forms.New(nil),
+		Data:   data,
+		IntMap: intMap,
 	})
 }
 
-// PostShowLogin handles logging the user in
-func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("PostShowLogin")
-	_ = m.App.Session.RenewToken(r.Context())
+// PostPayment authorizes and captures the amount due for the pending reservations in one transaction
+func (m *Repository) PostPayment(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("PostPayment")
+	reservations, isBooking := m.checkoutReservations(r)
+	if reservations == nil {
+		m.App.Session.Put(r.Context(), "error", "can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
 	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/payment", http.StatusSeeOther)
+		return
 	}
 
-	email := r.Form.Get("email")
-	password := r.Form.Get("password")
+	pending, amount := pendingPayment(reservations)
+	if len(pending) == 0 {
+		http.Redirect(w, r, summaryURL(isBooking), http.StatusSeeOther)
+		return
+	}
 
-	form := forms.New(r.PostForm)
-	form.Required("email", "password")
-	form.IsEmail("email")
-	if !form.Valid() {
-		render.Template(w, r, "login.page.html", &models.TemplateData{
-			Form: form,
+	reference := strconv.Itoa(pending[0].ID)
+	description := fmt.Sprintf("Reservation %d", pending[0].ID)
+	if isBooking {
+		reference = fmt.Sprintf("B%d", pending[0].BookingID)
+		description = fmt.Sprintf("Booking %d", pending[0].BookingID)
+	}
+
+	tx, err := m.App.Payments.Authorize(payment.AuthorizeRequest{
+		Amount:      amount,
+		Currency:    "USD",
+		CardToken:   r.Form.Get("card_token"),
+		Reference:   reference,
+		Description: description,
+	})
+	if err == payment.ErrDeclined {
+		for _, res := range pending {
+			_, _ = m.DB.InsertPayment(models.Payment{
+				ReservationID: res.ID,
+				Provider:      m.App.Payments.Name(),
+				Amount:        pricing.AmountDue(res.Room, res.TotalAmount),
+				Status:        payment.StatusDeclined,
+				Message:       tx.Message,
+			})
+		}
+		m.App.Session.Put(r.Context(), "error", "Your card was declined")
+		http.Redirect(w, r, "/payment", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't process payment!")
+		http.Redirect(w, r, "/payment", http.StatusSeeOther)
+		return
+	}
+
+	// one row per reservation, all sharing the transaction, so refunds can be made room by room
+	for _, res := range pending {
+		_, err = m.DB.InsertPayment(models.Payment{
+			ReservationID: res.ID,
+			Provider:      m.App.Payments.Name(),
+			ProviderRef:   tx.Ref,
+			Amount:        pricing.AmountDue(res.Room, res.TotalAmount),
+			Status:        tx.Status,
 		})
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't insert payment into database!")
+			http.Redirect(w, r, "/payment", http.StatusSeeOther)
+			return
+		}
+	}
+
+	ref := tx.Ref
+	tx, err = m.App.Payments.Capture(ref, amount)
+	if err != nil {
+		_ = m.DB.UpdatePaymentStatus(ref, payment.StatusFailed, tx.Message)
+		m.App.Session.Put(r.Context(), "error", "can't capture payment!")
+		http.Redirect(w, r, "/payment", http.StatusSeeOther)
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	err = m.DB.UpdatePaymentStatus(ref, tx.Status, tx.Message)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't update payment!")
+		http.Redirect(w, r, "/payment", http.StatusSeeOther)
+		return
+	}
+
+	for i, res := range reservations {
+		if res.Status != models.ReservationStatusPendingPayment {
+			continue
+		}
+
+		_, err = m.DB.UpdatePendingReservationStatus(res.ID, models.ReservationStatusConfirmed)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't update reservation!")
+			http.Redirect(w, r, "/payment", http.StatusSeeOther)
+			return
+		}
+		reservations[i].Status = models.ReservationStatusConfirmed
+	}
+
+	if isBooking {
+		b := m.App.Session.Get(r.Context(), "booking").(models.Booking)
+		b.Reservations = reservations
+		m.sendBookingMails(b)
+		m.App.Session.Put(r.Context(), "booking", b)
+	} else {
+		m.sendReservationMails(reservations[0])
+		m.App.Session.Put(r.Context(), "reservation", reservations[0])
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Payment received")
+
+	http.Redirect(w, r, summaryURL(isBooking), http.StatusSeeOther)
+}
+
+// PaymentWebhook handles notifications sent by the payment gateway
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("PaymentWebhook")
+	event, err := m.App.Payments.VerifyWebhook(r)
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	payments, err := m.DB.GetPaymentsByProviderRef(event.Ref)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	// only payments still authorized are settled, so replayed events and refunds under the same ref change nothing
+	switch event.Type {
+	case payment.EventCaptured:
+		err = m.DB.UpdatePaymentStatus(event.Ref, payment.StatusCaptured, "")
+		for _, p := range payments {
+			if err != nil {
+				break
+			}
+			if p.Status == payment.StatusAuthorized {
+				_, err = m.DB.UpdatePendingReservationStatus(p.ReservationID, models.ReservationStatusConfirmed)
+			}
+		}
+	case payment.EventFailed:
+		// the guest's payment won't come through, so the pending reservation is cancelled and its room freed
+		err = m.DB.UpdatePaymentStatus(event.Ref, payment.StatusFailed, "")
+		released := false
+		for _, p := range payments {
+			if err != nil {
+				break
+			}
+			if p.Status == payment.StatusAuthorized {
+				var ok bool
+				ok, err = m.DB.UpdatePendingReservationStatus(p.ReservationID, models.ReservationStatusCancelled)
+				released = released || ok
+			}
+		}
+		if err == nil && released {
+			m.NotifyWaitlist()
+		}
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ReservationSummary displays the reservation summary page
+func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("ReservationSummary")
+	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+
+	sd := reservation.StartDate.Format("2006-01-02")
+	ed := reservation.EndDate.Format("2006-01-02")
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = sd
+	stringMap["end_date"] = ed
+
+	render.Template(w, r, "reservation-summary.page.html", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// Generals renders the room page
+func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("Generals")
+	render.Template(w, r, "generals.page.html", &models.TemplateData{})
+}
+
+// Majors renders the room page
+func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("Majors")
+	render.Template(w, r, "majors.page.html", &models.TemplateData{})
+}
+
+// Availability renders the search availability page
+func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("Availability")
+	render.Template(w, r, "search-availability.page.html", &models.TemplateData{})
+}
+
+// PostAvailability renders the search availability page
+func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("PostAvailability")
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	start := r.Form.Get("start")
+	end := r.Form.Get("end")
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, start)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse start date!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	endDate, err := time.Parse(layout, end)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse end date!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "end date is earlier than start date!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if len(rooms) == 0 {
+		m.App.Session.Put(r.Context(), "error", "No Availability")
+		http.Redirect(w, r, fmt.Sprintf("/waitlist?s=%s&e=%s", start, end), http.StatusSeeOther)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	res := models.Reservation{
+		StartDate: startDate,
+		EndDate:   endDate,
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+
+	render.Template(w, r, "choose-room.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// Waitlist renders the form to join the waitlist for sold-out dates
+func (m *Repository) Waitlist(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("Waitlist")
+	layout := "2006-01-02"
+	entry := models.WaitlistEntry{Guests: 1}
+	entry.StartDate, _ = time.Parse(layout, r.URL.Query().Get("s"))
+	entry.EndDate, _ = time.Parse(layout, r.URL.Query().Get("e"))
+
+	m.renderWaitlist(w, r, entry, forms.New(nil))
+}
+
+// renderWaitlist renders the waitlist form for an entry
+func (m *Repository) renderWaitlist(w http.ResponseWriter, r *http.Request, entry models.WaitlistEntry, form *forms.Form) {
+	stringMap := make(map[string]string)
+	if !entry.StartDate.IsZero() {
+		stringMap["start_date"] = entry.StartDate.Format("2006-01-02")
+	}
+	if !entry.EndDate.IsZero() {
+		stringMap["end_date"] = entry.EndDate.Format("2006-01-02")
+	}
+
+	data := make(map[string]interface{})
+	data["entry"] = entry
+
+	render.Template(w, r, "waitlist.page.html", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// PostWaitlist puts a guest on the waitlist
+func (m *Repository) PostWaitlist(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("PostWaitlist")
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start_date", "end_date", "first_name", "last_name", "email")
+	form.IsEmail("email")
+
+	entry := models.WaitlistEntry{
+		FirstName: r.Form.Get("first_name"),
+		LastName:  r.Form.Get("last_name"),
+		Email:     r.Form.Get("email"),
+		Token:     helpers.NewAccessCode(),
+	}
+
+	layout := "2006-01-02"
+	entry.StartDate, err = time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+	entry.EndDate, err = time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Invalid date")
+	} else if !entry.EndDate.After(entry.StartDate) {
+		form.Errors.Add("end_date", "Departure has to be after arrival")
+	}
+
+	entry.Guests, err = strconv.Atoi(r.Form.Get("guests"))
+	if err != nil || entry.Guests < 1 {
+		form.Errors.Add("guests", "There has to be at least one guest")
+	}
+
+	if !form.Valid() {
+		m.renderWaitlist(w, r, entry, form)
+		return
+	}
+
+	_, err = m.DB.InsertWaitlistEntry(entry)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't insert waitlist entry into database!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "You are on the waitlist, we will email you as soon as a room becomes free")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// NotifyWaitlist offers freed rooms to the guests who have waited longest for matching dates and party sizes.
+// Offers which ran out unused are not open any more, so their rooms go to the next guest in line
+func (m *Repository) NotifyWaitlist() {
+	entries, err := m.DB.OpenWaitlistEntries()
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+
+	var offers []models.WaitlistEntry
+	for _, e := range entries {
+		if e.Status == models.WaitlistStatusNotified {
+			offers = append(offers, e)
+		}
+	}
+
+	for _, e := range entries {
+		if e.Status != models.WaitlistStatusWaiting {
+			continue
+		}
+
+		rooms, err := m.DB.SearchAvailabilityForAllRooms(e.StartDate, e.EndDate)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+			continue
+		}
+
+		// rooms which were offered to someone who waited longer are not free
+		free := len(roomsForParty(rooms, e.Guests))
+		for _, o := range offers {
+			if o.StartDate.Before(e.EndDate) && o.EndDate.After(e.StartDate) {
+				free--
+			}
+		}
+		if free <= 0 {
+			continue
+		}
+
+		e.OfferExpiresAt = time.Now().Add(m.App.OfferDuration)
+		err = m.DB.MarkWaitlistEntryNotified(e.ID, e.OfferExpiresAt)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+			continue
+		}
+		e.Status = models.WaitlistStatusNotified
+		offers = append(offers, e)
+
+		m.sendWaitlistOffer(e)
+	}
+}
+
+// roomsForParty returns the rooms which sleep at least guests people
+func roomsForParty(rooms []models.Room, guests int) []models.Room {
+	var fit []models.Room
+	for _, room := range rooms {
+		if room.MaxGuests >= guests {
+			fit = append(fit, room)
+		}
+	}
+	return fit
+}
+
+// sendWaitlistOffer emails a waiting guest the link to book the freed room
+func (m *Repository) sendWaitlistOffer(e models.WaitlistEntry) {
+	htmlMessage := fmt.Sprintf(`
+		<strong>A Room Is Available</strong><br>
+		Dear %s, <br>
+		A room has become available for your stay from %s to %s.<br>
+		Book it at <a href="%s/waitlist/%s">%s/waitlist/%s</a> before %s.`,
+		e.FirstName, e.StartDate.Format("2006-01-02"), e.EndDate.Format("2006-01-02"),
+		m.App.BaseURL, e.Token, m.App.BaseURL, e.Token, e.OfferExpiresAt.Format("2006-01-02 15:04"))
+
+	msg := models.MailData{
+		To:       e.Email,
+		From:     "me@here.com",
+		Subject:  "A Room Is Available",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+
+	m.App.MailChan <- msg
+}
+
+// WaitlistOffer lets a notified guest choose one of the rooms which became free for their dates
+func (m *Repository) WaitlistOffer(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("WaitlistOffer")
+	exploded := strings.Split(r.RequestURI, "/")
+	token := exploded[2]
+
+	e, err := m.DB.GetWaitlistEntryByToken(token)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find waitlist entry!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if e.Status != models.WaitlistStatusNotified || time.Now().After(e.OfferExpiresAt) {
+		m.App.Session.Put(r.Context(), "error", "This offer is no longer valid")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(e.StartDate, e.EndDate)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't search availability!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	rooms = roomsForParty(rooms, e.Guests)
+	if len(rooms) == 0 {
+		// the guest keeps their place and is notified again when the next room becomes free
+		err = m.DB.UpdateWaitlistEntryStatus(e.ID, models.WaitlistStatusWaiting)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+		m.App.Session.Put(r.Context(), "error", "Sorry, the room was taken in the meantime, you are back on the waitlist")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", models.Reservation{
+		FirstName: e.FirstName,
+		LastName:  e.LastName,
+		Email:     e.Email,
+		StartDate: e.StartDate,
+		EndDate:   e.EndDate,
+		Guests:    e.Guests,
+	})
+	m.App.Session.Put(r.Context(), "waitlist_id", e.ID)
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "choose-room.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+type jsonResponse struct {
+	OK        bool   `json:"ok"`
+	Message   string `json:"message"`
+	RoomID    string `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+// AvailabilityJSON handles request for availability and send JSON response
+func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AvailabilityJSON")
+	err := r.ParseForm()
+	if err != nil {
+		resp := jsonResponse{
+			OK:      false,
+			Message: "Internal server error",
+		}
+
+		out, _ := json.MarshalIndent(resp, "", "    ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+
+	sd := r.Form.Get("start")
+	ed := r.Form.Get("end")
+
+	layout := "2006-01-02"
+	startDate, _ := time.Parse(layout, sd)
+	endDate, _ := time.Parse(layout, ed)
+
+	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	if err != nil {
+		resp := jsonResponse{
+			OK:      false,
+			Message: "Error connecting to database",
+		}
+
+		out, _ := json.MarshalIndent(resp, "", "    ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+
+	resp := jsonResponse{
+		OK:        available,
+		Message:   "",
+		StartDate: sd,
+		EndDate:   ed,
+		RoomID:    strconv.Itoa(roomID),
+	}
+
+	out, _ := json.MarshalIndent(resp, "", "  ")
+
+	log.Println(string(out))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// Contact renders the contact form
+func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("Contact")
+	render.Template(w, r, "contact.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostContact stores a message from the contact form in the guest inbox. The message is threaded with the
+// guest's earlier conversations, and with their reservation when the booking code and email belong together
+func (m *Repository) PostContact(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("PostContact")
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "email", "subject", "message")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		render.Template(w, r, "contact.page.html", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	c := models.Conversation{
+		Token:   helpers.NewAccessCode(),
+		Name:    strings.TrimSpace(form.Get("name")),
+		Email:   strings.ToLower(strings.TrimSpace(form.Get("email"))),
+		Subject: strings.TrimSpace(form.Get("subject")),
+	}
+	m.linkConversation(&c, strings.TrimSpace(form.Get("booking_code")))
+
+	_, err = m.DB.AddInboundMessage(c, models.ConversationMessage{
+		Body: strings.TrimSpace(form.Get("message")),
+	})
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't store your message!")
+		http.Redirect(w, r, "/contact", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Thank you, we will answer by email shortly")
+	http.Redirect(w, r, "/contact", http.StatusSeeOther)
+}
+
+// linkConversation ties a conversation to the guest with its email and to the reservation with the booking code,
+// if the reservation was made with that email
+func (m *Repository) linkConversation(c *models.Conversation, code string) {
+	guest, err := m.DB.GetGuestByEmail(c.Email)
+	if err == nil {
+		c.GuestID = guest.ID
+	}
+
+	if code == "" {
+		return
+	}
+	res, err := m.DB.GetReservationByAccessCode(code)
+	if err == nil && strings.EqualFold(res.Email, c.Email) {
+		c.ReservationID = res.ID
+	}
+}
+
+// ReceiveInboundMail adds an email from a guest to the guest inbox, threaded by the reference in its subject
+// or else by the guest's email
+func (m *Repository) ReceiveInboundMail(msg inbox.Message) error {
+	c := models.Conversation{
+		Token:   inbox.Token(msg.Subject),
+		Name:    msg.Name,
+		Email:   msg.From,
+		Subject: inbox.CleanSubject(msg.Subject),
+	}
+	if c.Token == "" {
+		c.Token = helpers.NewAccessCode()
+	}
+	if c.Name == "" {
+		c.Name = msg.From
+	}
+	if c.Subject == "" {
+		c.Subject = "(no subject)"
+	}
+	m.linkConversation(&c, "")
+
+	_, err := m.DB.AddInboundMessage(c, models.ConversationMessage{
+		Body:      msg.Body,
+		MessageID: msg.MessageID,
+	})
+	return err
+}
+
+// ChooseRoom displays list of available rooms
+func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("ChooseRoom")
+	// roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[2])
+	if err != nil {
+		log.Println(1)
+		m.App.Session.Put(r.Context(), "error", "missing url parameter!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "can't get reservation from session!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	res.RoomID = roomID
+
+	if !m.holdRoom(w, r, res) {
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// holdRoom releases the guest's previous hold and holds the room of res while the reservation form is filled in;
+// if the room can't be held the guest is redirected and false is returned
+func (m *Repository) holdRoom(w http.ResponseWriter, r *http.Request, res models.Reservation) bool {
+	if holdID := m.App.Session.PopInt(r.Context(), "hold_id"); holdID > 0 {
+		err := m.DB.DeleteHoldByID(holdID)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
+
+	holdID, err := m.DB.InsertHold(models.RoomRestriction{
+		StartDate: res.StartDate,
+		EndDate:   res.EndDate,
+		RoomID:    res.RoomID,
+		ExpiresAt: time.Now().Add(m.App.HoldDuration),
+	})
+	if err == repository.ErrRoomNotAvailable {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room was just taken, please choose another one")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return false
+	} else if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't hold room!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return false
+	}
+
+	m.App.Session.Put(r.Context(), "hold_id", holdID)
+	return true
+}
+
+// BookRoom takes URL parameters, builds a session variable, and takes user to make res screen
+func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("BookRoom")
+	roomID, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	sd := r.URL.Query().Get("s")
+	ed := r.URL.Query().Get("e")
+
+	layout := "2006-01-02"
+	startDate, _ := time.Parse(layout, sd)
+	endDate, _ := time.Parse(layout, ed)
+
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get room from DB!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	var res models.Reservation
+
+	res.Room.RoomName = room.RoomName
+	res.RoomID = roomID
+	res.StartDate = startDate
+	res.EndDate = endDate
+
+	if !m.holdRoom(w, r, res) {
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// cartLine is a room in the cart with its price
+type cartLine struct {
+	Index int
+	Item  models.CartItem
+	Quote pricing.Quote
+}
+
+// AddToCart adds the chosen room for the searched dates to the cart
+func (m *Repository) AddToCart(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AddToCart")
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "missing url parameter!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "can't get reservation from session!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(roomID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find room!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	cart, _ := m.App.Session.Get(r.Context(), "cart").(models.Cart)
+
+	for _, item := range cart.Items {
+		if item.RoomID == roomID && res.StartDate.Before(item.EndDate) && res.EndDate.After(item.StartDate) {
+			m.App.Session.Put(r.Context(), "warning", "This room is already in your cart for these dates")
+			http.Redirect(w, r, "/cart", http.StatusSeeOther)
+			return
+		}
+	}
+
+	cart.Items = append(cart.Items, models.CartItem{
+		RoomID:    roomID,
+		RoomName:  room.RoomName,
+		StartDate: res.StartDate,
+		EndDate:   res.EndDate,
+	})
+
+	m.App.Session.Put(r.Context(), "cart", cart)
+	m.App.Session.Put(r.Context(), "flash", "Room added to your cart")
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+// PostRemoveFromCart removes one room from the cart
+func (m *Repository) PostRemoveFromCart(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("PostRemoveFromCart")
+	exploded := strings.Split(r.RequestURI, "/")
+	index, err := strconv.Atoi(exploded[3])
+
+	cart, _ := m.App.Session.Get(r.Context(), "cart").(models.Cart)
+	if err != nil || index < 0 || index >= len(cart.Items) {
+		m.App.Session.Put(r.Context(), "error", "can't find room in cart!")
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	cart.Items = append(cart.Items[:index], cart.Items[index+1:]...)
+	m.App.Session.Put(r.Context(), "cart", cart)
+
+	http.Redirect(w, r, "/cart", http.StatusSeeOther)
+}
+
+// Cart shows the rooms in the cart and the checkout form
+func (m *Repository) Cart(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("Cart")
+	m.renderCart(w, r, models.Booking{}, forms.New(nil))
+}
+
+// renderCart prices the rooms in the cart and renders the cart page
+func (m *Repository) renderCart(w http.ResponseWriter, r *http.Request, b models.Booking, form *forms.Form) {
+	cart, _ := m.App.Session.Get(r.Context(), "cart").(models.Cart)
+
+	rules, err := m.DB.AllTaxFeeRules()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get taxes and fees!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	var lines []cartLine
+	total := 0
+	for i, item := range cart.Items {
+		room, err := m.DB.GetRoomByID(item.RoomID)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't find room!")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		guests := 1
+		if n, err := strconv.Atoi(form.Get(fmt.Sprintf("guests_%d", i))); err == nil && n > 0 {
+			guests = n
+		}
+
+		q := pricing.NewQuote(room, item.StartDate, item.EndDate, guests, rules)
+		lines = append(lines, cartLine{Index: i, Item: item, Quote: q})
+		total += q.Total
+	}
+
+	data := make(map[string]interface{})
+	data["lines"] = lines
+	data["booking"] = b
+
+	intMap := make(map[string]int)
+	intMap["total"] = total
+
+	render.Template(w, r, "cart.page.html", &models.TemplateData{
+		Form:   form,
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// PostCart checks out all rooms in the cart as one booking
+func (m *Repository) PostCart(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("PostCart")
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	cart, _ := m.App.Session.Get(r.Context(), "cart").(models.Cart)
+	if len(cart.Items) == 0 {
+		m.App.Session.Put(r.Context(), "warning", "Your cart is empty")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	rules, err := m.DB.AllTaxFeeRules()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get taxes and fees!")
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	checkReservationForm(form, m.App.PhoneCountryCode)
+
+	b := models.Booking{
+		FirstName:  r.Form.Get("first_name"),
+		LastName:   r.Form.Get("last_name"),
+		Email:      r.Form.Get("email"),
+		Phone:      form.Get("phone"),
+		AccessCode: helpers.NewAccessCode(),
+	}
+
+	requiresPayment := false
+	for i, item := range cart.Items {
+		room, err := m.DB.GetRoomByID(item.RoomID)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't find room!")
+			http.Redirect(w, r, "/cart", http.StatusSeeOther)
+			return
+		}
+
+		field := fmt.Sprintf("guests_%d", i)
+		guests := 1
+		if form.Has(field) {
+			guests, err = strconv.Atoi(form.Get(field))
+			if err != nil || guests < 1 {
+				form.Errors.Add(field, "There has to be at least one guest")
+				guests = 1
+			}
+		}
+
+		q := pricing.NewQuote(room, item.StartDate, item.EndDate, guests, rules)
+
+		res := models.Reservation{
+			FirstName:   b.FirstName,
+			LastName:    b.LastName,
+			Email:       b.Email,
+			Phone:       b.Phone,
+			StartDate:   item.StartDate,
+			EndDate:     item.EndDate,
+			RoomID:      item.RoomID,
+			Room:        room,
+			Status:      models.ReservationStatusConfirmed,
+			TotalAmount: q.Total,
+			AccessCode:  helpers.NewAccessCode(),
+			Guests:      guests,
+			Charges:     q.Charges,
+		}
+
+		if pricing.RequiresPayment(room) {
+			res.Status = models.ReservationStatusPendingPayment
+			requiresPayment = true
+		}
+
+		b.Reservations = append(b.Reservations, res)
+	}
+
+	if !form.Valid() {
+		m.renderCart(w, r, b, form)
+		return
+	}
+
+	b, err = m.DB.InsertBooking(b, m.App.Session.GetInt(r.Context(), "hold_id"))
+	if err == repository.ErrRoomNotAvailable {
+		m.App.Session.Put(r.Context(), "error", "One of the rooms is no longer available for your dates, please remove it and try again")
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't insert booking into database!")
+		http.Redirect(w, r, "/cart", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Remove(r.Context(), "cart")
+	m.App.Session.Remove(r.Context(), "reservation")
+	m.App.Session.Remove(r.Context(), "hold_id")
+	m.App.Session.Put(r.Context(), "booking", b)
+
+	if requiresPayment {
+		http.Redirect(w, r, "/payment", http.StatusSeeOther)
+		return
+	}
+
+	m.sendBookingMails(b)
+
+	http.Redirect(w, r, "/booking-summary", http.StatusSeeOther)
+}
+
+// BookingSummary displays the rooms of a booking which was just checked out
+func (m *Repository) BookingSummary(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("BookingSummary")
+	b, ok := m.App.Session.Get(r.Context(), "booking").(models.Booking)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Can't get booking from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	total := 0
+	for _, res := range b.Reservations {
+		total += res.TotalAmount
+	}
+
+	data := make(map[string]interface{})
+	data["booking"] = b
+
+	intMap := make(map[string]int)
+	intMap["total"] = total
+
+	render.Template(w, r, "booking-summary.page.html", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// groupBookingLine is a reservation of a booking with what the guest gets back when cancelling it
+type groupBookingLine struct {
+	Reservation models.Reservation
+	Paid        int
+	Refund      int
+}
+
+// GroupBooking shows a guest all rooms of their booking
+func (m *Repository) GroupBooking(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("GroupBooking")
+	exploded := strings.Split(r.RequestURI, "/")
+	code := exploded[2]
+
+	b, err := m.DB.GetBookingByAccessCode(code)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find booking!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	var lines []groupBookingLine
+	refund := 0
+	open := 0
+	for _, res := range b.Reservations {
+		line := groupBookingLine{Reservation: res}
+		if res.Status != models.ReservationStatusCancelled {
+			_, paid, refundable, err := m.cancellationQuote(res)
+			if err != nil {
+				m.App.Session.Put(r.Context(), "error", "can't calculate refund!")
+				http.Redirect(w, r, "/", http.StatusSeeOther)
+				return
+			}
+			line.Paid = paid
+			line.Refund = refundable
+			refund += refundable
+			open++
+		}
+		lines = append(lines, line)
+	}
+
+	data := make(map[string]interface{})
+	data["booking"] = b
+	data["lines"] = lines
+
+	intMap := make(map[string]int)
+	intMap["refund"] = refund
+	intMap["open"] = open
+
+	render.Template(w, r, "group-booking.page.html", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// PostCancelGroupBooking cancels one room of a booking, or all of them when no reservation is given
+func (m *Repository) PostCancelGroupBooking(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("PostCancelGroupBooking")
+	exploded := strings.Split(r.RequestURI, "/")
+	code := exploded[2]
+
+	b, err := m.DB.GetBookingByAccessCode(code)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find booking!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	back := fmt.Sprintf("/group-bookings/%s", code)
+
+	id := 0
+	if len(exploded) > 4 {
+		id, err = strconv.Atoi(exploded[4])
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "invalid reservation!")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+	}
+
+	cancelled := 0
+	refund := 0
+	for _, res := range b.Reservations {
+		if (id > 0 && res.ID != id) || res.Status == models.ReservationStatusCancelled {
+			continue
+		}
+
+		_, _, refundable, err := m.cancellationQuote(res)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't calculate refund!")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+
+		err = m.cancelReservation(res, refundable)
+		if err == repository.ErrAlreadyCancelled {
+			continue
+		}
+		if err == errRefundFailed {
+			m.App.Session.Put(r.Context(), "error", "Room cancelled, but the refund could not be made yet; we will be in touch")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't cancel reservation!")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+
+		cancelled++
+		refund += refundable
+	}
+
+	if cancelled == 0 {
+		m.App.Session.Put(r.Context(), "warning", "Nothing left to cancel")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%d room(s) cancelled, refund %s", cancelled, render.Money(refund)))
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// ShowLogin shows the login screen
+func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("ShowLogin")
+	render.Template(w, r, "login.page.html", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostShowLogin handles logging the user in
+func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("PostShowLogin")
+	_ = m.App.Session.RenewToken(r.Context())
+
+	err := r.ParseForm()
+	if err != nil {
+		log.Println(err)
+	}
+
+	email := r.Form.Get("email")
+	password := r.Form.Get("password")
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, r, "login.page.html", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	id, _, err := m.DB.Authenticate(email, password)
+	if err != nil {
+		log.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "user_id", id)
+	m.App.Session.Put(r.Context(), "access_level", user.AccessLevel)
+	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
+	if user.AccessLevel == models.AccessLevelHousekeeper {
+		http.Redirect(w, r, "/housekeeping", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// Logout logs a user out
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("Logout")
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// dashboardTrendPeriods is how many periods the dashboard charts go back
+const dashboardTrendPeriods = 6
+
+// dashboardFigure is one figure on the dashboard next to the one of the previous period
+type dashboardFigure struct {
+	Name     string
+	Current  string
+	Previous string
+	Change   string
+	Class    string
+}
+
+// dashboardRoom holds the figures of one room, or of all rooms
+type dashboardRoom struct {
+	Name    string
+	Figures []dashboardFigure
+}
+
+// dashboardChart is what the dashboard charts draw
+type dashboardChart struct {
+	Labels           []string  `json:"labels"`
+	Occupancy        []float64 `json:"occupancy"`
+	ADR              []float64 `json:"adr"`
+	RevPAR           []float64 `json:"revpar"`
+	Rooms            []string  `json:"rooms"`
+	RoomOccupancy    []float64 `json:"room_occupancy"`
+	RoomOccupancyWas []float64 `json:"room_occupancy_was"`
+}
+
+// compareFigures lists figures of a period with those of the previous one; a falling cancellation rate is good news
+func compareFigures(cur, prev reports.Figures) []dashboardFigure {
+	figures := []struct {
+		name        string
+		cur, prev   float64
+		format      func(float64) string
+		lowerIsGood bool
+	}{
+		{"Occupancy", cur.Occupancy, prev.Occupancy, func(v float64) string { return fmt.Sprintf("%.1f%%", v) }, false},
+		{"ADR", float64(cur.ADR), float64(prev.ADR), func(v float64) string { return render.Money(int(v)) }, false},
+		{"RevPAR", float64(cur.RevPAR), float64(prev.RevPAR), func(v float64) string { return render.Money(int(v)) }, false},
+		{"Bookings", float64(cur.Bookings), float64(prev.Bookings), func(v float64) string { return fmt.Sprintf("%.0f", v) }, false},
+		{"Lead time", cur.LeadTime, prev.LeadTime, func(v float64) string { return fmt.Sprintf("%.1f days", v) }, false},
+		{"Length of stay", cur.LengthOfStay, prev.LengthOfStay, func(v float64) string { return fmt.Sprintf("%.1f nights", v) }, false},
+		{"Cancellation rate", cur.CancellationRate, prev.CancellationRate, func(v float64) string { return fmt.Sprintf("%.1f%%", v) }, true},
+	}
+
+	var out []dashboardFigure
+	for _, f := range figures {
+		d := dashboardFigure{
+			Name:     f.name,
+			Current:  f.format(f.cur),
+			Previous: f.format(f.prev),
+			Change:   "n/a",
+			Class:    "text-muted",
+		}
+		if change, ok := reports.Change(f.cur, f.prev); ok {
+			d.Change = fmt.Sprintf("%+.1f%%", change)
+			if change != 0 {
+				d.Class = "text-success"
+				if (change < 0) != f.lowerIsGood {
+					d.Class = "text-danger"
+				}
+			}
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// periodLabel names a reporting period
+func periodLabel(kind string, start time.Time) string {
+	if kind == reports.PeriodWeek {
+		return "Week of " + start.Format("2006-01-02")
+	}
+	return start.Format("January 2006")
+}
+
+// AdminDashboard shows occupancy and revenue of a month or week, for all rooms and each room, against the period before
+func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminDashboard")
+	q := r.URL.Query()
+
+	kind := reports.PeriodMonth
+	if q.Get("period") == reports.PeriodWeek {
+		kind = reports.PeriodWeek
+	}
+
+	day := time.Now()
+	if d, err := time.Parse("2006-01-02", q.Get("date")); err == nil {
+		day = d
+	}
+
+	start, end := reports.Period(kind, day)
+
+	// nights out of order can be left out of what could have been sold
+	sellable := q.Get("sellable") != ""
+
+	// stats of the chosen period first, then going back
+	var periods [][]models.RoomStats
+	var labels []string
+	periodStart, periodEnd := start, end
+	for i := 0; i < dashboardTrendPeriods; i++ {
+		stats, err := m.DB.RoomStats(periodStart, periodEnd)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if sellable {
+			for i := range stats {
+				stats[i] = reports.Sellable(stats[i])
+			}
+		}
+		periods = append(periods, stats)
+		labels = append(labels, periodLabel(kind, periodStart))
+		periodStart, periodEnd = reports.Previous(kind, periodStart)
+	}
+	current, previous := periods[0], periods[1]
+
+	rooms := []dashboardRoom{
+		{Name: "All rooms", Figures: compareFigures(reports.Compute(reports.Sum(current)), reports.Compute(reports.Sum(previous)))},
+	}
+
+	chart := dashboardChart{}
+	for _, cur := range current {
+		var prev models.RoomStats
+		for _, p := range previous {
+			if p.RoomID == cur.RoomID {
+				prev = p
+			}
+		}
+		curFigures, prevFigures := reports.Compute(cur), reports.Compute(prev)
+		rooms = append(rooms, dashboardRoom{Name: cur.RoomName, Figures: compareFigures(curFigures, prevFigures)})
+
+		chart.Rooms = append(chart.Rooms, cur.RoomName)
+		chart.RoomOccupancy = append(chart.RoomOccupancy, curFigures.Occupancy)
+		chart.RoomOccupancyWas = append(chart.RoomOccupancyWas, prevFigures.Occupancy)
+	}
+
+	for i := len(periods) - 1; i >= 0; i-- {
+		f := reports.Compute(reports.Sum(periods[i]))
+		chart.Labels = append(chart.Labels, labels[i])
+		chart.Occupancy = append(chart.Occupancy, f.Occupancy)
+		chart.ADR = append(chart.ADR, float64(f.ADR)/100)
+		chart.RevPAR = append(chart.RevPAR, float64(f.RevPAR)/100)
+	}
+
+	layout := "2006-01-02"
+	stringMap := make(map[string]string)
+	stringMap["period"] = kind
+	stringMap["label"] = labels[0]
+	stringMap["previous_label"] = labels[1]
+	stringMap["date"] = start.Format(layout)
+	stringMap["previous"] = start.AddDate(0, 0, -1).Format(layout)
+	stringMap["next"] = end.Format(layout)
+	if sellable {
+		stringMap["sellable"] = "1"
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["chart"] = chart
+
+	render.Template(w, r, "admin-dashboard.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminToday lists the arrivals, departures and stay-overs of a day, owner blocks starting or ending that day
+// and the rooms which are free that night; ?print=1 renders a printable sheet
+func (m *Repository) AdminToday(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminToday")
+	layout := "2006-01-02"
+
+	now := time.Now()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if d, err := time.Parse(layout, r.URL.Query().Get("date")); err == nil {
+		day = d
+	}
+	// dates formatted this way compare in calendar order
+	today := day.Format(layout)
+
+	// the rooms not ready below include those still to be cleaned today
+	err := m.GenerateHousekeepingTasks(day)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	statuses, err := m.DB.HousekeepingStatuses(day)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var arrivals, departures, stayOvers, blocks []models.RoomRestriction
+	var free []models.Room
+	notReady := make(map[int]bool)
+
+	for _, room := range rooms {
+		// everything in the room from yesterday's night to tonight
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, day.AddDate(0, 0, -1), day)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		occupied := false
+		for _, restriction := range restrictions {
+			start, end := restriction.StartDate.Format(layout), restriction.EndDate.Format(layout)
+			if start > today || end < today {
+				continue
+			}
+			restriction.Room = room
+
+			if restriction.ReservationID == 0 {
+				occupied = occupied || end > today
+				if restriction.RestrictionID == models.RestrictionOwnerBlock && (start == today || end == today) {
+					blocks = append(blocks, restriction)
+				}
+				continue
+			}
+
+			res, err := m.DB.GetReservationByID(restriction.ReservationID)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			if res.Status == models.ReservationStatusCancelled {
+				continue
+			}
+			restriction.Reservation = res
+			occupied = occupied || end > today
+
+			switch {
+			case start == today:
+				arrivals = append(arrivals, restriction)
+				notReady[room.ID] = !roomReady(statuses[room.ID])
+			case end == today:
+				departures = append(departures, restriction)
+			default:
+				stayOvers = append(stayOvers, restriction)
+			}
+		}
+
+		if !occupied {
+			free = append(free, room)
+		}
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["date"] = today
+	stringMap["previous"] = day.AddDate(0, 0, -1).Format(layout)
+	stringMap["next"] = day.AddDate(0, 0, 1).Format(layout)
+	stringMap["label"] = day.Format("Monday, January 2, 2006")
+
+	data := make(map[string]interface{})
+	data["arrivals"] = arrivals
+	data["departures"] = departures
+	data["stay_overs"] = stayOvers
+	data["blocks"] = blocks
+	data["free"] = free
+	data["not_ready"] = notReady
+
+	page := "admin-today.page.html"
+	if r.URL.Query().Get("print") != "" {
+		page = "admin-today-print.page.html"
+	}
+
+	render.Template(w, r, page, &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminPostCheckIn records the arrival of the guest of a reservation
+func (m *Repository) AdminPostCheckIn(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostCheckIn")
+	m.checkInOrOut(w, r, true)
+}
+
+// AdminPostCheckOut records the departure of the guest of a reservation
+func (m *Repository) AdminPostCheckOut(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostCheckOut")
+	m.checkInOrOut(w, r, false)
+}
+
+// checkInOrOut checks the guest of the reservation in the URL in or out and goes back to the day it was done from
+func (m *Repository) checkInOrOut(w http.ResponseWriter, r *http.Request, in bool) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	back := "/admin/today"
+	if d, err := time.Parse("2006-01-02", r.Form.Get("date")); err == nil {
+		back = "/admin/today?date=" + d.Format("2006-01-02")
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if res.Status == models.ReservationStatusCancelled {
+		m.App.Session.Put(r.Context(), "error", "This reservation has been cancelled")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	if in {
+		if !res.CheckedInAt.IsZero() {
+			m.App.Session.Put(r.Context(), "error", "The guest has already checked in")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		err = m.DB.CheckInReservation(id)
+	} else {
+		if !res.CheckedOutAt.IsZero() {
+			m.App.Session.Put(r.Context(), "error", "The guest has already checked out")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		err = m.DB.CheckOutReservation(id)
+		if err == nil {
+			// the room has to be cleaned before the next guest
+			now := time.Now()
+			err = m.DB.UpsertHousekeepingTask(models.HousekeepingTask{
+				RoomID:        res.RoomID,
+				ReservationID: id,
+				Day:           time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+				Kind:          models.HousekeepingTaskDeparture,
+				Status:        models.HousekeepingStatusDirty,
+			})
+		}
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if in {
+		m.App.Session.Put(r.Context(), "flash", "Checked in")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Checked out")
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// housekeepingServiceEvery is after how many nights the room of a guest staying on is cleaned
+const housekeepingServiceEvery = 3
+
+// housekeepingStatuses are what housekeepers can mark a room as, in the order a room goes through them
+var housekeepingStatuses = []string{
+	models.HousekeepingStatusDirty,
+	models.HousekeepingStatusInProgress,
+	models.HousekeepingStatusClean,
+	models.HousekeepingStatusInspected,
+}
+
+// housekeepingLabels names the housekeeping statuses and task kinds
+var housekeepingLabels = map[string]string{
+	models.HousekeepingStatusDirty:      "Dirty",
+	models.HousekeepingStatusInProgress: "In progress",
+	models.HousekeepingStatusClean:      "Clean",
+	models.HousekeepingStatusInspected:  "Inspected",
+	models.HousekeepingTaskDeparture:    "Departure",
+	models.HousekeepingTaskService:      "Service",
+	models.HousekeepingTaskManual:       "Manual",
+}
+
+// roomReady reports whether a room with a housekeeping status can take the next guest; rooms never cleaned by us can
+func roomReady(status string) bool {
+	return status == "" || status == models.HousekeepingStatusClean || status == models.HousekeepingStatusInspected
+}
+
+// housekeepingRoom is a room on the housekeeping board
+type housekeepingRoom struct {
+	Room    models.Room
+	Status  string
+	Ready   bool
+	Arrival bool
+	Tasks   []models.HousekeepingTask
+}
+
+// housekeepingDay returns the day in the query or form, or today
+func housekeepingDay(value string) time.Time {
+	if d, err := time.Parse("2006-01-02", value); err == nil {
+		return d
+	}
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// GenerateHousekeepingTasks creates the departure and service cleans of a day which do not exist yet
+func (m *Repository) GenerateHousekeepingTasks(day time.Time) error {
+	return m.DB.GenerateHousekeepingTasks(day, housekeepingServiceEvery)
+}
+
+// Housekeeping shows housekeepers every room with its state and the cleaning tasks of a day
+func (m *Repository) Housekeeping(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("Housekeeping")
+	day := housekeepingDay(r.URL.Query().Get("date"))
+
+	err := m.GenerateHousekeepingTasks(day)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	tasks, err := m.DB.HousekeepingTasksByDay(day)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	statuses, err := m.DB.HousekeepingStatuses(day)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var board []housekeepingRoom
+	for _, room := range rooms {
+		hr := housekeepingRoom{Room: room, Status: statuses[room.ID], Ready: roomReady(statuses[room.ID])}
+		for _, t := range tasks {
+			if t.RoomID == room.ID {
+				hr.Tasks = append(hr.Tasks, t)
+			}
+		}
+
+		// rooms with a guest arriving should be done first
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, day, day)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		for _, restriction := range restrictions {
+			if restriction.ReservationID > 0 && restriction.StartDate.Format("2006-01-02") == day.Format("2006-01-02") {
+				hr.Arrival = true
+			}
+		}
+
+		board = append(board, hr)
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["date"] = day.Format("2006-01-02")
+	stringMap["previous"] = day.AddDate(0, 0, -1).Format("2006-01-02")
+	stringMap["next"] = day.AddDate(0, 0, 1).Format("2006-01-02")
+	stringMap["label"] = day.Format("Mon, Jan 2")
+
+	data := make(map[string]interface{})
+	data["rooms"] = board
+	data["statuses"] = housekeepingStatuses
+	data["labels"] = housekeepingLabels
+
+	render.Template(w, r, "housekeeping.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// PostHousekeepingRoom marks a room dirty, in progress, clean or inspected along with its tasks of the day
+func (m *Repository) PostHousekeepingRoom(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("PostHousekeepingRoom")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	day := housekeepingDay(r.Form.Get("date"))
+	back := "/housekeeping?date=" + day.Format("2006-01-02")
+
+	status := r.Form.Get("status")
+	valid := false
+	for _, s := range housekeepingStatuses {
+		valid = valid || s == status
+	}
+	if !valid {
+		m.App.Session.Put(r.Context(), "error", "Unknown housekeeping status")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	tasks, err := m.DB.HousekeepingTasksByDay(day)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	updated := false
+	for _, t := range tasks {
+		if t.RoomID != roomID {
+			continue
+		}
+		err = m.DB.UpdateHousekeepingTaskStatus(t.ID, status)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		updated = true
+	}
+
+	// a room nobody asked to be cleaned gets a task of its own
+	if !updated {
+		err = m.DB.UpsertHousekeepingTask(models.HousekeepingTask{
+			RoomID: roomID,
+			Day:    day,
+			Kind:   models.HousekeepingTaskManual,
+			Status: status,
+		})
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room marked "+strings.ToLower(housekeepingLabels[status]))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminNewReservations shows all new reservations in admin tool
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminNewReservations")
+	m.renderReservationList(w, r, "new", "admin-new-reservations.page.html")
+}
+
+// exportResponse remembers whether any part of an export has reached the client
+type exportResponse struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (e *exportResponse) Write(b []byte) (int, error) {
+	e.started = true
+	return e.ResponseWriter.Write(b)
+}
+
+// writeExport streams a table as a download in the format named by the last part of the path, csv or xlsx
+func (m *Repository) writeExport(w http.ResponseWriter, r *http.Request, name string, write func(export.Writer) error) {
+	exploded := strings.Split(r.URL.Path, "/")
+	m.writeExportAs(w, exploded[len(exploded)-1], name, write)
+}
+
+// writeExportAs streams a table as a download in the given format
+func (m *Repository) writeExportAs(w http.ResponseWriter, format, name string, write func(export.Writer) error) {
+	contentType, ok := export.ContentType(format)
+	if !ok {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s-%s.%s"`, name, time.Now().Format("2006-01-02"), format))
+
+	out := &exportResponse{ResponseWriter: w}
+	x, err := export.New(format, out, name)
+	if err == nil {
+		err = write(x)
+	}
+	if err == nil {
+		err = x.Close()
+	}
+	if err != nil {
+		if !out.started {
+			w.Header().Del("Content-Disposition")
+			helpers.ServerError(w, err)
+			return
+		}
+		// the download has begun, so all we can do is cut it short
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// AdminExportReservations downloads the reservations matching the filters of the reservation lists
+func (m *Repository) AdminExportReservations(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminExportReservations")
+	q := r.URL.Query()
+	f := reservationFilterFromQuery(q)
+	f.NewOnly = q.Get("new") == "1"
+
+	exploded := strings.Split(r.URL.Path, "/")
+	m.exportReservations(w, exploded[len(exploded)-1], f)
+}
+
+// exportReservations streams the reservations matching a filter as a download in the given format
+func (m *Repository) exportReservations(w http.ResponseWriter, format string, f models.ReservationFilter) {
+	m.writeExportAs(w, format, "reservations", func(x export.Writer) error {
+		err := x.Header("ID", "First name", "Last name", "Email", "Phone", "Room", "Arrival", "Departure",
+			"Nights", "Guests", "Status", "Total", "Tags", "Booked")
+		if err != nil {
+			return err
+		}
+
+		return m.DB.EachReservation(f, func(res models.Reservation) error {
+			return x.Row(res.ID, res.FirstName, res.LastName, res.Email, res.Phone, res.Room.RoomName, res.StartDate, res.EndDate,
+				pricing.Nights(res.StartDate, res.EndDate), res.Guests, res.Status, export.Money(res.TotalAmount),
+				strings.Join(res.Tags, ", "), res.CreatedAt)
+		})
+	})
+}
+
+// AdminExportGuests downloads the guest list
+func (m *Repository) AdminExportGuests(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminExportGuests")
+	m.writeExport(w, r, "guests", func(x export.Writer) error {
+		err := x.Header("ID", "First name", "Last name", "Email", "Phone", "Flag", "Stays", "Preferences", "Notes", "Guest since")
+		if err != nil {
+			return err
+		}
+
+		return m.DB.EachGuest(func(g models.Guest) error {
+			return x.Row(g.ID, g.FirstName, g.LastName, g.Email, g.Phone, g.Flag, g.Stays, g.Preferences, g.Notes, g.CreatedAt)
+		})
+	})
+}
+
+// AdminExportMovements downloads the arrivals and departures between the start and end dates of the query
+func (m *Repository) AdminExportMovements(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminExportMovements")
+	layout := "2006-01-02"
+	start, err := time.Parse(layout, r.URL.Query().Get("start"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Choose the first day of the export")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+	end, err := time.Parse(layout, r.URL.Query().Get("end"))
+	if err != nil || end.Before(start) {
+		m.App.Session.Put(r.Context(), "error", "Choose a last day of the export on or after the first")
+		http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+		return
+	}
+
+	m.writeExport(w, r, "arrivals-departures", func(x export.Writer) error {
+		err := x.Header("Date", "Movement", "Room", "First name", "Last name", "Email", "Phone", "Guests",
+			"Arrival", "Departure", "Nights", "Reservation")
+		if err != nil {
+			return err
+		}
+
+		return m.DB.EachMovement(start, end, func(kind string, res models.Reservation) error {
+			day := res.StartDate
+			if kind == "departure" {
+				day = res.EndDate
+			}
+			return x.Row(day, kind, res.Room.RoomName, res.FirstName, res.LastName, res.Email, res.Phone, res.Guests,
+				res.StartDate, res.EndDate, pricing.Nights(res.StartDate, res.EndDate), res.ID)
+		})
+	})
+}
+
+// parseTags turns comma separated input into lower case tags without blanks or duplicates
+func parseTags(input string) []string {
+	seen := make(map[string]bool)
+	var tags []string
+	for _, t := range strings.Split(input, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		tags = append(tags, t)
+	}
+	return tags
+}
+
+// AdminAllReservations shows all reservations in admin tool
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminAllReservations")
+	m.renderReservationList(w, r, "all", "admin-all-reservations.page.html")
+}
+
+// reservationsPerPage is the page size of the admin reservation lists
+const reservationsPerPage = 25
+
+// reservationFilterFromQuery reads the search form of the admin reservation lists; dates which do not parse are ignored
+func reservationFilterFromQuery(q url.Values) models.ReservationFilter {
+	layout := "2006-01-02"
+	f := models.ReservationFilter{
+		Search:  strings.TrimSpace(q.Get("q")),
+		Status:  q.Get("status"),
+		Tag:     strings.ToLower(strings.TrimSpace(q.Get("tag"))),
+		Sort:    q.Get("sort"),
+		Desc:    q.Get("dir") == "desc",
+		PerPage: reservationsPerPage,
+	}
+	f.RoomID, _ = strconv.Atoi(q.Get("room"))
+	f.From, _ = time.Parse(layout, q.Get("from"))
+	f.To, _ = time.Parse(layout, q.Get("to"))
+	f.CreatedFrom, _ = time.Parse(layout, q.Get("created_from"))
+	f.CreatedTo, _ = time.Parse(layout, q.Get("created_to"))
+	f.Page, _ = strconv.Atoi(q.Get("page"))
+	if f.Page < 1 {
+		f.Page = 1
+	}
+	return f
+}
+
+// listURL returns a link to a reservation list with some query parameters changed; empty values are dropped
+func listURL(base string, q url.Values, set map[string]string) template.URL {
+	v := url.Values{}
+	for key, values := range q {
+		v[key] = values
+	}
+	for key, value := range set {
+		if value == "" {
+			v.Del(key)
+		} else {
+			v.Set(key, value)
+		}
+	}
+	if len(v) == 0 {
+		return template.URL(base)
+	}
+	return template.URL(base + "?" + v.Encode())
+}
+
+// renderReservationList renders one page of an admin reservation list, searched and sorted as the query asks
+func (m *Repository) renderReservationList(w http.ResponseWriter, r *http.Request, src, tmpl string) {
+	q := r.URL.Query()
+	f := reservationFilterFromQuery(q)
+	f.NewOnly = src == "new"
+
+	reservations, total, err := m.DB.SearchReservations(f)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	pages := (total + f.PerPage - 1) / f.PerPage
+	base := "/admin/reservations-" + src
+
+	// clicking the sorted column again flips the direction
+	sortLinks := make(map[string]template.URL)
+	for _, column := range []string{"id", "last_name", "room", "start_date", "end_date", "status", "created_at"} {
+		dir := ""
+		if column == f.Sort && !f.Desc {
+			dir = "desc"
+		}
+		sortLinks[column] = listURL(base, q, map[string]string{"sort": column, "dir": dir, "page": ""})
+	}
+
+	stringMap := make(map[string]string)
+	for _, key := range []string{"q", "room", "status", "tag", "from", "to", "created_from", "created_to", "sort", "dir"} {
+		stringMap[key] = q.Get(key)
+	}
+
+	intMap := make(map[string]int)
+	intMap["page"] = f.Page
+	intMap["pages"] = pages
+	intMap["total"] = total
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["rooms"] = rooms
+	data["sort_links"] = sortLinks
+	if f.Page > 1 {
+		data["prev"] = listURL(base, q, map[string]string{"page": strconv.Itoa(f.Page - 1)})
+	}
+	if f.Page < pages {
+		data["next"] = listURL(base, q, map[string]string{"page": strconv.Itoa(f.Page + 1)})
+	}
+	newOnly := ""
+	if f.NewOnly {
+		newOnly = "1"
+	}
+	for _, format := range []string{"csv", "xlsx"} {
+		data["export_"+format] = listURL("/admin/export/reservations/"+format, q, map[string]string{"page": "", "new": newOnly})
+	}
+
+	render.Template(w, r, tmpl, &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+	})
+}
+
+// AdminShowReservation shows the reservation in the admin tool
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminShowReservation")
+	exploded := strings.Split(r.RequestURI, "/")
+
+	src := exploded[3]
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	// get year and month if previous page is calendar
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	stringMap["year"] = year
+	stringMap["month"] = month
+
+	// get reservation from database
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservation, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	payments, err := m.DB.GetPaymentsByReservationID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	invoices, err := m.DB.GetInvoicesByReservationID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = reservation
+	data["payments"] = payments
+	data["invoices"] = invoices
+	data["open_invoices"] = len(invoice.Open(invoices))
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data["rooms"] = rooms
+
+	notes, err := m.DB.GetNotesByReservationID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data["notes"] = notes
+
+	sent, err := m.DB.GetMessagesByReservationID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data["messages"] = sent
+
+	texts, err := m.DB.GetSMSMessagesByReservationID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data["sms"] = texts
+
+	if reservation.GuestID > 0 {
+		guest, err := m.DB.GetGuestByID(reservation.GuestID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["guest"] = guest
+	}
+
+	render.Template(w, r, "admin-reservations-show.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      forms.New(nil),
+	})
+}
+
+// AdminPostShowReservation shows the reservation in the admin tool
+func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostShowReservation")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+
+	src := exploded[3]
+
+	// get reservation from database
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservation, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the version the form was drawn from; saving is refused when someone else saved the reservation since
+	reservation.Version, err = strconv.Atoi(r.Form.Get("version"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	reservation.FirstName = r.Form.Get("first_name")
+	reservation.LastName = r.Form.Get("last_name")
+	reservation.Email = r.Form.Get("email")
+
+	back := adminReservationURL(src, id, r.Form.Get("year"), r.Form.Get("month"))
+
+	form := forms.New(r.PostForm)
+	form.IsPhone("phone", m.App.PhoneCountryCode)
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", form.Errors.Get("phone"))
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	reservation.Phone = form.Get("phone")
+
+	// dates and room only change when the form carries new ones
+	layout := "2006-01-02"
+	startDate, endDate, roomID := reservation.StartDate, reservation.EndDate, reservation.RoomID
+	if sd := r.Form.Get("start_date"); sd != "" {
+		startDate, err = time.Parse(layout, sd)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	if ed := r.Form.Get("end_date"); ed != "" {
+		endDate, err = time.Parse(layout, ed)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	if rid := r.Form.Get("room_id"); rid != "" {
+		roomID, err = strconv.Atoi(rid)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	moved := !startDate.Equal(reservation.StartDate) || !endDate.Equal(reservation.EndDate) || roomID != reservation.RoomID
+	previous := reservation
+	if moved {
+		if reservation.Status == models.ReservationStatusCancelled {
+			m.App.Session.Put(r.Context(), "error", "A cancelled reservation cannot be moved")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		if !endDate.After(startDate) {
+			m.App.Session.Put(r.Context(), "error", "Departure must be after arrival")
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+
+		room, err := m.DB.GetRoomByID(roomID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		reservation.StartDate = startDate
+		reservation.EndDate = endDate
+		reservation.RoomID = roomID
+		reservation.Room = room
+
+		err = m.priceMove(&reservation)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		err = m.DB.MoveReservation(reservation)
+		if err == repository.ErrRoomNotAvailable {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s is not available for those dates", room.RoomName))
+			http.Redirect(w, r, back, http.StatusSeeOther)
+			return
+		}
+		if err == repository.ErrVersionConflict {
+			m.renderReservationConflict(w, r, id, src, reservation)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		reservation.Version++
+	}
+
+	err = m.DB.UpdateReservation(reservation)
+	if err == repository.ErrVersionConflict {
+		m.renderReservationConflict(w, r, id, src, reservation)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.SetReservationTags(id, parseTags(r.Form.Get("tags")))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if moved {
+		if r.Form.Get("notify_guest") != "" {
+			m.sendReservationMovedMail(previous, reservation)
+		}
+		// the old dates may have freed a room someone is waiting for
+		m.NotifyWaitlist()
+	}
+
+	year := r.Form.Get("year")
+	month := r.Form.Get("month")
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+}
+
+// reservationConflictField is a field of a reservation as an edit would have saved it and as it is stored now
+type reservationConflictField struct {
+	Name   string
+	Mine   string
+	Theirs string
+}
+
+// priceMove prices a reservation again for the room and dates it is moving to, with the taxes and fees in effect now
+func (m *Repository) priceMove(reservation *models.Reservation) error {
+	rules, err := m.DB.AllTaxFeeRules()
+	if err != nil {
+		return err
+	}
+
+	quote := pricing.NewQuote(reservation.Room, reservation.StartDate, reservation.EndDate, reservation.Guests, rules)
+	reservation.TotalAmount = quote.Total
+	reservation.Charges = quote.Charges
+	return nil
+}
+
+// renderReservationConflict shows an edit refused because someone else saved the reservation first next to
+// what is stored now, with a form to save the edit again over the current version
+func (m *Repository) renderReservationConflict(w http.ResponseWriter, r *http.Request, id int, src string, mine models.Reservation) {
+	theirs, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	layout := "2006-01-02"
+	fields := []reservationConflictField{
+		{Name: "First name", Mine: mine.FirstName, Theirs: theirs.FirstName},
+		{Name: "Last name", Mine: mine.LastName, Theirs: theirs.LastName},
+		{Name: "Email", Mine: mine.Email, Theirs: theirs.Email},
+		{Name: "Phone", Mine: mine.Phone, Theirs: theirs.Phone},
+		{Name: "Arrival", Mine: mine.StartDate.Format(layout), Theirs: theirs.StartDate.Format(layout)},
+		{Name: "Departure", Mine: mine.EndDate.Format(layout), Theirs: theirs.EndDate.Format(layout)},
+		{Name: "Room", Mine: mine.Room.RoomName, Theirs: theirs.Room.RoomName},
+		{Name: "Tags", Mine: strings.Join(parseTags(r.Form.Get("tags")), ", "), Theirs: strings.Join(theirs.Tags, ", ")},
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+	stringMap["year"] = r.Form.Get("year")
+	stringMap["month"] = r.Form.Get("month")
+	stringMap["tags"] = r.Form.Get("tags")
+	stringMap["back"] = adminReservationURL(src, id, r.Form.Get("year"), r.Form.Get("month"))
+
+	mine.ID = id
+	mine.Version = theirs.Version
+
+	data := make(map[string]interface{})
+	data["fields"] = fields
+	data["mine"] = mine
+
+	w.WriteHeader(http.StatusConflict)
+	render.Template(w, r, "admin-reservation-conflict.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminPostReservationNote adds an internal note, signed by the logged in user, to a reservation
+func (m *Repository) AdminPostReservationNote(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostReservationNote")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+	src := exploded[3]
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	back := adminReservationURL(src, id, r.Form.Get("year"), r.Form.Get("month"))
+
+	body := strings.TrimSpace(r.Form.Get("body"))
+	if body == "" {
+		m.App.Session.Put(r.Context(), "error", "A note cannot be blank")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	_, err = m.DB.InsertReservationNote(models.ReservationNote{
+		ReservationID: id,
+		UserID:        m.App.Session.GetInt(r.Context(), "user_id"),
+		Body:          body,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Note added")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// sendGuestMessage fills the placeholders of a message for a reservation, records it on the reservation and mails it to the guest
+func (m *Repository) sendGuestMessage(userID int, res models.Reservation, subject, body string) error {
+	msg := models.ReservationMessage{
+		ReservationID: res.ID,
+		UserID:        userID,
+		Recipient:     res.Email,
+		Subject:       messages.Fill(subject, res),
+		Body:          messages.Fill(body, res),
+	}
+
+	_, err := m.DB.InsertReservationMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	m.App.MailChan <- models.MailData{
+		To:       msg.Recipient,
+		From:     "me@here.com",
+		Subject:  msg.Subject,
+		Content:  messages.HTML(msg.Body),
+		Template: "basic.html",
+	}
+	return nil
+}
+
+// composeRecipients returns the reservations with the ids of a compose form in the order they were given
+func (m *Repository) composeRecipients(values []string) ([]models.Reservation, error) {
+	var ids []int
+	for _, value := range values {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	found := make(map[int]models.Reservation)
+	err := m.DB.EachReservation(models.ReservationFilter{IDs: ids}, func(res models.Reservation) error {
+		found[res.ID] = res
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []models.Reservation
+	for _, id := range ids {
+		if res, ok := found[id]; ok {
+			recipients = append(recipients, res)
+		}
+	}
+	return recipients, nil
+}
+
+// AdminComposeMessage shows the form to write an email to the guests of one or more reservations,
+// filled in from a saved template when one is chosen
+func (m *Repository) AdminComposeMessage(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminComposeMessage")
+	q := r.URL.Query()
+
+	recipients, err := m.composeRecipients(q["ids"])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if len(recipients) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Select the reservations first")
+		http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
+		return
+	}
+
+	values := url.Values{}
+	for _, key := range []string{"src", "y", "m"} {
+		values.Set(key, q.Get(key))
+	}
+
+	if q.Get("template") != "" {
+		id, err := strconv.Atoi(q.Get("template"))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		t, err := m.DB.GetMessageTemplateByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		values.Set("template", q.Get("template"))
+		values.Set("subject", t.Subject)
+		values.Set("body", t.Body)
+	}
+
+	m.renderComposeMessage(w, r, recipients, forms.New(values), false)
+}
+
+// AdminPostComposeMessage previews an email for the first guest or sends it to every guest. An email to
+// one guest goes back to the reservation; an email to many is run as a bulk action
+func (m *Repository) AdminPostComposeMessage(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostComposeMessage")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	recipients, err := m.composeRecipients(r.Form["ids"])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if len(recipients) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Select the reservations first")
+		http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("subject", "body")
+
+	if !form.Valid() || r.Form.Get("do") != "send" {
+		m.renderComposeMessage(w, r, recipients, form, form.Valid())
+		return
+	}
+
+	subject := strings.TrimSpace(r.Form.Get("subject"))
+	body := strings.TrimSpace(r.Form.Get("body"))
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+
+	if len(recipients) > 1 {
+		b := models.BulkAction{UserID: userID, Action: models.BulkActionEmail, Detail: subject}
+		var ids []int
+		for _, res := range recipients {
+			ids = append(ids, res.ID)
+		}
+		m.runBulkAction(w, r, b, ids, body)
+		return
+	}
+
+	res := recipients[0]
+	back := adminReservationURL(r.Form.Get("src"), res.ID, r.Form.Get("y"), r.Form.Get("m"))
+
+	if res.Email == "" {
+		m.App.Session.Put(r.Context(), "error", "The guest has no email address")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	err = m.sendGuestMessage(userID, res, subject, body)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Email sent to %s", res.Email))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// renderComposeMessage renders the compose form, with the message as the first guest would get it when previewing
+func (m *Repository) renderComposeMessage(w http.ResponseWriter, r *http.Request, recipients []models.Reservation, form *forms.Form, preview bool) {
+	templates, err := m.DB.AllMessageTemplates()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["recipients"] = recipients
+	data["templates"] = templates
+	data["placeholders"] = messages.Placeholders
+	if preview {
+		data["preview"] = models.ReservationMessage{
+			Recipient: recipients[0].Email,
+			Subject:   messages.Fill(form.Get("subject"), recipients[0]),
+			Body:      messages.Fill(form.Get("body"), recipients[0]),
+		}
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["back"] = "/admin/reservations-all"
+	if form.Get("src") == "new" {
+		stringMap["back"] = "/admin/reservations-new"
+	}
+	if len(recipients) == 1 {
+		stringMap["back"] = adminReservationURL(form.Get("src"), recipients[0].ID, form.Get("y"), form.Get("m"))
+	}
+
+	render.Template(w, r, "admin-compose-message.page.html", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminReservationsCalendar displays the reservation calendar
+func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminReservationsCalendar")
+	// assume that there is no month/year specified
+	now := time.Now()
+
+	if r.URL.Query().Get("y") != "" {
+		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
+		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
+		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	next := now.AddDate(0, 1, 0)
+	last := now.AddDate(0, -1, 0)
+
+	stringMap := make(map[string]string)
+	stringMap["this_month"] = now.Format("01")
+	stringMap["this_month_year"] = now.Format("2006")
+	stringMap["next_month"] = next.Format("01")
+	stringMap["next_month_year"] = next.Format("2006")
+	stringMap["last_month"] = last.Format("01")
+	stringMap["last_month_year"] = last.Format("2006")
+
+	data := make(map[string]interface{})
+	data["now"] = now
+
+	// get the first and last days of the month
+	currentYear, currentMonth, _ := now.Date()
+	currentLocation := now.Location()
+	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
+	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+
+	intMap := make(map[string]int)
+	intMap["last_of_month"] = lastOfMonth.Day()
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data["rooms"] = rooms
+
+	for _, room := range rooms {
+		reservaitonMap := make(map[string]int)
+		blockMap := make(map[string]int)
+		outOfOrderMap := make(map[string]int)
+
+		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
+			reservaitonMap[d.Format("2006-01-2")] = 0
+			blockMap[d.Format("2006-01-2")] = 0
+			outOfOrderMap[d.Format("2006-01-2")] = 0
+		}
+
+		// get all the restrictions for the current room
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, firstOfMonth, lastOfMonth)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		for _, restriction := range restrictions {
+			if restriction.ReservationID > 0 {
+				// it's a reservation
+				for d := restriction.StartDate; !d.After(restriction.EndDate.AddDate(0, 0, -1)); d = d.AddDate(0, 0, 1) {
+					reservaitonMap[d.Format("2006-01-2")] = restriction.ReservationID
+				}
+			} else if restriction.RestrictionID == models.RestrictionOutOfOrder {
+				// out of order for maintenance, which is not touched by the block checkboxes
+				for d := restriction.StartDate; !d.After(restriction.EndDate.AddDate(0, 0, -1)); d = d.AddDate(0, 0, 1) {
+					outOfOrderMap[d.Format("2006-01-2")] = restriction.ID
+				}
+			} else {
+				// it's a block, which may last several nights when made on the timeline
+				for d := restriction.StartDate; !d.After(restriction.EndDate.AddDate(0, 0, -1)); d = d.AddDate(0, 0, 1) {
+					blockMap[d.Format("2006-01-2")] = restriction.ID
+				}
+			}
+		}
+
+		data[fmt.Sprintf("reservation_map_%d", room.ID)] = reservaitonMap
+		data[fmt.Sprintf("block_map_%d", room.ID)] = blockMap
+		data[fmt.Sprintf("out_of_order_map_%d", room.ID)] = outOfOrderMap
+	}
+
+	render.Template(w, r, "admin-reservations-calendar.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		IntMap:    intMap,
+	})
+}
+
+// AdminProcessReservation marks a reservation as processed
+func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminProcessReservation")
+	// use chi's method
+	// id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+	// src := chi.URLParam(r, "src")
+
+	// without chi's method
+	exploded := strings.Split(r.RequestURI, "/")
+	id, _ := strconv.Atoi(exploded[4])
+	src := exploded[3]
+	_ = m.DB.UpdateProcessedForReservation(id, 1)
+
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation marked as process")
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+}
+
+// AdminPostDeleteReservation moves a reservation to the trash
+func (m *Repository) AdminPostDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostDeleteReservation")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+	src := exploded[3]
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteReservation(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.NotifyWaitlist()
+
+	year := r.Form.Get("year")
+	month := r.Form.Get("month")
+
+	m.App.Session.Put(r.Context(), "flash", "Reservation moved to the trash")
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+}
+
+// AdminPostBulkReservations runs one action on all reservations selected in an admin list. The changes are
+// made in one transaction and recorded as one batch, which shows what happened to each reservation
+func (m *Repository) AdminPostBulkReservations(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostBulkReservations")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := r.Form.Get("src")
+	if src != "new" {
+		src = "all"
+	}
+	back := "/admin/reservations-" + src
+
+	var ids []int
+	for _, value := range r.Form["ids"] {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Select the reservations first")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	b := models.BulkAction{
+		UserID: m.App.Session.GetInt(r.Context(), "user_id"),
+		Action: r.Form.Get("action"),
+	}
+
+	// emails are written and previewed on their own page first
+	if b.Action == models.BulkActionEmail {
+		q := url.Values{"src": {src}, "ids": r.Form["ids"]}
+		http.Redirect(w, r, "/admin/messages/new?"+q.Encode(), http.StatusSeeOther)
+		return
+	}
+
+	problem := ""
+	switch b.Action {
+	case models.BulkActionProcess, models.BulkActionCancel:
+	case models.BulkActionTag:
+		b.Detail = strings.Join(parseTags(r.Form.Get("tag")), ",")
+		if b.Detail == "" {
+			problem = "Enter the tags to add"
+		}
+	case models.BulkActionExport:
+		b.Detail = r.Form.Get("format")
+		if _, ok := export.ContentType(b.Detail); !ok {
+			problem = "Choose the format of the export"
+		}
+	default:
+		problem = "Choose an action"
+	}
+	if problem != "" {
+		m.App.Session.Put(r.Context(), "error", problem)
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	m.runBulkAction(w, r, b, ids, "")
+}
+
+// runBulkAction applies a bulk action to the reservations with the given ids, then shows the outcome
+// of the batch; message is the body of the email of an email action
+func (m *Repository) runBulkAction(w http.ResponseWriter, r *http.Request, b models.BulkAction, ids []int, message string) {
+	reservations := make(map[int]models.Reservation)
+	err := m.DB.EachReservation(models.ReservationFilter{IDs: ids}, func(res models.Reservation) error {
+		reservations[res.ID] = res
+		return nil
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	for _, id := range ids {
+		item := models.BulkActionItem{ReservationID: id, OK: true}
+		res, found := reservations[id]
+		item.Guest = strings.TrimSpace(res.FirstName + " " + res.LastName)
+
+		// whether a reservation can be cancelled without a refund is checked by RunBulkAction, under its lock
+		switch {
+		case !found:
+			item.Message = "Reservation not found"
+		case b.Action == models.BulkActionEmail && res.Email == "":
+			item.Message = "The guest has no email address"
+		}
+
+		item.OK = item.Message == ""
+		b.Items = append(b.Items, item)
+	}
+
+	b, err = m.DB.RunBulkAction(b)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var done []int
+	for _, item := range b.Items {
+		if item.OK {
+			done = append(done, item.ReservationID)
+		}
+	}
+
+	switch b.Action {
+	case models.BulkActionCancel:
+		for _, id := range done {
+			m.sendCancellationMails(reservations[id], 0, 0)
+		}
+		if len(done) > 0 {
+			m.NotifyWaitlist()
+		}
+	case models.BulkActionEmail:
+		for _, id := range done {
+			err = m.sendGuestMessage(b.UserID, reservations[id], b.Detail, message)
+			if err != nil {
+				m.App.ErrorLog.Println(err)
+			}
+		}
+	case models.BulkActionExport:
+		if len(done) > 0 {
+			m.exportReservations(w, b.Detail, models.ReservationFilter{IDs: done})
+			return
+		}
+	}
+
+	if failed := b.Failed(); failed > 0 {
+		m.App.Session.Put(r.Context(), "warning",
+			fmt.Sprintf("Done for %d of %d reservations, see below why the others failed", len(b.Items)-failed, len(b.Items)))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Done for %d reservations", len(b.Items)))
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/bulk-actions/%d", b.ID), http.StatusSeeOther)
+}
+
+// AdminBulkActions shows the latest batches of bulk actions
+func (m *Repository) AdminBulkActions(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminBulkActions")
+	batches, err := m.DB.RecentBulkActions(100)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["batches"] = batches
+
+	render.Template(w, r, "admin-bulk-actions.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminBulkAction shows what a batch of a bulk action did to each of its reservations
+func (m *Repository) AdminBulkAction(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminBulkAction")
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	b, err := m.DB.GetBulkActionByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["batch"] = b
+
+	render.Template(w, r, "admin-bulk-action.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminTrash shows the deleted reservations and blocks which can still be restored
+func (m *Repository) AdminTrash(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminTrash")
+	reservations, err := m.DB.TrashedReservations()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	blocks, err := m.DB.TrashedBlocks()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["blocks"] = blocks
+
+	intMap := make(map[string]int)
+	intMap["retention_days"] = int(m.App.TrashRetention.Hours() / 24)
+
+	render.Template(w, r, "admin-trash.page.html", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// AdminPostRestoreReservation takes a reservation out of the trash
+func (m *Repository) AdminPostRestoreReservation(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostRestoreReservation")
+	m.restoreFromTrash(w, r, m.DB.RestoreReservation, "Reservation restored")
+}
+
+// AdminPostRestoreBlock takes an owner block or out-of-order period out of the trash
+func (m *Repository) AdminPostRestoreBlock(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostRestoreBlock")
+	m.restoreFromTrash(w, r, m.DB.RestoreBlock, "Block restored")
+}
+
+// restoreFromTrash restores the item with the id of /admin/trash/{kind}/{id}/restore and goes back to the trash
+func (m *Repository) restoreFromTrash(w http.ResponseWriter, r *http.Request, restore func(id int) error, flash string) {
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = restore(id)
+	if err == repository.ErrRoomNotAvailable {
+		m.App.Session.Put(r.Context(), "error", "The room has been booked or blocked for those dates since, so it cannot be restored")
+		http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+}
+
+// AdminPostReservationsCalendar handles post of reservation calendar. The form names the blocks to add and
+// remove together with the version of each room's blocks it was drawn from, so a room changed since is left alone
+func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostReservationsCalendar")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	year, _ := strconv.Atoi(r.Form.Get("y"))
+	month, _ := strconv.Atoi(r.Form.Get("m"))
+
+	// process blocks
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the blocks to add and remove, by room
+	adds := make(map[int][]time.Time)
+	for _, value := range r.PostForm["add_block"] {
+		roomID, day, err := splitBlockIntent(value)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		t, err := time.Parse("2006-01-2", day)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		adds[roomID] = append(adds[roomID], t)
+	}
+
+	removes := make(map[int][]int)
+	for _, value := range r.PostForm["remove_block"] {
+		roomID, id, err := splitBlockIntent(value)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		blockID, err := strconv.Atoi(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		removes[roomID] = append(removes[roomID], blockID)
+	}
+
+	var stale, taken []string
+	removed := false
+	for _, room := range rooms {
+		if len(adds[room.ID]) == 0 && len(removes[room.ID]) == 0 {
+			continue
+		}
+
+		version, err := strconv.Atoi(r.PostForm.Get(fmt.Sprintf("version_%d", room.ID)))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		err = m.DB.UpdateBlocks(room.ID, version, adds[room.ID], removes[room.ID])
+		switch {
+		case err == repository.ErrVersionConflict:
+			stale = append(stale, room.RoomName)
+		case err == repository.ErrRoomNotAvailable:
+			taken = append(taken, room.RoomName)
+		case err != nil:
+			helpers.ServerError(w, err)
+			return
+		default:
+			removed = removed || len(removes[room.ID]) > 0
+		}
+	}
+
+	if removed {
+		m.NotifyWaitlist()
+	}
+
+	redirect := fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month)
+	if len(stale) > 0 {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The blocks of %s were changed by someone else; check the calendar and make your changes again", strings.Join(stale, ", ")))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+	if len(taken) > 0 {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s is booked on some of the days to block; nothing was changed there", strings.Join(taken, ", ")))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// splitBlockIntent splits a calendar checkbox value of the form room id, underscore, day or block id
+func splitBlockIntent(value string) (int, string, error) {
+	exploded := strings.SplitN(value, "_", 2)
+	if len(exploded) != 2 {
+		return 0, "", fmt.Errorf("invalid block %q", value)
+	}
+	roomID, err := strconv.Atoi(exploded[0])
+	if err != nil {
+		return 0, "", err
+	}
+	return roomID, exploded[1], nil
+}
+
+// timelineMaxDays is the longest range the timeline loads at once
+const timelineMaxDays = 366
+
+// timelineRoom is a row of the timeline
+type timelineRoom struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// timelineItem is a reservation, owner block or out-of-order period on the timeline; end is the day of departure
+type timelineItem struct {
+	ID            int    `json:"id"`
+	Kind          string `json:"kind"`
+	RoomID        int    `json:"room_id"`
+	ReservationID int    `json:"reservation_id,omitempty"`
+	Version       int    `json:"version,omitempty"`
+	Start         string `json:"start"`
+	End           string `json:"end"`
+	Label         string `json:"label"`
+}
+
+// timelineResponse is what the timeline endpoints send back
+type timelineResponse struct {
+	OK      bool           `json:"ok"`
+	Message string         `json:"message,omitempty"`
+	Start   string         `json:"start,omitempty"`
+	End     string         `json:"end,omitempty"`
+	Rooms   []timelineRoom `json:"rooms,omitempty"`
+	Items   []timelineItem `json:"items,omitempty"`
+}
+
+// timelineChange is a reservation moved or a block drawn on the timeline; a move carries the version
+// of the reservation the timeline was drawn from
+type timelineChange struct {
+	RoomID  int    `json:"room_id"`
+	Start   string `json:"start"`
+	End     string `json:"end"`
+	Version int    `json:"version"`
+}
+
+// writeTimelineJSON sends a timeline response with a status code
+func writeTimelineJSON(w http.ResponseWriter, status int, resp timelineResponse) {
+	out, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// parseTimelineChange reads a change posted by the timeline; the message says what is wrong with it, if anything
+func parseTimelineChange(r *http.Request) (change timelineChange, start, end time.Time, message string) {
+	err := json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		return change, start, end, "Invalid request"
+	}
+
+	layout := "2006-01-02"
+	start, err = time.Parse(layout, change.Start)
+	if err != nil {
+		return change, start, end, "Invalid start date"
+	}
+	end, err = time.Parse(layout, change.End)
+	if err != nil {
+		return change, start, end, "Invalid end date"
+	}
+	if !end.After(start) {
+		return change, start, end, "The end must be after the start"
+	}
+	return change, start, end, ""
+}
+
+// AdminTimeline shows the rooms by days timeline, which loads its data from AdminTimelineJSON
+func (m *Repository) AdminTimeline(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminTimeline")
+	render.Template(w, r, "admin-timeline.page.html", &models.TemplateData{})
+}
+
+// AdminTimelineJSON returns the rooms and whatever occupies them between ?start and ?end
+func (m *Repository) AdminTimelineJSON(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminTimelineJSON")
+	layout := "2006-01-02"
+
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if d, err := time.Parse(layout, r.URL.Query().Get("start")); err == nil {
+		start = d
+	}
+	end := start.AddDate(0, 0, 14)
+	if d, err := time.Parse(layout, r.URL.Query().Get("end")); err == nil {
+		end = d
+	}
+
+	if !end.After(start) || end.Sub(start).Hours()/24 > timelineMaxDays {
+		writeTimelineJSON(w, http.StatusBadRequest, timelineResponse{
+			Message: fmt.Sprintf("Choose an end after the start and at most %d days later", timelineMaxDays),
+		})
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// guest names and versions of the reservations in the range, read in one go
+	names := make(map[int]string)
+	versions := make(map[int]int)
+	err = m.DB.EachReservation(models.ReservationFilter{From: start, To: end}, func(res models.Reservation) error {
+		names[res.ID] = strings.TrimSpace(res.FirstName + " " + res.LastName)
+		versions[res.ID] = res.Version
+		return nil
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	resp := timelineResponse{OK: true, Start: start.Format(layout), End: end.Format(layout)}
+	for _, room := range rooms {
+		resp.Rooms = append(resp.Rooms, timelineRoom{ID: room.ID, Name: room.RoomName})
+
+		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, start, end.AddDate(0, 0, -1))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		for _, restriction := range restrictions {
+			item := timelineItem{
+				ID:            restriction.ID,
+				RoomID:        room.ID,
+				ReservationID: restriction.ReservationID,
+				Start:         restriction.StartDate.Format(layout),
+				End:           restriction.EndDate.Format(layout),
+			}
+			switch {
+			case restriction.ReservationID > 0:
+				item.Kind = "reservation"
+				item.Label = names[restriction.ReservationID]
+				item.Version = versions[restriction.ReservationID]
+			case restriction.RestrictionID == models.RestrictionOutOfOrder:
+				item.Kind = "out_of_order"
+				item.Label = restriction.Reason
+			default:
+				item.Kind = "block"
+				item.Label = "Owner block"
+			}
+			resp.Items = append(resp.Items, item)
+		}
+	}
+
+	writeTimelineJSON(w, http.StatusOK, resp)
+}
+
+// AdminPostTimelineMove moves a reservation dragged or stretched on the timeline to its new room and dates
+func (m *Repository) AdminPostTimelineMove(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostTimelineMove")
+	exploded := strings.Split(r.RequestURI, "/")
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		writeTimelineJSON(w, http.StatusBadRequest, timelineResponse{Message: "Invalid reservation"})
+		return
+	}
+
+	change, start, end, message := parseTimelineChange(r)
+	if message != "" {
+		writeTimelineJSON(w, http.StatusBadRequest, timelineResponse{Message: message})
+		return
+	}
+
+	reservation, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if reservation.Status == models.ReservationStatusCancelled {
+		writeTimelineJSON(w, http.StatusConflict, timelineResponse{Message: "A cancelled reservation cannot be moved"})
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(change.RoomID)
+	if err != nil {
+		writeTimelineJSON(w, http.StatusBadRequest, timelineResponse{Message: "Unknown room"})
+		return
+	}
+
+	reservation.StartDate = start
+	reservation.EndDate = end
+	reservation.RoomID = change.RoomID
+	reservation.Room = room
+	reservation.Version = change.Version
+
+	err = m.priceMove(&reservation)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.MoveReservation(reservation)
+	if err == repository.ErrRoomNotAvailable {
+		writeTimelineJSON(w, http.StatusConflict, timelineResponse{
+			Message: fmt.Sprintf("%s is not available for those dates", room.RoomName),
+		})
+		return
+	}
+	if err == repository.ErrVersionConflict {
+		writeTimelineJSON(w, http.StatusConflict, timelineResponse{
+			Message: "The reservation was changed by someone else; the timeline now shows the current state",
+		})
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the old dates may have freed a room someone is waiting for
+	m.NotifyWaitlist()
+
+	writeTimelineJSON(w, http.StatusOK, timelineResponse{OK: true, Message: "Reservation moved"})
+}
+
+// AdminPostTimelineBlock blocks a room for the owner over the days dragged across on the timeline
+func (m *Repository) AdminPostTimelineBlock(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostTimelineBlock")
+
+	change, start, end, message := parseTimelineChange(r)
+	if message != "" {
+		writeTimelineJSON(w, http.StatusBadRequest, timelineResponse{Message: message})
+		return
+	}
+
+	_, err := m.DB.GetRoomByID(change.RoomID)
+	if err != nil {
+		writeTimelineJSON(w, http.StatusBadRequest, timelineResponse{Message: "Unknown room"})
+		return
+	}
+
+	_, err = m.DB.InsertBlock(models.RoomRestriction{
+		RoomID:    change.RoomID,
+		StartDate: start,
+		EndDate:   end,
+	})
+	if err == repository.ErrRoomNotAvailable {
+		writeTimelineJSON(w, http.StatusConflict, timelineResponse{Message: "The room is booked or blocked during these dates"})
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	writeTimelineJSON(w, http.StatusOK, timelineResponse{OK: true, Message: "Room blocked"})
+}
+
+// AdminCancelReservation shows the refund before a reservation is cancelled
+func (m *Repository) AdminCancelReservation(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminCancelReservation")
+	exploded := strings.Split(r.RequestURI, "/")
+	src := exploded[3]
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	policy, paid, refund, err := m.cancellationQuote(res)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+	stringMap["year"] = r.URL.Query().Get("y")
+	stringMap["month"] = r.URL.Query().Get("m")
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["policy"] = policy
+
+	intMap := make(map[string]int)
+	intMap["paid"] = paid
+	intMap["refund"] = refund
+
+	render.Template(w, r, "admin-cancel-reservation.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		IntMap:    intMap,
+	})
+}
+
+// AdminPostCancelReservation cancels a reservation and records the refund
+func (m *Repository) AdminPostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostCancelReservation")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+	src := exploded[3]
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	year := r.Form.Get("year")
+	month := r.Form.Get("month")
+
+	if res.Status == models.ReservationStatusCancelled {
+		m.App.Session.Put(r.Context(), "warning", "Reservation is already cancelled")
+	} else {
+		_, _, refund, err := m.cancellationQuote(res)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		err = m.cancelReservation(res, refund)
+		switch {
+		case err == repository.ErrAlreadyCancelled:
+			m.App.Session.Put(r.Context(), "warning", "Reservation is already cancelled")
+		case err == errRefundFailed:
+			m.App.Session.Put(r.Context(), "error",
+				fmt.Sprintf("Reservation cancelled, but the refund of %s failed; it will be retried", render.Money(refund)))
+		case err != nil:
+			helpers.ServerError(w, err)
+			return
+		default:
+			m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation cancelled, refund %s", render.Money(refund)))
+		}
+	}
+
+	if year == "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	}
+}
+
+// AdminInvoice downloads an invoice or credit note as PDF
+func (m *Repository) AdminInvoice(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminInvoice")
+	exploded := strings.Split(r.RequestURI, "/")
+
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	inv, err := m.DB.GetInvoiceByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(inv.ReservationID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	writeInvoice(w, inv, res)
+}
+
+// AdminPostIssueInvoice issues an invoice for a reservation which has no open invoice
+func (m *Repository) AdminPostIssueInvoice(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostIssueInvoice")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+	src := exploded[3]
+
+	id, err := strconv.Atoi(exploded[4])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	invoices, err := m.DB.GetInvoicesByReservationID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if len(invoice.Open(invoices)) > 0 {
+		m.App.Session.Put(r.Context(), "warning", "Credit the open invoice before issuing a new one")
+	} else {
+		inv, err := m.issueInvoice(res)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Invoice %s issued", invoice.Number(inv)))
+	}
+
+	http.Redirect(w, r, adminReservationURL(src, id, r.Form.Get("year"), r.Form.Get("month")), http.StatusSeeOther)
+}
+
+// AdminPostCreditNote cancels an issued invoice with a credit note
+func (m *Repository) AdminPostCreditNote(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostCreditNote")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	inv, err := m.DB.GetInvoiceByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	invoices, err := m.DB.GetInvoicesByReservationID(inv.ReservationID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	open := false
+	for _, o := range invoice.Open(invoices) {
+		if o.ID == inv.ID {
+			open = true
+		}
+	}
+
+	if !open {
+		m.App.Session.Put(r.Context(), "warning", "Only open invoices can be credited")
+	} else {
+		cn, err := m.DB.InsertInvoice(invoice.CreditNote(inv))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Credit note %s issued", invoice.Number(cn)))
+	}
+
+	http.Redirect(w, r, adminReservationURL(r.Form.Get("src"), inv.ReservationID, r.Form.Get("year"), r.Form.Get("month")), http.StatusSeeOther)
+}
+
+// adminReservationURL returns the admin page of a reservation, keeping the calendar month if there is one
+func adminReservationURL(src string, id int, year, month string) string {
+	if src == "" {
+		src = "all"
+	}
+	if year == "" {
+		return fmt.Sprintf("/admin/reservations/%s/%d/show", src, id)
+	}
+	return fmt.Sprintf("/admin/reservations/%s/%d/show?y=%s&m=%s", src, id, year, month)
+}
+
+// importMaxBytes is the largest CSV file which can be imported
+const importMaxBytes = 10 << 20
+
+// importColumns are the columns a reservation import needs; phone, guests and total may be left out
+var importColumns = []string{"first_name", "last_name", "email", "room", "start_date", "end_date"}
+
+// importRow is one line of a reservation import and what is wrong with it
+type importRow struct {
+	Line        int
+	Reservation models.Reservation
+	Errors      []string
+}
+
+// outOfOrderStatuses are the statuses of an out-of-order period, in the order work goes through them
+var outOfOrderStatuses = []string{
+	models.OutOfOrderStatusOpen,
+	models.OutOfOrderStatusInProgress,
+	models.OutOfOrderStatusResolved,
+}
+
+// AdminOutOfOrder lists the out-of-order periods which have not ended yet
+func (m *Repository) AdminOutOfOrder(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminOutOfOrder")
+
+	// include periods which ended before a day given, to look back
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if d, err := time.Parse("2006-01-02", r.URL.Query().Get("from")); err == nil {
+		from = d
+	}
+
+	periods, err := m.DB.OutOfOrderPeriods(from)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["periods"] = periods
+
+	render.Template(w, r, "admin-out-of-order.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminOutOfOrderPeriod shows the form for a new or existing out-of-order period
+func (m *Repository) AdminOutOfOrderPeriod(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminOutOfOrderPeriod")
+	exploded := strings.Split(r.RequestURI, "/")
+
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	period := models.RoomRestriction{Status: models.OutOfOrderStatusOpen}
+	if id > 0 {
+		period, err = m.DB.GetOutOfOrderByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.renderOutOfOrderForm(w, r, period, forms.New(nil))
+}
+
+// AdminPostOutOfOrderPeriod saves a new or existing out-of-order period
+func (m *Repository) AdminPostOutOfOrderPeriod(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostOutOfOrderPeriod")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	period := models.RoomRestriction{
+		ID:            id,
+		RestrictionID: models.RestrictionOutOfOrder,
+		Reason:        strings.TrimSpace(r.Form.Get("reason")),
+		AssignedTo:    strings.TrimSpace(r.Form.Get("assigned_to")),
+		Status:        r.Form.Get("status"),
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "start_date", "end_date", "reason")
+	form.IsOneOf("status", outOfOrderStatuses...)
+
+	period.RoomID, err = strconv.Atoi(r.Form.Get("room_id"))
+	if form.Has("room_id") && err != nil {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+
+	layout := "2006-01-02"
+	if form.Has("start_date") {
+		period.StartDate, err = time.Parse(layout, r.Form.Get("start_date"))
+		if err != nil {
+			form.Errors.Add("start_date", "Invalid date")
+		}
+	}
+	if form.Has("end_date") {
+		period.EndDate, err = time.Parse(layout, r.Form.Get("end_date"))
+		if err != nil {
+			form.Errors.Add("end_date", "Invalid date")
+		}
+	}
+	if !period.StartDate.IsZero() && !period.EndDate.IsZero() && !period.EndDate.After(period.StartDate) {
+		form.Errors.Add("end_date", "The room has to be back in service after the period starts")
+	}
+
+	if !form.Valid() {
+		m.renderOutOfOrderForm(w, r, period, form)
+		return
+	}
+
+	if id > 0 {
+		err = m.DB.UpdateOutOfOrder(period)
+	} else {
+		_, err = m.DB.InsertOutOfOrder(period)
+	}
+	if errors.Is(err, repository.ErrRoomNotAvailable) {
+		form.Errors.Add("start_date", "The room is booked or blocked during these dates")
+		m.renderOutOfOrderForm(w, r, period, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// shortening a period may free nights someone is waiting for
+	if id > 0 {
+		m.NotifyWaitlist()
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/out-of-order", http.StatusSeeOther)
+}
+
+// AdminPostDeleteOutOfOrderPeriod puts a room back in service
+func (m *Repository) AdminPostDeleteOutOfOrderPeriod(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostDeleteOutOfOrderPeriod")
+	exploded := strings.Split(r.RequestURI, "/")
+
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteOutOfOrder(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.NotifyWaitlist()
+
+	m.App.Session.Put(r.Context(), "flash", "Room back in service")
+	http.Redirect(w, r, "/admin/out-of-order", http.StatusSeeOther)
+}
+
+// renderOutOfOrderForm renders the form of an out-of-order period
+func (m *Repository) renderOutOfOrderForm(w http.ResponseWriter, r *http.Request, period models.RoomRestriction, form *forms.Form) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["period"] = period
+	data["rooms"] = rooms
+	data["statuses"] = outOfOrderStatuses
+
+	render.Template(w, r, "admin-out-of-order-period.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminImport shows the form for importing reservations from a CSV file
+func (m *Repository) AdminImport(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminImport")
+	render.Template(w, r, "admin-import.page.html", &models.TemplateData{
+		Data: make(map[string]interface{}),
+	})
+}
+
+// AdminPostImport previews a CSV file of reservations and, when asked to and every row is fine, imports all of them
+func (m *Repository) AdminPostImport(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostImport")
+	r.Body = http.MaxBytesReader(w, r.Body, importMaxBytes)
+	err := r.ParseMultipartForm(importMaxBytes)
+	if err != nil && err != http.ErrNotMultipart {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// an upload is previewed first; confirming the preview posts its content back
+	content := r.Form.Get("csv")
+	file, _, err := r.FormFile("file")
+	if err == nil {
+		defer file.Close()
+		b, err := ioutil.ReadAll(file)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		content = string(b)
+	}
+
+	if strings.TrimSpace(content) == "" {
+		m.App.Session.Put(r.Context(), "error", "Choose a CSV file to import")
+		http.Redirect(w, r, "/admin/import", http.StatusSeeOther)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rules, err := m.DB.AllTaxFeeRules()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rows, err := m.parseImport(strings.NewReader(content), rooms, rules)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("The file cannot be imported: %s", err))
+		http.Redirect(w, r, "/admin/import", http.StatusSeeOther)
+		return
+	}
+
+	invalid := 0
+	for _, row := range rows {
+		if len(row.Errors) > 0 {
+			invalid++
+		}
+	}
+
+	if r.Form.Get("action") == "import" && invalid == 0 {
+		var reservations []models.Reservation
+		for _, row := range rows {
+			reservations = append(reservations, row.Reservation)
+		}
+
+		err = m.DB.ImportReservations(reservations)
+		var rowErr *repository.RowError
+		if errors.As(err, &rowErr) {
+			msg := fmt.Sprintf("could not be saved: %s", rowErr.Err)
+			if errors.Is(rowErr.Err, repository.ErrRoomNotAvailable) {
+				msg = "the room has been taken in the meantime"
+			}
+			rows[rowErr.Index].Errors = append(rows[rowErr.Index].Errors, msg)
+			invalid++
+			m.App.Session.Put(r.Context(), "error", "Nothing was imported")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		} else {
+			m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Imported %d reservations", len(reservations)))
+			http.Redirect(w, r, "/admin/reservations-all", http.StatusSeeOther)
+			return
+		}
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["csv"] = content
+
+	intMap := make(map[string]int)
+	intMap["rows"] = len(rows)
+	intMap["invalid"] = invalid
+
+	data := make(map[string]interface{})
+	data["rows"] = rows
+
+	render.Template(w, r, "admin-import.page.html", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+	})
+}
+
+// parseImport reads the rows of a reservation import and checks each with the rules of PostReservation,
+// including whether its room is free; the error is about the file as a whole
+func (m *Repository) parseImport(in io.Reader, rooms []models.Room, rules []models.TaxFeeRule) ([]importRow, error) {
+	rd := csv.NewReader(in)
+	rd.FieldsPerRecord = -1
+	rd.TrimLeadingSpace = true
+
+	header, err := rd.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		name = strings.TrimPrefix(name, "\ufeff")
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range importColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("the column %s is missing", name)
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// the header is line 1
+		line := len(rows) + 2
+		values := url.Values{}
+		for name, i := range columns {
+			if i < len(record) {
+				values.Set(name, strings.TrimSpace(record[i]))
+			}
+		}
+
+		rows = append(rows, m.checkImportRow(line, values, rooms, rules))
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("the file has no reservations")
+	}
+
+	// rows can also take each other's rooms
+	for i := range rows {
+		a := rows[i].Reservation
+		if a.RoomID == 0 || !a.EndDate.After(a.StartDate) {
+			continue
+		}
+		for j := 0; j < i; j++ {
+			b := rows[j].Reservation
+			if a.RoomID == b.RoomID && a.StartDate.Before(b.EndDate) && a.EndDate.After(b.StartDate) {
+				rows[i].Errors = append(rows[i].Errors, fmt.Sprintf("overlaps line %d in the same room", rows[j].Line))
+				break
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+// checkImportRow turns one line of an import into a reservation and lists what is wrong with it
+func (m *Repository) checkImportRow(line int, values url.Values, rooms []models.Room, rules []models.TaxFeeRule) importRow {
+	row := importRow{Line: line}
+
+	form := forms.New(values)
+	checkReservationForm(form, m.App.PhoneCountryCode)
+	for _, field := range []string{"first_name", "last_name", "email", "phone"} {
+		if msg := form.Errors.Get(field); msg != "" {
+			row.Errors = append(row.Errors, fmt.Sprintf("%s: %s", field, msg))
+		}
+	}
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, values.Get("start_date"))
+	if err != nil {
+		row.Errors = append(row.Errors, "start_date: can't parse date")
+	}
+	endDate, err := time.Parse(layout, values.Get("end_date"))
+	if err != nil {
+		row.Errors = append(row.Errors, "end_date: can't parse date")
+	}
+	datesOK := !startDate.IsZero() && !endDate.IsZero()
+	if datesOK && !endDate.After(startDate) {
+		row.Errors = append(row.Errors, "end_date: the departure has to be after the arrival")
+		datesOK = false
+	}
+
+	guests := 1
+	if form.Has("guests") {
+		guests, err = strconv.Atoi(values.Get("guests"))
+		if err != nil || guests < 1 {
+			row.Errors = append(row.Errors, "guests: There has to be at least one guest")
+			guests = 1
+		}
+	}
+
+	// rooms are given by id or by name
+	roomID, err := strconv.Atoi(values.Get("room"))
+	if err != nil {
+		roomID = 0
+		for _, rm := range rooms {
+			if strings.EqualFold(rm.RoomName, values.Get("room")) {
+				roomID = rm.ID
+			}
+		}
+	}
+
+	var room models.Room
+	if roomID > 0 {
+		room, err = m.DB.GetRoomByID(roomID)
+	}
+	if roomID == 0 || err != nil {
+		row.Errors = append(row.Errors, fmt.Sprintf("room: unknown room %q", values.Get("room")))
+		roomID = 0
+	}
+
+	row.Reservation = models.Reservation{
+		FirstName:  values.Get("first_name"),
+		LastName:   values.Get("last_name"),
+		Email:      values.Get("email"),
+		Phone:      values.Get("phone"),
+		StartDate:  startDate,
+		EndDate:    endDate,
+		RoomID:     roomID,
+		Room:       room,
+		Status:     models.ReservationStatusConfirmed,
+		AccessCode: helpers.NewAccessCode(),
+		Guests:     guests,
+	}
+
+	if !datesOK || roomID == 0 {
+		return row
+	}
+
+	// a total from the old system wins over today's prices
+	if form.Has("total") {
+		row.Reservation.TotalAmount, err = pricing.ParseHundredths(values.Get("total"))
+		if err != nil || row.Reservation.TotalAmount < 0 {
+			row.Errors = append(row.Errors, "total: invalid amount")
+		}
+	} else {
+		quote := pricing.NewQuote(room, startDate, endDate, guests, rules)
+		row.Reservation.TotalAmount = quote.Total
+		row.Reservation.Charges = quote.Charges
+	}
+
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	if err != nil {
+		row.Errors = append(row.Errors, "room: can't check availability")
+	} else if !available {
+		row.Errors = append(row.Errors, "room: conflicts with an existing stay or block")
+	}
+
+	return row
+}
+
+// AdminGuests lists all guests
+func (m *Repository) AdminGuests(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminGuests")
+	guests, err := m.DB.AllGuests()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["guests"] = guests
+
+	render.Template(w, r, "admin-guests.page.html", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminGuest shows a guest with their past and upcoming stays
+func (m *Repository) AdminGuest(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminGuest")
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	guest, err := m.DB.GetGuestByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.renderGuest(w, r, guest, forms.New(nil))
+}
+
+// renderGuest renders the guest page with the stays split into upcoming and past ones
+func (m *Repository) renderGuest(w http.ResponseWriter, r *http.Request, guest models.Guest, form *forms.Form) {
+	today := time.Now().Truncate(24 * time.Hour)
+
+	var upcoming, past []models.Reservation
+	for _, res := range guest.Reservations {
+		if res.EndDate.Before(today) {
+			past = append(past, res)
+		} else {
+			upcoming = append(upcoming, res)
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["guest"] = guest
+	data["upcoming"] = upcoming
+	data["past"] = past
+
+	render.Template(w, r, "admin-guest.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
+
+// AdminPostGuest saves the notes, preferences and flag of a guest
+func (m *Repository) AdminPostGuest(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostGuest")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	guest, err := m.DB.GetGuestByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	guest.Notes = r.Form.Get("notes")
+	guest.Preferences = r.Form.Get("preferences")
+	guest.Flag = r.Form.Get("flag")
+	guest.SMSOptOut = r.Form.Get("sms_opt_out") != ""
+
+	form := forms.New(r.PostForm)
+	form.IsOneOf("flag", "", models.GuestFlagVIP, models.GuestFlagDoNotRent)
+	if !form.Valid() {
+		m.renderGuest(w, r, guest, form)
+		return
+	}
+
+	err = m.DB.UpdateGuest(guest)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/guests/%d", id), http.StatusSeeOther)
+}
+
+// AdminTaxFees lists the tax and fee rules with what was charged for them
+func (m *Repository) AdminTaxFees(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminTaxFees")
+	rules, err := m.DB.AllTaxFeeRules()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// report on arrivals of the current year unless a range is given
+	layout := "2006-01-02"
+	now := time.Now()
+	from := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(1, 0, -1)
+
+	if d, err := time.Parse(layout, r.URL.Query().Get("from")); err == nil {
+		from = d
+	}
+	if d, err := time.Parse(layout, r.URL.Query().Get("to")); err == nil {
+		to = d
+	}
+
+	totals, err := m.DB.ChargeTotals(from, to.AddDate(0, 0, 1))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = rules
+	data["totals"] = totals
+
+	stringMap := make(map[string]string)
+	stringMap["from"] = from.Format(layout)
+	stringMap["to"] = to.Format(layout)
+
+	render.Template(w, r, "admin-tax-fees.page.html", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// AdminTaxFee shows the form for a new or existing tax or fee rule
+func (m *Repository) AdminTaxFee(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminTaxFee")
+	exploded := strings.Split(r.RequestURI, "/")
+
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rule := models.TaxFeeRule{Kind: models.InvoiceLineTax, Basis: models.TaxFeeBasisPercent}
+	if id > 0 {
+		rule, err = m.DB.GetTaxFeeRuleByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.renderTaxFeeForm(w, r, rule, forms.New(nil))
+}
+
+// AdminPostTaxFee saves a new or existing tax or fee rule
+func (m *Repository) AdminPostTaxFee(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostTaxFee")
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	exploded := strings.Split(r.RequestURI, "/")
+
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rule := models.TaxFeeRule{
+		ID:    id,
+		Name:  r.Form.Get("name"),
+		Kind:  r.Form.Get("kind"),
+		Basis: r.Form.Get("basis"),
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "amount")
+	form.IsOneOf("kind", models.InvoiceLineTax, models.InvoiceLineFee)
+	form.IsOneOf("basis", models.TaxFeeBasisPercent, models.TaxFeeBasisPerNight, models.TaxFeeBasisPerStay,
+		models.TaxFeeBasisPerGuest, models.TaxFeeBasisPerGuestNight)
+
+	if form.Has("amount") {
+		rule.Amount, err = pricing.ParseHundredths(r.Form.Get("amount"))
+		if err != nil || rule.Amount < 0 {
+			form.Errors.Add("amount", "Enter a positive amount with at most two decimal places")
+		}
+	}
+
+	layout := "2006-01-02"
+	if form.Has("starts_on") {
+		rule.StartsOn, err = time.Parse(layout, r.Form.Get("starts_on"))
+		if err != nil {
+			form.Errors.Add("starts_on", "Invalid date")
+		}
+	}
+	if form.Has("ends_on") {
+		rule.EndsOn, err = time.Parse(layout, r.Form.Get("ends_on"))
+		if err != nil {
+			form.Errors.Add("ends_on", "Invalid date")
+		}
+	}
+	if !rule.StartsOn.IsZero() && !rule.EndsOn.IsZero() && rule.EndsOn.Before(rule.StartsOn) {
+		form.Errors.Add("ends_on", "The rule can't end before it starts")
+	}
+
+	if !form.Valid() {
+		m.renderTaxFeeForm(w, r, rule, form)
+		return
+	}
+
+	if id > 0 {
+		err = m.DB.UpdateTaxFeeRule(rule)
+	} else {
+		_, err = m.DB.InsertTaxFeeRule(rule)
+	}
 	if err != nil {
-		log.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
-		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		helpers.ServerError(w, err)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "user_id", id)
-	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, "/admin/tax-fees", http.StatusSeeOther)
 }
 
-// Logout logs a user out
-func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("Logout")
-	_ = m.App.Session.Destroy(r.Context())
-	_ = m.App.Session.RenewToken(r.Context())
-
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-}
+// AdminPostDeleteTaxFee deletes a tax or fee rule; existing reservations keep their charges
+func (m *Repository) AdminPostDeleteTaxFee(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostDeleteTaxFee")
+	exploded := strings.Split(r.RequestURI, "/")
 
-//
-func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("AdminDashboard")
-	render.Template(w, r, "admin-dashboard.page.html", &models.TemplateData{})
-}
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-// AdminNewReservations shows all new reservations in admin tool
-func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("AdminNewReservations")
-	reservations, err := m.DB.AllNewReservations()
+	err = m.DB.DeleteTaxFeeRule(id)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
+	m.App.Session.Put(r.Context(), "flash", "Rule deleted")
+	http.Redirect(w, r, "/admin/tax-fees", http.StatusSeeOther)
+}
+
+// renderTaxFeeForm renders the form of a tax or fee rule
+func (m *Repository) renderTaxFeeForm(w http.ResponseWriter, r *http.Request, rule models.TaxFeeRule, form *forms.Form) {
+	stringMap := make(map[string]string)
+	stringMap["amount"] = pricing.FormatHundredths(rule.Amount)
+	if form.Has("amount") {
+		stringMap["amount"] = form.Get("amount")
+	}
+
 	data := make(map[string]interface{})
-	data["reservations"] = reservations
+	data["rule"] = rule
 
-	render.Template(w, r, "admin-new-reservations.page.html", &models.TemplateData{
-		Data: data,
+	render.Template(w, r, "admin-tax-fee.page.html", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
 	})
 }
 
-// AdminAllReservations shows all reservations in admin tool
-func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("AdminAllReservations")
-	reservations, err := m.DB.AllReservations()
+// AdminMessageTemplates shows the saved message templates
+func (m *Repository) AdminMessageTemplates(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminMessageTemplates")
+	templates, err := m.DB.AllMessageTemplates()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
 	data := make(map[string]interface{})
-	data["reservations"] = reservations
+	data["templates"] = templates
 
-	render.Template(w, r, "admin-all-reservations.page.html", &models.TemplateData{
+	render.Template(w, r, "admin-message-templates.page.html", &models.TemplateData{
 		Data: data,
 	})
 }
 
-// AdminShowReservation shows the reservation in the admin tool
-func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("AdminShowReservation")
+// AdminMessageTemplate shows the form for a new or existing message template
+func (m *Repository) AdminMessageTemplate(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminMessageTemplate")
 	exploded := strings.Split(r.RequestURI, "/")
 
-	src := exploded[3]
-
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
-
-	// get year and month if previous page is calendar
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
-
-	stringMap["year"] = year
-	stringMap["month"] = month
-
-	// get reservation from database
-	id, err := strconv.Atoi(exploded[4])
+	id, err := strconv.Atoi(exploded[3])
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	reservation, err := m.DB.GetReservationByID(id)
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
+	var t models.MessageTemplate
+	if id > 0 {
+		t, err = m.DB.GetMessageTemplateByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
 	}
 
-	data := make(map[string]interface{})
-	data["reservation"] = reservation
-
-	render.Template(w, r, "admin-reservations-show.page.html", &models.TemplateData{
-		StringMap: stringMap,
-		Data:      data,
-		Form:      forms.New(nil),
-	})
+	m.renderMessageTemplateForm(w, r, t, forms.New(nil))
 }
 
-// AdminPostShowReservation shows the reservation in the admin tool
-func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("AdminPostShowReservation")
+// AdminPostMessageTemplate saves a new or existing message template
+func (m *Repository) AdminPostMessageTemplate(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostMessageTemplate")
 	err := r.ParseForm()
 	if err != nil {
 		helpers.ServerError(w, err)
@@ -598,227 +5291,186 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 
 	exploded := strings.Split(r.RequestURI, "/")
 
-	src := exploded[3]
-
-	// get reservation from database
-	id, err := strconv.Atoi(exploded[4])
+	id, err := strconv.Atoi(exploded[3])
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	reservation, err := m.DB.GetReservationByID(id)
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
+	t := models.MessageTemplate{
+		ID:      id,
+		Name:    strings.TrimSpace(r.Form.Get("name")),
+		Subject: strings.TrimSpace(r.Form.Get("subject")),
+		Body:    strings.TrimSpace(r.Form.Get("body")),
 	}
 
-	reservation.FirstName = r.Form.Get("first_name")
-	reservation.LastName = r.Form.Get("last_name")
-	reservation.Email = r.Form.Get("email")
-	reservation.Phone = r.Form.Get("phone")
+	form := forms.New(r.PostForm)
+	form.Required("name", "subject", "body")
 
-	err = m.DB.UpdateReservation(reservation)
+	if !form.Valid() {
+		m.renderMessageTemplateForm(w, r, t, form)
+		return
+	}
+
+	if id > 0 {
+		err = m.DB.UpdateMessageTemplate(t)
+	} else {
+		_, err = m.DB.InsertMessageTemplate(t)
+	}
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	year := r.Form.Get("year")
-	month := r.Form.Get("month")
-
 	m.App.Session.Put(r.Context(), "flash", "Changes saved")
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
-	}
+	http.Redirect(w, r, "/admin/message-templates", http.StatusSeeOther)
 }
 
-// AdminReservationsCalendar displays the reservation calendar
-func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("AdminReservationsCalendar")
-	// assume that there is no month/year specified
-	now := time.Now()
+// AdminPostDeleteMessageTemplate deletes a message template; messages already sent are kept
+func (m *Repository) AdminPostDeleteMessageTemplate(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostDeleteMessageTemplate")
+	exploded := strings.Split(r.RequestURI, "/")
 
-	if r.URL.Query().Get("y") != "" {
-		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
-		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
-		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	next := now.AddDate(0, 1, 0)
-	last := now.AddDate(0, -1, 0)
+	err = m.DB.DeleteMessageTemplate(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	stringMap := make(map[string]string)
-	stringMap["this_month"] = now.Format("01")
-	stringMap["this_month_year"] = now.Format("2006")
-	stringMap["next_month"] = next.Format("01")
-	stringMap["next_month_year"] = next.Format("2006")
-	stringMap["last_month"] = last.Format("01")
-	stringMap["last_month_year"] = last.Format("2006")
+	m.App.Session.Put(r.Context(), "flash", "Template deleted")
+	http.Redirect(w, r, "/admin/message-templates", http.StatusSeeOther)
+}
 
+// renderMessageTemplateForm renders the form of a message template
+func (m *Repository) renderMessageTemplateForm(w http.ResponseWriter, r *http.Request, t models.MessageTemplate, form *forms.Form) {
 	data := make(map[string]interface{})
-	data["now"] = now
-
-	// get the first and last days of the month
-	currentYear, currentMonth, _ := now.Date()
-	currentLocation := now.Location()
-	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+	data["template"] = t
+	data["placeholders"] = messages.Placeholders
 
-	intMap := make(map[string]int)
-	intMap["last_of_month"] = lastOfMonth.Day()
+	render.Template(w, r, "admin-message-template.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
+}
 
-	rooms, err := m.DB.AllRooms()
+// AdminInbox lists the conversations with guests, latest first, with their unread messages
+func (m *Repository) AdminInbox(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminInbox")
+	conversations, err := m.DB.AllConversations()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	data["rooms"] = rooms
-
-	for _, room := range rooms {
-		reservaitonMap := make(map[string]int)
-		blockMap := make(map[string]int)
-
-		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
-			reservaitonMap[d.Format("2006-01-2")] = 0
-			blockMap[d.Format("2006-01-2")] = 0
-		}
-
-		// get all the restrictions for the current room
-		restrictions, err := m.DB.GetRestrictionsForRoomByDate(room.ID, firstOfMonth, lastOfMonth)
-		if err != nil {
-			helpers.ServerError(w, err)
-			return
-		}
-
-		for _, restriction := range restrictions {
-			if restriction.ReservationID > 0 {
-				// it's a reservation
-				for d := restriction.StartDate; !d.After(restriction.EndDate.AddDate(0, 0, -1)); d = d.AddDate(0, 0, 1) {
-					reservaitonMap[d.Format("2006-01-2")] = restriction.ReservationID
-				}
-			} else {
-				// it's a block
-				blockMap[restriction.StartDate.Format("2006-01-2")] = restriction.ID
-			}
-		}
+	unread := 0
+	for _, c := range conversations {
+		unread += c.Unread
+	}
 
-		data[fmt.Sprintf("reservation_map_%d", room.ID)] = reservaitonMap
-		data[fmt.Sprintf("block_map_%d", room.ID)] = blockMap
+	data := make(map[string]interface{})
+	data["conversations"] = conversations
 
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", room.ID), blockMap)
-	}
+	intMap := make(map[string]int)
+	intMap["unread"] = unread
 
-	render.Template(w, r, "admin-reservations-calendar.page.html", &models.TemplateData{
-		StringMap: stringMap,
-		Data:      data,
-		IntMap:    intMap,
+	render.Template(w, r, "admin-inbox.page.html", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
 	})
 }
 
-// AdminProcessReservation marks a reservation as processed
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("AdminProcessReservation")
-	// use chi's method
-	// id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	// src := chi.URLParam(r, "src")
-
-	// without chi's method
+// AdminConversation shows a conversation with a guest and marks it read
+func (m *Repository) AdminConversation(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminConversation")
 	exploded := strings.Split(r.RequestURI, "/")
-	id, _ := strconv.Atoi(exploded[4])
-	src := exploded[3]
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
-
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
-
-	m.App.Session.Put(r.Context(), "flash", "Reservation marked as process")
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
-}
-
-// AdminDeleteReservation deletes a reservation
-func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("AdminDeleteReservation")
-	// use chi's method
-	// id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	// src := chi.URLParam(r, "src")
 
-	// without chi's method
-	exploded := strings.Split(r.RequestURI, "/")
-	id, _ := strconv.Atoi(exploded[4])
-	src := exploded[3]
-	err := m.DB.DeleteReservation(id)
+	c, err := m.DB.GetConversationByID(id)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
-
-	m.App.Session.Put(r.Context(), "flash", "Reservation deleted")
-	if year == "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+	if c.Unread > 0 {
+		err = m.DB.MarkConversationRead(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
 	}
+
+	m.renderConversation(w, r, c, forms.New(nil))
 }
 
-// AdminPostReservationsCalendar handles post of reservation calendar
-func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	m.App.InfoLog.Print("AdminPostReservationsCalendar")
+// AdminPostConversationReply emails a reply to the guest and adds it to the conversation
+func (m *Repository) AdminPostConversationReply(w http.ResponseWriter, r *http.Request) {
+	m.App.InfoLog.Print("AdminPostConversationReply")
 	err := r.ParseForm()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	year, _ := strconv.Atoi(r.Form.Get("y"))
-	month, _ := strconv.Atoi(r.Form.Get("m"))
+	exploded := strings.Split(r.RequestURI, "/")
+	id, err := strconv.Atoi(exploded[3])
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	// process blocks
-	rooms, err := m.DB.AllRooms()
+	c, err := m.DB.GetConversationByID(id)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
 	form := forms.New(r.PostForm)
+	form.Required("body")
+	if !form.Valid() {
+		m.renderConversation(w, r, c, form)
+		return
+	}
 
-	// delete existing blocks
-	for _, room := range rooms {
-		curMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", room.ID)).(map[string]int)
-
-		for name, value := range curMap {
-			if val, ok := curMap[name]; ok {
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", room.ID, name)) {
-						// delete the room restriction by id
-						m.DB.DeleteBlockByID(value)
-					}
-				}
-			}
-		}
+	body := strings.TrimSpace(form.Get("body"))
+	_, err = m.DB.InsertConversationReply(models.ConversationMessage{
+		ConversationID: c.ID,
+		UserID:         m.App.Session.GetInt(r.Context(), "user_id"),
+		Body:           body,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	// handle new blocks
-	for name := range r.PostForm {
-		if strings.HasPrefix(name, "add_block") {
-			exploded := strings.Split(name, "_")
-			roomID, _ := strconv.Atoi(exploded[2])
-			// insert a new block
-			t, _ := time.Parse("2006-01-2", exploded[3])
-			m.DB.InsertBlockForRoom(roomID, t)
-		}
+	m.App.MailChan <- models.MailData{
+		To:       c.Email,
+		From:     "me@here.com",
+		Subject:  inbox.Ref(c.Subject, c.Token),
+		Content:  messages.HTML(body),
+		Template: "basic.html",
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved")
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+	m.App.Session.Put(r.Context(), "flash", "Reply sent")
+	http.Redirect(w, r, fmt.Sprintf("/admin/inbox/%d", c.ID), http.StatusSeeOther)
+}
+
+// renderConversation renders a conversation with the reply form
+func (m *Repository) renderConversation(w http.ResponseWriter, r *http.Request, c models.Conversation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["conversation"] = c
+
+	render.Template(w, r, "admin-conversation.page.html", &models.TemplateData{
+		Form: form,
+		Data: data,
+	})
 }
@@ -1,10 +1,11 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
-	"fmt"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -14,7 +15,10 @@ import (
 	"time"
 
 	"github.com/yj-matmul/bookings/internal/driver"
+	"github.com/yj-matmul/bookings/internal/inbox"
 	"github.com/yj-matmul/bookings/internal/models"
+	"github.com/yj-matmul/bookings/internal/payment"
+	"github.com/yj-matmul/bookings/internal/sms"
 )
 
 type postData struct {
@@ -41,6 +45,18 @@ var theTests = []struct {
 	{"log out", "/user/logout", "GET", http.StatusOK},
 
 	{"dashboard", "/admin/dashboard", "GET", http.StatusOK},
+	{"today", "/admin/today", "GET", http.StatusOK},
+	{"out of order", "/admin/out-of-order", "GET", http.StatusOK},
+	{"timeline", "/admin/timeline", "GET", http.StatusOK},
+	{"trash", "/admin/trash", "GET", http.StatusOK},
+	{"bulk actions", "/admin/bulk-actions", "GET", http.StatusOK},
+	{"message templates", "/admin/message-templates", "GET", http.StatusOK},
+	{"message template", "/admin/message-templates/1", "GET", http.StatusOK},
+	{"compose message", "/admin/messages/new?ids=1", "GET", http.StatusOK},
+	{"bulk action", "/admin/bulk-actions/1", "GET", http.StatusOK},
+	{"inbox", "/admin/inbox", "GET", http.StatusOK},
+	{"conversation", "/admin/inbox/1", "GET", http.StatusOK},
+	{"out of order period", "/admin/out-of-order/2", "GET", http.StatusOK},
 	{"reservation new", "/admin/reservations-new", "GET", http.StatusOK},
 	{"reservation all", "/admin/reservations-all", "GET", http.StatusOK},
 	{"show new res", "/admin/reservations/new/1/show", "GET", http.StatusOK},
@@ -92,6 +108,11 @@ var reservationTests = []struct {
 		reservation:        models.Reservation{RoomID: 1, Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
 		expectedStatusCode: http.StatusOK, expectedHTML: `action="/make-reservation"`,
 	},
+	{
+		name:               "quote-reservation",
+		reservation:        models.Reservation{RoomID: 1, Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+		expectedStatusCode: http.StatusOK, expectedHTML: `Cleaning fee`,
+	},
 	{
 		name:               "reservation-not-in-session",
 		reservation:        models.Reservation{},
@@ -140,6 +161,7 @@ func TestRepository_Reservation(t *testing.T) {
 var postReservationTests = []struct {
 	name               string
 	postedData         url.Values
+	holdID             int
 	expectedStatusCode int
 	expectedHTML       string
 	expectedLocation   string
@@ -148,11 +170,20 @@ var postReservationTests = []struct {
 		name: "valid-post-reservation",
 		postedData: url.Values{
 			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
-			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-111"}, "room_id": {"1"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"1"},
 		},
 		expectedStatusCode: http.StatusSeeOther,
 		expectedLocation:   "/reservation-summary",
 	},
+	{
+		name: "invalid-phone-post-reservation",
+		postedData: url.Values{
+			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"call me"}, "room_id": {"1"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Invalid phone number",
+	},
 	{
 		name:               "non-existent-post-reservation",
 		postedData:         nil,
@@ -163,7 +194,7 @@ var postReservationTests = []struct {
 		name: "invalid-start-date-post-reservation",
 		postedData: url.Values{
 			"start_date": {"invalid"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
-			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-111"}, "room_id": {"1"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"1"},
 		},
 		expectedStatusCode: http.StatusSeeOther,
 		expectedLocation:   "/",
@@ -172,7 +203,7 @@ var postReservationTests = []struct {
 		name: "invalid-end-date-post-reservation",
 		postedData: url.Values{
 			"start_date": {"2050-01-02"}, "end_date": {"invalid"}, "first_name": {"John"},
-			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-111"}, "room_id": {"1"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"1"},
 		},
 		expectedStatusCode: http.StatusSeeOther,
 		expectedLocation:   "/",
@@ -181,7 +212,7 @@ var postReservationTests = []struct {
 		name: "invalid-room-id-post-reservation",
 		postedData: url.Values{
 			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
-			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-111"}, "room_id": {"invalid"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"invalid"},
 		},
 		expectedStatusCode: http.StatusSeeOther,
 		expectedLocation:   "/",
@@ -190,16 +221,26 @@ var postReservationTests = []struct {
 		name: "invalid-room-data-post-reservation",
 		postedData: url.Values{
 			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
-			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-111"}, "room_id": {"100000"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"100000"},
 		},
 		expectedStatusCode: http.StatusSeeOther,
 		expectedLocation:   "/",
 	},
+	{
+		name: "invalid-guests-post-reservation",
+		postedData: url.Values{
+			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"1"},
+			"guests": {"0"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       `There has to be at least one guest`,
+	},
 	{
 		name: "missing-first-name-post-reservation",
 		postedData: url.Values{
 			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {""},
-			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-111"}, "room_id": {"1"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"1"},
 		},
 		expectedStatusCode: http.StatusOK,
 		expectedHTML:       `action="/make-reservation"`,
@@ -208,7 +249,7 @@ var postReservationTests = []struct {
 		name: "missing-last-name-post-reservation",
 		postedData: url.Values{
 			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
-			"last_name": {""}, "email": {"john@smith.com"}, "phone": {"555-111"}, "room_id": {"1"},
+			"last_name": {""}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"1"},
 		},
 		expectedStatusCode: http.StatusOK,
 		expectedHTML:       `action="/make-reservation"`,
@@ -217,7 +258,7 @@ var postReservationTests = []struct {
 		name: "missing-email-post-reservation",
 		postedData: url.Values{
 			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
-			"last_name": {"Smith"}, "email": {""}, "phone": {"555-111"}, "room_id": {"1"},
+			"last_name": {"Smith"}, "email": {""}, "phone": {"555-555-0111"}, "room_id": {"1"},
 		},
 		expectedStatusCode: http.StatusOK,
 		expectedHTML:       `action="/make-reservation"`,
@@ -226,7 +267,7 @@ var postReservationTests = []struct {
 		name: "short-first-name-post-reservation",
 		postedData: url.Values{
 			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"J"},
-			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-111"}, "room_id": {"1"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"1"},
 		},
 		expectedStatusCode: http.StatusOK,
 		expectedHTML:       `action="/make-reservation"`,
@@ -235,16 +276,25 @@ var postReservationTests = []struct {
 		name: "invalid-email-post-reservation",
 		postedData: url.Values{
 			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
-			"last_name": {"Smith"}, "email": {"invalid"}, "phone": {"555-111"}, "room_id": {"1"},
+			"last_name": {"Smith"}, "email": {"invalid"}, "phone": {"555-555-0111"}, "room_id": {"1"},
 		},
 		expectedStatusCode: http.StatusOK,
 		expectedHTML:       `action="/make-reservation"`,
 	},
+	{
+		name: "payment-required-post-reservation",
+		postedData: url.Values{
+			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"2"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/payment",
+	},
 	{
 		name: "database-insert--fail-reservation-post-reservation",
 		postedData: url.Values{
 			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
-			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-111"}, "room_id": {"10000"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"10000"},
 		},
 		expectedStatusCode: http.StatusSeeOther,
 		expectedLocation:   "/",
@@ -253,11 +303,51 @@ var postReservationTests = []struct {
 		name: "database-insert--fail-room-restriction-post-reservation",
 		postedData: url.Values{
 			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
-			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-111"}, "room_id": {"10001"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"10001"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+	{
+		name: "held-room-post-reservation",
+		postedData: url.Values{
+			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"10001"},
+		},
+		holdID:             1,
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/reservation-summary",
+	},
+	{
+		name: "expired-hold-post-reservation",
+		postedData: url.Values{
+			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"10001"},
 		},
+		holdID:             2,
 		expectedStatusCode: http.StatusSeeOther,
 		expectedLocation:   "/",
 	},
+	{
+		name: "room-taken-after-hold-expired-post-reservation",
+		postedData: url.Values{
+			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"1000"},
+		},
+		holdID:             2,
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
+	},
+	{
+		name: "hold-for-other-room-post-reservation",
+		postedData: url.Values{
+			"start_date": {"2050-01-02"}, "end_date": {"2050-01-03"}, "first_name": {"John"},
+			"last_name": {"Smith"}, "email": {"john@smith.com"}, "phone": {"555-555-0111"}, "room_id": {"1000"},
+		},
+		holdID:             1,
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search-availability",
+	},
 }
 
 func TestRepository_PostReservation(t *testing.T) {
@@ -272,6 +362,9 @@ func TestRepository_PostReservation(t *testing.T) {
 		req = req.WithContext(ctx)
 		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
 		rr := httptest.NewRecorder()
+		if e.holdID > 0 {
+			session.Put(ctx, "hold_id", e.holdID)
+		}
 		handler := http.HandlerFunc(Repo.PostReservation)
 		handler.ServeHTTP(rr, req)
 
@@ -388,7 +481,7 @@ var postAvailbilityTests = []struct {
 	},
 	{
 		name: "no-left-room-post-availability", postedData: url.Values{"start": {"2021-08-11"}, "end": {"2021-08-12"}},
-		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/search-availability",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/waitlist?s=2021-08-11&e=2021-08-12",
 	},
 }
 
@@ -498,6 +591,16 @@ var chooseRoomTests = []struct {
 		reservation: models.Reservation{RoomID: 1, Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
 		url:         "/choose-room/invalid", expectedStatusCode: http.StatusSeeOther, expectedLocation: "/",
 	},
+	{
+		name:        "room-held-by-someone-else-choose-room",
+		reservation: models.Reservation{RoomID: 1, Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+		url:         "/choose-room/10001", expectedStatusCode: http.StatusSeeOther, expectedLocation: "/search-availability",
+	},
+	{
+		name:        "hold-fails-choose-room",
+		reservation: models.Reservation{RoomID: 1, Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+		url:         "/choose-room/10000", expectedStatusCode: http.StatusSeeOther, expectedLocation: "/",
+	},
 }
 
 func TestRepository_ChooseRoom(t *testing.T) {
@@ -525,6 +628,10 @@ func TestRepository_ChooseRoom(t *testing.T) {
 				t.Errorf("failed %s: expected loaction %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
 			}
 		}
+
+		if e.expectedLocation == "/make-reservation" && session.GetInt(ctx, "hold_id") == 0 {
+			t.Errorf("failed %s: expected room to be held", e.name)
+		}
 	}
 }
 
@@ -630,21 +737,81 @@ var adminPostShowReservationTests = []struct {
 }{
 	{
 		name: "valid-data-from-new-admin-post-show-res", url: "/admin/reservations/new/1",
-		postedData:         url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555"}},
+		postedData:         url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555-555-0111"}, "version": {"1"}},
 		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations-new",
 	},
+	{
+		name: "invalid-phone-admin-post-show-res", url: "/admin/reservations/all/1",
+		postedData:         url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555"}, "version": {"1"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations/all/1/show",
+	},
 	{
 		name: "valid-data-from-all-admin-post-show-res", url: "/admin/reservations/all/1",
-		postedData:         url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555"}},
+		postedData:         url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555-555-0111"}, "version": {"1"}},
 		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations-all",
 	},
 	{
 		name: "valid-data-from-cal-admin-post-show-res", url: "/admin/reservations/cal/1",
 		postedData: url.Values{
-			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555"},
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555-555-0111"}, "version": {"1"},
 			"year": {"2050"}, "month": {"01"}},
 		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations-calendar?y=2050&m=01",
 	},
+	{
+		name: "moved-admin-post-show-res", url: "/admin/reservations/all/1",
+		postedData: url.Values{
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555-555-0111"}, "version": {"1"},
+			"start_date": {"2050-01-01"}, "end_date": {"2050-01-03"}, "room_id": {"2"}, "notify_guest": {"1"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations-all",
+	},
+	{
+		name: "moved-to-taken-room-admin-post-show-res", url: "/admin/reservations/all/1",
+		postedData: url.Values{
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555-555-0111"}, "version": {"1"},
+			"start_date": {"2050-01-01"}, "end_date": {"2050-01-03"}, "room_id": {"10001"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations/all/1/show",
+	},
+	{
+		name: "moved-failing-admin-post-show-res", url: "/admin/reservations/all/1",
+		postedData: url.Values{
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555-555-0111"}, "version": {"1"},
+			"start_date": {"2050-01-01"}, "end_date": {"2050-01-03"}, "room_id": {"10000"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name: "moved-backwards-admin-post-show-res", url: "/admin/reservations/cal/1",
+		postedData: url.Values{
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555-555-0111"}, "version": {"1"},
+			"start_date": {"2050-01-03"}, "end_date": {"2050-01-01"}, "room_id": {"1"},
+			"year": {"2050"}, "month": {"01"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations/cal/1/show?y=2050&m=01",
+	},
+	{
+		name: "invalid-start-date-admin-post-show-res", url: "/admin/reservations/all/1",
+		postedData: url.Values{
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555-555-0111"}, "version": {"1"},
+			"start_date": {"invalid"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name: "missing-version-admin-post-show-res", url: "/admin/reservations/all/1",
+		postedData:         url.Values{"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555-555-0111"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name: "stale-admin-post-show-res", url: "/admin/reservations/all/1",
+		postedData: url.Values{
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555-555-0111"}, "version": {"1000"},
+			"tags": {"vip"}},
+		expectedStatusCode: http.StatusConflict, expectedHTML: `<td>john@smi.com</td>`,
+	},
+	{
+		name: "stale-moved-admin-post-show-res", url: "/admin/reservations/all/1",
+		postedData: url.Values{
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smi.com"}, "phone": {"555-555-0111"}, "version": {"1000"},
+			"start_date": {"2050-01-01"}, "end_date": {"2050-01-03"}, "room_id": {"2"}},
+		expectedStatusCode: http.StatusConflict, expectedHTML: `<input type="hidden" name="version" value="1">`,
+	},
 }
 
 func TestAdminPostShowReservation(t *testing.T) {
@@ -700,6 +867,16 @@ var adminReservationsCalendarTests = []struct {
 		url:                "/admin/reservations-calendar?y=2050&m=01",
 		expectedStatusCode: http.StatusOK, expectedHTML: `action="/admin/reservations-calendar"`,
 	},
+	{
+		name:               "blocks-version-admin-res-cal",
+		url:                "/admin/reservations-calendar?y=2050&m=01",
+		expectedStatusCode: http.StatusOK, expectedHTML: `name="version_1" value="1"`,
+	},
+	{
+		name:               "remove-block-admin-res-cal",
+		url:                "/admin/reservations-calendar?y=2050&m=01",
+		expectedStatusCode: http.StatusOK, expectedHTML: `value="1_1"`,
+	},
 }
 
 func TestAdminReservationsCalendar(t *testing.T) {
@@ -780,44 +957,56 @@ func TestAdminProcessReservation(t *testing.T) {
 	}
 }
 
-var adminDeleteReservationTests = []struct {
+var adminPostDeleteReservationTests = []struct {
 	name               string
 	url                string
+	postedData         url.Values
 	expectedStatusCode int
 	expectedLocation   string
 }{
 	{
-		name:               "res-new-without-year-admin-process-res",
-		url:                "/admin/delete-reservation/new/1/do",
+		name:               "res-new-without-year-admin-delete-res",
+		url:                "/admin/reservations/new/1/delete",
 		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations-new",
 	},
 	{
-		name:               "res-all-without-year-admin-process-res",
-		url:                "/admin/delete-reservation/all/1/do",
+		name:               "res-all-without-year-admin-delete-res",
+		url:                "/admin/reservations/all/1/delete",
 		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations-all",
 	},
 	{
-		name:               "res-cal-without-year-admin-process-res",
-		url:                "/admin/delete-reservation/cal/1/do",
+		name:               "res-cal-without-year-admin-delete-res",
+		url:                "/admin/reservations/cal/1/delete",
 		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations-cal",
 	},
 	{
-		name:               "res-cal-with-year-admin-process-res",
-		url:                "/admin/delete-reservation/cal/1/do?y=2050&m=01",
+		name:               "res-cal-with-year-admin-delete-res",
+		url:                "/admin/reservations/cal/1/delete",
+		postedData:         url.Values{"year": {"2050"}, "month": {"01"}},
 		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations-calendar?y=2050&m=01",
 	},
+	{
+		name:               "failed-admin-delete-res",
+		url:                "/admin/reservations/all/1000/delete",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "invalid-id-admin-delete-res",
+		url:                "/admin/reservations/all/x/delete",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
 }
 
-func TestAdminDeleteReservation(t *testing.T) {
-	for _, e := range adminDeleteReservationTests {
-		req, _ := http.NewRequest("GET", e.url, nil)
+func TestAdminPostDeleteReservation(t *testing.T) {
+	for _, e := range adminPostDeleteReservationTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		req.RequestURI = e.url
 		rr := httptest.NewRecorder()
 
-		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
+		handler := http.HandlerFunc(Repo.AdminPostDeleteReservation)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedStatusCode {
@@ -836,72 +1025,273 @@ func TestAdminDeleteReservation(t *testing.T) {
 var adminPostReservationTests = []struct {
 	name               string
 	postedData         url.Values
-	existBlock         int
 	expectedStatusCode int
 	expectedLocation   string
+	expectedError      string
 }{
 	{
-		name: "exist-block-admin-post-res",
+		name: "add-and-remove-blocks",
 		postedData: url.Values{
-			"y": {time.Now().Format("2006")},
-			"m": {time.Now().Format("01")},
-			fmt.Sprintf("add_block_1_%s", time.Now().Format("2006-01-2")): {"1"},
+			"y":            {"2050"},
+			"m":            {"01"},
+			"version_1":    {"1"},
+			"add_block":    {"1_2050-01-6", "1_2050-01-7"},
+			"remove_block": {"1_3"},
 		},
-		existBlock: 1, expectedStatusCode: http.StatusSeeOther,
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations-calendar?y=2050&m=1",
 	},
 	{
-		name: "no-exist-block-admin-post-res",
+		name:               "nothing-ticked",
+		postedData:         url.Values{"y": {"2050"}, "m": {"01"}, "version_1": {"1"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations-calendar?y=2050&m=1",
+	},
+	{
+		name: "stale-version",
 		postedData: url.Values{
-			"y": {time.Now().Format("2006")},
-			"m": {time.Now().Format("01")},
-			fmt.Sprintf("add_block_1_%s", time.Now().Format("2006-01-2")): {"1"},
+			"y":            {"2050"},
+			"m":            {"01"},
+			"version_1":    {"2"},
+			"remove_block": {"1_3"},
 		},
-		existBlock: 0, expectedStatusCode: http.StatusSeeOther,
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations-calendar?y=2050&m=1",
+		expectedError:      "were changed by someone else",
+	},
+	{
+		name:               "missing-version",
+		postedData:         url.Values{"y": {"2050"}, "m": {"01"}, "add_block": {"1_2050-01-6"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "invalid-add",
+		postedData:         url.Values{"y": {"2050"}, "m": {"01"}, "version_1": {"1"}, "add_block": {"2050-01-6"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "invalid-remove",
+		postedData:         url.Values{"y": {"2050"}, "m": {"01"}, "version_1": {"1"}, "remove_block": {"1_x"}},
+		expectedStatusCode: http.StatusInternalServerError,
 	},
 }
 
 func TestAdminPostReservationsCalendar(t *testing.T) {
 	for _, e := range adminPostReservationTests {
-		var req *http.Request
-		if e.postedData != nil {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
-		} else {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", nil)
-		}
+		req, _ := http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-		blockMap := make(map[string]int)
-		now := time.Now()
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedError != "" && !strings.Contains(session.GetString(ctx, "error"), e.expectedError) {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, session.GetString(ctx, "error"))
+		}
+	}
+}
+
+var pendingReservation = models.Reservation{
+	ID:          1,
+	RoomID:      2,
+	Room:        models.Room{ID: 2, RoomName: "Major's Suite", Price: 22000, PaymentPolicy: models.PaymentPolicyPrepay},
+	Status:      models.ReservationStatusPendingPayment,
+	TotalAmount: 22000,
+}
+
+var paymentTests = []struct {
+	name               string
+	reservation        models.Reservation
+	expectedStatusCode int
+	expectedHTML       string
+	expectedLocation   string
+}{
+	{
+		name:               "pending-reservation-in-session-payment",
+		reservation:        pendingReservation,
+		expectedStatusCode: http.StatusOK, expectedHTML: `action="/payment"`,
+	},
+	{
+		name:               "confirmed-reservation-in-session-payment",
+		reservation:        models.Reservation{ID: 1, RoomID: 1, Status: models.ReservationStatusConfirmed},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/reservation-summary",
+	},
+	{
+		name:               "reservation-not-in-session-payment",
+		reservation:        models.Reservation{},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/",
+	},
+}
+
+func TestRepository_Payment(t *testing.T) {
+	for _, e := range paymentTests {
+		req, _ := http.NewRequest("GET", "/payment", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		if e.reservation.RoomID > 0 {
+			session.Put(ctx, "reservation", e.reservation)
+		}
 
-		year, month, day := now.Date()
-		location := now.Location()
+		handler := http.HandlerFunc(Repo.Payment)
+		handler.ServeHTTP(rr, req)
 
-		curDate := time.Date(year, month, day, 0, 0, 0, 0, location)
-		firstOfMonth := time.Date(year, month, 1, 0, 0, 0, 0, location)
-		lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
 
-		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
-			blockMap[d.Format("2006-01-2")] = 0
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
 		}
 
-		if e.existBlock > 0 {
-			if firstOfMonth.Equal(curDate) {
-				blockMap[firstOfMonth.AddDate(0, 0, 1).Format("2006-01-2")] = e.existBlock
-			} else {
-				blockMap[firstOfMonth.Format("2006-01-2")] = e.existBlock
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
 			}
 		}
+	}
+}
 
-		session.Put(ctx, "block_map_1", blockMap)
+var postPaymentTests = []struct {
+	name               string
+	reservation        models.Reservation
+	cardToken          string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		name:               "valid-card-post-payment",
+		reservation:        pendingReservation,
+		cardToken:          payment.TestCardToken,
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/reservation-summary",
+	},
+	{
+		name:               "declined-card-post-payment",
+		reservation:        pendingReservation,
+		cardToken:          payment.DeclineCardToken,
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/payment",
+	},
+	{
+		name:               "reservation-not-in-session-post-payment",
+		reservation:        models.Reservation{},
+		cardToken:          payment.TestCardToken,
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/",
+	},
+}
 
+func TestRepository_PostPayment(t *testing.T) {
+	for _, e := range postPaymentTests {
+		postedData := url.Values{"card_token": {e.cardToken}}
+		req, _ := http.NewRequest("POST", "/payment", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		rr := httptest.NewRecorder()
-		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+		if e.reservation.RoomID > 0 {
+			session.Put(ctx, "reservation", e.reservation)
+		}
+
+		handler := http.HandlerFunc(Repo.PostPayment)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedStatusCode {
-			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var paymentWebhookTests = []struct {
+	name               string
+	body               string
+	secret             string
+	expectedStatusCode int
+}{
+	{"valid-captured-webhook", `{"type":"payment.captured","ref":"fake_1","amount":22000}`, paymentSecret, http.StatusOK},
+	{"invalid-signature-webhook", `{"type":"payment.captured","ref":"fake_1","amount":22000}`, "wrong", http.StatusBadRequest},
+	{"unknown-payment-webhook", `{"type":"payment.captured","ref":"unknown","amount":22000}`, paymentSecret, http.StatusNotFound},
+	{"replayed-captured-webhook", `{"type":"payment.captured","ref":"fake_captured","amount":22000}`, paymentSecret, http.StatusOK},
+	{"failed-confirm-webhook", `{"type":"payment.captured","ref":"fake_10000","amount":22000}`, paymentSecret, http.StatusInternalServerError},
+	{"failed-webhook", `{"type":"payment.failed","ref":"fake_1","amount":22000}`, paymentSecret, http.StatusOK},
+	{"replayed-failed-webhook", `{"type":"payment.failed","ref":"fake_captured","amount":22000}`, paymentSecret, http.StatusOK},
+	{"failed-release-webhook", `{"type":"payment.failed","ref":"fake_10000","amount":22000}`, paymentSecret, http.StatusInternalServerError},
+}
+
+func TestRepository_PaymentWebhook(t *testing.T) {
+	for _, e := range paymentWebhookTests {
+		req, _ := http.NewRequest("POST", "/payments/webhook", strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(payment.SignatureHeader, payment.Sign(e.secret, []byte(e.body)))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PaymentWebhook)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+var bookingTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedHTML       string
+	expectedLocation   string
+}{
+	{
+		name: "valid-code-booking", url: "/bookings/abc",
+		expectedStatusCode: http.StatusOK, expectedHTML: `action="/bookings/abc/cancel"`,
+	},
+	{
+		name: "invoices-booking", url: "/bookings/abc",
+		expectedStatusCode: http.StatusOK, expectedHTML: `href="/bookings/abc/invoices/1"`,
+	},
+	{
+		name: "cancelled-booking", url: "/bookings/cancelled",
+		expectedStatusCode: http.StatusOK, expectedHTML: `This reservation was cancelled`,
+	},
+	{
+		name: "unknown-code-booking", url: "/bookings/unknown",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/",
+	},
+}
+
+func TestRepository_Booking(t *testing.T) {
+	for _, e := range bookingTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.Booking)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
 		}
 
 		if e.expectedLocation != "" {
@@ -910,6 +1300,2945 @@ func TestAdminPostReservationsCalendar(t *testing.T) {
 				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
 			}
 		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+var postCancelBookingTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedLocation   string
+	expectedWarning    string
+}{
+	{
+		name: "valid-code-cancel-booking", url: "/bookings/abc/cancel",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/bookings/abc",
+	},
+	{
+		name: "already-cancelled-cancel-booking", url: "/bookings/cancelled/cancel",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/bookings/cancelled",
+		expectedWarning: "Reservation is already cancelled",
+	},
+	{
+		name: "cancelled-meanwhile-cancel-booking", url: "/bookings/cancelling/cancel",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/bookings/cancelling",
+		expectedWarning: "Reservation is already cancelled",
+	},
+	{
+		name: "unknown-code-cancel-booking", url: "/bookings/unknown/cancel",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/",
+	},
+}
+
+func TestRepository_PostCancelBooking(t *testing.T) {
+	for _, e := range postCancelBookingTests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostCancelBooking)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedWarning != "" && session.GetString(ctx, "warning") != e.expectedWarning {
+			t.Errorf("failed %s: expected warning %q", e.name, e.expectedWarning)
+		}
+	}
+}
+
+var adminPostCancelReservationTests = []struct {
+	name               string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		name: "from-new-admin-cancel-res", url: "/admin/reservations/new/1/cancel",
+		postedData:         url.Values{},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations-new",
+	},
+	{
+		name: "from-cal-admin-cancel-res", url: "/admin/reservations/cal/1/cancel",
+		postedData:         url.Values{"year": {"2050"}, "month": {"01"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations-calendar?y=2050&m=01",
+	},
+}
+
+func TestAdminCancelReservation(t *testing.T) {
+	target := "/admin/reservations/all/1/cancel"
+	req, _ := http.NewRequest("GET", target, nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = target
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminCancelReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected code %d, but got %d", http.StatusOK, rr.Code)
+	}
+
+	if !strings.Contains(rr.Body.String(), `action="/admin/reservations/all/0/cancel"`) {
+		t.Error("expected to find cancel form, but did not")
+	}
+}
+
+func TestAdminPostCancelReservation(t *testing.T) {
+	for _, e := range adminPostCancelReservationTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostCancelReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var bookingInvoiceTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		name: "valid-booking-invoice", url: "/bookings/abc/invoices/1",
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name: "other-reservation-booking-invoice", url: "/bookings/abc/invoices/2",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/bookings/abc",
+	},
+	{
+		name: "missing-booking-invoice", url: "/bookings/abc/invoices/3",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/bookings/abc",
+	},
+	{
+		name: "invalid-id-booking-invoice", url: "/bookings/abc/invoices/x",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/bookings/abc",
+	},
+	{
+		name: "unknown-code-booking-invoice", url: "/bookings/unknown/invoices/1",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/",
+	},
+}
+
+func TestRepository_BookingInvoice(t *testing.T) {
+	for _, e := range bookingInvoiceTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.BookingInvoice)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if rr.Code == http.StatusOK {
+			if rr.Header().Get("Content-Type") != "application/pdf" {
+				t.Errorf("failed %s: expected a PDF but got %s", e.name, rr.Header().Get("Content-Type"))
+			}
+			if !strings.HasPrefix(rr.Body.String(), "%PDF-") {
+				t.Errorf("failed %s: body is not a PDF", e.name)
+			}
+		}
+	}
+}
+
+var adminInvoiceTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+}{
+	{name: "valid-admin-invoice", url: "/admin/invoices/1", expectedStatusCode: http.StatusOK},
+	{name: "missing-admin-invoice", url: "/admin/invoices/3", expectedStatusCode: http.StatusInternalServerError},
+	{name: "invalid-id-admin-invoice", url: "/admin/invoices/x", expectedStatusCode: http.StatusInternalServerError},
+}
+
+func TestAdminInvoice(t *testing.T) {
+	for _, e := range adminInvoiceTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminInvoice)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if rr.Code == http.StatusOK && !strings.HasPrefix(rr.Body.String(), "%PDF-") {
+			t.Errorf("failed %s: body is not a PDF", e.name)
+		}
+	}
+}
+
+var adminPostIssueInvoiceTests = []struct {
+	name               string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedMessage    string
+}{
+	{
+		name: "open-invoice-admin-issue-invoice", url: "/admin/reservations/all/1/invoice",
+		postedData:         url.Values{},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations/all/1/show",
+		expectedMessage: "Credit the open invoice before issuing a new one",
+	},
+	{
+		name: "from-cal-admin-issue-invoice", url: "/admin/reservations/cal/3/invoice",
+		postedData:         url.Values{"year": {"2050"}, "month": {"01"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations/cal/3/show?y=2050&m=01",
+		expectedMessage: "Invoice INV-000001 issued",
+	},
+	{
+		name: "invalid-id-admin-issue-invoice", url: "/admin/reservations/all/x/invoice",
+		postedData:         url.Values{},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminPostIssueInvoice(t *testing.T) {
+	for _, e := range adminPostIssueInvoiceTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostIssueInvoice)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedMessage != "" {
+			msg := session.PopString(ctx, "flash") + session.PopString(ctx, "warning")
+			if msg != e.expectedMessage {
+				t.Errorf("failed %s: expected message %q, but got %q", e.name, e.expectedMessage, msg)
+			}
+		}
+	}
+}
+
+var adminPostCreditNoteTests = []struct {
+	name               string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedMessage    string
+}{
+	{
+		name: "open-admin-credit-note", url: "/admin/invoices/1/credit-note",
+		postedData:         url.Values{"src": {"new"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations/new/1/show",
+		expectedMessage: "Credit note CN-000001 issued",
+	},
+	{
+		name: "not-open-admin-credit-note", url: "/admin/invoices/2/credit-note",
+		postedData:         url.Values{"src": {"cal"}, "year": {"2050"}, "month": {"01"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations/cal/2/show?y=2050&m=01",
+		expectedMessage: "Only open invoices can be credited",
+	},
+	{
+		name: "missing-admin-credit-note", url: "/admin/invoices/3/credit-note",
+		postedData:         url.Values{},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminPostCreditNote(t *testing.T) {
+	for _, e := range adminPostCreditNoteTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostCreditNote)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedMessage != "" {
+			msg := session.PopString(ctx, "flash") + session.PopString(ctx, "warning")
+			if msg != e.expectedMessage {
+				t.Errorf("failed %s: expected message %q, but got %q", e.name, e.expectedMessage, msg)
+			}
+		}
+	}
+}
+
+func TestAdminTaxFees(t *testing.T) {
+	var tests = []struct {
+		name         string
+		url          string
+		expectedHTML string
+	}{
+		{"rules-admin-tax-fees", "/admin/tax-fees", `href="/admin/tax-fees/3"`},
+		{"totals-admin-tax-fees", "/admin/tax-fees?from=2050-01-01&to=2050-12-31", `value="2050-12-31"`},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminTaxFees)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusOK, rr.Code)
+		}
+
+		if !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminTaxFeeTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{"new-admin-tax-fee", "/admin/tax-fees/0", http.StatusOK, `action="/admin/tax-fees/0"`},
+	{"existing-admin-tax-fee", "/admin/tax-fees/1", http.StatusOK, `value="10"`},
+	{"missing-admin-tax-fee", "/admin/tax-fees/4", http.StatusInternalServerError, ""},
+	{"invalid-id-admin-tax-fee", "/admin/tax-fees/x", http.StatusInternalServerError, ""},
+}
+
+func TestAdminTaxFee(t *testing.T) {
+	for _, e := range adminTaxFeeTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminTaxFee)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminPostTaxFeeTests = []struct {
+	name               string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedHTML       string
+	expectedLocation   string
+}{
+	{
+		name: "new-admin-post-tax-fee", url: "/admin/tax-fees/0",
+		postedData: url.Values{
+			"name": {"City tax"}, "kind": {"tax"}, "basis": {"per_guest_night"}, "amount": {"1.50"},
+			"starts_on": {"2050-01-01"}, "ends_on": {"2050-12-31"},
+		},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/tax-fees",
+	},
+	{
+		name: "update-admin-post-tax-fee", url: "/admin/tax-fees/1",
+		postedData:         url.Values{"name": {"VAT"}, "kind": {"tax"}, "basis": {"percent"}, "amount": {"7.5"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/tax-fees",
+	},
+	{
+		name: "invalid-amount-admin-post-tax-fee", url: "/admin/tax-fees/1",
+		postedData:         url.Values{"name": {"VAT"}, "kind": {"tax"}, "basis": {"percent"}, "amount": {"7.555"}},
+		expectedStatusCode: http.StatusOK, expectedHTML: `at most two decimal places`,
+	},
+	{
+		name: "invalid-basis-admin-post-tax-fee", url: "/admin/tax-fees/1",
+		postedData:         url.Values{"name": {"VAT"}, "kind": {"tax"}, "basis": {"per_week"}, "amount": {"7"}},
+		expectedStatusCode: http.StatusOK, expectedHTML: `Invalid choice`,
+	},
+	{
+		name: "invalid-dates-admin-post-tax-fee", url: "/admin/tax-fees/0",
+		postedData: url.Values{
+			"name": {"City tax"}, "kind": {"tax"}, "basis": {"per_stay"}, "amount": {"1"},
+			"starts_on": {"2050-02-01"}, "ends_on": {"2050-01-01"},
+		},
+		expectedStatusCode: http.StatusOK, expectedHTML: `can&#39;t end before it starts`,
+	},
+	{
+		name: "invalid-id-admin-post-tax-fee", url: "/admin/tax-fees/x",
+		postedData:         url.Values{},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminPostTaxFee(t *testing.T) {
+	for _, e := range adminPostTaxFeeTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostTaxFee)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestAdminPostDeleteTaxFee(t *testing.T) {
+	target := "/admin/tax-fees/1/delete"
+	req, _ := http.NewRequest("POST", target, nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = target
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminPostDeleteTaxFee)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("expected code %d, but got %d", http.StatusSeeOther, rr.Code)
+	}
+
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != "/admin/tax-fees" {
+		t.Errorf("expected location /admin/tax-fees, but got %s", actualLoc.String())
+	}
+}
+
+var cartDate, _ = time.Parse("2006-01-02", "2050-01-01")
+
+var cartItem = models.CartItem{RoomID: 1, RoomName: "General's Quarters", StartDate: cartDate, EndDate: cartDate.AddDate(0, 0, 2)}
+
+var addToCartTests = []struct {
+	name             string
+	url              string
+	reservation      models.Reservation
+	cart             models.Cart
+	expectedLocation string
+	expectedFlash    string
+	expectedWarning  string
+}{
+	{
+		name: "valid-add-to-cart", url: "/cart/add/1",
+		reservation:      models.Reservation{StartDate: cartDate, EndDate: cartDate.AddDate(0, 0, 2)},
+		expectedLocation: "/cart", expectedFlash: "Room added to your cart",
+	},
+	{
+		name: "overlapping-add-to-cart", url: "/cart/add/1",
+		reservation:      models.Reservation{StartDate: cartDate.AddDate(0, 0, 1), EndDate: cartDate.AddDate(0, 0, 3)},
+		cart:             models.Cart{Items: []models.CartItem{cartItem}},
+		expectedLocation: "/cart", expectedWarning: "This room is already in your cart for these dates",
+	},
+	{
+		name: "other-dates-add-to-cart", url: "/cart/add/1",
+		reservation:      models.Reservation{StartDate: cartDate.AddDate(0, 0, 2), EndDate: cartDate.AddDate(0, 0, 3)},
+		cart:             models.Cart{Items: []models.CartItem{cartItem}},
+		expectedLocation: "/cart", expectedFlash: "Room added to your cart",
+	},
+	{
+		name: "no-search-add-to-cart", url: "/cart/add/1",
+		expectedLocation: "/",
+	},
+	{
+		name: "unknown-room-add-to-cart", url: "/cart/add/20000",
+		reservation:      models.Reservation{StartDate: cartDate, EndDate: cartDate.AddDate(0, 0, 2)},
+		expectedLocation: "/",
+	},
+}
+
+func TestRepository_AddToCart(t *testing.T) {
+	for _, e := range addToCartTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+		if !e.reservation.StartDate.IsZero() {
+			session.Put(ctx, "reservation", e.reservation)
+		}
+		if len(e.cart.Items) > 0 {
+			session.Put(ctx, "cart", e.cart)
+		}
+
+		handler := http.HandlerFunc(Repo.AddToCart)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+
+		if flash := session.PopString(ctx, "flash"); flash != e.expectedFlash {
+			t.Errorf("failed %s: expected flash %q but got %q", e.name, e.expectedFlash, flash)
+		}
+
+		if warning := session.PopString(ctx, "warning"); warning != e.expectedWarning {
+			t.Errorf("failed %s: expected warning %q but got %q", e.name, e.expectedWarning, warning)
+		}
+	}
+}
+
+func TestRepository_Cart(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/cart", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	session.Put(ctx, "cart", models.Cart{Items: []models.CartItem{cartItem}})
+
+	handler := http.HandlerFunc(Repo.Cart)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Cart handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	html := rr.Body.String()
+	for _, expected := range []string{`action="/cart/remove/0"`, `name="guests_0"`, `General&#39;s Quarters`} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Cart: expected to find %s but did not", expected)
+		}
+	}
+
+	// an empty cart has nothing to check out
+	req, _ = http.NewRequest("GET", "/cart", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if strings.Contains(rr.Body.String(), `action="/cart"`) {
+		t.Error("Cart: expected no checkout form for an empty cart")
+	}
+}
+
+func TestRepository_PostRemoveFromCart(t *testing.T) {
+	for _, index := range []string{"0", "1"} {
+		url := "/cart/remove/" + index
+		req, _ := http.NewRequest("POST", url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = url
+		rr := httptest.NewRecorder()
+		session.Put(ctx, "cart", models.Cart{Items: []models.CartItem{cartItem}})
+
+		handler := http.HandlerFunc(Repo.PostRemoveFromCart)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("remove %s returned wrong response code: got %d, wanted %d", index, rr.Code, http.StatusSeeOther)
+		}
+
+		cart := session.Get(ctx, "cart").(models.Cart)
+		if index == "0" && len(cart.Items) != 0 {
+			t.Errorf("remove %s: expected empty cart but got %d items", index, len(cart.Items))
+		}
+		if index == "1" && len(cart.Items) != 1 {
+			t.Errorf("remove %s: expected cart to be unchanged but got %d items", index, len(cart.Items))
+		}
+	}
+}
+
+var postCartTests = []struct {
+	name               string
+	postedData         url.Values
+	rooms              []int
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{
+		name: "valid-post-cart",
+		postedData: url.Values{
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+			"phone": {"010-1234-5678"}, "guests_0": {"2"}, "guests_1": {"1"},
+		},
+		rooms:              []int{1, 3},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/booking-summary",
+	},
+	{
+		name: "prepay-room-post-cart",
+		postedData: url.Values{
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+		},
+		rooms:              []int{1, 2},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/payment",
+	},
+	{
+		name: "invalid-data-post-cart",
+		postedData: url.Values{
+			"first_name": {"J"}, "last_name": {"Smith"}, "email": {"john@smith.com"}, "guests_0": {"0"},
+		},
+		rooms:              []int{1},
+		expectedStatusCode: http.StatusOK, expectedHTML: `There has to be at least one guest`,
+	},
+	{
+		name: "empty-cart-post-cart",
+		postedData: url.Values{
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+		},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/search-availability",
+	},
+	{
+		name: "room-taken-post-cart",
+		postedData: url.Values{
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+		},
+		rooms:              []int{1, 10001},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/cart",
+	},
+	{
+		name: "database-error-post-cart",
+		postedData: url.Values{
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+		},
+		rooms:              []int{10000},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/cart",
+	},
+}
+
+func TestRepository_PostCart(t *testing.T) {
+	for _, e := range postCartTests {
+		req, _ := http.NewRequest("POST", "/cart", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		var cart models.Cart
+		for _, id := range e.rooms {
+			item := cartItem
+			item.RoomID = id
+			cart.Items = append(cart.Items, item)
+		}
+		session.Put(ctx, "cart", cart)
+
+		handler := http.HandlerFunc(Repo.PostCart)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+
+		if e.expectedLocation == "/booking-summary" || e.expectedLocation == "/payment" {
+			b, ok := session.Get(ctx, "booking").(models.Booking)
+			if !ok || len(b.Reservations) != len(e.rooms) {
+				t.Errorf("failed %s: expected booking with %d rooms in session", e.name, len(e.rooms))
+			}
+			if session.Exists(ctx, "cart") {
+				t.Errorf("failed %s: expected cart to be emptied", e.name)
+			}
+		}
+	}
+}
+
+func TestRepository_PostPaymentBooking(t *testing.T) {
+	confirmed := models.Reservation{ID: 2, RoomID: 1, BookingID: 1, Status: models.ReservationStatusConfirmed, TotalAmount: 10000}
+	pending := pendingReservation
+	pending.BookingID = 1
+	b := models.Booking{ID: 1, AccessCode: "group", Reservations: []models.Reservation{confirmed, pending}}
+
+	postedData := url.Values{"card_token": {payment.TestCardToken}}
+	req, _ := http.NewRequest("POST", "/payment", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	session.Put(ctx, "booking", b)
+
+	handler := http.HandlerFunc(Repo.PostPayment)
+	handler.ServeHTTP(rr, req)
+
+	actualLoc, _ := rr.Result().Location()
+	if rr.Code != http.StatusSeeOther || actualLoc.String() != "/booking-summary" {
+		t.Errorf("PostPayment for booking: got %d to %s, wanted %d to /booking-summary", rr.Code, actualLoc.String(), http.StatusSeeOther)
+	}
+
+	b = session.Get(ctx, "booking").(models.Booking)
+	for _, res := range b.Reservations {
+		if res.Status != models.ReservationStatusConfirmed {
+			t.Errorf("PostPayment for booking: expected reservation %d to be confirmed but it is %s", res.ID, res.Status)
+		}
+	}
+}
+
+func TestRepository_BookingSummary(t *testing.T) {
+	b := models.Booking{
+		FirstName:  "John",
+		AccessCode: "group",
+		Reservations: []models.Reservation{
+			{ID: 1, Room: models.Room{RoomName: "General's Quarters"}, TotalAmount: 10000},
+			{ID: 2, Room: models.Room{RoomName: "Major's Suite"}, TotalAmount: 22000},
+		},
+	}
+
+	req, _ := http.NewRequest("GET", "/booking-summary", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	session.Put(ctx, "booking", b)
+
+	handler := http.HandlerFunc(Repo.BookingSummary)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("BookingSummary handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	html := rr.Body.String()
+	for _, expected := range []string{"$320.00", `href="/group-bookings/group"`} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("BookingSummary: expected to find %s but did not", expected)
+		}
+	}
+
+	// booking not in session
+	req, _ = http.NewRequest("GET", "/booking-summary", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("BookingSummary handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+}
+
+var groupBookingTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedHTML       string
+	expectedLocation   string
+}{
+	{
+		name: "valid-code-group-booking", url: "/group-bookings/abc",
+		expectedStatusCode: http.StatusOK, expectedHTML: `action="/group-bookings/abc/cancel"`,
+	},
+	{
+		name: "cancel-one-group-booking", url: "/group-bookings/abc",
+		expectedStatusCode: http.StatusOK, expectedHTML: `action="/group-bookings/abc/cancel/2"`,
+	},
+	{
+		name: "partly-cancelled-group-booking", url: "/group-bookings/partly-cancelled",
+		expectedStatusCode: http.StatusOK, expectedHTML: `Cancelled, refund`,
+	},
+	{
+		name: "unknown-code-group-booking", url: "/group-bookings/unknown",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/",
+	},
+}
+
+func TestRepository_GroupBooking(t *testing.T) {
+	for _, e := range groupBookingTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.GroupBooking)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+var postCancelGroupBookingTests = []struct {
+	name             string
+	url              string
+	expectedLocation string
+	expectedFlash    string
+	expectedWarning  string
+}{
+	{
+		name: "all-rooms-cancel-group-booking", url: "/group-bookings/abc/cancel",
+		expectedLocation: "/group-bookings/abc", expectedFlash: "2 room(s) cancelled",
+	},
+	{
+		name: "one-room-cancel-group-booking", url: "/group-bookings/abc/cancel/2",
+		expectedLocation: "/group-bookings/abc", expectedFlash: "1 room(s) cancelled",
+	},
+	{
+		name: "already-cancelled-cancel-group-booking", url: "/group-bookings/partly-cancelled/cancel/2",
+		expectedLocation: "/group-bookings/partly-cancelled", expectedWarning: "Nothing left to cancel",
+	},
+	{
+		name: "invalid-id-cancel-group-booking", url: "/group-bookings/abc/cancel/x",
+		expectedLocation: "/group-bookings/abc",
+	},
+	{
+		name: "unknown-code-cancel-group-booking", url: "/group-bookings/unknown/cancel",
+		expectedLocation: "/",
+	},
+}
+
+func TestRepository_PostCancelGroupBooking(t *testing.T) {
+	for _, e := range postCancelGroupBookingTests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostCancelGroupBooking)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+
+		if flash := session.PopString(ctx, "flash"); !strings.HasPrefix(flash, e.expectedFlash) || (e.expectedFlash == "" && flash != "") {
+			t.Errorf("failed %s: expected flash %q but got %q", e.name, e.expectedFlash, flash)
+		}
+
+		if warning := session.PopString(ctx, "warning"); warning != e.expectedWarning {
+			t.Errorf("failed %s: expected warning %q but got %q", e.name, e.expectedWarning, warning)
+		}
+	}
+}
+
+func TestRepository_Waitlist(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/waitlist?s=2050-01-02&e=2050-01-04", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.Waitlist)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Waitlist handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	if !strings.Contains(rr.Body.String(), `value="2050-01-02"`) {
+		t.Error("Waitlist: expected arrival date to be filled in")
+	}
+}
+
+var postWaitlistTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedHTML       string
+	expectedLocation   string
+}{
+	{
+		name: "valid-post-waitlist",
+		postedData: url.Values{
+			"start_date": {"2050-01-02"}, "end_date": {"2050-01-04"}, "guests": {"2"},
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+		},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/",
+	},
+	{
+		name: "end-before-start-post-waitlist",
+		postedData: url.Values{
+			"start_date": {"2050-01-04"}, "end_date": {"2050-01-02"}, "guests": {"2"},
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+		},
+		expectedStatusCode: http.StatusOK, expectedHTML: `Departure has to be after arrival`,
+	},
+	{
+		name: "no-guests-post-waitlist",
+		postedData: url.Values{
+			"start_date": {"2050-01-02"}, "end_date": {"2050-01-04"}, "guests": {"0"},
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john@smith.com"},
+		},
+		expectedStatusCode: http.StatusOK, expectedHTML: `There has to be at least one guest`,
+	},
+	{
+		name: "invalid-email-post-waitlist",
+		postedData: url.Values{
+			"start_date": {"2050-01-02"}, "end_date": {"2050-01-04"}, "guests": {"1"},
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"john"},
+		},
+		expectedStatusCode: http.StatusOK, expectedHTML: `Invalid email address`,
+	},
+	{
+		name: "database-error-post-waitlist",
+		postedData: url.Values{
+			"start_date": {"2050-01-02"}, "end_date": {"2050-01-04"}, "guests": {"1"},
+			"first_name": {"John"}, "last_name": {"Smith"}, "email": {"fail@here.com"},
+		},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/",
+	},
+}
+
+func TestRepository_PostWaitlist(t *testing.T) {
+	for _, e := range postWaitlistTests {
+		req, _ := http.NewRequest("POST", "/waitlist", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostWaitlist)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+var waitlistOfferTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedHTML       string
+	expectedLocation   string
+}{
+	{
+		name: "valid-waitlist-offer", url: "/waitlist/abc",
+		expectedStatusCode: http.StatusOK, expectedHTML: `Choose a Room`,
+	},
+	{
+		name: "expired-waitlist-offer", url: "/waitlist/expired",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/search-availability",
+	},
+	{
+		name: "booked-waitlist-offer", url: "/waitlist/booked",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/search-availability",
+	},
+	{
+		name: "unknown-waitlist-offer", url: "/waitlist/unknown",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/",
+	},
+	{
+		name: "too-small-waitlist-offer", url: "/waitlist/party",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/",
+	},
+}
+
+func TestRepository_WaitlistOffer(t *testing.T) {
+	for _, e := range waitlistOfferTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.WaitlistOffer)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+
+			res := session.Get(ctx, "reservation").(models.Reservation)
+			if res.Guests != 2 || session.GetInt(ctx, "waitlist_id") != 1 {
+				t.Errorf("failed %s: expected waitlist reservation in session", e.name)
+			}
+		}
+	}
+}
+
+func TestNotifyWaitlist(t *testing.T) {
+	// replace the mail listener so the offers can be inspected
+	testApp := app
+	mailChan := make(chan models.MailData, 10)
+	testApp.MailChan = mailChan
+	repo := NewTestRepo(&testApp)
+
+	repo.NotifyWaitlist()
+	close(mailChan)
+
+	var sent []string
+	for msg := range mailChan {
+		sent = append(sent, msg.To)
+	}
+
+	// the sold-out stay and the party too big for the free room stay on the waitlist
+	if len(sent) != 1 || sent[0] != "first@here.com" {
+		t.Errorf("NotifyWaitlist: expected one offer to first@here.com but sent %v", sent)
+	}
+}
+
+func TestCancelReservationRefundFailed(t *testing.T) {
+	// replace the mail listener so the cancellation mail can be inspected
+	testApp := app
+	mailChan := make(chan models.MailData, 10)
+	testApp.MailChan = mailChan
+	repo := NewTestRepo(&testApp)
+
+	res := models.Reservation{ID: 1002, FirstName: "John", Email: "john@smith.com"}
+	err := repo.cancelReservation(res, 5000)
+	if err != errRefundFailed {
+		t.Errorf("expected the refund to fail, got %v", err)
+	}
+	close(mailChan)
+
+	told := false
+	for msg := range mailChan {
+		if msg.To == res.Email && strings.Contains(msg.Content, "will follow") {
+			told = true
+		}
+	}
+	if !told {
+		t.Error("expected the guest to be told about the cancellation and the pending refund")
+	}
+}
+
+func TestRetryFailedRefunds(t *testing.T) {
+	// the gateway still doesn't know the transaction, so the refund stays failed
+	n, err := Repo.RetryFailedRefunds()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("expected no refund to be made, got %d", n)
+	}
+}
+
+func TestAdminGuests(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/guests", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminGuests)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminGuests handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	html := rr.Body.String()
+	for _, expected := range []string{`href="/admin/guests/1"`, `Do not rent`} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("AdminGuests: expected to find %s but did not", expected)
+		}
+	}
+}
+
+var adminGuestTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{"upcoming-admin-guest", "/admin/guests/1", http.StatusOK, `href="/admin/reservations/all/2/show"`},
+	{"past-admin-guest", "/admin/guests/1", http.StatusOK, `href="/admin/reservations/all/1/show"`},
+	{"notes-admin-guest", "/admin/guests/1", http.StatusOK, `Celebrates anniversary in August`},
+	{"missing-admin-guest", "/admin/guests/3", http.StatusInternalServerError, ""},
+	{"invalid-id-admin-guest", "/admin/guests/x", http.StatusInternalServerError, ""},
+}
+
+func TestAdminGuest(t *testing.T) {
+	for _, e := range adminGuestTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminGuest)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminPostGuestTests = []struct {
+	name               string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{
+		name: "valid-admin-post-guest", url: "/admin/guests/1",
+		postedData:         url.Values{"flag": {"do_not_rent"}, "notes": {"Damaged the room"}},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name: "invalid-flag-admin-post-guest", url: "/admin/guests/1",
+		postedData:         url.Values{"flag": {"banned"}},
+		expectedStatusCode: http.StatusOK, expectedHTML: `Invalid choice`,
+	},
+	{
+		name: "missing-admin-post-guest", url: "/admin/guests/3",
+		postedData:         url.Values{"flag": {""}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminPostGuest(t *testing.T) {
+	for _, e := range adminPostGuestTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostGuest)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+func TestGuestWarning(t *testing.T) {
+	tests := []struct {
+		email    string
+		expected string
+	}{
+		{"john@smith.com", "VIP guest"},
+		{"jane@doe.com", "do not rent"},
+		{"new@here.com", ""},
+	}
+
+	for _, e := range tests {
+		warning := Repo.guestWarning(e.email)
+		if e.expected == "" && warning != "" {
+			t.Errorf("%s: expected no warning but got %q", e.email, warning)
+		}
+		if !strings.Contains(warning, e.expected) {
+			t.Errorf("%s: expected warning to contain %q but got %q", e.email, e.expected, warning)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tags := parseTags(" Late-Arrival, vip,, allergy ,VIP ")
+	expected := []string{"late-arrival", "vip", "allergy"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("parseTags: expected %v but got %v", expected, tags)
+	}
+
+	if tags := parseTags(" , "); len(tags) != 0 {
+		t.Errorf("parseTags: expected no tags but got %v", tags)
+	}
+}
+
+var adminAllReservationsTagTests = []struct {
+	name         string
+	url          string
+	expectedHTML string
+	missingHTML  string
+}{
+	{"no-tag", "/admin/reservations-all", `href="/admin/reservations/all/2/show"`, ""},
+	{"tag-vip", "/admin/reservations-all?tag=vip", `href="/admin/reservations/all/1/show"`, `href="/admin/reservations/all/2/show"`},
+	{"tag-allergy", "/admin/reservations-all?tag=allergy", `href="/admin/reservations/all/2/show"`, `href="/admin/reservations/all/1/show"`},
+	{"tag-unknown", "/admin/reservations-all?tag=pets", `0 reservation(s)`, `href="/admin/reservations/all/1/show"`},
+}
+
+func TestAdminAllReservationsByTag(t *testing.T) {
+	for _, e := range adminAllReservationsTagTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminAllReservations)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, http.StatusOK, rr.Code)
+		}
+
+		html := rr.Body.String()
+		if !strings.Contains(html, e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+		if e.missingHTML != "" && strings.Contains(html, e.missingHTML) {
+			t.Errorf("failed %s: did not expect to find %s", e.name, e.missingHTML)
+		}
+	}
+}
+
+func TestAdminShowReservationNotes(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations/all/1/show", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.RequestURI = "/admin/reservations/all/1/show"
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminShowReservation)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminShowReservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	html := rr.Body.String()
+	for _, expected := range []string{`Admin User`, `Arrives after midnight`, `value="Add Note"`} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("AdminShowReservation: expected to find %s but did not", expected)
+		}
+	}
+}
+
+var adminPostReservationNoteTests = []struct {
+	name               string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		name: "valid-note", url: "/admin/reservations/all/1/notes",
+		postedData:         url.Values{"body": {"Asked for extra towels"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations/all/1/show",
+	},
+	{
+		name: "valid-note-from-calendar", url: "/admin/reservations/cal/1/notes",
+		postedData:         url.Values{"body": {"Asked for extra towels"}, "year": {"2050"}, "month": {"01"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations/cal/1/show?y=2050&m=01",
+	},
+	{
+		name: "blank-note", url: "/admin/reservations/all/1/notes",
+		postedData:         url.Values{"body": {"   "}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations/all/1/show",
+	},
+	{
+		name: "failed-insert-note", url: "/admin/reservations/all/10000/notes",
+		postedData:         url.Values{"body": {"Asked for extra towels"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name: "invalid-id-note", url: "/admin/reservations/all/x/notes",
+		postedData:         url.Values{"body": {"Asked for extra towels"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminPostReservationNote(t *testing.T) {
+	for _, e := range adminPostReservationNoteTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostReservationNote)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var adminReservationListTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedHTML       string
+	missingHTML        string
+}{
+	{
+		name: "search-by-name", url: "/admin/reservations-all?q=doe",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       `href="/admin/reservations/all/2/show"`, missingHTML: `href="/admin/reservations/all/1/show"`,
+	},
+	{
+		name: "sort-link-flips-direction", url: "/admin/reservations-all?sort=last_name",
+		expectedStatusCode: http.StatusOK, expectedHTML: `href="/admin/reservations-all?dir=desc&amp;sort=last_name"`,
+	},
+	{
+		name: "sort-link-keeps-search", url: "/admin/reservations-new?q=smith&page=2",
+		expectedStatusCode: http.StatusOK, expectedHTML: `href="/admin/reservations-new?q=smith&amp;sort=room"`,
+	},
+	{
+		name: "failed-search", url: "/admin/reservations-all?room=10000",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminReservationLists(t *testing.T) {
+	for _, e := range adminReservationListTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminAllReservations)
+		if strings.HasPrefix(e.url, "/admin/reservations-new") {
+			handler = Repo.AdminNewReservations
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		html := rr.Body.String()
+		if e.expectedHTML != "" && !strings.Contains(html, e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+		if e.missingHTML != "" && strings.Contains(html, e.missingHTML) {
+			t.Errorf("failed %s: did not expect to find %s", e.name, e.missingHTML)
+		}
+	}
+}
+
+func TestReservationFilterFromQuery(t *testing.T) {
+	q, _ := url.ParseQuery("q=+Smith+&room=2&status=confirmed&tag=VIP&from=2050-01-01&to=bad&created_to=2049-12-31&sort=room&dir=desc&page=3")
+	f := reservationFilterFromQuery(q)
+
+	if f.Search != "Smith" || f.RoomID != 2 || f.Status != "confirmed" || f.Tag != "vip" {
+		t.Errorf("unexpected filter fields: %+v", f)
+	}
+	if f.From.Format("2006-01-02") != "2050-01-01" || !f.To.IsZero() || f.CreatedTo.Format("2006-01-02") != "2049-12-31" {
+		t.Errorf("unexpected filter dates: %+v", f)
+	}
+	if f.Sort != "room" || !f.Desc || f.Page != 3 || f.PerPage != reservationsPerPage {
+		t.Errorf("unexpected filter paging: %+v", f)
+	}
+
+	if f := reservationFilterFromQuery(url.Values{"page": {"-1"}}); f.Page != 1 {
+		t.Errorf("expected page 1 but got %d", f.Page)
+	}
+
+	// wildcards are searched for literally, so they reach the repository as typed
+	if f := reservationFilterFromQuery(url.Values{"q": {"50%_off"}}); f.Search != "50%_off" {
+		t.Errorf("expected search 50%%_off but got %q", f.Search)
+	}
+}
+
+var adminExportTests = []struct {
+	name                string
+	url                 string
+	expectedStatusCode  int
+	expectedContentType string
+	expectedBody        string
+	expectedLocation    string
+}{
+	{
+		name: "reservations-csv", url: "/admin/export/reservations/csv?tag=vip",
+		expectedStatusCode: http.StatusOK, expectedContentType: "text/csv; charset=utf-8",
+		expectedBody: "1,,Smith,,,,2050-01-02,2050-01-04,2,0,,0.00,\"late-arrival, vip\",\n",
+	},
+	{
+		name: "reservations-xlsx", url: "/admin/export/reservations/xlsx",
+		expectedStatusCode:  http.StatusOK,
+		expectedContentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", expectedBody: "PK",
+	},
+	{
+		name: "reservations-failing", url: "/admin/export/reservations/csv?room=10000",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name: "reservations-unknown-format", url: "/admin/export/reservations/pdf",
+		expectedStatusCode: http.StatusNotFound,
+	},
+	{
+		name: "guests-csv", url: "/admin/export/guests/csv",
+		expectedStatusCode: http.StatusOK, expectedContentType: "text/csv; charset=utf-8",
+		expectedBody: "2,Jane,Doe,jane@doe.com,,do_not_rent,1,,,\n",
+	},
+	{
+		name: "movements-csv", url: "/admin/export/movements/csv?start=2050-01-01&end=2050-01-31",
+		expectedStatusCode: http.StatusOK, expectedContentType: "text/csv; charset=utf-8",
+		expectedBody: "2050-01-03,departure,,,Doe,,,0,2050-01-02,2050-01-03,1,2\n",
+	},
+	{
+		name: "movements-missing-dates", url: "/admin/export/movements/csv?start=2050-01-01",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/dashboard",
+	},
+	{
+		name: "movements-backwards", url: "/admin/export/movements/csv?start=2050-01-31&end=2050-01-01",
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/dashboard",
+	},
+	{
+		name: "movements-failing", url: "/admin/export/movements/csv?start=2000-01-01&end=2000-01-31",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminExports(t *testing.T) {
+	for _, e := range adminExportTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		var handler http.HandlerFunc
+		switch {
+		case strings.HasPrefix(e.url, "/admin/export/guests"):
+			handler = Repo.AdminExportGuests
+		case strings.HasPrefix(e.url, "/admin/export/movements"):
+			handler = Repo.AdminExportMovements
+		default:
+			handler = Repo.AdminExportReservations
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedContentType != "" && rr.Header().Get("Content-Type") != e.expectedContentType {
+			t.Errorf("failed %s: expected content type %s, but got %s", e.name, e.expectedContentType, rr.Header().Get("Content-Type"))
+		}
+
+		if e.expectedBody != "" && !strings.Contains(rr.Body.String(), e.expectedBody) {
+			t.Errorf("failed %s: expected body to contain %q, but got %q", e.name, e.expectedBody, rr.Body.String())
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+const importHeader = "first_name,last_name,email,phone,room,start_date,end_date,guests,total\n"
+
+var adminPostImportTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       []string
+	missingHTML        string
+}{
+	{
+		name: "preview-valid",
+		postedData: url.Values{"action": {"preview"}, "csv": {importHeader +
+			"John,Smith,john@smith.com,555 555 0111,1,2050-02-01,2050-02-03,2,\n" +
+			"Jane,Doe,jane@doe.com,,general's quarters,2050-02-03,2050-02-04,,150.00\n"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       []string{`all of them can be imported`, `value="Import 2 reservation(s)"`, `$150.00`},
+	},
+	{
+		name: "preview-invalid-rows",
+		postedData: url.Values{"action": {"preview"}, "csv": {importHeader +
+			"Jo,Smith,not-an-email,,1,2050-02-01,2050-02-03,0,\n" +
+			"John,Smith,john@smith.com,,9,2050-02-03,2050-02-01,,\n" +
+			"John,Smith,john@smith.com,,20000,soon,2050-02-01,,\n" +
+			"John,Smith,john@smith.com,,1,2049-02-01,2049-02-03,,-5\n"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML: []string{
+			`4 with errors`,
+			`first_name: This field must be at least 3 characters long`,
+			`email: Invalid email address`,
+			`guests: There has to be at least one guest`,
+			`end_date: the departure has to be after the arrival`,
+			`room: unknown room &#34;20000&#34;`,
+			`start_date: can&#39;t parse date`,
+			`total: invalid amount`,
+			`room: conflicts with an existing stay or block`,
+		},
+		missingHTML: `name="action" value="import"`,
+	},
+	{
+		name: "preview-overlapping-rows",
+		postedData: url.Values{"action": {"preview"}, "csv": {importHeader +
+			"John,Smith,john@smith.com,,1,2050-02-01,2050-02-03,,\n" +
+			"Jane,Doe,jane@doe.com,,1,2050-02-02,2050-02-05,,\n"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       []string{`overlaps line 2 in the same room`},
+	},
+	{
+		name: "import-valid",
+		postedData: url.Values{"action": {"import"}, "csv": {importHeader +
+			"John,Smith,john@smith.com,555 555 0111,1,2050-02-01,2050-02-03,2,\n"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/reservations-all",
+	},
+	{
+		name: "import-with-invalid-rows",
+		postedData: url.Values{"action": {"import"}, "csv": {importHeader +
+			"Jo,Smith,john@smith.com,555 555 0111,1,2050-02-01,2050-02-03,2,\n"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       []string{`1 with errors`},
+	},
+	{
+		name: "import-room-taken-meanwhile",
+		postedData: url.Values{"action": {"import"}, "csv": {importHeader +
+			"John,Smith,john@smith.com,555 555 0111,1,2050-02-01,2050-02-03,2,\n" +
+			"John,Taken,john@smith.com,555 555 0111,1,2050-03-01,2050-03-03,2,\n"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       []string{`the room has been taken in the meantime`, `1 with errors`},
+	},
+	{
+		name: "import-failing-row",
+		postedData: url.Values{"action": {"import"}, "csv": {importHeader +
+			"John,Fail,john@smith.com,555 555 0111,1,2050-02-01,2050-02-03,2,\n"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       []string{`could not be saved: some error`},
+	},
+	{
+		name:               "missing-column",
+		postedData:         url.Values{"csv": {"first_name,last_name,email\nJohn,Smith,john@smith.com\n"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/import",
+	},
+	{
+		name:               "no-rows",
+		postedData:         url.Values{"csv": {importHeader}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/import",
+	},
+	{
+		name:               "no-file",
+		postedData:         url.Values{},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/import",
+	},
+}
+
+func TestAdminPostImport(t *testing.T) {
+	for _, e := range adminPostImportTests {
+		req, _ := http.NewRequest("POST", "/admin/import", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostImport)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		html := rr.Body.String()
+		for _, expected := range e.expectedHTML {
+			if !strings.Contains(html, expected) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, expected)
+			}
+		}
+		if e.missingHTML != "" && strings.Contains(html, e.missingHTML) {
+			t.Errorf("failed %s: did not expect to find %s", e.name, e.missingHTML)
+		}
+	}
+}
+
+func TestAdminPostImportUpload(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	_ = mw.WriteField("action", "preview")
+	fw, _ := mw.CreateFormFile("file", "reservations.csv")
+	_, _ = fw.Write([]byte("\ufeff" + importHeader + "John,Smith,john@smith.com,555 555 0111,1,2050-02-01,2050-02-03,2,\n"))
+	mw.Close()
+
+	req, _ := http.NewRequest("POST", "/admin/import", &body)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminPostImport)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("AdminPostImport handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	// the preview carries the file along so it can be confirmed without uploading it again
+	if !strings.Contains(rr.Body.String(), "John,Smith,john@smith.com,555 555 0111,1,2050-02-01") {
+		t.Error("AdminPostImport: expected the preview to carry the uploaded file")
+	}
+}
+
+var adminDashboardTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedHTML       []string
+}{
+	{
+		name:               "month",
+		url:                "/admin/dashboard?date=2021-09-15",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML: []string{
+			"September 2021", "compared with August 2021",
+			"25.0%", "(was 11.3%)", "&#43;121.4%",
+			"$173.33", "Major&#39;s Suite",
+			`"labels":["April 2021","May 2021","June 2021","July 2021","August 2021","September 2021"]`,
+			"date=2021-08-31", "date=2021-10-01",
+		},
+	},
+	{
+		name:               "week",
+		url:                "/admin/dashboard?period=week&date=2021-09-15",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       []string{"Week of 2021-09-13", "compared with Week of 2021-09-06", "date=2021-09-12", "date=2021-09-20"},
+	},
+	{
+		name:               "sellable-nights",
+		url:                "/admin/dashboard?date=2021-09-15&sellable=1",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       []string{"30.0%", "Count out-of-order nights"},
+	},
+	{
+		name:               "database-error",
+		url:                "/admin/dashboard?date=1999-01-01",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminDashboard(t *testing.T) {
+	for _, e := range adminDashboardTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDashboard)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		for _, html := range e.expectedHTML {
+			if !strings.Contains(rr.Body.String(), html) {
+				t.Errorf("failed %s: expected to find %s in response", e.name, html)
+			}
+		}
+	}
+}
+
+var adminTodayTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedHTML       []string
+}{
+	{
+		name: "arrival", url: "/admin/today?date=2050-01-02",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       []string{"Sunday, January 2, 2050", `action="/admin/today/check-in/1"`, "Room not ready", "No departures", "All rooms are taken"},
+	},
+	{
+		name: "departure", url: "/admin/today?date=2050-01-03",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       []string{"No arrivals", `action="/admin/today/check-out/1"`, "No owner blocks start or end today", "General&#39;s Quarters\n"},
+	},
+	{
+		name: "owner-block", url: "/admin/today?date=2050-01-04",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       []string{"No arrivals", "No departures", "No stay-overs", "<td>2050-01-04</td>", "All rooms are taken"},
+	},
+	{
+		name: "out-of-order", url: "/admin/today?date=2050-01-07",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       []string{"No owner blocks start or end today", "All rooms are taken"},
+	},
+	{
+		name: "printable", url: "/admin/today?date=2050-01-02&print=1",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       []string{"Front desk - Sunday, January 2, 2050", "No departures"},
+	},
+	{
+		name: "failed-generate", url: "/admin/today?date=2000-01-01",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminToday(t *testing.T) {
+	for _, e := range adminTodayTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminToday)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		for _, html := range e.expectedHTML {
+			if !strings.Contains(rr.Body.String(), html) {
+				t.Errorf("failed %s: expected to find %s in response", e.name, html)
+			}
+		}
+	}
+}
+
+var adminPostCheckInOutTests = []struct {
+	name               string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		name: "check-in", url: "/admin/today/check-in/1",
+		postedData:         url.Values{"date": {"2050-01-02"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/today?date=2050-01-02",
+	},
+	{
+		name: "check-out-without-date", url: "/admin/today/check-out/1",
+		postedData:         url.Values{},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/today",
+	},
+	{
+		name: "failed-check-in", url: "/admin/today/check-in/1000",
+		postedData:         url.Values{"date": {"2050-01-02"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name: "failed-check-out", url: "/admin/today/check-out/1000",
+		postedData:         url.Values{"date": {"2050-01-03"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name: "invalid-id", url: "/admin/today/check-in/x",
+		postedData:         url.Values{},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminPostCheckInOut(t *testing.T) {
+	for _, e := range adminPostCheckInOutTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostCheckIn)
+		if strings.Contains(e.url, "check-out") {
+			handler = Repo.AdminPostCheckOut
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var housekeepingTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedHTML       []string
+	missingHTML        string
+}{
+	{
+		name: "arrival-in-dirty-room", url: "/housekeeping?date=2050-01-02",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       []string{"Guest arriving today", "Departure cleaning", "border-danger", `action="/housekeeping/rooms/1"`},
+	},
+	{
+		name: "inspected-room", url: "/housekeeping?date=2050-01-03",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       []string{`<span class="badge bg-success">Inspected</span>`},
+		missingHTML:        "Guest arriving today",
+	},
+	{
+		name: "failed-generate", url: "/housekeeping?date=2000-01-01",
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestHousekeeping(t *testing.T) {
+	for _, e := range housekeepingTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.Housekeeping)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		for _, html := range e.expectedHTML {
+			if !strings.Contains(rr.Body.String(), html) {
+				t.Errorf("failed %s: expected to find %s in response", e.name, html)
+			}
+		}
+
+		if e.missingHTML != "" && strings.Contains(rr.Body.String(), e.missingHTML) {
+			t.Errorf("failed %s: did not expect to find %s in response", e.name, e.missingHTML)
+		}
+	}
+}
+
+var postHousekeepingRoomTests = []struct {
+	name               string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		name: "room-with-task", url: "/housekeeping/rooms/1",
+		postedData:         url.Values{"status": {"clean"}, "date": {"2050-01-02"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/housekeeping?date=2050-01-02",
+	},
+	{
+		name: "room-without-task", url: "/housekeeping/rooms/2",
+		postedData:         url.Values{"status": {"dirty"}, "date": {"2050-01-02"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/housekeeping?date=2050-01-02",
+	},
+	{
+		name: "unknown-status", url: "/housekeeping/rooms/1",
+		postedData:         url.Values{"status": {"sparkling"}, "date": {"2050-01-02"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/housekeeping?date=2050-01-02",
+	},
+	{
+		name: "failed-upsert", url: "/housekeeping/rooms/10000",
+		postedData:         url.Values{"status": {"dirty"}, "date": {"2050-01-02"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name: "invalid-id", url: "/housekeeping/rooms/x",
+		postedData:         url.Values{"status": {"dirty"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestPostHousekeepingRoom(t *testing.T) {
+	for _, e := range postHousekeepingRoomTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostHousekeepingRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var adminOutOfOrderTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{name: "list", url: "/admin/out-of-order", expectedStatusCode: http.StatusOK, expectedHTML: "Leaking shower"},
+	{name: "failed-list", url: "/admin/out-of-order?from=2000-01-01", expectedStatusCode: http.StatusInternalServerError},
+	{name: "new", url: "/admin/out-of-order/0", expectedStatusCode: http.StatusOK, expectedHTML: `<option value="open" selected>`},
+	{name: "existing", url: "/admin/out-of-order/2", expectedStatusCode: http.StatusOK, expectedHTML: `value="Leaking shower"`},
+	{name: "failed-get", url: "/admin/out-of-order/1000", expectedStatusCode: http.StatusInternalServerError},
+	{name: "invalid-id", url: "/admin/out-of-order/x", expectedStatusCode: http.StatusInternalServerError},
+	{name: "calendar", url: "/admin/reservations-calendar?y=2050&m=01", expectedStatusCode: http.StatusOK, expectedHTML: `href="/admin/out-of-order/2"`},
+}
+
+func TestAdminOutOfOrder(t *testing.T) {
+	for _, e := range adminOutOfOrderTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		var handler http.HandlerFunc
+		switch {
+		case strings.HasPrefix(e.url, "/admin/reservations-calendar"):
+			handler = Repo.AdminReservationsCalendar
+		case strings.HasPrefix(e.url, "/admin/out-of-order/"):
+			handler = Repo.AdminOutOfOrderPeriod
+		default:
+			handler = Repo.AdminOutOfOrder
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in response", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminPostOutOfOrderTests = []struct {
+	name               string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{
+		name: "new-period", url: "/admin/out-of-order/0",
+		postedData:         url.Values{"room_id": {"1"}, "start_date": {"2050-02-01"}, "end_date": {"2050-02-03"}, "reason": {"Painting"}, "status": {"open"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/out-of-order",
+	},
+	{
+		name: "updated-period", url: "/admin/out-of-order/2",
+		postedData:         url.Values{"room_id": {"1"}, "start_date": {"2050-01-06"}, "end_date": {"2050-01-08"}, "reason": {"Leaking shower"}, "assigned_to": {"Bob"}, "status": {"resolved"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/out-of-order",
+	},
+	{
+		name: "missing-reason", url: "/admin/out-of-order/0",
+		postedData:         url.Values{"room_id": {"1"}, "start_date": {"2050-02-01"}, "end_date": {"2050-02-03"}, "status": {"open"}},
+		expectedStatusCode: http.StatusOK, expectedHTML: "This field cannot be blank",
+	},
+	{
+		name: "ends-before-start", url: "/admin/out-of-order/0",
+		postedData:         url.Values{"room_id": {"1"}, "start_date": {"2050-02-03"}, "end_date": {"2050-02-03"}, "reason": {"Painting"}, "status": {"open"}},
+		expectedStatusCode: http.StatusOK, expectedHTML: "The room has to be back in service after the period starts",
+	},
+	{
+		name: "unknown-status", url: "/admin/out-of-order/0",
+		postedData:         url.Values{"room_id": {"1"}, "start_date": {"2050-02-01"}, "end_date": {"2050-02-03"}, "reason": {"Painting"}, "status": {"later"}},
+		expectedStatusCode: http.StatusOK, expectedHTML: "Invalid choice",
+	},
+	{
+		name: "room-booked", url: "/admin/out-of-order/0",
+		postedData:         url.Values{"room_id": {"10001"}, "start_date": {"2050-02-01"}, "end_date": {"2050-02-03"}, "reason": {"Painting"}, "status": {"open"}},
+		expectedStatusCode: http.StatusOK, expectedHTML: "The room is booked or blocked during these dates",
+	},
+	{
+		name: "failed-insert", url: "/admin/out-of-order/0",
+		postedData:         url.Values{"room_id": {"10000"}, "start_date": {"2050-02-01"}, "end_date": {"2050-02-03"}, "reason": {"Painting"}, "status": {"open"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name: "deleted", url: "/admin/out-of-order/2/delete",
+		postedData:         url.Values{},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/out-of-order",
+	},
+	{
+		name: "failed-delete", url: "/admin/out-of-order/1000/delete",
+		postedData:         url.Values{},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminPostOutOfOrder(t *testing.T) {
+	for _, e := range adminPostOutOfOrderTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostOutOfOrderPeriod)
+		if strings.HasSuffix(e.url, "/delete") {
+			handler = Repo.AdminPostDeleteOutOfOrderPeriod
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in response", e.name, e.expectedHTML)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var adminTimelineJSONTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedJSON       []string
+}{
+	{name: "range", url: "/admin/api/timeline?start=2050-01-01&end=2050-01-10", expectedStatusCode: http.StatusOK,
+		expectedJSON: []string{`"ok":true`, `"kind":"reservation"`, `"kind":"block"`, `"kind":"out_of_order"`, `"label":"Leaking shower"`}},
+	{name: "end-before-start", url: "/admin/api/timeline?start=2050-01-10&end=2050-01-01", expectedStatusCode: http.StatusBadRequest,
+		expectedJSON: []string{`"ok":false`}},
+	{name: "too-long", url: "/admin/api/timeline?start=2050-01-01&end=2052-01-01", expectedStatusCode: http.StatusBadRequest},
+}
+
+func TestAdminTimelineJSON(t *testing.T) {
+	for _, e := range adminTimelineJSONTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminTimelineJSON)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		for _, want := range e.expectedJSON {
+			if !strings.Contains(rr.Body.String(), want) {
+				t.Errorf("failed %s: expected to find %s in response %s", e.name, want, rr.Body.String())
+			}
+		}
+	}
+}
+
+var adminPostTimelineTests = []struct {
+	name               string
+	url                string
+	body               string
+	expectedStatusCode int
+	expectedJSON       string
+}{
+	{name: "move", url: "/admin/api/reservations/1/move", body: `{"room_id":1,"start":"2050-01-02","end":"2050-01-04"}`,
+		expectedStatusCode: http.StatusOK, expectedJSON: `"message":"Reservation moved"`},
+	{name: "move-priced", url: "/admin/api/reservations/1/move", body: `{"room_id":2,"start":"2050-01-02","end":"2050-01-04"}`,
+		expectedStatusCode: http.StatusOK, expectedJSON: `"message":"Reservation moved"`},
+	{name: "move-taken", url: "/admin/api/reservations/1/move", body: `{"room_id":10001,"start":"2050-01-02","end":"2050-01-04"}`,
+		expectedStatusCode: http.StatusConflict, expectedJSON: `"ok":false`},
+	{name: "move-failed", url: "/admin/api/reservations/1/move", body: `{"room_id":10000,"start":"2050-01-02","end":"2050-01-04"}`,
+		expectedStatusCode: http.StatusInternalServerError},
+	{name: "move-bad-json", url: "/admin/api/reservations/1/move", body: `{"room_id":`,
+		expectedStatusCode: http.StatusBadRequest, expectedJSON: `"message":"Invalid request"`},
+	{name: "move-backwards", url: "/admin/api/reservations/1/move", body: `{"room_id":1,"start":"2050-01-04","end":"2050-01-02"}`,
+		expectedStatusCode: http.StatusBadRequest, expectedJSON: `"message":"The end must be after the start"`},
+	{name: "move-bad-date", url: "/admin/api/reservations/1/move", body: `{"room_id":1,"start":"soon","end":"2050-01-02"}`,
+		expectedStatusCode: http.StatusBadRequest, expectedJSON: `"message":"Invalid start date"`},
+	{name: "move-stale", url: "/admin/api/reservations/1/move", body: `{"room_id":1,"start":"2050-01-02","end":"2050-01-04","version":1000}`,
+		expectedStatusCode: http.StatusConflict, expectedJSON: `"message":"The reservation was changed by someone else`},
+	{name: "move-unknown-room", url: "/admin/api/reservations/1/move", body: `{"room_id":100000,"start":"2050-01-02","end":"2050-01-04"}`,
+		expectedStatusCode: http.StatusBadRequest, expectedJSON: `"message":"Unknown room"`},
+	{name: "move-invalid-id", url: "/admin/api/reservations/x/move", body: `{"room_id":1,"start":"2050-01-02","end":"2050-01-04"}`,
+		expectedStatusCode: http.StatusBadRequest},
+	{name: "block", url: "/admin/api/blocks", body: `{"room_id":1,"start":"2050-02-02","end":"2050-02-04"}`,
+		expectedStatusCode: http.StatusOK, expectedJSON: `"message":"Room blocked"`},
+	{name: "block-taken", url: "/admin/api/blocks", body: `{"room_id":10001,"start":"2050-02-02","end":"2050-02-04"}`,
+		expectedStatusCode: http.StatusConflict, expectedJSON: `"ok":false`},
+	{name: "block-failed", url: "/admin/api/blocks", body: `{"room_id":10000,"start":"2050-02-02","end":"2050-02-04"}`,
+		expectedStatusCode: http.StatusInternalServerError},
+	{name: "block-unknown-room", url: "/admin/api/blocks", body: `{"room_id":100000,"start":"2050-02-02","end":"2050-02-04"}`,
+		expectedStatusCode: http.StatusBadRequest, expectedJSON: `"message":"Unknown room"`},
+}
+
+func TestAdminPostTimeline(t *testing.T) {
+	for _, e := range adminPostTimelineTests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostTimelineMove)
+		if e.url == "/admin/api/blocks" {
+			handler = Repo.AdminPostTimelineBlock
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedJSON != "" && !strings.Contains(rr.Body.String(), e.expectedJSON) {
+			t.Errorf("failed %s: expected to find %s in response %s", e.name, e.expectedJSON, rr.Body.String())
+		}
+	}
+}
+
+var adminTrashTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedHTML       string
+	expectedLocation   string
+	expectedError      string
+}{
+	{name: "trash", url: "/admin/trash", expectedStatusCode: http.StatusOK, expectedHTML: `action="/admin/trash/reservations/1/restore"`},
+	{name: "trash-blocks", url: "/admin/trash", expectedStatusCode: http.StatusOK, expectedHTML: `action="/admin/trash/blocks/3/restore"`},
+	{name: "restore-reservation", url: "/admin/trash/reservations/1/restore", expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/trash"},
+	{name: "restore-block", url: "/admin/trash/blocks/3/restore", expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/trash"},
+	{name: "restore-taken", url: "/admin/trash/reservations/1001/restore", expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/trash",
+		expectedError: "cannot be restored"},
+	{name: "restore-failed", url: "/admin/trash/blocks/1000/restore", expectedStatusCode: http.StatusInternalServerError},
+	{name: "restore-invalid-id", url: "/admin/trash/blocks/x/restore", expectedStatusCode: http.StatusInternalServerError},
+}
+
+func TestAdminTrash(t *testing.T) {
+	for _, e := range adminTrashTests {
+		method := "POST"
+		if e.url == "/admin/trash" {
+			method = "GET"
+		}
+		req, _ := http.NewRequest(method, e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		var handler http.HandlerFunc
+		switch {
+		case method == "GET":
+			handler = Repo.AdminTrash
+		case strings.HasPrefix(e.url, "/admin/trash/blocks/"):
+			handler = Repo.AdminPostRestoreBlock
+		default:
+			handler = Repo.AdminPostRestoreReservation
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in response", e.name, e.expectedHTML)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedError != "" && !strings.Contains(session.GetString(ctx, "error"), e.expectedError) {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, session.GetString(ctx, "error"))
+		}
+	}
+}
+
+var adminPostBulkReservationsTests = []struct {
+	name                string
+	postedData          url.Values
+	expectedStatusCode  int
+	expectedLocation    string
+	expectedError       string
+	expectedContentType string
+	expectedBody        string
+}{
+	{
+		name:               "process",
+		postedData:         url.Values{"src": {"all"}, "action": {"process"}, "ids": {"1", "2"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/bulk-actions/1",
+	},
+	{
+		name:               "cancel",
+		postedData:         url.Values{"src": {"new"}, "action": {"cancel"}, "ids": {"1"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/bulk-actions/1",
+	},
+	{
+		name:               "tag",
+		postedData:         url.Values{"action": {"tag"}, "tag": {"VIP, late-arrival"}, "ids": {"1", "2"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/bulk-actions/1",
+	},
+	{
+		name:               "email",
+		postedData:         url.Values{"action": {"email"}, "ids": {"1", "2"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/messages/new?ids=1&ids=2&src=all",
+	},
+	{
+		name:                "export",
+		postedData:          url.Values{"action": {"export"}, "format": {"csv"}, "ids": {"1"}},
+		expectedStatusCode:  http.StatusOK,
+		expectedContentType: "text/csv",
+		expectedBody:        "Smith",
+	},
+	{
+		name:               "unknown-reservation",
+		postedData:         url.Values{"action": {"process"}, "ids": {"1", "1001"}},
+		expectedStatusCode: http.StatusSeeOther, expectedLocation: "/admin/bulk-actions/1",
+	},
+	{
+		name:               "nothing-selected",
+		postedData:         url.Values{"src": {"new"}, "action": {"process"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations-new",
+		expectedError:      "Select the reservations first",
+	},
+	{
+		name:               "no-action",
+		postedData:         url.Values{"ids": {"1"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations-all",
+		expectedError:      "Choose an action",
+	},
+	{
+		name:               "no-tags",
+		postedData:         url.Values{"action": {"tag"}, "tag": {" , "}, "ids": {"1"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations-all",
+		expectedError:      "Enter the tags to add",
+	},
+	{
+		name:               "bad-export-format",
+		postedData:         url.Values{"action": {"export"}, "format": {"pdf"}, "ids": {"1"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations-all",
+		expectedError:      "Choose the format of the export",
+	},
+	{
+		name:               "invalid-id",
+		postedData:         url.Values{"action": {"process"}, "ids": {"x"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "failed-batch",
+		postedData:         url.Values{"action": {"process"}, "ids": {"1", "1000"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminPostBulkReservations(t *testing.T) {
+	for _, e := range adminPostBulkReservationsTests {
+		req, _ := http.NewRequest("POST", "/admin/reservations-bulk", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostBulkReservations)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedError != "" && session.GetString(ctx, "error") != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, session.GetString(ctx, "error"))
+		}
+
+		if e.expectedContentType != "" && !strings.HasPrefix(rr.Header().Get("Content-Type"), e.expectedContentType) {
+			t.Errorf("failed %s: expected content type %s, but got %s", e.name, e.expectedContentType, rr.Header().Get("Content-Type"))
+		}
+
+		if e.expectedBody != "" && !strings.Contains(rr.Body.String(), e.expectedBody) {
+			t.Errorf("failed %s: expected to find %s in response", e.name, e.expectedBody)
+		}
+	}
+}
+
+var adminBulkActionTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{name: "list", url: "/admin/bulk-actions", expectedStatusCode: http.StatusOK, expectedHTML: `href="/admin/bulk-actions/1"`},
+	{name: "show", url: "/admin/bulk-actions/1", expectedStatusCode: http.StatusOK, expectedHTML: "Already cancelled"},
+	{name: "show-failed", url: "/admin/bulk-actions/1000", expectedStatusCode: http.StatusInternalServerError},
+	{name: "show-invalid-id", url: "/admin/bulk-actions/x", expectedStatusCode: http.StatusInternalServerError},
+}
+
+func TestAdminBulkActions(t *testing.T) {
+	for _, e := range adminBulkActionTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminBulkAction)
+		if e.url == "/admin/bulk-actions" {
+			handler = Repo.AdminBulkActions
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in response", e.name, e.expectedHTML)
+		}
+	}
+}
+
+var adminComposeMessageTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{name: "one", url: "/admin/messages/new?src=all&ids=1", expectedStatusCode: http.StatusOK, expectedHTML: `name="ids" value="1"`},
+	{name: "many", url: "/admin/messages/new?src=new&ids=1&ids=2", expectedStatusCode: http.StatusOK, expectedHTML: `name="ids" value="2"`},
+	{
+		name:               "from-template",
+		url:                "/admin/messages/new?ids=1&template=2",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       `value="Parking for {room}"`,
+	},
+	{name: "unknown-template", url: "/admin/messages/new?ids=1&template=9", expectedStatusCode: http.StatusInternalServerError},
+	{name: "invalid-template", url: "/admin/messages/new?ids=1&template=x", expectedStatusCode: http.StatusInternalServerError},
+	{name: "invalid-id", url: "/admin/messages/new?ids=x", expectedStatusCode: http.StatusInternalServerError},
+	{
+		name:               "no-reservations",
+		url:                "/admin/messages/new?ids=1001",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations-all",
+	},
+}
+
+func TestAdminComposeMessage(t *testing.T) {
+	for _, e := range adminComposeMessageTests {
+		req, _ := http.NewRequest("GET", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminComposeMessage)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in response", e.name, e.expectedHTML)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var adminPostComposeMessageTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+	expectedError      string
+}{
+	{
+		name:               "preview",
+		postedData:         url.Values{"ids": {"2"}, "do": {"preview"}, "subject": {"Hello {last_name}"}, "body": {"See you on {arrival}"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "See you on 2050-01-02",
+	},
+	{
+		name:               "preview-offers-send",
+		postedData:         url.Values{"ids": {"2"}, "do": {"preview"}, "subject": {"Hello"}, "body": {"Hi"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       `value="send"`,
+	},
+	{
+		name:               "missing-subject",
+		postedData:         url.Values{"ids": {"1"}, "do": {"send"}, "body": {"Hi"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "is-invalid",
+	},
+	{
+		name:               "send-one-without-email",
+		postedData:         url.Values{"src": {"new"}, "ids": {"1"}, "do": {"send"}, "subject": {"Hello"}, "body": {"Hi"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations/new/1/show",
+		expectedError:      "The guest has no email address",
+	},
+	{
+		name:               "send-many",
+		postedData:         url.Values{"ids": {"1", "2"}, "do": {"send"}, "subject": {"Hello"}, "body": {"Hi {first_name}"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/bulk-actions/1",
+	},
+	{
+		name:               "no-reservations",
+		postedData:         url.Values{"do": {"send"}, "subject": {"Hello"}, "body": {"Hi"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/reservations-all",
+		expectedError:      "Select the reservations first",
+	},
+	{
+		name:               "invalid-id",
+		postedData:         url.Values{"ids": {"x"}, "do": {"send"}, "subject": {"Hello"}, "body": {"Hi"}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminPostComposeMessage(t *testing.T) {
+	for _, e := range adminPostComposeMessageTests {
+		req, _ := http.NewRequest("POST", "/admin/messages/new", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostComposeMessage)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in response", e.name, e.expectedHTML)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedError != "" && session.GetString(ctx, "error") != e.expectedError {
+			t.Errorf("failed %s: expected error %q, but got %q", e.name, e.expectedError, session.GetString(ctx, "error"))
+		}
+	}
+}
+
+var adminMessageTemplateTests = []struct {
+	name               string
+	method             string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{name: "list", method: "GET", url: "/admin/message-templates", expectedStatusCode: http.StatusOK,
+		expectedHTML: `href="/admin/message-templates/2"`},
+	{name: "new", method: "GET", url: "/admin/message-templates/0", expectedStatusCode: http.StatusOK,
+		expectedHTML: `action="/admin/message-templates/0"`},
+	{name: "existing", method: "GET", url: "/admin/message-templates/1", expectedStatusCode: http.StatusOK,
+		expectedHTML: "turn left at the lighthouse"},
+	{name: "unknown", method: "GET", url: "/admin/message-templates/9", expectedStatusCode: http.StatusInternalServerError},
+	{name: "invalid-id", method: "GET", url: "/admin/message-templates/x", expectedStatusCode: http.StatusInternalServerError},
+	{
+		name:               "insert",
+		method:             "POST",
+		url:                "/admin/message-templates/0",
+		postedData:         url.Values{"name": {"Welcome"}, "subject": {"Welcome {first_name}"}, "body": {"See you soon"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/message-templates",
+	},
+	{
+		name:               "update",
+		method:             "POST",
+		url:                "/admin/message-templates/1",
+		postedData:         url.Values{"name": {"Directions"}, "subject": {"How to find us"}, "body": {"Turn right"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/message-templates",
+	},
+	{
+		name:               "missing-body",
+		method:             "POST",
+		url:                "/admin/message-templates/0",
+		postedData:         url.Values{"name": {"Welcome"}, "subject": {"Welcome"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "is-invalid",
+	},
+	{name: "delete", method: "POST", url: "/admin/message-templates/1/delete", expectedStatusCode: http.StatusSeeOther,
+		expectedLocation: "/admin/message-templates"},
+	{name: "failed-delete", method: "POST", url: "/admin/message-templates/1000/delete",
+		expectedStatusCode: http.StatusInternalServerError},
+}
+
+func TestAdminMessageTemplates(t *testing.T) {
+	for _, e := range adminMessageTemplateTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		var handler http.HandlerFunc
+		switch {
+		case e.url == "/admin/message-templates":
+			handler = Repo.AdminMessageTemplates
+		case strings.HasSuffix(e.url, "/delete"):
+			handler = Repo.AdminPostDeleteMessageTemplate
+		case e.method == "POST":
+			handler = Repo.AdminPostMessageTemplate
+		default:
+			handler = Repo.AdminMessageTemplate
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in response", e.name, e.expectedHTML)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var postContactTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{
+		name: "valid",
+		postedData: url.Values{"name": {"John Smith"}, "email": {"john@smith.com"}, "booking_code": {"abc"},
+			"subject": {"Parking"}, "message": {"Is there parking?"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/contact",
+	},
+	{
+		name:               "missing-message",
+		postedData:         url.Values{"name": {"John Smith"}, "email": {"john@smith.com"}, "subject": {"Parking"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       `id="message"`,
+	},
+	{
+		name: "invalid-email",
+		postedData: url.Values{"name": {"John Smith"}, "email": {"john"}, "subject": {"Parking"},
+			"message": {"Is there parking?"}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Invalid email address",
+	},
+	{
+		name: "insert-fails",
+		postedData: url.Values{"name": {"fail"}, "email": {"john@smith.com"}, "subject": {"Parking"},
+			"message": {"Is there parking?"}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/contact",
+	},
+}
+
+func TestPostContact(t *testing.T) {
+	for _, e := range postContactTests {
+		req, _ := http.NewRequest("POST", "/contact", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostContact)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in response", e.name, e.expectedHTML)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+var adminInboxTests = []struct {
+	name               string
+	method             string
+	url                string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+	expectedHTML       string
+}{
+	{name: "list", method: "GET", url: "/admin/inbox", expectedStatusCode: http.StatusOK,
+		expectedHTML: "1 unread"},
+	{name: "conversation", method: "GET", url: "/admin/inbox/1", expectedStatusCode: http.StatusOK,
+		expectedHTML: "Yes, behind the house."},
+	{name: "unknown", method: "GET", url: "/admin/inbox/1000", expectedStatusCode: http.StatusInternalServerError},
+	{name: "invalid-id", method: "GET", url: "/admin/inbox/x", expectedStatusCode: http.StatusInternalServerError},
+	{
+		name:               "reply",
+		method:             "POST",
+		url:                "/admin/inbox/1/reply",
+		postedData:         url.Values{"body": {"The gate code is 1234."}},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/admin/inbox/1",
+	},
+	{
+		name:               "blank-reply",
+		method:             "POST",
+		url:                "/admin/inbox/1/reply",
+		postedData:         url.Values{"body": {""}},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "This field cannot be blank",
+	},
+	{
+		name:               "reply-fails",
+		method:             "POST",
+		url:                "/admin/inbox/1001/reply",
+		postedData:         url.Values{"body": {"The gate code is 1234."}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+	{
+		name:               "reply-unknown",
+		method:             "POST",
+		url:                "/admin/inbox/1000/reply",
+		postedData:         url.Values{"body": {"The gate code is 1234."}},
+		expectedStatusCode: http.StatusInternalServerError,
+	},
+}
+
+func TestAdminInbox(t *testing.T) {
+	for _, e := range adminInboxTests {
+		req, _ := http.NewRequest(e.method, e.url, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RequestURI = e.url
+		rr := httptest.NewRecorder()
+
+		var handler http.HandlerFunc
+		switch {
+		case e.url == "/admin/inbox":
+			handler = Repo.AdminInbox
+		case e.method == "POST":
+			handler = Repo.AdminPostConversationReply
+		default:
+			handler = Repo.AdminConversation
+		}
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s in response", e.name, e.expectedHTML)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+func TestReceiveInboundMail(t *testing.T) {
+	err := Repo.ReceiveInboundMail(inbox.Message{MessageID: "<1@smith.com>", Name: "John Smith", From: "john@smith.com",
+		Subject: "Re: Parking [ref:0a1b2c]", Body: "Thanks!"})
+	if err != nil {
+		t.Errorf("expected the email to be filed, got %v", err)
+	}
+
+	err = Repo.ReceiveInboundMail(inbox.Message{MessageID: "<2@fail.com>", Name: "fail", From: "fail@here.com"})
+	if err == nil {
+		t.Error("expected an error when the email cannot be stored")
+	}
+}
+
+func TestSendReservationSMS(t *testing.T) {
+	start, _ := time.Parse("2006-01-02", "2050-01-02")
+	res := models.Reservation{ID: 1, FirstName: "John", Email: "john@smith.com", Phone: "+15555550199", StartDate: start,
+		EndDate: start.AddDate(0, 0, 1), Room: models.Room{ID: 1, RoomName: "General's Quarters"}}
+
+	// replace the sms listener so the confirmation is only sent once taken off the queue
+	testApp := app
+	smsChan := make(chan models.SMSMessage, 10)
+	testApp.SMSChan = smsChan
+	repo := NewTestRepo(&testApp)
+
+	before := len(smsProvider.Messages())
+	repo.textConfirmation(res)
+	if len(smsProvider.Messages()) != before || len(smsChan) != 1 {
+		t.Fatal("expected the confirmation to be queued, not sent")
+	}
+
+	if err := repo.SendSMS(<-smsChan); err != nil {
+		t.Fatal(err)
+	}
+	messages := smsProvider.Messages()
+	if len(messages) != before+1 {
+		t.Fatalf("expected one text message, got %d", len(messages)-before)
+	}
+	if last := messages[len(messages)-1]; last.To != "+15555550199" || !strings.Contains(last.Body, "is confirmed") {
+		t.Errorf("unexpected confirmation %+v", last)
+	}
+
+	res.Email = "jane@doe.com"
+	repo.textConfirmation(res)
+	res.Email = "john@smith.com"
+	res.Phone = ""
+	repo.textConfirmation(res)
+	if len(smsChan) != 0 {
+		t.Error("expected no text message to a guest who opted out or has no phone number")
+	}
+
+	res.Phone = sms.UndeliverableNumber
+	if err := Repo.sendReservationSMS(res, models.SMSKindConfirmation, "Hello"); err == nil {
+		t.Error("expected an error for an undeliverable number")
+	}
+
+	res.Phone = "call me"
+	if err := Repo.sendReservationSMS(res, models.SMSKindConfirmation, "Hello"); err != sms.ErrInvalidPhone {
+		t.Errorf("expected a number which is not a phone number to be refused, got %v", err)
+	}
+
+	res.ID = 10000
+	res.Phone = "+15555550199"
+	if err := Repo.sendReservationSMS(res, models.SMSKindConfirmation, "Hello"); err == nil {
+		t.Error("expected an error when the text message can't be recorded")
+	}
+}
+
+func TestSendArrivalReminders(t *testing.T) {
+	before := len(smsProvider.Messages())
+
+	n, err := Repo.SendArrivalReminders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected 1 reminder to be sent, got %d", n)
+	}
+
+	messages := smsProvider.Messages()
+	if len(messages) != before+1 {
+		t.Fatalf("expected one text message, got %d", len(messages)-before)
+	}
+	if last := messages[len(messages)-1]; last.To != "+15555550111" || !strings.Contains(last.Body, "look forward to welcoming you") {
+		t.Errorf("unexpected reminder %+v", last)
+	}
+}
+
+var smsReportTests = []struct {
+	name               string
+	body               string
+	secret             string
+	expectedStatusCode int
+}{
+	{name: "delivered", body: `{"ref":"fake_sms_1","status":"delivered"}`, secret: smsSecret, expectedStatusCode: http.StatusOK},
+	{name: "forged", body: `{"ref":"fake_sms_1","status":"delivered"}`, secret: "wrong", expectedStatusCode: http.StatusBadRequest},
+	{name: "failed", body: `{"ref":"fail","status":"undelivered"}`, secret: smsSecret, expectedStatusCode: http.StatusInternalServerError},
+}
+
+func TestSMSReport(t *testing.T) {
+	for _, e := range smsReportTests {
+		req, _ := http.NewRequest("POST", "/sms/reports", strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(sms.SignatureHeader, sms.Sign(e.secret, []byte(e.body)))
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.SMSReport)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
 	}
 }
 
@@ -6,6 +6,7 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
@@ -16,18 +17,28 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/justinas/nosurf"
 	"github.com/yj-matmul/bookings/internal/config"
+	"github.com/yj-matmul/bookings/internal/helpers"
 	"github.com/yj-matmul/bookings/internal/models"
+	"github.com/yj-matmul/bookings/internal/payment"
+	"github.com/yj-matmul/bookings/internal/pricing"
 	"github.com/yj-matmul/bookings/internal/render"
+	"github.com/yj-matmul/bookings/internal/sms"
 )
 
 var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
+var paymentSecret = "test-secret"
+var smsSecret = "test-secret"
+var smsProvider = sms.NewFakeServer(smsSecret)
 var functions = template.FuncMap{
 	"humanDate":  render.HumanDate,
 	"formatDate": render.FormatDate,
 	"iterate":    render.Iterate,
 	"add":        render.Add,
+	"money":      render.Money,
+	"hundredths": pricing.FormatHundredths,
+	"roomAmount": render.RoomAmount,
 }
 
 func TestMain(m *testing.M) {
@@ -36,11 +47,18 @@ func TestMain(m *testing.M) {
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
+	gob.Register(models.Cart{})
+	gob.Register(models.Booking{})
 	gob.Register(map[string]int{})
 
 	// change this to true when in production
 	app.InProduction = false
 	app.UseCache = true
+	app.HoldDuration = 15 * time.Minute
+	app.OfferDuration = 24 * time.Hour
+	app.TrashRetention = 30 * 24 * time.Hour
+	app.PhoneCountryCode = "1"
+	app.ReminderLead = 48 * time.Hour
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -62,6 +80,18 @@ func TestMain(m *testing.M) {
 
 	listenForMail()
 
+	smsChan := make(chan models.SMSMessage)
+	app.SMSChan = smsChan
+	defer close(smsChan)
+
+	listenForSMS()
+
+	paymentServer := httptest.NewServer(payment.NewFakeServer(paymentSecret, ""))
+	app.Payments = payment.NewFakeGateway(paymentServer.URL, paymentSecret)
+
+	smsServer := httptest.NewServer(smsProvider)
+	app.SMS = sms.NewFakeProvider(smsServer.URL, smsSecret, "")
+
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
 		log.Fatal("cannot create template cache")
@@ -72,8 +102,12 @@ func TestMain(m *testing.M) {
 	repo := NewTestRepo(&app)
 	NewHandlers(repo)
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
-	os.Exit(m.Run())
+	code := m.Run()
+	paymentServer.Close()
+	smsServer.Close()
+	os.Exit(code)
 }
 
 func listenForMail() {
@@ -84,6 +118,14 @@ func listenForMail() {
 	}()
 }
 
+func listenForSMS() {
+	go func() {
+		for {
+			_ = <-app.SMSChan
+		}
+	}()
+}
+
 func getRoutes() http.Handler {
 
 	mux := chi.NewRouter()
@@ -104,27 +146,105 @@ func getRoutes() http.Handler {
 	mux.Post("/search-availability", Repo.PostAvailability)
 	mux.Post("/search-availability-json", Repo.AvailabilityJSON)
 
+	mux.Get("/cart", Repo.Cart)
+	mux.Post("/cart", Repo.PostCart)
+	mux.Get("/cart/add/{id}", Repo.AddToCart)
+	mux.Post("/cart/remove/{index}", Repo.PostRemoveFromCart)
+	mux.Get("/booking-summary", Repo.BookingSummary)
+
+	mux.Get("/waitlist", Repo.Waitlist)
+	mux.Post("/waitlist", Repo.PostWaitlist)
+	mux.Get("/waitlist/{token}", Repo.WaitlistOffer)
+
 	mux.Get("/make-reservation", Repo.Reservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
 
+	mux.Get("/payment", Repo.Payment)
+	mux.Post("/payment", Repo.PostPayment)
+	mux.Post("/payments/webhook", Repo.PaymentWebhook)
+	mux.Post("/sms/reports", Repo.SMSReport)
+
+	mux.Get("/bookings/{code}", Repo.Booking)
+	mux.Post("/bookings/{code}/cancel", Repo.PostCancelBooking)
+	mux.Get("/bookings/{code}/invoices/{id}", Repo.BookingInvoice)
+
+	mux.Get("/group-bookings/{code}", Repo.GroupBooking)
+	mux.Post("/group-bookings/{code}/cancel", Repo.PostCancelGroupBooking)
+	mux.Post("/group-bookings/{code}/cancel/{id}", Repo.PostCancelGroupBooking)
+
 	mux.Get("/contact", Repo.Contact)
+	mux.Post("/contact", Repo.PostContact)
 
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostShowLogin)
 	mux.Get("/user/logout", Repo.Logout)
 
+	mux.Get("/housekeeping", Repo.Housekeeping)
+	mux.Post("/housekeeping/rooms/{id}", Repo.PostHousekeepingRoom)
+
 	mux.Get("/admin/dashboard", Repo.AdminDashboard)
+	mux.Get("/admin/today", Repo.AdminToday)
+	mux.Post("/admin/today/check-in/{id}", Repo.AdminPostCheckIn)
+	mux.Post("/admin/today/check-out/{id}", Repo.AdminPostCheckOut)
 
 	mux.Get("/admin/reservations-new", Repo.AdminNewReservations)
 	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
 	mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalendar)
 	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar)
+	mux.Post("/admin/reservations-bulk", Repo.AdminPostBulkReservations)
+	mux.Get("/admin/bulk-actions", Repo.AdminBulkActions)
+	mux.Get("/admin/bulk-actions/{id}", Repo.AdminBulkAction)
+	mux.Get("/admin/trash", Repo.AdminTrash)
+	mux.Post("/admin/trash/reservations/{id}/restore", Repo.AdminPostRestoreReservation)
+	mux.Post("/admin/trash/blocks/{id}/restore", Repo.AdminPostRestoreBlock)
+	mux.Get("/admin/timeline", Repo.AdminTimeline)
+	mux.Get("/admin/api/timeline", Repo.AdminTimelineJSON)
+	mux.Post("/admin/api/reservations/{id}/move", Repo.AdminPostTimelineMove)
+	mux.Post("/admin/api/blocks", Repo.AdminPostTimelineBlock)
 	mux.Get("/admin/process-reservation/{src}/{id}/do", Repo.AdminProcessReservation)
-	mux.Get("/admin/delete-reservation/{src}/{id}/do", Repo.AdminDeleteReservation)
 
 	mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
 	mux.Post("/admin/reservations/{src}/{id}", Repo.AdminPostShowReservation)
+	mux.Post("/admin/reservations/{src}/{id}/delete", Repo.AdminPostDeleteReservation)
+	mux.Get("/admin/reservations/{src}/{id}/cancel", Repo.AdminCancelReservation)
+	mux.Post("/admin/reservations/{src}/{id}/cancel", Repo.AdminPostCancelReservation)
+	mux.Post("/admin/reservations/{src}/{id}/invoice", Repo.AdminPostIssueInvoice)
+	mux.Post("/admin/reservations/{src}/{id}/notes", Repo.AdminPostReservationNote)
+
+	mux.Get("/admin/invoices/{id}", Repo.AdminInvoice)
+	mux.Post("/admin/invoices/{id}/credit-note", Repo.AdminPostCreditNote)
+
+	mux.Get("/admin/out-of-order", Repo.AdminOutOfOrder)
+	mux.Get("/admin/out-of-order/{id}", Repo.AdminOutOfOrderPeriod)
+	mux.Post("/admin/out-of-order/{id}", Repo.AdminPostOutOfOrderPeriod)
+	mux.Post("/admin/out-of-order/{id}/delete", Repo.AdminPostDeleteOutOfOrderPeriod)
+
+	mux.Get("/admin/import", Repo.AdminImport)
+	mux.Post("/admin/import", Repo.AdminPostImport)
+
+	mux.Get("/admin/export/reservations/{format}", Repo.AdminExportReservations)
+	mux.Get("/admin/export/guests/{format}", Repo.AdminExportGuests)
+	mux.Get("/admin/export/movements/{format}", Repo.AdminExportMovements)
+
+	mux.Get("/admin/guests", Repo.AdminGuests)
+	mux.Get("/admin/guests/{id}", Repo.AdminGuest)
+	mux.Post("/admin/guests/{id}", Repo.AdminPostGuest)
+
+	mux.Get("/admin/inbox", Repo.AdminInbox)
+	mux.Get("/admin/inbox/{id}", Repo.AdminConversation)
+	mux.Post("/admin/inbox/{id}/reply", Repo.AdminPostConversationReply)
+
+	mux.Get("/admin/messages/new", Repo.AdminComposeMessage)
+	mux.Post("/admin/messages/new", Repo.AdminPostComposeMessage)
+	mux.Get("/admin/message-templates", Repo.AdminMessageTemplates)
+	mux.Get("/admin/message-templates/{id}", Repo.AdminMessageTemplate)
+	mux.Post("/admin/message-templates/{id}", Repo.AdminPostMessageTemplate)
+	mux.Post("/admin/message-templates/{id}/delete", Repo.AdminPostDeleteMessageTemplate)
+	mux.Get("/admin/tax-fees", Repo.AdminTaxFees)
+	mux.Get("/admin/tax-fees/{id}", Repo.AdminTaxFee)
+	mux.Post("/admin/tax-fees/{id}", Repo.AdminPostTaxFee)
+	mux.Post("/admin/tax-fees/{id}/delete", Repo.AdminPostDeleteTaxFee)
 
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
@@ -1,6 +1,8 @@
 package helpers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"runtime/debug"
@@ -30,3 +32,18 @@ func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// AccessLevel returns the access level of the logged in user, or 0
+func AccessLevel(r *http.Request) int {
+	return app.Session.GetInt(r.Context(), "access_level")
+}
+
+// NewAccessCode returns a random code which gives a guest access to their booking
+func NewAccessCode() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,136 @@
+package inbox
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Each calls fn for every message waiting in a local mail drop, which is a maildir when path is a directory
+// and an mbox file otherwise. Messages fn accepted are taken out of the drop; when fn fails Each stops and
+// leaves the rest for the next call. A message which cannot be parsed is taken out too, and its error returned
+func Each(path string, fn func(Message) error) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		return eachMaildir(path, fn)
+	}
+	return eachMbox(path, fn)
+}
+
+// eachMaildir reads the messages in the new folder of a maildir and moves them to cur when done
+func eachMaildir(dir string, fn func(Message) error) error {
+	files, err := ioutil.ReadDir(filepath.Join(dir, "new"))
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	var parseErr error
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		name := filepath.Join(dir, "new", f.Name())
+
+		in, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		msg, err := Parse(in)
+		in.Close()
+
+		if err != nil {
+			if parseErr == nil {
+				parseErr = err
+			}
+		} else if err = fn(msg); err != nil {
+			return err
+		}
+
+		err = os.Rename(name, filepath.Join(dir, "cur", f.Name()+":2,S"))
+		if err != nil {
+			return err
+		}
+	}
+
+	return parseErr
+}
+
+// eachMbox reads the messages of an mbox file and empties it when done; messages appended meanwhile are kept
+func eachMbox(path string, fn func(Message) error) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var parseErr error
+	for _, raw := range splitMbox(content) {
+		msg, err := Parse(bytes.NewReader(raw))
+		if err != nil {
+			if parseErr == nil {
+				parseErr = err
+			}
+			continue
+		}
+		if err = fn(msg); err != nil {
+			return err
+		}
+	}
+
+	current, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path, bytes.TrimPrefix(current, content), 0600)
+	if err != nil {
+		return err
+	}
+
+	return parseErr
+}
+
+// splitMbox splits an mbox file at its "From " lines and undoes the quoting of "From " inside the messages
+func splitMbox(content []byte) [][]byte {
+	var messages [][]byte
+	var current []string
+	blank := true
+
+	flush := func() {
+		if len(current) > 0 {
+			messages = append(messages, []byte(strings.Join(current, "\n")))
+		}
+		current = nil
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if blank && strings.HasPrefix(line, "From ") {
+			flush()
+			current = []string{}
+			blank = false
+			continue
+		}
+		blank = line == ""
+
+		if current == nil {
+			continue
+		}
+		if strings.HasPrefix(line, ">From ") {
+			line = line[1:]
+		}
+		current = append(current, line)
+	}
+	flush()
+
+	return messages
+}
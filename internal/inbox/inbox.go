@@ -0,0 +1,161 @@
+package inbox
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+// Message is an email received from a guest, reduced to what the guest inbox keeps
+type Message struct {
+	MessageID string
+	Name      string
+	From      string
+	Subject   string
+	Body      string
+}
+
+// refPattern finds the reference of a conversation which replies carry in their subject
+var refPattern = regexp.MustCompile(`\[ref:([0-9a-f]+)\]`)
+
+// Ref returns the subject of a reply to a conversation, with the reference that threads the guest's answer back to it
+func Ref(subject, token string) string {
+	return fmt.Sprintf("Re: %s [ref:%s]", CleanSubject(subject), token)
+}
+
+// Token returns the conversation reference in a subject, or nothing
+func Token(subject string) string {
+	m := refPattern.FindStringSubmatch(subject)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+// CleanSubject drops the reply prefixes and the conversation reference from a subject
+func CleanSubject(subject string) string {
+	subject = strings.TrimSpace(refPattern.ReplaceAllString(subject, ""))
+	for {
+		lower := strings.ToLower(subject)
+		if !strings.HasPrefix(lower, "re:") && !strings.HasPrefix(lower, "fwd:") {
+			return subject
+		}
+		subject = strings.TrimSpace(subject[strings.Index(subject, ":")+1:])
+	}
+}
+
+// Parse reads one email, keeping the plain text of its body without the quoted message it replies to.
+// Messages without a Message-ID get one derived from their content, so reading them twice is noticed
+func Parse(r io.Reader) (Message, error) {
+	var msg Message
+
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return msg, err
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		return msg, err
+	}
+
+	from, err := m.Header.AddressList("From")
+	if err != nil || len(from) == 0 {
+		return msg, fmt.Errorf("inbox: message without a sender: %v", err)
+	}
+	msg.Name = from[0].Name
+	msg.From = strings.ToLower(from[0].Address)
+
+	dec := new(mime.WordDecoder)
+	msg.Subject, err = dec.DecodeHeader(m.Header.Get("Subject"))
+	if err != nil {
+		msg.Subject = m.Header.Get("Subject")
+	}
+
+	msg.MessageID = strings.TrimSpace(m.Header.Get("Message-ID"))
+	if msg.MessageID == "" {
+		msg.MessageID = fmt.Sprintf("<%x@inbox.local>", sha1.Sum(raw))
+	}
+
+	body, err := textBody(m.Header.Get("Content-Type"), m.Header.Get("Content-Transfer-Encoding"), m.Body)
+	if err != nil {
+		return msg, err
+	}
+	msg.Body = StripQuoted(body)
+
+	return msg, nil
+}
+
+// textBody returns the first plain text part of a body, decoded
+func textBody(contentType, encoding string, body io.Reader) (string, error) {
+	if contentType == "" {
+		contentType = "text/plain"
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(mediaType, "multipart/") {
+		mr := multipart.NewReader(body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				return "", nil
+			}
+			if err != nil {
+				return "", err
+			}
+
+			text, err := textBody(part.Header.Get("Content-Type"), part.Header.Get("Content-Transfer-Encoding"), part)
+			if err != nil {
+				return "", err
+			}
+			if text != "" {
+				return text, nil
+			}
+		}
+	}
+
+	if mediaType != "text/plain" {
+		return "", nil
+	}
+
+	switch strings.ToLower(encoding) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+
+	b, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(string(b), "\r\n", "\n", -1), nil
+}
+
+// wrotePattern matches the line mail clients put above the message they quote
+var wrotePattern = regexp.MustCompile(`^On .+ wrote:$`)
+
+// StripQuoted cuts a reply off where it starts to quote the message it answers
+func StripQuoted(body string) string {
+	lines := strings.Split(body, "\n")
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, ">") || wrotePattern.MatchString(line) {
+			lines = lines[:i]
+			break
+		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,162 @@
+package inbox
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const plainMessage = "From: John Smith <John@Smith.com>\r\n" +
+	"To: me@here.com\r\n" +
+	"Subject: Re: Parking [ref:0a1b2c]\r\n" +
+	"Message-ID: <1@smith.com>\r\n" +
+	"\r\n" +
+	"Thanks, we will arrive by car.\r\n" +
+	"\r\n" +
+	"On Mon, 3 Jan 2050 at 10:00, me@here.com wrote:\r\n" +
+	"> The car park is closed.\r\n"
+
+const multipartMessage = "From: jane@doe.com\r\n" +
+	"Subject: =?UTF-8?Q?Caf=C3=A9_nearby?=\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/alternative; boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>ignored</p>\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"Is there a caf=C3=A9 close by?\r\n" +
+	"--b1--\r\n"
+
+func TestParse(t *testing.T) {
+	msg, err := Parse(strings.NewReader(plainMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.From != "john@smith.com" || msg.Name != "John Smith" {
+		t.Errorf("unexpected sender %q <%s>", msg.Name, msg.From)
+	}
+	if msg.MessageID != "<1@smith.com>" {
+		t.Errorf("unexpected message id %s", msg.MessageID)
+	}
+	if msg.Body != "Thanks, we will arrive by car." {
+		t.Errorf("expected the quoted message to be cut off, got %q", msg.Body)
+	}
+	if Token(msg.Subject) != "0a1b2c" {
+		t.Errorf("expected token 0a1b2c in %q", msg.Subject)
+	}
+
+	msg, err = Parse(strings.NewReader(multipartMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Subject != "Café nearby" {
+		t.Errorf("expected the subject to be decoded, got %q", msg.Subject)
+	}
+	if msg.Body != "Is there a café close by?" {
+		t.Errorf("expected the plain text part, got %q", msg.Body)
+	}
+	if !strings.HasSuffix(msg.MessageID, "@inbox.local>") {
+		t.Errorf("expected a message id derived from the content, got %s", msg.MessageID)
+	}
+
+	_, err = Parse(strings.NewReader("Subject: no sender\r\n\r\nHello\r\n"))
+	if err == nil {
+		t.Error("expected an error for a message without a sender")
+	}
+}
+
+func TestSubjects(t *testing.T) {
+	subject := Ref("Parking", "0a1b2c")
+	if subject != "Re: Parking [ref:0a1b2c]" {
+		t.Errorf("unexpected reply subject %q", subject)
+	}
+	if Ref(subject, "0a1b2c") != subject {
+		t.Errorf("expected a reply to a reply to keep one reference, got %q", Ref(subject, "0a1b2c"))
+	}
+	if Token("Parking") != "" {
+		t.Error("expected no token")
+	}
+	if CleanSubject("RE: Fwd: Parking [ref:0a1b2c]") != "Parking" {
+		t.Errorf("unexpected clean subject %q", CleanSubject("RE: Fwd: Parking [ref:0a1b2c]"))
+	}
+}
+
+func TestEachMaildir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "maildir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, sub := range []string{"new", "cur", "tmp"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ioutil.WriteFile(filepath.Join(dir, "new", "1"), []byte(plainMessage), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "new", "2"), []byte(multipartMessage), 0600)
+
+	var got []Message
+	err = Each(dir, func(msg Message) error {
+		got = append(got, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].From != "john@smith.com" || got[1].From != "jane@doe.com" {
+		t.Fatalf("unexpected messages %+v", got)
+	}
+
+	left, _ := ioutil.ReadDir(filepath.Join(dir, "new"))
+	done, _ := ioutil.ReadDir(filepath.Join(dir, "cur"))
+	if len(left) != 0 || len(done) != 2 {
+		t.Errorf("expected the messages to move to cur, %d left in new and %d in cur", len(left), len(done))
+	}
+}
+
+func TestEachMbox(t *testing.T) {
+	f, err := ioutil.TempFile("", "mbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	mbox := "From john@smith.com Mon Jan  3 10:00:00 2050\n" +
+		strings.Replace(plainMessage, "Thanks,", ">From the hotel: thanks,", 1) + "\n" +
+		"From jane@doe.com Mon Jan  3 11:00:00 2050\n" +
+		multipartMessage
+	f.WriteString(mbox)
+	f.Close()
+
+	var got []Message
+	err = Each(f.Name(), func(msg Message) error {
+		got = append(got, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(got))
+	}
+	if !strings.HasPrefix(got[0].Body, "From the hotel: thanks,") {
+		t.Errorf("expected the From quoting to be undone, got %q", got[0].Body)
+	}
+
+	content, _ := ioutil.ReadFile(f.Name())
+	if len(content) != 0 {
+		t.Errorf("expected the mbox to be emptied, %d bytes left", len(content))
+	}
+
+	if err := Each(filepath.Join(os.TempDir(), "no-such-drop"), nil); err != nil {
+		t.Errorf("expected a missing drop to be empty, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package invoice
+
+import (
+	"fmt"
+
+	"github.com/yj-matmul/bookings/internal/models"
+	"github.com/yj-matmul/bookings/internal/pricing"
+)
+
+// Number returns the printed number of an invoice or credit note
+func Number(inv models.Invoice) string {
+	if inv.Kind == models.InvoiceKindCreditNote {
+		return fmt.Sprintf("CN-%06d", inv.Number)
+	}
+	return fmt.Sprintf("INV-%06d", inv.Number)
+}
+
+// Lines returns the line items of a reservation: its nights followed by the stored taxes and fees
+func Lines(res models.Reservation) []models.InvoiceLine {
+	var lines []models.InvoiceLine
+
+	roomTotal := res.TotalAmount - pricing.ChargesTotal(res.Charges)
+
+	nights := pricing.Nights(res.StartDate, res.EndDate)
+	if nights > 0 && roomTotal%nights == 0 {
+		lines = append(lines, models.InvoiceLine{
+			Kind:        models.InvoiceLineNights,
+			Description: fmt.Sprintf("%s, %s - %s", res.Room.RoomName, res.StartDate.Format("2006-01-02"), res.EndDate.Format("2006-01-02")),
+			Quantity:    nights,
+			UnitAmount:  roomTotal / nights,
+			Amount:      roomTotal,
+		})
+	} else {
+		lines = append(lines, models.InvoiceLine{
+			Kind:        models.InvoiceLineNights,
+			Description: fmt.Sprintf("%s, %d nights from %s", res.Room.RoomName, nights, res.StartDate.Format("2006-01-02")),
+			Quantity:    1,
+			UnitAmount:  roomTotal,
+			Amount:      roomTotal,
+		})
+	}
+
+	for _, c := range res.Charges {
+		lines = append(lines, models.InvoiceLine{
+			Kind:        c.Kind,
+			Description: c.Description,
+			Quantity:    c.Quantity,
+			UnitAmount:  c.UnitAmount,
+			Amount:      c.Amount,
+		})
+	}
+
+	return lines
+}
+
+// New builds an invoice for a reservation; the number is assigned when it is stored
+func New(res models.Reservation) models.Invoice {
+	inv := models.Invoice{
+		Kind:          models.InvoiceKindInvoice,
+		ReservationID: res.ID,
+		BillToName:    fmt.Sprintf("%s %s", res.FirstName, res.LastName),
+		BillToEmail:   res.Email,
+		Lines:         Lines(res),
+	}
+	inv.Total = Total(inv.Lines)
+	return inv
+}
+
+// CreditNote builds a credit note which cancels out original
+func CreditNote(original models.Invoice) models.Invoice {
+	cn := models.Invoice{
+		Kind:              models.InvoiceKindCreditNote,
+		ReservationID:     original.ReservationID,
+		OriginalInvoiceID: original.ID,
+		BillToName:        original.BillToName,
+		BillToEmail:       original.BillToEmail,
+	}
+
+	for _, l := range original.Lines {
+		cn.Lines = append(cn.Lines, models.InvoiceLine{
+			Kind:        l.Kind,
+			Description: l.Description,
+			Quantity:    l.Quantity,
+			UnitAmount:  -l.UnitAmount,
+			Amount:      -l.Amount,
+		})
+	}
+	cn.Total = Total(cn.Lines)
+
+	return cn
+}
+
+// Total returns the sum of all line amounts
+func Total(lines []models.InvoiceLine) int {
+	total := 0
+	for _, l := range lines {
+		total += l.Amount
+	}
+	return total
+}
+
+// Open returns the invoices which have not been cancelled by a credit note
+func Open(invoices []models.Invoice) []models.Invoice {
+	credited := make(map[int]bool)
+	for _, inv := range invoices {
+		if inv.Kind == models.InvoiceKindCreditNote {
+			credited[inv.OriginalInvoiceID] = true
+		}
+	}
+
+	var open []models.Invoice
+	for _, inv := range invoices {
+		if inv.Kind == models.InvoiceKindInvoice && !credited[inv.ID] {
+			open = append(open, inv)
+		}
+	}
+	return open
+}
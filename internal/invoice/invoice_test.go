@@ -0,0 +1,116 @@
+package invoice
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/models"
+)
+
+func testReservation() models.Reservation {
+	start, _ := time.Parse("2006-01-02", "2050-01-10")
+	return models.Reservation{
+		ID:          7,
+		FirstName:   "John",
+		LastName:    "Smith",
+		Email:       "john@smith.com",
+		StartDate:   start,
+		EndDate:     start.AddDate(0, 0, 3),
+		Room:        models.Room{RoomName: "General's Quarters"},
+		TotalAmount: 45000,
+	}
+}
+
+func TestNew(t *testing.T) {
+	inv := New(testReservation())
+
+	if inv.Kind != models.InvoiceKindInvoice {
+		t.Errorf("expected kind %s but got %s", models.InvoiceKindInvoice, inv.Kind)
+	}
+
+	if len(inv.Lines) != 1 {
+		t.Fatalf("expected 1 line but got %d", len(inv.Lines))
+	}
+
+	l := inv.Lines[0]
+	if l.Quantity != 3 || l.UnitAmount != 15000 || l.Amount != 45000 {
+		t.Errorf("unexpected nights line %+v", l)
+	}
+
+	if inv.Total != 45000 {
+		t.Errorf("expected total 45000 but got %d", inv.Total)
+	}
+
+	// taxes and fees follow the nights
+	res := testReservation()
+	res.TotalAmount = 47500
+	res.Charges = []models.ReservationCharge{
+		{Kind: models.InvoiceLineTax, Description: "Tourist tax", Quantity: 4, UnitAmount: 250, Amount: 1000},
+		{Kind: models.InvoiceLineFee, Description: "Cleaning fee", Quantity: 1, UnitAmount: 1500, Amount: 1500},
+	}
+	inv = New(res)
+	if len(inv.Lines) != 3 || inv.Lines[0].UnitAmount != 15000 || inv.Lines[1].Kind != models.InvoiceLineTax {
+		t.Errorf("unexpected lines with charges %+v", inv.Lines)
+	}
+	if inv.Total != 47500 {
+		t.Errorf("expected total 47500 but got %d", inv.Total)
+	}
+
+	// a total which can't be split evenly is billed as one line
+	res = testReservation()
+	res.TotalAmount = 10000
+	inv = New(res)
+	if inv.Lines[0].Quantity != 1 || inv.Total != 10000 {
+		t.Errorf("unexpected line for uneven total %+v", inv.Lines[0])
+	}
+}
+
+func TestCreditNote(t *testing.T) {
+	inv := New(testReservation())
+	inv.ID = 3
+
+	cn := CreditNote(inv)
+	if cn.Kind != models.InvoiceKindCreditNote || cn.OriginalInvoiceID != 3 {
+		t.Errorf("unexpected credit note %+v", cn)
+	}
+
+	if cn.Total != -inv.Total {
+		t.Errorf("expected total %d but got %d", -inv.Total, cn.Total)
+	}
+
+	cn.ID = 4
+	if open := Open([]models.Invoice{inv, cn}); len(open) != 0 {
+		t.Errorf("expected no open invoices but got %d", len(open))
+	}
+
+	if open := Open([]models.Invoice{inv}); len(open) != 1 {
+		t.Errorf("expected 1 open invoice but got %d", len(open))
+	}
+}
+
+func TestNumber(t *testing.T) {
+	if n := Number(models.Invoice{Kind: models.InvoiceKindInvoice, Number: 12}); n != "INV-000012" {
+		t.Errorf("expected INV-000012 but got %s", n)
+	}
+
+	if n := Number(models.Invoice{Kind: models.InvoiceKindCreditNote, Number: 13}); n != "CN-000013" {
+		t.Errorf("expected CN-000013 but got %s", n)
+	}
+}
+
+func TestPDF(t *testing.T) {
+	inv := New(testReservation())
+	inv.Number = 1
+
+	out := PDF(inv, testReservation())
+	if !bytes.HasPrefix(out, []byte("%PDF-")) {
+		t.Error("output is not a PDF")
+	}
+
+	for _, s := range []string{"INV-000001", "$450.00", "$150.00", "John Smith"} {
+		if !bytes.Contains(out, []byte(s)) {
+			t.Errorf("expected PDF to contain %s", s)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package invoice
+
+import (
+	"fmt"
+
+	"github.com/yj-matmul/bookings/internal/models"
+	"github.com/yj-matmul/bookings/internal/pdf"
+	"github.com/yj-matmul/bookings/internal/render"
+)
+
+// Issuer is the business printed at the top of every invoice
+var Issuer = []string{
+	"Paradise Resort",
+}
+
+const (
+	left   = 50.0
+	right  = pdf.PageWidth - 50
+	bottom = 80.0
+)
+
+// PDF renders an invoice or credit note of a reservation
+func PDF(inv models.Invoice, res models.Reservation) []byte {
+	doc := pdf.New()
+	page := doc.AddPage()
+
+	title := "Invoice"
+	if inv.Kind == models.InvoiceKindCreditNote {
+		title = "Credit Note"
+	}
+
+	y := pdf.PageHeight - 60
+	page.Text(left, y, pdf.HelveticaBold, 20, title)
+	page.TextRight(right, y, 10, Number(inv))
+
+	y -= 30
+	for _, l := range Issuer {
+		page.Text(left, y, pdf.Helvetica, 10, l)
+		y -= 14
+	}
+
+	y -= 10
+	page.Text(left, y, pdf.HelveticaBold, 10, "Bill to")
+	page.Text(330, y, pdf.HelveticaBold, 10, "Details")
+	y -= 14
+	page.Text(left, y, pdf.Helvetica, 10, inv.BillToName)
+	page.Text(330, y, pdf.Helvetica, 10, fmt.Sprintf("Issued: %s", inv.IssuedAt.Format("2006-01-02")))
+	y -= 14
+	page.Text(left, y, pdf.Helvetica, 10, inv.BillToEmail)
+	page.Text(330, y, pdf.Helvetica, 10, fmt.Sprintf("Reservation: %d", res.ID))
+	if inv.Kind == models.InvoiceKindCreditNote {
+		y -= 14
+		page.Text(330, y, pdf.Helvetica, 10, fmt.Sprintf("Corrects invoice id: %d", inv.OriginalInvoiceID))
+	}
+
+	y -= 36
+	page.Text(left, y, pdf.HelveticaBold, 10, "Description")
+	page.TextRight(380, y, 10, "Qty")
+	page.TextRight(460, y, 10, "Unit")
+	page.TextRight(right, y, 10, "Amount")
+	y -= 6
+	page.Line(left, y, right, y)
+
+	for _, l := range inv.Lines {
+		y -= 16
+		if y < bottom {
+			page = doc.AddPage()
+			y = pdf.PageHeight - 60
+		}
+		page.Text(left, y, pdf.Helvetica, 10, l.Description)
+		page.TextRight(380, y, 10, fmt.Sprintf("%d", l.Quantity))
+		page.TextRight(460, y, 10, render.Money(l.UnitAmount))
+		page.TextRight(right, y, 10, render.Money(l.Amount))
+	}
+
+	y -= 8
+	page.Line(left, y, right, y)
+	y -= 16
+	page.Text(380, y, pdf.HelveticaBold, 11, "Total")
+	page.TextRight(right, y, 11, render.Money(inv.Total))
+
+	return doc.Bytes()
+}
+
+// Filename returns the name under which an invoice is downloaded
+func Filename(inv models.Invoice) string {
+	return fmt.Sprintf("%s.pdf", Number(inv))
+}
@@ -0,0 +1,43 @@
+package messages
+
+import (
+	"html/template"
+	"strconv"
+	"strings"
+
+	"github.com/yj-matmul/bookings/internal/models"
+	"github.com/yj-matmul/bookings/internal/pricing"
+)
+
+// Placeholders lists the placeholders a message can use, in the order they are offered to staff
+var Placeholders = []string{
+	"{first_name}",
+	"{last_name}",
+	"{arrival}",
+	"{departure}",
+	"{nights}",
+	"{room}",
+	"{guests}",
+	"{reservation_id}",
+}
+
+// Fill replaces the placeholders in a text with the details of a reservation; unknown placeholders are left as they are
+func Fill(text string, res models.Reservation) string {
+	layout := "2006-01-02"
+	r := strings.NewReplacer(
+		"{first_name}", res.FirstName,
+		"{last_name}", res.LastName,
+		"{arrival}", res.StartDate.Format(layout),
+		"{departure}", res.EndDate.Format(layout),
+		"{nights}", strconv.Itoa(pricing.Nights(res.StartDate, res.EndDate)),
+		"{room}", res.Room.RoomName,
+		"{guests}", strconv.Itoa(res.Guests),
+		"{reservation_id}", strconv.Itoa(res.ID),
+	)
+	return r.Replace(text)
+}
+
+// HTML turns a plain text message into the html content of a mail, keeping its line breaks
+func HTML(text string) string {
+	return strings.Replace(template.HTMLEscapeString(text), "\n", "<br>", -1)
+}
@@ -0,0 +1,48 @@
+package messages
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/models"
+)
+
+func TestFill(t *testing.T) {
+	start, _ := time.Parse("2006-01-02", "2050-01-10")
+	res := models.Reservation{
+		ID:        7,
+		FirstName: "John",
+		LastName:  "Smith",
+		StartDate: start,
+		EndDate:   start.AddDate(0, 0, 3),
+		Guests:    2,
+		Room:      models.Room{RoomName: "General's Quarters"},
+	}
+
+	tests := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{"names", "Dear {first_name} {last_name},", "Dear John Smith,"},
+		{"stay", "{room} from {arrival} to {departure}, {nights} nights for {guests}",
+			"General's Quarters from 2050-01-10 to 2050-01-13, 3 nights for 2"},
+		{"id", "Reservation #{reservation_id}", "Reservation #7"},
+		{"unknown", "{first_name}, see you {soon}", "John, see you {soon}"},
+		{"none", "Hello", "Hello"},
+	}
+
+	for _, e := range tests {
+		if got := Fill(e.text, res); got != e.expected {
+			t.Errorf("failed %s: expected %q but got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestHTML(t *testing.T) {
+	got := HTML("Dear <John>,\nsee you soon")
+	expected := "Dear &lt;John&gt;,<br>see you soon"
+	if got != expected {
+		t.Errorf("expected %q but got %q", expected, got)
+	}
+}
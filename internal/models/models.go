@@ -2,6 +2,115 @@ package models
 
 import "time"
 
+// payment policies of a room
+const (
+	PaymentPolicyNone    = "none"
+	PaymentPolicyDeposit = "deposit"
+	PaymentPolicyPrepay  = "prepay"
+)
+
+// statuses of a reservation
+const (
+	ReservationStatusPendingPayment = "pending_payment"
+	ReservationStatusConfirmed      = "confirmed"
+	ReservationStatusCancelled      = "cancelled"
+)
+
+// kinds of an invoice
+const (
+	InvoiceKindInvoice    = "invoice"
+	InvoiceKindCreditNote = "credit_note"
+)
+
+// bases on which a tax or fee rule is charged
+const (
+	TaxFeeBasisPercent       = "percent"
+	TaxFeeBasisPerNight      = "per_night"
+	TaxFeeBasisPerStay       = "per_stay"
+	TaxFeeBasisPerGuest      = "per_guest"
+	TaxFeeBasisPerGuestNight = "per_guest_night"
+)
+
+// kinds of an invoice line and of a reservation charge
+const (
+	InvoiceLineNights   = "nights"
+	InvoiceLineFee      = "fee"
+	InvoiceLineTax      = "tax"
+	InvoiceLineDiscount = "discount"
+)
+
+// statuses of a waitlist entry
+const (
+	WaitlistStatusWaiting  = "waiting"
+	WaitlistStatusNotified = "notified"
+	WaitlistStatusBooked   = "booked"
+	WaitlistStatusExpired  = "expired"
+)
+
+// restrictions a room can have, the ids of the restrictions table
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	RestrictionHold        = 3
+	RestrictionOutOfOrder  = 4
+)
+
+// statuses of an out-of-order period
+const (
+	OutOfOrderStatusOpen       = "open"
+	OutOfOrderStatusInProgress = "in_progress"
+	OutOfOrderStatusResolved   = "resolved"
+)
+
+// access levels of a user; staff is the column default, housekeepers have to be given their level explicitly
+const (
+	AccessLevelStaff       = 1
+	AccessLevelAdmin       = 3
+	AccessLevelHousekeeper = 5
+)
+
+// statuses of a room's housekeeping, from just vacated to ready for the next guest
+const (
+	HousekeepingStatusDirty      = "dirty"
+	HousekeepingStatusInProgress = "in_progress"
+	HousekeepingStatusClean      = "clean"
+	HousekeepingStatusInspected  = "inspected"
+)
+
+// kinds of a housekeeping task
+const (
+	HousekeepingTaskDeparture = "departure"
+	HousekeepingTaskService   = "service"
+	HousekeepingTaskManual    = "manual"
+)
+
+// flags staff can put on a guest
+const (
+	GuestFlagVIP       = "vip"
+	GuestFlagDoNotRent = "do_not_rent"
+)
+
+// kinds of text messages sent to guests
+const (
+	SMSKindConfirmation = "confirmation"
+	SMSKindReminder     = "reminder"
+)
+
+// directions of a message in a guest conversation
+const (
+	ConversationMessageIn  = "in"
+	ConversationMessageOut = "out"
+)
+
+// actions staff can run on many reservations at once
+const (
+	BulkActionProcess = "process"
+	BulkActionCancel  = "cancel"
+	BulkActionTag     = "tag"
+	BulkActionExport  = "export"
+	BulkActionEmail   = "email"
+)
+
 // User is the user model
 type User struct {
 	ID          int
@@ -10,16 +119,36 @@ type User struct {
 	Email       string
 	Password    string
 	AccessLevel int
+	Version     int
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
 // Room is the room model
 type Room struct {
-	ID        int
-	RoomName  string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	ID                   int
+	RoomName             string
+	Price                int
+	PaymentPolicy        string
+	DepositPercent       int
+	CancellationPolicyID int
+	BlocksVersion        int
+	MaxGuests            int
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+	CancellationPolicy   CancellationPolicy
+}
+
+// CancellationPolicy is the cancellation policy model
+type CancellationPolicy struct {
+	ID                   int
+	Name                 string
+	Description          string
+	FullRefundDays       int
+	PartialRefundDays    int
+	PartialRefundPercent int
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
 }
 
 // Restriction is the restriction model
@@ -32,18 +161,235 @@ type Restriction struct {
 
 // Reservation is the reservation model
 type Reservation struct {
+	ID           int
+	FirstName    string
+	LastName     string
+	Email        string
+	Phone        string
+	StartDate    time.Time
+	EndDate      time.Time
+	RoomID       int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Room         Room
+	Processed    int
+	Status       string
+	TotalAmount  int
+	AccessCode   string
+	CancelledAt  time.Time
+	RefundAmount int
+	Guests       int
+	Charges      []ReservationCharge
+	BookingID    int
+	GuestID      int
+	Tags         []string
+	CheckedInAt  time.Time
+	CheckedOutAt time.Time
+	Version      int
+	DeletedAt    time.Time
+}
+
+// HousekeepingTask is a room which has to be cleaned on a day
+type HousekeepingTask struct {
+	ID            int
+	RoomID        int
+	ReservationID int
+	Day           time.Time
+	Kind          string
+	Status        string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Room          Room
+}
+
+// ReservationFilter narrows, orders and pages the admin reservation lists; zero values do not filter
+type ReservationFilter struct {
+	Search      string
+	RoomID      int
+	Status      string
+	Tag         string
+	From        time.Time
+	To          time.Time
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	NewOnly     bool
+	IDs         []int
+	Sort        string
+	Desc        bool
+	Page        int
+	PerPage     int
+}
+
+// ReservationNote is an internal note staff keep on a reservation; guests never see it
+type ReservationNote struct {
+	ID            int
+	ReservationID int
+	UserID        int
+	Author        string
+	Body          string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// MessageTemplate is a saved email staff can send to guests; its subject and body may hold placeholders
+type MessageTemplate struct {
 	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomID    int
+	Name      string
+	Subject   string
+	Body      string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ReservationMessage is an email staff sent to the guest of a reservation, as it was sent
+type ReservationMessage struct {
+	ID            int
+	ReservationID int
+	UserID        int
+	Author        string
+	Recipient     string
+	Subject       string
+	Body          string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// SMSMessage is a text message sent to the guest of a reservation, with what the provider reported for it
+type SMSMessage struct {
+	ID            int
+	ReservationID int
+	Kind          string
+	Phone         string
+	Body          string
+	Provider      string
+	Ref           string
+	Status        string
+	Error         string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Conversation is a thread of messages with a guest, tied to a reservation or guest where we know them.
+// Token is the reference replies carry in their subject so the guest's answers find their way back
+type Conversation struct {
+	ID            int
+	Token         string
+	GuestID       int
+	ReservationID int
+	Name          string
+	Email         string
+	Subject       string
+	Unread        int
+	LastMessageAt time.Time
+	Messages      []ConversationMessage
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// ConversationMessage is one message of a conversation, from the guest or a reply by staff
+type ConversationMessage struct {
+	ID             int
+	ConversationID int
+	Direction      string
+	UserID         int
+	Author         string
+	Body           string
+	MessageID      string
+	ReadAt         time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+// BulkAction is one batch of an action run on many reservations, kept as an audit record;
+// Detail holds the tags added, the export format or the subject of the email sent
+type BulkAction struct {
+	ID        int
+	UserID    int
+	Author    string
+	Action    string
+	Detail    string
+	Items     []BulkActionItem
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	Room      Room
-	Processed int
+}
+
+// Failed returns the number of reservations the action could not be applied to
+func (b BulkAction) Failed() int {
+	failed := 0
+	for _, item := range b.Items {
+		if !item.OK {
+			failed++
+		}
+	}
+	return failed
+}
+
+// BulkActionItem is the outcome of a bulk action for one reservation
+type BulkActionItem struct {
+	ReservationID int
+	Guest         string
+	OK            bool
+	Message       string
+}
+
+// Guest is a person who has stayed or will stay with us, identified by their email
+type Guest struct {
+	ID           int
+	FirstName    string
+	LastName     string
+	Email        string
+	Phone        string
+	Notes        string
+	Preferences  string
+	Flag         string
+	SMSOptOut    bool
+	Stays        int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Reservations []Reservation
+}
+
+// Booking is a group of reservations checked out together
+type Booking struct {
+	ID           int
+	FirstName    string
+	LastName     string
+	Email        string
+	Phone        string
+	AccessCode   string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Reservations []Reservation
+}
+
+// Cart holds the rooms a guest wants to book together
+type Cart struct {
+	Items []CartItem
+}
+
+// CartItem is one room in a cart
+type CartItem struct {
+	RoomID    int
+	RoomName  string
+	StartDate time.Time
+	EndDate   time.Time
+}
+
+// WaitlistEntry is a guest waiting for a room to become free for their dates
+type WaitlistEntry struct {
+	ID             int
+	FirstName      string
+	LastName       string
+	Email          string
+	StartDate      time.Time
+	EndDate        time.Time
+	Guests         int
+	Status         string
+	Token          string
+	NotifiedAt     time.Time
+	OfferExpiresAt time.Time
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
 }
 
 // RoomRestriction is the room restriction model
@@ -54,6 +400,11 @@ type RoomRestriction struct {
 	RoomID        int
 	ReservationID int
 	RestrictionID int
+	ExpiresAt     time.Time
+	Reason        string
+	AssignedTo    string
+	Status        string
+	DeletedAt     time.Time
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	Room          Room
@@ -61,11 +412,103 @@ type RoomRestriction struct {
 	Restriction   Restriction
 }
 
+// RoomStats are the totals of one room over a reporting period
+type RoomStats struct {
+	RoomID          int
+	RoomName        string
+	AvailableNights int
+	OccupiedNights  int
+	Revenue         int
+	Bookings        int
+	Cancellations   int
+	LeadDays        int
+	BookedNights    int
+	// OutOfOrderNights are the available nights the room was out of order
+	OutOfOrderNights int
+}
+
+// Payment is the payment model
+type Payment struct {
+	ID            int
+	ReservationID int
+	Provider      string
+	ProviderRef   string
+	Amount        int
+	Status        string
+	Message       string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// TaxFeeRule is a tax or fee added to bookings; Amount is in cents, or in hundredths of a percent for percentages
+type TaxFeeRule struct {
+	ID        int
+	Name      string
+	Kind      string
+	Basis     string
+	Amount    int
+	StartsOn  time.Time
+	EndsOn    time.Time
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ReservationCharge is a tax or fee as it was applied to a reservation
+type ReservationCharge struct {
+	ID            int
+	ReservationID int
+	TaxFeeRuleID  int
+	Kind          string
+	Description   string
+	Quantity      int
+	UnitAmount    int
+	Amount        int
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Invoice is the invoice model; credit notes are invoices with negative lines
+type Invoice struct {
+	ID                int
+	Number            int
+	Kind              string
+	ReservationID     int
+	OriginalInvoiceID int
+	BillToName        string
+	BillToEmail       string
+	Total             int
+	IssuedAt          time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Lines             []InvoiceLine
+}
+
+// InvoiceLine is one line item of an invoice
+type InvoiceLine struct {
+	ID          int
+	InvoiceID   int
+	Kind        string
+	Description string
+	Quantity    int
+	UnitAmount  int
+	Amount      int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
 // MailData holds an email message
 type MailData struct {
-	To       string
-	From     string
-	Subject  string
-	Content  string
-	Template string
+	To          string
+	From        string
+	Subject     string
+	Content     string
+	Template    string
+	Attachments []MailAttachment
+}
+
+// MailAttachment is a file attached to an email message
+type MailAttachment struct {
+	Name     string
+	MimeType string
+	Data     []byte
 }
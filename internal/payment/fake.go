@@ -0,0 +1,305 @@
+package payment
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SignatureHeader is the header which carries the signature of a fake webhook
+const SignatureHeader = "X-Fake-Signature"
+
+// card tokens understood by the fake gateway
+const (
+	TestCardToken    = "tok_visa"
+	DeclineCardToken = "tok_declined"
+)
+
+// Sign returns the hex encoded HMAC-SHA256 of body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// FakeGateway is a PaymentGateway which talks to a FakeServer over HTTP
+type FakeGateway struct {
+	BaseURL string
+	Secret  string
+	Client  *http.Client
+}
+
+// NewFakeGateway creates a fake gateway for the stand-in running at baseURL
+func NewFakeGateway(baseURL, secret string) *FakeGateway {
+	return &FakeGateway{
+		BaseURL: baseURL,
+		Secret:  secret,
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type amountRequest struct {
+	Ref    string `json:"ref"`
+	Amount int    `json:"amount"`
+}
+
+// Name returns the name of the provider
+func (g *FakeGateway) Name() string {
+	return "fake"
+}
+
+// Authorize reserves the amount on the card of the guest
+func (g *FakeGateway) Authorize(req AuthorizeRequest) (Transaction, error) {
+	return g.post("/v1/authorize", req)
+}
+
+// Capture takes the amount of an authorized transaction
+func (g *FakeGateway) Capture(ref string, amount int) (Transaction, error) {
+	return g.post("/v1/capture", amountRequest{Ref: ref, Amount: amount})
+}
+
+// Refund gives back the amount of a captured transaction
+func (g *FakeGateway) Refund(ref string, amount int) (Transaction, error) {
+	return g.post("/v1/refund", amountRequest{Ref: ref, Amount: amount})
+}
+
+// VerifyWebhook checks the signature of a webhook and decodes its event
+func (g *FakeGateway) VerifyWebhook(r *http.Request) (WebhookEvent, error) {
+	var event WebhookEvent
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return event, err
+	}
+
+	expected := Sign(g.Secret, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(SignatureHeader))) {
+		return event, ErrInvalidSignature
+	}
+
+	err = json.Unmarshal(body, &event)
+	if err != nil {
+		return event, err
+	}
+
+	return event, nil
+}
+
+func (g *FakeGateway) post(path string, payload interface{}) (Transaction, error) {
+	var tx Transaction
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return tx, err
+	}
+
+	resp, err := g.Client.Post(g.BaseURL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return tx, err
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(&tx)
+	if err != nil {
+		return tx, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return tx, nil
+	case http.StatusPaymentRequired:
+		return tx, ErrDeclined
+	default:
+		return tx, fmt.Errorf("payment gateway returned %d: %s", resp.StatusCode, tx.Message)
+	}
+}
+
+// FakeServer is a local stand-in for a payment provider
+type FakeServer struct {
+	Secret     string
+	WebhookURL string
+
+	mu           sync.Mutex
+	next         int
+	transactions map[string]*fakeTransaction
+	mux          *http.ServeMux
+}
+
+type fakeTransaction struct {
+	Transaction
+	reference  string
+	authorized int
+	captured   int
+	refunded   int
+}
+
+// NewFakeServer creates a stand-in which posts signed events to webhookURL
+func NewFakeServer(secret, webhookURL string) *FakeServer {
+	s := &FakeServer{
+		Secret:       secret,
+		WebhookURL:   webhookURL,
+		transactions: make(map[string]*fakeTransaction),
+		mux:          http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/v1/authorize", s.authorize)
+	s.mux.HandleFunc("/v1/capture", s.capture)
+	s.mux.HandleFunc("/v1/refund", s.refund)
+
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *FakeServer) authorize(w http.ResponseWriter, r *http.Request) {
+	var req AuthorizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Amount <= 0 {
+		writeTransaction(w, http.StatusBadRequest, Transaction{Status: StatusFailed, Message: "invalid request"})
+		return
+	}
+
+	if req.CardToken == "" || req.CardToken == DeclineCardToken {
+		writeTransaction(w, http.StatusPaymentRequired, Transaction{
+			Status:  StatusDeclined,
+			Amount:  req.Amount,
+			Message: "card declined",
+		})
+		return
+	}
+
+	s.mu.Lock()
+	s.next++
+	tx := &fakeTransaction{
+		Transaction: Transaction{
+			Ref:    fmt.Sprintf("fake_%d", s.next),
+			Status: StatusAuthorized,
+			Amount: req.Amount,
+		},
+		reference:  req.Reference,
+		authorized: req.Amount,
+	}
+	s.transactions[tx.Ref] = tx
+	s.mu.Unlock()
+
+	writeTransaction(w, http.StatusOK, tx.Transaction)
+}
+
+func (s *FakeServer) capture(w http.ResponseWriter, r *http.Request) {
+	req, tx, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	if tx.Status != StatusAuthorized || req.Amount > tx.authorized {
+		s.mu.Unlock()
+		writeTransaction(w, http.StatusConflict, Transaction{Ref: req.Ref, Status: tx.Status, Message: "can't capture transaction"})
+		return
+	}
+	tx.captured = req.Amount
+	tx.Status = StatusCaptured
+	tx.Amount = req.Amount
+	result := tx.Transaction
+	s.mu.Unlock()
+
+	s.sendWebhook(EventCaptured, result, tx.reference)
+	writeTransaction(w, http.StatusOK, result)
+}
+
+func (s *FakeServer) refund(w http.ResponseWriter, r *http.Request) {
+	req, tx, ok := s.lookup(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	if tx.Status != StatusCaptured || req.Amount > tx.captured-tx.refunded {
+		s.mu.Unlock()
+		writeTransaction(w, http.StatusConflict, Transaction{Ref: req.Ref, Status: tx.Status, Message: "can't refund transaction"})
+		return
+	}
+	tx.refunded += req.Amount
+	result := Transaction{Ref: tx.Ref, Status: StatusRefunded, Amount: req.Amount}
+	if tx.refunded == tx.captured {
+		tx.Status = StatusRefunded
+	}
+	s.mu.Unlock()
+
+	s.sendWebhook(EventRefunded, result, tx.reference)
+	writeTransaction(w, http.StatusOK, result)
+}
+
+// lookup decodes an amount request and finds its transaction
+func (s *FakeServer) lookup(w http.ResponseWriter, r *http.Request) (amountRequest, *fakeTransaction, bool) {
+	var req amountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Amount <= 0 {
+		writeTransaction(w, http.StatusBadRequest, Transaction{Status: StatusFailed, Message: "invalid request"})
+		return req, nil, false
+	}
+
+	s.mu.Lock()
+	tx, ok := s.transactions[req.Ref]
+	s.mu.Unlock()
+	if !ok {
+		writeTransaction(w, http.StatusNotFound, Transaction{Ref: req.Ref, Status: StatusFailed, Message: "unknown transaction"})
+		return req, nil, false
+	}
+
+	return req, tx, true
+}
+
+// sendWebhook posts a signed event to the webhook url, if there is one
+func (s *FakeServer) sendWebhook(eventType string, tx Transaction, reference string) error {
+	if s.WebhookURL == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(WebhookEvent{
+		Type:      eventType,
+		Ref:       tx.Ref,
+		Reference: reference,
+		Amount:    tx.Amount,
+		Status:    tx.Status,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(s.Secret, body))
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("webhook was not accepted")
+	}
+
+	return nil
+}
+
+func writeTransaction(w http.ResponseWriter, status int, tx Transaction) {
+	out, _ := json.Marshal(tx)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
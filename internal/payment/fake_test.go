@@ -0,0 +1,117 @@
+package payment
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testSecret = "test-secret"
+
+func TestFakeGateway_Flow(t *testing.T) {
+	var events []WebhookEvent
+
+	gateway := NewFakeGateway("", testSecret)
+
+	hooks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event, err := gateway.VerifyWebhook(r)
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		events = append(events, event)
+	}))
+	defer hooks.Close()
+
+	srv := httptest.NewServer(NewFakeServer(testSecret, hooks.URL))
+	defer srv.Close()
+	gateway.BaseURL = srv.URL
+
+	tx, err := gateway.Authorize(AuthorizeRequest{Amount: 5000, Currency: "USD", CardToken: TestCardToken, Reference: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Status != StatusAuthorized || tx.Ref == "" {
+		t.Errorf("expected authorized transaction with a ref, but got %+v", tx)
+	}
+
+	_, err = gateway.Refund(tx.Ref, 5000)
+	if err == nil {
+		t.Error("refunded a transaction which was not captured")
+	}
+
+	_, err = gateway.Capture(tx.Ref, 6000)
+	if err == nil {
+		t.Error("captured more than was authorized")
+	}
+
+	captured, err := gateway.Capture(tx.Ref, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if captured.Status != StatusCaptured {
+		t.Errorf("expected status %s but got %s", StatusCaptured, captured.Status)
+	}
+
+	refunded, err := gateway.Refund(tx.Ref, 2000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded.Status != StatusRefunded || refunded.Amount != 2000 {
+		t.Errorf("expected refund of 2000 but got %+v", refunded)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("expected 2 webhook events but got %d", len(events))
+	}
+	if events[0].Type != EventCaptured || events[0].Reference != "1" {
+		t.Errorf("unexpected first event %+v", events[0])
+	}
+	if events[1].Type != EventRefunded || events[1].Amount != 2000 {
+		t.Errorf("unexpected second event %+v", events[1])
+	}
+}
+
+func TestFakeGateway_Decline(t *testing.T) {
+	srv := httptest.NewServer(NewFakeServer(testSecret, ""))
+	defer srv.Close()
+
+	gateway := NewFakeGateway(srv.URL, testSecret)
+
+	tx, err := gateway.Authorize(AuthorizeRequest{Amount: 5000, CardToken: DeclineCardToken})
+	if err != ErrDeclined {
+		t.Errorf("expected ErrDeclined but got %v", err)
+	}
+	if tx.Status != StatusDeclined {
+		t.Errorf("expected status %s but got %s", StatusDeclined, tx.Status)
+	}
+
+	_, err = gateway.Capture("fake_unknown", 5000)
+	if err == nil {
+		t.Error("captured a transaction which does not exist")
+	}
+}
+
+func TestFakeGateway_VerifyWebhook(t *testing.T) {
+	gateway := NewFakeGateway("", testSecret)
+	body := []byte(`{"type":"payment.captured","ref":"fake_1","amount":100}`)
+
+	req := httptest.NewRequest("POST", "/payments/webhook", bytes.NewReader(body))
+	req.Header.Set(SignatureHeader, Sign(testSecret, body))
+	event, err := gateway.VerifyWebhook(req)
+	if err != nil {
+		t.Error(err)
+	}
+	if event.Ref != "fake_1" {
+		t.Errorf("expected ref fake_1 but got %s", event.Ref)
+	}
+
+	req = httptest.NewRequest("POST", "/payments/webhook", bytes.NewReader(body))
+	req.Header.Set(SignatureHeader, Sign("wrong-secret", body))
+	_, err = gateway.VerifyWebhook(req)
+	if err != ErrInvalidSignature {
+		t.Errorf("expected ErrInvalidSignature but got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package payment
+
+import (
+	"errors"
+	"net/http"
+)
+
+// statuses of a payment transaction
+const (
+	StatusAuthorized = "authorized"
+	StatusCaptured   = "captured"
+	StatusRefunded   = "refunded"
+	StatusDeclined   = "declined"
+	StatusFailed     = "failed"
+
+	// StatusRefundFailed marks a refund the gateway did not make; it is retried until it goes through
+	StatusRefundFailed = "refund_failed"
+)
+
+// types of webhook events sent by a gateway
+const (
+	EventCaptured = "payment.captured"
+	EventRefunded = "payment.refunded"
+	EventFailed   = "payment.failed"
+)
+
+// ErrDeclined is returned when the gateway declines a payment
+var ErrDeclined = errors.New("payment declined")
+
+// ErrInvalidSignature is returned when a webhook can't be verified
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// PaymentGateway is the interface every payment provider has to implement
+type PaymentGateway interface {
+	Name() string
+	Authorize(req AuthorizeRequest) (Transaction, error)
+	Capture(ref string, amount int) (Transaction, error)
+	Refund(ref string, amount int) (Transaction, error)
+	VerifyWebhook(r *http.Request) (WebhookEvent, error)
+}
+
+// AuthorizeRequest holds the data needed to authorize a payment
+type AuthorizeRequest struct {
+	Amount      int    `json:"amount"`
+	Currency    string `json:"currency"`
+	CardToken   string `json:"card_token"`
+	Reference   string `json:"reference"`
+	Description string `json:"description"`
+}
+
+// Transaction is the result of a gateway call
+type Transaction struct {
+	Ref     string `json:"ref"`
+	Status  string `json:"status"`
+	Amount  int    `json:"amount"`
+	Message string `json:"message"`
+}
+
+// WebhookEvent is a verified notification sent by a gateway
+type WebhookEvent struct {
+	Type      string `json:"type"`
+	Ref       string `json:"ref"`
+	Reference string `json:"reference"`
+	Amount    int    `json:"amount"`
+	Status    string `json:"status"`
+}
@@ -0,0 +1,69 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestDocument_Bytes(t *testing.T) {
+	doc := New()
+	page := doc.AddPage()
+	page.Text(50, 800, HelveticaBold, 18, "Invoice (copy)")
+	page.TextRight(545, 780, 10, "$150.00")
+	page.Line(50, 770, 545, 770)
+	doc.AddPage()
+
+	out := doc.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4")) {
+		t.Error("document does not start with the PDF header")
+	}
+
+	if !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Error("document does not end with EOF marker")
+	}
+
+	if !bytes.Contains(out, []byte(`(Invoice \(copy\)) Tj`)) {
+		t.Error("text was not escaped")
+	}
+
+	if !bytes.Contains(out, []byte("/Count 2")) {
+		t.Error("expected two pages")
+	}
+
+	// every xref entry has to point at the start of its object
+	m := regexp.MustCompile(`startxref\n(\d+)`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(out[xref:], []byte("xref")) {
+		t.Fatal("startxref does not point at the xref table")
+	}
+
+	entries := regexp.MustCompile(`(\d{10}) 00000 n`).FindAllSubmatch(out[xref:], -1)
+	for i, e := range entries {
+		offset, _ := strconv.Atoi(string(e[1]))
+		expected := fmt.Sprintf("%d 0 obj", i+1)
+		if !bytes.HasPrefix(out[offset:], []byte(expected)) {
+			t.Errorf("xref entry %d does not point at %s", i+1, expected)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	if e := escape(`a\b`); e != `a\\b` {
+		t.Errorf("expected a\\\\b but got %s", e)
+	}
+
+	if e := escape("café"); e != `caf\351` {
+		t.Errorf("expected latin-1 octal escape but got %s", e)
+	}
+
+	if e := escape("한"); e != "?" {
+		t.Errorf("expected unsupported rune to be replaced but got %s", e)
+	}
+}
@@ -0,0 +1,41 @@
+package pricing
+
+import (
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/models"
+)
+
+// Nights returns the number of nights between start and end date
+func Nights(start, end time.Time) int {
+	nights := int(end.Sub(start).Hours() / 24)
+	if nights < 0 {
+		return 0
+	}
+	return nights
+}
+
+// RoomTotal returns the price of a room for the given stay in cents
+func RoomTotal(room models.Room, start, end time.Time) int {
+	return room.Price * Nights(start, end)
+}
+
+// RequiresPayment reports whether a room has to be paid for when it is booked
+func RequiresPayment(room models.Room) bool {
+	switch room.PaymentPolicy {
+	case models.PaymentPolicyDeposit, models.PaymentPolicyPrepay:
+		return true
+	}
+	return false
+}
+
+// AmountDue returns the amount which has to be paid at booking time for a total
+func AmountDue(room models.Room, total int) int {
+	switch room.PaymentPolicy {
+	case models.PaymentPolicyPrepay:
+		return total
+	case models.PaymentPolicyDeposit:
+		return total * room.DepositPercent / 100
+	}
+	return 0
+}
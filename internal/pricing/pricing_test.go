@@ -0,0 +1,58 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/models"
+)
+
+func TestNights(t *testing.T) {
+	layout := "2006-01-02"
+	start, _ := time.Parse(layout, "2050-01-02")
+	end, _ := time.Parse(layout, "2050-01-05")
+
+	if n := Nights(start, end); n != 3 {
+		t.Errorf("expected 3 nights, but got %d", n)
+	}
+
+	if n := Nights(end, start); n != 0 {
+		t.Errorf("expected 0 nights when end is before start, but got %d", n)
+	}
+}
+
+var amountDueTests = []struct {
+	name     string
+	room     models.Room
+	total    int
+	required bool
+	expected int
+}{
+	{"no-policy", models.Room{}, 30000, false, 0},
+	{"none-policy", models.Room{PaymentPolicy: models.PaymentPolicyNone}, 30000, false, 0},
+	{"deposit-policy", models.Room{PaymentPolicy: models.PaymentPolicyDeposit, DepositPercent: 20}, 30000, true, 6000},
+	{"prepay-policy", models.Room{PaymentPolicy: models.PaymentPolicyPrepay}, 30000, true, 30000},
+}
+
+func TestAmountDue(t *testing.T) {
+	for _, e := range amountDueTests {
+		if RequiresPayment(e.room) != e.required {
+			t.Errorf("%s: expected requires payment to be %v", e.name, e.required)
+		}
+
+		if due := AmountDue(e.room, e.total); due != e.expected {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expected, due)
+		}
+	}
+}
+
+func TestRoomTotal(t *testing.T) {
+	layout := "2006-01-02"
+	start, _ := time.Parse(layout, "2050-01-02")
+	end, _ := time.Parse(layout, "2050-01-04")
+
+	total := RoomTotal(models.Room{Price: 15000}, start, end)
+	if total != 30000 {
+		t.Errorf("expected 30000 but got %d", total)
+	}
+}
@@ -0,0 +1,186 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/models"
+)
+
+// Quote is the price of a stay broken down into the room and its taxes and fees
+type Quote struct {
+	Nights    int
+	RoomTotal int
+	Charges   []models.ReservationCharge
+	Total     int
+}
+
+// RuleApplies reports whether a rule is in effect for a stay arriving on start
+func RuleApplies(rule models.TaxFeeRule, start time.Time) bool {
+	day := start.Truncate(24 * time.Hour)
+	if !rule.StartsOn.IsZero() && day.Before(rule.StartsOn) {
+		return false
+	}
+	if !rule.EndsOn.IsZero() && day.After(rule.EndsOn) {
+		return false
+	}
+	return true
+}
+
+// NewQuote prices a stay with all rules in effect on the arrival date.
+// Fixed charges are worked out first; percentages apply to the room plus fixed fees, never to other taxes.
+func NewQuote(room models.Room, start, end time.Time, guests int, rules []models.TaxFeeRule) Quote {
+	if guests < 1 {
+		guests = 1
+	}
+
+	q := Quote{
+		Nights:    Nights(start, end),
+		RoomTotal: RoomTotal(room, start, end),
+	}
+
+	base := q.RoomTotal
+	var percents []models.TaxFeeRule
+
+	for _, rule := range rules {
+		if !RuleApplies(rule, start) {
+			continue
+		}
+
+		quantity := 0
+		switch rule.Basis {
+		case models.TaxFeeBasisPercent:
+			percents = append(percents, rule)
+			continue
+		case models.TaxFeeBasisPerNight:
+			quantity = q.Nights
+		case models.TaxFeeBasisPerStay:
+			quantity = 1
+		case models.TaxFeeBasisPerGuest:
+			quantity = guests
+		case models.TaxFeeBasisPerGuestNight:
+			quantity = guests * q.Nights
+		}
+
+		if quantity == 0 {
+			continue
+		}
+
+		c := models.ReservationCharge{
+			TaxFeeRuleID: rule.ID,
+			Kind:         rule.Kind,
+			Description:  rule.Name,
+			Quantity:     quantity,
+			UnitAmount:   rule.Amount,
+			Amount:       quantity * rule.Amount,
+		}
+		q.Charges = append(q.Charges, c)
+
+		if rule.Kind == models.InvoiceLineFee {
+			base += c.Amount
+		}
+	}
+
+	for _, rule := range percents {
+		// amounts are rounded half up to the cent
+		amount := (base*rule.Amount + 5000) / 10000
+		q.Charges = append(q.Charges, models.ReservationCharge{
+			TaxFeeRuleID: rule.ID,
+			Kind:         rule.Kind,
+			Description:  fmt.Sprintf("%s (%s%%)", rule.Name, FormatHundredths(rule.Amount)),
+			Quantity:     1,
+			UnitAmount:   amount,
+			Amount:       amount,
+		})
+	}
+
+	q.Total = q.RoomTotal
+	for _, c := range q.Charges {
+		q.Total += c.Amount
+	}
+
+	return q
+}
+
+// ChargesTotal returns the sum of all charges
+func ChargesTotal(charges []models.ReservationCharge) int {
+	total := 0
+	for _, c := range charges {
+		total += c.Amount
+	}
+	return total
+}
+
+// ParseHundredths parses a decimal like 12.5 into hundredths, e.g. cents or hundredths of a percent
+func ParseHundredths(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	parts := strings.SplitN(s, ".", 2)
+	if parts[0] == "" && len(parts) == 2 {
+		parts[0] = "0"
+	}
+
+	// Atoi alone would take signs, so "1.-5" or "--1" would pass
+	if !isDigits(parts[0]) {
+		return 0, errors.New("invalid number")
+	}
+	whole, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, errors.New("invalid number")
+	}
+
+	fraction := 0
+	if len(parts) == 2 {
+		f := parts[1]
+		if len(f) == 0 || len(f) > 2 {
+			return 0, errors.New("at most two decimal places are allowed")
+		}
+		if !isDigits(f) {
+			return 0, errors.New("invalid number")
+		}
+		if len(f) == 1 {
+			f += "0"
+		}
+		fraction, err = strconv.Atoi(f)
+		if err != nil {
+			return 0, errors.New("invalid number")
+		}
+	}
+
+	n := whole*100 + fraction
+	if negative {
+		n = -n
+	}
+	return n, nil
+}
+
+// isDigits reports whether s is made of one or more digits 0-9 and nothing else
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatHundredths formats hundredths as a decimal, dropping a zero fraction
+func FormatHundredths(n int) string {
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+	if n%100 == 0 {
+		return fmt.Sprintf("%s%d", sign, n/100)
+	}
+	return strings.TrimSuffix(fmt.Sprintf("%s%d.%02d", sign, n/100, n%100), "0")
+}
@@ -0,0 +1,113 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/models"
+)
+
+var vat = models.TaxFeeRule{ID: 1, Name: "VAT", Kind: models.InvoiceLineTax, Basis: models.TaxFeeBasisPercent, Amount: 1000}
+var tourist = models.TaxFeeRule{ID: 2, Name: "Tourist tax", Kind: models.InvoiceLineTax, Basis: models.TaxFeeBasisPerGuestNight, Amount: 250}
+var cleaning = models.TaxFeeRule{ID: 3, Name: "Cleaning", Kind: models.InvoiceLineFee, Basis: models.TaxFeeBasisPerStay, Amount: 3000}
+
+func TestNewQuote(t *testing.T) {
+	start, _ := time.Parse("2006-01-02", "2050-01-10")
+	end := start.AddDate(0, 0, 2)
+	room := models.Room{Price: 10000}
+
+	q := NewQuote(room, start, end, 2, []models.TaxFeeRule{vat, tourist, cleaning})
+
+	if q.RoomTotal != 20000 {
+		t.Errorf("expected room total 20000 but got %d", q.RoomTotal)
+	}
+
+	if len(q.Charges) != 3 {
+		t.Fatalf("expected 3 charges but got %d", len(q.Charges))
+	}
+
+	// tourist tax: 2 guests x 2 nights
+	if q.Charges[0].Quantity != 4 || q.Charges[0].Amount != 1000 {
+		t.Errorf("unexpected tourist tax %+v", q.Charges[0])
+	}
+
+	// VAT applies to room and cleaning fee but not to the tourist tax
+	if q.Charges[2].Amount != 2300 || q.Charges[2].Description != "VAT (10%)" {
+		t.Errorf("unexpected VAT %+v", q.Charges[2])
+	}
+
+	if q.Total != 20000+1000+3000+2300 {
+		t.Errorf("unexpected total %d", q.Total)
+	}
+
+	if ChargesTotal(q.Charges) != q.Total-q.RoomTotal {
+		t.Error("charges total does not match")
+	}
+}
+
+func TestRuleApplies(t *testing.T) {
+	from, _ := time.Parse("2006-01-02", "2050-01-01")
+	to, _ := time.Parse("2006-01-02", "2050-01-31")
+	rule := models.TaxFeeRule{StartsOn: from, EndsOn: to}
+
+	var tests = []struct {
+		day      string
+		expected bool
+	}{
+		{"2049-12-31", false},
+		{"2050-01-01", true},
+		{"2050-01-31", true},
+		{"2050-02-01", false},
+	}
+
+	for _, e := range tests {
+		day, _ := time.Parse("2006-01-02", e.day)
+		if RuleApplies(rule, day) != e.expected {
+			t.Errorf("expected %t for %s", e.expected, e.day)
+		}
+	}
+
+	if !RuleApplies(models.TaxFeeRule{}, from) {
+		t.Error("rule without dates should always apply")
+	}
+}
+
+func TestParseHundredths(t *testing.T) {
+	var tests = []struct {
+		input    string
+		expected int
+		valid    bool
+	}{
+		{"12", 1200, true},
+		{"12.5", 1250, true},
+		{"12.05", 1205, true},
+		{".5", 50, true},
+		{"-1.25", -125, true},
+		{"1.255", 0, false},
+		{"abc", 0, false},
+		{"1.", 0, false},
+		{"1.-5", 0, false},
+		{"1.+5", 0, false},
+		{"--1", 0, false},
+		{"+1", 0, false},
+		{"1. 5", 0, false},
+		{"", 0, false},
+		{"-", 0, false},
+	}
+
+	for _, e := range tests {
+		n, err := ParseHundredths(e.input)
+		if (err == nil) != e.valid {
+			t.Errorf("%s: expected valid %t but got error %v", e.input, e.valid, err)
+		}
+		if e.valid && n != e.expected {
+			t.Errorf("%s: expected %d but got %d", e.input, e.expected, n)
+		}
+	}
+
+	for n, expected := range map[int]string{1000: "10", 750: "7.5", 725: "7.25", -50: "-0.5"} {
+		if s := FormatHundredths(n); s != expected {
+			t.Errorf("expected %s but got %s", expected, s)
+		}
+	}
+}
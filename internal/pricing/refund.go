@@ -0,0 +1,82 @@
+package pricing
+
+import (
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/models"
+	"github.com/yj-matmul/bookings/internal/payment"
+)
+
+// DaysBeforeArrival returns the number of whole days between t and the arrival date
+func DaysBeforeArrival(arrival, t time.Time) int {
+	y, mo, d := t.Date()
+	day := time.Date(y, mo, d, 0, 0, 0, 0, arrival.Location())
+	return int(arrival.Sub(day).Hours() / 24)
+}
+
+// RefundPercent returns the percentage of the paid amount a policy gives back
+func RefundPercent(policy models.CancellationPolicy, arrival, cancelledAt time.Time) int {
+	days := DaysBeforeArrival(arrival, cancelledAt)
+
+	switch {
+	case days < 0:
+		return 0
+	case days >= policy.FullRefundDays:
+		return 100
+	case policy.PartialRefundPercent > 0 && days >= policy.PartialRefundDays:
+		return policy.PartialRefundPercent
+	}
+
+	return 0
+}
+
+// RefundAmount returns the amount owed to the guest when cancelling at cancelledAt
+func RefundAmount(policy models.CancellationPolicy, paid int, arrival, cancelledAt time.Time) int {
+	return paid * RefundPercent(policy, arrival, cancelledAt) / 100
+}
+
+// RefundablePayments returns the captured payments with what can still be refunded of each as their amount,
+// in the order they were made. Refunds made or still being retried against a provider reference are taken off
+// its captures first, and payments with nothing left are dropped
+func RefundablePayments(payments []models.Payment) []models.Payment {
+	refunded := make(map[string]int)
+	for _, p := range payments {
+		if p.Status == payment.StatusRefunded || p.Status == payment.StatusRefundFailed {
+			refunded[p.ProviderRef] += p.Amount
+		}
+	}
+
+	var refundable []models.Payment
+	for _, p := range payments {
+		if p.Status != payment.StatusCaptured {
+			continue
+		}
+
+		taken := refunded[p.ProviderRef]
+		if taken > p.Amount {
+			taken = p.Amount
+		}
+		refunded[p.ProviderRef] -= taken
+
+		if p.Amount-taken > 0 {
+			p.Amount -= taken
+			refundable = append(refundable, p)
+		}
+	}
+
+	return refundable
+}
+
+// PaidAmount returns the amount captured for a reservation minus what was refunded
+func PaidAmount(payments []models.Payment) int {
+	paid := 0
+	for _, p := range payments {
+		switch p.Status {
+		case payment.StatusCaptured:
+			paid += p.Amount
+		case payment.StatusRefunded:
+			paid -= p.Amount
+		}
+	}
+	return paid
+}
@@ -0,0 +1,84 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/models"
+)
+
+var moderate = models.CancellationPolicy{
+	Name:                 "moderate",
+	FullRefundDays:       5,
+	PartialRefundDays:    1,
+	PartialRefundPercent: 50,
+}
+
+var flexible = models.CancellationPolicy{
+	Name:           "flexible",
+	FullRefundDays: 1,
+}
+
+var refundTests = []struct {
+	name        string
+	policy      models.CancellationPolicy
+	cancelledAt string
+	expected    int
+}{
+	{"moderate-early", moderate, "2050-01-01 10:00", 10000},
+	{"moderate-five-days", moderate, "2050-01-05 23:00", 10000},
+	{"moderate-four-days", moderate, "2050-01-06 08:00", 5000},
+	{"moderate-one-day", moderate, "2050-01-09 08:00", 5000},
+	{"moderate-same-day", moderate, "2050-01-10 08:00", 0},
+	{"flexible-one-day", flexible, "2050-01-09 08:00", 10000},
+	{"flexible-same-day", flexible, "2050-01-10 08:00", 0},
+	{"no-policy-same-day", models.CancellationPolicy{}, "2050-01-10 08:00", 10000},
+	{"no-policy-after-arrival", models.CancellationPolicy{}, "2050-01-11 08:00", 0},
+}
+
+func TestRefundAmount(t *testing.T) {
+	arrival, _ := time.Parse("2006-01-02", "2050-01-10")
+
+	for _, e := range refundTests {
+		cancelledAt, _ := time.Parse("2006-01-02 15:04", e.cancelledAt)
+		refund := RefundAmount(e.policy, 10000, arrival, cancelledAt)
+		if refund != e.expected {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expected, refund)
+		}
+	}
+}
+
+func TestRefundablePayments(t *testing.T) {
+	payments := []models.Payment{
+		{ProviderRef: "a", Amount: 10000, Status: "captured"},
+		{ProviderRef: "b", Amount: 5000, Status: "declined"},
+		{ProviderRef: "c", Amount: 8000, Status: "captured"},
+		{ProviderRef: "a", Amount: 4000, Status: "refunded"},
+		{ProviderRef: "c", Amount: 8000, Status: "refunded"},
+		{ProviderRef: "d", Amount: 3000, Status: "captured"},
+		{ProviderRef: "d", Amount: 1000, Status: "refund_failed"},
+	}
+
+	refundable := RefundablePayments(payments)
+	if len(refundable) != 2 {
+		t.Fatalf("expected 2 refundable payments but got %+v", refundable)
+	}
+	if refundable[0].ProviderRef != "a" || refundable[0].Amount != 6000 {
+		t.Errorf("expected 6000 left on a but got %+v", refundable[0])
+	}
+	if refundable[1].ProviderRef != "d" || refundable[1].Amount != 2000 {
+		t.Errorf("expected 2000 left on d but got %+v", refundable[1])
+	}
+}
+
+func TestPaidAmount(t *testing.T) {
+	payments := []models.Payment{
+		{Amount: 5000, Status: "declined"},
+		{Amount: 22000, Status: "captured"},
+		{Amount: 2000, Status: "refunded"},
+	}
+
+	if paid := PaidAmount(payments); paid != 20000 {
+		t.Errorf("expected 20000 but got %d", paid)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/justinas/nosurf"
 	"github.com/yj-matmul/bookings/internal/config"
 	"github.com/yj-matmul/bookings/internal/models"
+	"github.com/yj-matmul/bookings/internal/pricing"
 )
 
 // implement custom function when go lang have no built-in function I want to
@@ -21,6 +22,9 @@ var functions = template.FuncMap{
 	"formatDate": FormatDate,
 	"iterate":    Iterate,
 	"add":        Add,
+	"money":      Money,
+	"hundredths": pricing.FormatHundredths,
+	"roomAmount": RoomAmount,
 }
 var pathToTemplates = "./templates"
 
@@ -31,6 +35,21 @@ func Add(a, b int) int {
 	return a + b
 }
 
+// Money returns an amount in cents as a dollar string
+func Money(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign = "-"
+		cents = -cents
+	}
+	return fmt.Sprintf("%s$%d.%02d", sign, cents/100, cents%100)
+}
+
+// RoomAmount returns the part of a reservation's total which is for the room itself
+func RoomAmount(res models.Reservation) int {
+	return res.TotalAmount - pricing.ChargesTotal(res.Charges)
+}
+
 // NewRenderer sets the config for the template package
 func NewRenderer(a *config.AppConfig) {
 	app = a
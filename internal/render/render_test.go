@@ -73,3 +73,24 @@ func TestCreateTemplateCache(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestMoney(t *testing.T) {
+	if m := Money(15050); m != "$150.50" {
+		t.Errorf("expected $150.50 but got %s", m)
+	}
+
+	if m := Money(-705); m != "-$7.05" {
+		t.Errorf("expected -$7.05 but got %s", m)
+	}
+}
+
+func TestRoomAmount(t *testing.T) {
+	res := models.Reservation{
+		TotalAmount: 25000,
+		Charges:     []models.ReservationCharge{{Amount: 3000}, {Amount: 2000}},
+	}
+
+	if a := RoomAmount(res); a != 20000 {
+		t.Errorf("expected 20000 but got %d", a)
+	}
+}
@@ -0,0 +1,112 @@
+package reports
+
+import (
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/models"
+)
+
+// lengths of a reporting period
+const (
+	PeriodMonth = "month"
+	PeriodWeek  = "week"
+)
+
+// Figures are the key numbers of a room, or of all rooms, over a period
+type Figures struct {
+	// Occupancy is the percentage of the available room nights which were sold
+	Occupancy float64
+	// ADR, the average daily rate, is the room revenue per sold night in cents
+	ADR int
+	// RevPAR is the room revenue per available room night in cents
+	RevPAR int
+	// Bookings is the number of reservations made during the period
+	Bookings int
+	// LeadTime is the average number of days between booking and arrival
+	LeadTime float64
+	// LengthOfStay is the average number of nights booked
+	LengthOfStay float64
+	// CancellationRate is the percentage of the bookings made which have been cancelled
+	CancellationRate float64
+}
+
+// Compute works out the figures from the totals of a period
+func Compute(s models.RoomStats) Figures {
+	f := Figures{Bookings: s.Bookings}
+
+	if s.AvailableNights > 0 {
+		f.Occupancy = percent(s.OccupiedNights, s.AvailableNights)
+		f.RevPAR = s.Revenue / s.AvailableNights
+	}
+	if s.OccupiedNights > 0 {
+		f.ADR = s.Revenue / s.OccupiedNights
+	}
+
+	if stays := s.Bookings - s.Cancellations; stays > 0 {
+		f.LeadTime = float64(s.LeadDays) / float64(stays)
+		f.LengthOfStay = float64(s.BookedNights) / float64(stays)
+	}
+	if s.Bookings > 0 {
+		f.CancellationRate = percent(s.Cancellations, s.Bookings)
+	}
+
+	return f
+}
+
+// Sum adds up the totals of several rooms
+func Sum(stats []models.RoomStats) models.RoomStats {
+	var total models.RoomStats
+	for _, s := range stats {
+		total.AvailableNights += s.AvailableNights
+		total.OccupiedNights += s.OccupiedNights
+		total.Revenue += s.Revenue
+		total.Bookings += s.Bookings
+		total.Cancellations += s.Cancellations
+		total.LeadDays += s.LeadDays
+		total.BookedNights += s.BookedNights
+		total.OutOfOrderNights += s.OutOfOrderNights
+	}
+	return total
+}
+
+// Sellable takes the nights a room was out of order off its available nights
+func Sellable(s models.RoomStats) models.RoomStats {
+	s.AvailableNights -= s.OutOfOrderNights
+	if s.AvailableNights < 0 {
+		s.AvailableNights = 0
+	}
+	s.OutOfOrderNights = 0
+	return s
+}
+
+// Period returns the month or week, starting on Monday, which contains a day; end is the first day after it
+func Period(kind string, day time.Time) (start, end time.Time) {
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+
+	if kind == PeriodWeek {
+		offset := (int(day.Weekday()) + 6) % 7
+		start = day.AddDate(0, 0, -offset)
+		return start, start.AddDate(0, 0, 7)
+	}
+
+	start = time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// Previous returns the period before the one starting on start
+func Previous(kind string, start time.Time) (time.Time, time.Time) {
+	return Period(kind, start.AddDate(0, 0, -1))
+}
+
+// Change returns by how much a figure went up compared with the previous period, in percent;
+// ok is false when there is nothing to compare with
+func Change(current, previous float64) (change float64, ok bool) {
+	if previous == 0 {
+		return 0, false
+	}
+	return (current - previous) / previous * 100, true
+}
+
+func percent(part, whole int) float64 {
+	return float64(part) / float64(whole) * 100
+}
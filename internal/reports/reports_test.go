@@ -0,0 +1,118 @@
+package reports
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/yj-matmul/bookings/internal/models"
+)
+
+func TestCompute(t *testing.T) {
+	s := models.RoomStats{
+		AvailableNights: 30,
+		OccupiedNights:  12,
+		Revenue:         180000,
+		Bookings:        5,
+		Cancellations:   1,
+		LeadDays:        40,
+		BookedNights:    14,
+	}
+
+	f := Compute(s)
+
+	if f.Occupancy != 40 {
+		t.Errorf("expected occupancy 40 but got %v", f.Occupancy)
+	}
+	if f.ADR != 15000 {
+		t.Errorf("expected ADR 15000 but got %d", f.ADR)
+	}
+	if f.RevPAR != 6000 {
+		t.Errorf("expected RevPAR 6000 but got %d", f.RevPAR)
+	}
+	if f.Bookings != 5 {
+		t.Errorf("expected 5 bookings but got %d", f.Bookings)
+	}
+	if f.LeadTime != 10 {
+		t.Errorf("expected lead time 10 but got %v", f.LeadTime)
+	}
+	if f.LengthOfStay != 3.5 {
+		t.Errorf("expected length of stay 3.5 but got %v", f.LengthOfStay)
+	}
+	if f.CancellationRate != 20 {
+		t.Errorf("expected cancellation rate 20 but got %v", f.CancellationRate)
+	}
+
+	// nothing sold and nothing booked must not divide by zero
+	if f := Compute(models.RoomStats{}); f != (Figures{}) {
+		t.Errorf("expected empty figures but got %+v", f)
+	}
+}
+
+func TestSum(t *testing.T) {
+	total := Sum([]models.RoomStats{
+		{RoomID: 1, AvailableNights: 30, OccupiedNights: 10, Revenue: 100, Bookings: 2, Cancellations: 1, LeadDays: 3, BookedNights: 4, OutOfOrderNights: 2},
+		{RoomID: 2, AvailableNights: 30, OccupiedNights: 5, Revenue: 50, Bookings: 1, LeadDays: 7, BookedNights: 2},
+	})
+
+	expected := models.RoomStats{AvailableNights: 60, OccupiedNights: 15, Revenue: 150, Bookings: 3, Cancellations: 1, LeadDays: 10, BookedNights: 6, OutOfOrderNights: 2}
+	if total != expected {
+		t.Errorf("expected %+v but got %+v", expected, total)
+	}
+}
+
+func TestSellable(t *testing.T) {
+	s := Sellable(models.RoomStats{AvailableNights: 30, OccupiedNights: 12, OutOfOrderNights: 10})
+	if s.AvailableNights != 20 || s.OccupiedNights != 12 || s.OutOfOrderNights != 0 {
+		t.Errorf("expected 20 sellable nights but got %+v", s)
+	}
+
+	if s := Sellable(models.RoomStats{AvailableNights: 7, OutOfOrderNights: 9}); s.AvailableNights != 0 {
+		t.Errorf("expected no sellable nights but got %d", s.AvailableNights)
+	}
+}
+
+func TestPeriod(t *testing.T) {
+	layout := "2006-01-02"
+	day, _ := time.Parse(layout, "2021-09-15") // a Wednesday
+
+	tests := []struct {
+		kind          string
+		start, end    string
+		previousStart string
+	}{
+		{PeriodMonth, "2021-09-01", "2021-10-01", "2021-08-01"},
+		{PeriodWeek, "2021-09-13", "2021-09-20", "2021-09-06"},
+		{"", "2021-09-01", "2021-10-01", "2021-08-01"},
+	}
+
+	for _, e := range tests {
+		start, end := Period(e.kind, day)
+		if start.Format(layout) != e.start || end.Format(layout) != e.end {
+			t.Errorf("%s: expected %s to %s but got %s to %s", e.kind, e.start, e.end, start.Format(layout), end.Format(layout))
+		}
+
+		prev, prevEnd := Previous(e.kind, start)
+		if prev.Format(layout) != e.previousStart || !prevEnd.Equal(start) {
+			t.Errorf("%s: expected previous period from %s but got %s to %s", e.kind, e.previousStart, prev.Format(layout), prevEnd.Format(layout))
+		}
+	}
+
+	// a Sunday belongs to the week which started on the Monday before
+	sunday, _ := time.Parse(layout, "2021-09-19")
+	if start, _ := Period(PeriodWeek, sunday); start.Format(layout) != "2021-09-13" {
+		t.Errorf("expected the week of a Sunday to start 2021-09-13 but got %s", start.Format(layout))
+	}
+}
+
+func TestChange(t *testing.T) {
+	if c, ok := Change(15, 10); !ok || math.Abs(c-50) > 1e-9 {
+		t.Errorf("expected +50%% but got %v", c)
+	}
+	if c, ok := Change(5, 10); !ok || math.Abs(c+50) > 1e-9 {
+		t.Errorf("expected -50%% but got %v", c)
+	}
+	if _, ok := Change(5, 0); ok {
+		t.Error("expected no change without a previous figure")
+	}
+}
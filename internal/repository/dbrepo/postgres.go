@@ -2,10 +2,14 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/yj-matmul/bookings/internal/models"
+	"github.com/yj-matmul/bookings/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -18,14 +22,68 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	newID, err := insertReservation(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// upsertGuest returns the guest with the email of a reservation, creating the guest if it is new;
+// the name and phone are updated to the ones given most recently
+func upsertGuest(ctx context.Context, tx *sql.Tx, res models.Reservation) (int, error) {
+	var guestID int
+
+	stmt := `insert into guests (first_name, last_name, email, phone, created_at, updated_at)
+			values ($1, $2, lower($3), $4, $5, $5)
+			on conflict (email) do update set
+				first_name = excluded.first_name,
+				last_name = excluded.last_name,
+				phone = case when excluded.phone <> '' then excluded.phone else guests.phone end,
+				updated_at = excluded.updated_at
+			returning id`
+
+	err := tx.QueryRowContext(ctx, stmt, res.FirstName, res.LastName, res.Email, res.Phone, time.Now()).Scan(&guestID)
+	if err != nil {
+		return 0, err
+	}
+
+	return guestID, nil
+}
+
+// insertReservation inserts a reservation with its charges as part of tx
+func insertReservation(ctx context.Context, tx *sql.Tx, res models.Reservation) (int, error) {
 	var newID int
 
+	var bookingID sql.NullInt64
+	if res.BookingID > 0 {
+		bookingID = sql.NullInt64{Int64: int64(res.BookingID), Valid: true}
+	}
+
+	guestID, err := upsertGuest(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
 	stmt := `insert into reservations 
-			 (first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at)
+			 (first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at,
+			  status, total_amount, access_code, guests, booking_id, guest_id)
 			 values
-			 ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+			 ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15) returning id`
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -35,42 +93,65 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.RoomID,
 		time.Now(),
 		time.Now(),
+		res.Status,
+		res.TotalAmount,
+		res.AccessCode,
+		res.Guests,
+		bookingID,
+		guestID,
 	).Scan(&newID)
 
 	if err != nil {
 		return 0, err
 	}
 
+	err = insertCharges(ctx, tx, newID, res.Charges)
+	if err != nil {
+		return 0, err
+	}
+
 	return newID, nil
 }
 
-// InsertRoomRestirction inserts a room restriction into the database
-func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	stmt := `insert into room_restrictions 
-			 (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
+// insertCharges inserts the taxes and fees of a reservation as part of tx
+func insertCharges(ctx context.Context, tx *sql.Tx, reservationID int, charges []models.ReservationCharge) error {
+	stmt := `insert into reservation_charges
+			 (reservation_id, tax_fee_rule_id, kind, description, quantity, unit_amount, amount, created_at, updated_at)
 			 values
-			 ($1, $2, $3, $4, $5, $6, $7)`
+			 ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
 
-	_, err := m.DB.ExecContext(ctx, stmt,
-		r.StartDate,
-		r.EndDate,
-		r.RoomID,
-		r.ReservationID,
-		time.Now(),
-		time.Now(),
-		r.RestrictionID,
-	)
+	for _, c := range charges {
+		var ruleID sql.NullInt64
+		if c.TaxFeeRuleID > 0 {
+			ruleID = sql.NullInt64{Int64: int64(c.TaxFeeRuleID), Valid: true}
+		}
 
-	if err != nil {
-		return err
+		_, err := tx.ExecContext(ctx, stmt,
+			reservationID,
+			ruleID,
+			c.Kind,
+			c.Description,
+			c.Quantity,
+			c.UnitAmount,
+			c.Amount,
+			time.Now(),
+			time.Now(),
+		)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// InsertRoomRestriction inserts a room restriction if nothing else is in the room during its dates; a room taken
+// in the meantime, for example after the guest's hold expired, fails with ErrRoomNotAvailable
+func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
+	_, err := m.insertFreeRestriction(r, r.RestrictionID)
+	return err
+}
+
 // SearchAvailabilityByDatesByRoomID returns true if availability exists for roomID, and false if no availability
 func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -86,9 +167,11 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time,
 				where
 					room_id = $1
 					and
-					$2 < end_date and $3 > start_date`
+					$2 < end_date and $3 > start_date
+					and
+					(expires_at is null or expires_at > $4) and deleted_at is null`
 
-	row := m.DB.QueryRowContext(ctx, query, roomID, start, end)
+	row := m.DB.QueryRowContext(ctx, query, roomID, start, end, time.Now())
 	err := row.Scan(&numRows)
 	if err != nil {
 		return false, err
@@ -109,7 +192,7 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 	var rooms []models.Room
 
 	query := `
-		select r.id, r.room_name
+		select r.id, r.room_name, r.max_guests
 		from rooms r 
 		where r.id not in (
 				select
@@ -117,9 +200,11 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 				from
 					room_restrictions rr
 				where
-					$1 < rr.end_date and $2 > rr.start_date)`
+					$1 < rr.end_date and $2 > rr.start_date
+					and
+					(rr.expires_at is null or rr.expires_at > $3) and rr.deleted_at is null)`
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	rows, err := m.DB.QueryContext(ctx, query, start, end, time.Now())
 	if err != nil {
 		return rooms, err
 	}
@@ -129,6 +214,7 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 		err := rows.Scan(
 			&room.ID,
 			&room.RoomName,
+			&room.MaxGuests,
 		)
 		if err != nil {
 			return rooms, err
@@ -151,7 +237,8 @@ func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 	var room models.Room
 
 	query := `
-		select id, room_name, created_at, updated_at
+		select id, room_name, price, payment_policy, deposit_percent, coalesce(cancellation_policy_id, 0), max_guests,
+			created_at, updated_at
 		from rooms
 		where id = $1`
 
@@ -159,6 +246,11 @@ func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 	err := row.Scan(
 		&room.ID,
 		&room.RoomName,
+		&room.Price,
+		&room.PaymentPolicy,
+		&room.DepositPercent,
+		&room.CancellationPolicyID,
+		&room.MaxGuests,
 		&room.CreatedAt,
 		&room.UpdatedAt,
 	)
@@ -175,7 +267,7 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at
+	query := `select id, first_name, last_name, email, password, access_level, version, created_at, updated_at
 		from users
 		where id = $1`
 
@@ -188,6 +280,7 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 		&u.Email,
 		&u.Password,
 		&u.AccessLevel,
+		&u.Version,
 		&u.CreatedAt,
 		&u.UpdatedAt,
 	)
@@ -199,25 +292,29 @@ func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
 	return u, nil
 }
 
-// UpdateUser updates a user in the database
+// UpdateUser updates a user in the database, returning ErrVersionConflict when it was changed since u was read
 func (m *postgresDBRepo) UpdateUser(u models.User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `update users set first_name = $1, last_name = $2, email= $3, access_level = $4, updated_at = $5`
+	query := `update users set first_name = $1, last_name = $2, email= $3, access_level = $4, updated_at = $5,
+			version = version + 1
+			where id = $6 and version = $7`
 
-	_, err := m.DB.ExecContext(ctx, query,
+	result, err := m.DB.ExecContext(ctx, query,
 		u.FirstName,
 		u.LastName,
 		u.Email,
 		u.AccessLevel,
-		time.Now())
+		time.Now(),
+		u.ID,
+		u.Version)
 
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return versionChecked(result)
 }
 
 // Authenticate authenticates a user
@@ -255,9 +352,11 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 
 	query := `
 		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
-			rm.id, rm.room_name
+			rm.id, rm.room_name,
+			(select coalesce(string_agg(t.tag, ',' order by t.tag), '') from reservation_tags t where t.reservation_id = r.id)
 		from reservations r
 		left join rooms rm on (r.room_id = rm.id)
+		where r.deleted_at is null
 		order by r.start_date asc`
 
 	var reservations []models.Reservation
@@ -270,6 +369,7 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 
 	for rows.Next() {
 		var r models.Reservation
+		var tags string
 		err := rows.Scan(
 			&r.ID,
 			&r.FirstName,
@@ -284,11 +384,13 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 			&r.Processed,
 			&r.Room.ID,
 			&r.Room.RoomName,
+			&tags,
 		)
 
 		if err != nil {
 			return reservations, err
 		}
+		r.Tags = splitTags(tags)
 
 		reservations = append(reservations, r)
 	}
@@ -301,6 +403,361 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 	return reservations, err
 }
 
+// reservationSortColumns maps the sortable columns of the admin lists to sql
+var reservationSortColumns = map[string]string{
+	"id":         "r.id",
+	"last_name":  "r.last_name",
+	"room":       "rm.room_name",
+	"start_date": "r.start_date",
+	"end_date":   "r.end_date",
+	"status":     "r.status",
+	"created_at": "r.created_at",
+}
+
+// likeEscaper escapes the wildcards of ilike so a search matches them literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// reservationConditions turns a filter into a where clause and its arguments
+func reservationConditions(f models.ReservationFilter) (string, []interface{}) {
+	where := []string{"r.deleted_at is null"}
+	var args []interface{}
+
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.Search != "" {
+		p := arg("%" + likeEscaper.Replace(f.Search) + "%")
+		where = append(where, fmt.Sprintf(
+			`(r.first_name || ' ' || r.last_name ilike %[1]s escape '\' or r.email ilike %[1]s escape '\' or r.phone ilike %[1]s escape '\')`, p))
+	}
+	if f.RoomID > 0 {
+		where = append(where, "r.room_id = "+arg(f.RoomID))
+	}
+	if f.Status != "" {
+		where = append(where, "r.status = "+arg(f.Status))
+	}
+	if f.Tag != "" {
+		where = append(where, "exists (select 1 from reservation_tags t where t.reservation_id = r.id and t.tag = "+arg(f.Tag)+")")
+	}
+	if !f.From.IsZero() {
+		where = append(where, "r.end_date > "+arg(f.From))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "r.start_date < "+arg(f.To))
+	}
+	if !f.CreatedFrom.IsZero() {
+		where = append(where, "r.created_at >= "+arg(f.CreatedFrom))
+	}
+	if !f.CreatedTo.IsZero() {
+		where = append(where, "r.created_at < "+arg(f.CreatedTo.AddDate(0, 0, 1)))
+	}
+	if f.NewOnly {
+		where = append(where, "r.processed = 0")
+	}
+	if len(f.IDs) > 0 {
+		where = append(where, "r.id = any("+arg(f.IDs)+")")
+	}
+
+	return "where " + strings.Join(where, " and "), args
+}
+
+// SearchReservations returns one page of the reservations matching a filter and the number of all matches
+func (m *postgresDBRepo) SearchReservations(f models.ReservationFilter) ([]models.Reservation, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	conditions, args := reservationConditions(f)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	var total int
+	query := `select count(r.id) from reservations r left join rooms rm on (r.room_id = rm.id) ` + conditions
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&total)
+	if err != nil {
+		return reservations, 0, err
+	}
+
+	order, ok := reservationSortColumns[f.Sort]
+	if !ok {
+		order = "r.start_date"
+	}
+	if f.Desc {
+		order += " desc"
+	}
+
+	perPage := f.PerPage
+	if perPage < 1 {
+		perPage = 25
+	}
+	page := f.Page
+	if page < 1 {
+		page = 1
+	}
+
+	query = `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
+			r.processed, r.status, rm.id, rm.room_name,
+			(select coalesce(string_agg(t.tag, ',' order by t.tag), '') from reservation_tags t where t.reservation_id = r.id)
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		` + conditions + `
+		order by ` + order + `, r.id
+		limit ` + arg(perPage) + ` offset ` + arg((page-1)*perPage)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return reservations, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Reservation
+		var tags string
+		err := rows.Scan(
+			&r.ID,
+			&r.FirstName,
+			&r.LastName,
+			&r.Email,
+			&r.Phone,
+			&r.StartDate,
+			&r.EndDate,
+			&r.RoomID,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+			&r.Processed,
+			&r.Status,
+			&r.Room.ID,
+			&r.Room.RoomName,
+			&tags,
+		)
+		if err != nil {
+			return reservations, 0, err
+		}
+		r.Tags = splitTags(tags)
+
+		reservations = append(reservations, r)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return reservations, 0, err
+	}
+
+	return reservations, total, nil
+}
+
+// RoomStats returns the totals of every room from start up to end: nights sold and their room revenue without
+// taxes and fees, prorated for stays reaching outside the period, and the reservations made during the period
+func (m *postgresDBRepo) RoomStats(start, end time.Time) ([]models.RoomStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var stats []models.RoomStats
+
+	query := `
+		select rm.id, rm.room_name, $2::date - $1::date,
+			coalesce(s.nights, 0), coalesce(s.revenue, 0),
+			coalesce(b.bookings, 0), coalesce(b.cancelled, 0), coalesce(b.lead_days, 0), coalesce(b.booked_nights, 0),
+			coalesce(o.nights, 0)
+		from rooms rm
+		left join (
+			select r.room_id,
+				sum(least(r.end_date, $2::date) - greatest(r.start_date, $1::date)) as nights,
+				round(sum(
+					(r.total_amount - coalesce((select sum(c.amount) from reservation_charges c where c.reservation_id = r.id), 0))::numeric
+					* (least(r.end_date, $2::date) - greatest(r.start_date, $1::date)) / (r.end_date - r.start_date)
+				))::bigint as revenue
+			from reservations r
+			where r.status <> $3 and r.start_date < $2::date and r.end_date > $1::date and r.end_date > r.start_date
+				and r.deleted_at is null
+			group by r.room_id
+		) s on (s.room_id = rm.id)
+		left join (
+			select r.room_id,
+				count(r.id) as bookings,
+				count(r.id) filter (where r.status = $3) as cancelled,
+				sum(greatest(r.start_date - r.created_at::date, 0)) filter (where r.status <> $3) as lead_days,
+				sum(r.end_date - r.start_date) filter (where r.status <> $3) as booked_nights
+			from reservations r
+			where r.created_at >= $1 and r.created_at < $2 and r.deleted_at is null
+			group by r.room_id
+		) b on (b.room_id = rm.id)
+		left join (
+			select rr.room_id,
+				sum(least(rr.end_date, $2::date) - greatest(rr.start_date, $1::date)) as nights
+			from room_restrictions rr
+			where rr.restriction_id = $4 and rr.start_date < $2::date and rr.end_date > $1::date and rr.deleted_at is null
+			group by rr.room_id
+		) o on (o.room_id = rm.id)
+		order by rm.room_name`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end, models.ReservationStatusCancelled, models.RestrictionOutOfOrder)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.RoomStats
+		err = rows.Scan(
+			&s.RoomID,
+			&s.RoomName,
+			&s.AvailableNights,
+			&s.OccupiedNights,
+			&s.Revenue,
+			&s.Bookings,
+			&s.Cancellations,
+			&s.LeadDays,
+			&s.BookedNights,
+			&s.OutOfOrderNights,
+		)
+		if err != nil {
+			return stats, err
+		}
+		stats = append(stats, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+// exportTimeout bounds how long an export may keep its database cursor open
+const exportTimeout = 5 * time.Minute
+
+// EachReservation calls fn for every reservation matching a filter, in the filter's order, reading them one at a time
+func (m *postgresDBRepo) EachReservation(f models.ReservationFilter, fn func(models.Reservation) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	conditions, args := reservationConditions(f)
+
+	order, ok := reservationSortColumns[f.Sort]
+	if !ok {
+		order = "r.start_date"
+	}
+	if f.Desc {
+		order += " desc"
+	}
+
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
+			r.processed, r.status, r.total_amount, r.guests, rm.id, rm.room_name,
+			(select coalesce(string_agg(t.tag, ',' order by t.tag), '') from reservation_tags t where t.reservation_id = r.id),
+			r.version
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		` + conditions + `
+		order by ` + order + `, r.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Reservation
+		var tags string
+		err := rows.Scan(
+			&r.ID,
+			&r.FirstName,
+			&r.LastName,
+			&r.Email,
+			&r.Phone,
+			&r.StartDate,
+			&r.EndDate,
+			&r.RoomID,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+			&r.Processed,
+			&r.Status,
+			&r.TotalAmount,
+			&r.Guests,
+			&r.Room.ID,
+			&r.Room.RoomName,
+			&tags,
+			&r.Version,
+		)
+		if err != nil {
+			return err
+		}
+		r.Tags = splitTags(tags)
+
+		err = fn(r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// EachMovement calls fn for every arrival and departure between two dates inclusive, by day, skipping cancelled
+// reservations; kind is "arrival" or "departure"
+func (m *postgresDBRepo) EachMovement(start, end time.Time, fn func(kind string, r models.Reservation) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	query := `
+		select m.kind, r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.status, r.guests,
+			rm.id, rm.room_name
+		from (
+			select 'arrival' as kind, id, start_date as day from reservations where start_date between $2 and $3
+			union all
+			select 'departure', id, end_date from reservations where end_date between $2 and $3
+		) m
+		join reservations r on (r.id = m.id)
+		left join rooms rm on (r.room_id = rm.id)
+		where r.status <> $1 and r.deleted_at is null
+		order by m.day, m.kind desc, rm.room_name, r.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.ReservationStatusCancelled, start, end)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind string
+		var r models.Reservation
+		err := rows.Scan(
+			&kind,
+			&r.ID,
+			&r.FirstName,
+			&r.LastName,
+			&r.Email,
+			&r.Phone,
+			&r.StartDate,
+			&r.EndDate,
+			&r.RoomID,
+			&r.Status,
+			&r.Guests,
+			&r.Room.ID,
+			&r.Room.RoomName,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = fn(kind, r)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
 // AllNewReservations returns a slice of all new reservations
 func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -308,10 +765,11 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 
 	query := `
 		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at,
-			rm.id, rm.room_name
+			rm.id, rm.room_name,
+			(select coalesce(string_agg(t.tag, ',' order by t.tag), '') from reservation_tags t where t.reservation_id = r.id)
 		from reservations r
 		left join rooms rm on (r.room_id = rm.id)
-		where r.processed = 0
+		where r.processed = 0 and r.deleted_at is null
 		order by r.start_date asc`
 
 	var reservations []models.Reservation
@@ -324,6 +782,7 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 
 	for rows.Next() {
 		var r models.Reservation
+		var tags string
 		err := rows.Scan(
 			&r.ID,
 			&r.FirstName,
@@ -337,11 +796,13 @@ func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
 			&r.UpdatedAt,
 			&r.Room.ID,
 			&r.Room.RoomName,
+			&tags,
 		)
 
 		if err != nil {
 			return reservations, err
 		}
+		r.Tags = splitTags(tags)
 
 		reservations = append(reservations, r)
 	}
@@ -361,12 +822,17 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 
 	query := `
 		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
-			rm.id, rm.room_name
+			r.status, r.total_amount, r.access_code, r.cancelled_at, r.refund_amount, r.guests,
+			coalesce(r.booking_id, 0), coalesce(r.guest_id, 0), rm.id, rm.room_name, coalesce(rm.cancellation_policy_id, 0),
+			(select coalesce(string_agg(t.tag, ',' order by t.tag), '') from reservation_tags t where t.reservation_id = r.id),
+			r.checked_in_at, r.checked_out_at, r.version, r.deleted_at
 		from reservations r
 		left join rooms rm on (r.room_id = rm.id)
 		where r.id = $1`
 
 	var res models.Reservation
+	var cancelledAt, checkedInAt, checkedOutAt, deletedAt sql.NullTime
+	var tags string
 
 	row := m.DB.QueryRowContext(ctx, query, id)
 	err := row.Scan(
@@ -381,37 +847,193 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 		&res.CreatedAt,
 		&res.UpdatedAt,
 		&res.Processed,
+		&res.Status,
+		&res.TotalAmount,
+		&res.AccessCode,
+		&cancelledAt,
+		&res.RefundAmount,
+		&res.Guests,
+		&res.BookingID,
+		&res.GuestID,
 		&res.Room.ID,
 		&res.Room.RoomName,
+		&res.Room.CancellationPolicyID,
+		&tags,
+		&checkedInAt,
+		&checkedOutAt,
+		&res.Version,
+		&deletedAt,
 	)
 
 	if err != nil {
 		return res, err
 	}
+	res.CancelledAt = cancelledAt.Time
+	res.CheckedInAt = checkedInAt.Time
+	res.CheckedOutAt = checkedOutAt.Time
+	res.DeletedAt = deletedAt.Time
+	res.Tags = splitTags(tags)
 
 	err = row.Err()
 	if err != nil {
 		return res, err
 	}
 
+	query = `
+		select id, reservation_id, coalesce(tax_fee_rule_id, 0), kind, description, quantity, unit_amount, amount,
+			created_at, updated_at
+		from reservation_charges
+		where reservation_id = $1
+		order by id`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return res, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.ReservationCharge
+		err = rows.Scan(
+			&c.ID,
+			&c.ReservationID,
+			&c.TaxFeeRuleID,
+			&c.Kind,
+			&c.Description,
+			&c.Quantity,
+			&c.UnitAmount,
+			&c.Amount,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+		)
+		if err != nil {
+			return res, err
+		}
+		res.Charges = append(res.Charges, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return res, err
+	}
+
 	return res, nil
 }
 
-// UpdateUser updates a reservation in the database
+// UpdateReservation updates a reservation in the database, returning ErrVersionConflict when it was changed since r was read
 func (m *postgresDBRepo) UpdateReservation(r models.Reservation) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `update reservations set first_name = $1, last_name = $2, email= $3, phone = $4, updated_at = $5 where id = $6`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := m.DB.ExecContext(ctx, query,
+	// a changed email moves the reservation to that guest
+	guestID, err := upsertGuest(ctx, tx, r)
+	if err != nil {
+		return err
+	}
+
+	query := `update reservations set first_name = $1, last_name = $2, email= $3, phone = $4, updated_at = $5, guest_id = $6,
+			version = version + 1
+			where id = $7 and version = $8`
+
+	result, err := tx.ExecContext(ctx, query,
 		r.FirstName,
 		r.LastName,
 		r.Email,
 		r.Phone,
 		time.Now(),
-		r.ID)
+		guestID,
+		r.ID,
+		r.Version)
+
+	if err != nil {
+		return err
+	}
+
+	err = versionChecked(result)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MoveReservation changes the dates and room of a reservation and of its room restriction and replaces
+// its total and charges with those of r, which the caller priced for the new stay; it returns ErrRoomNotAvailable when anything but the reservation itself occupies the new dates
+// and ErrVersionConflict when the reservation was changed since r was read
+func (m *postgresDBRepo) MoveReservation(r models.Reservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "lock table room_restrictions in share row exclusive mode")
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	query := `select count(id) from room_restrictions
+			where room_id = $1 and $2 < end_date and $3 > start_date and (expires_at is null or expires_at > $4) and deleted_at is null
+			and (reservation_id is null or reservation_id <> $5)`
+
+	err = tx.QueryRowContext(ctx, query, r.RoomID, r.StartDate, r.EndDate, time.Now(), r.ID).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomNotAvailable
+	}
+
+	stmt := `update reservations set start_date = $1, end_date = $2, room_id = $3, total_amount = $4, updated_at = $5,
+			version = version + 1
+			where id = $6 and version = $7`
+
+	result, err := tx.ExecContext(ctx, stmt, r.StartDate, r.EndDate, r.RoomID, r.TotalAmount, time.Now(), r.ID, r.Version)
+	if err != nil {
+		return err
+	}
+
+	err = versionChecked(result)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from reservation_charges where reservation_id = $1`, r.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertCharges(ctx, tx, r.ID, r.Charges)
+	if err != nil {
+		return err
+	}
+
+	stmt = `update room_restrictions set start_date = $1, end_date = $2, room_id = $3, updated_at = $4
+			where reservation_id = $5`
+
+	_, err = tx.ExecContext(ctx, stmt, r.StartDate, r.EndDate, r.RoomID, time.Now(), r.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DiscardReservation deletes for good a reservation which was just inserted but could not get its room
+func (m *postgresDBRepo) DiscardReservation(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
+	_, err := m.DB.ExecContext(ctx, `delete from reservations where id = $1`, id)
 	if err != nil {
 		return err
 	}
@@ -419,19 +1041,30 @@ func (m *postgresDBRepo) UpdateReservation(r models.Reservation) error {
 	return nil
 }
 
-// DeleteReservation deletes one reservation by id
+// DeleteReservation moves one reservation by id to the trash, freeing its room; PurgeTrash deletes it for good
 func (m *postgresDBRepo) DeleteReservation(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `delete from reservations where id = $1`
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	_, err = tx.ExecContext(ctx, `update reservations set deleted_at = $1 where id = $2 and deleted_at is null`, now, id)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	_, err = tx.ExecContext(ctx, `update room_restrictions set deleted_at = $1 where reservation_id = $2 and deleted_at is null`, now, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // UpdateProcessedForReservation updates processed for a reservation by id
@@ -449,12 +1082,43 @@ func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error
 	return nil
 }
 
+// CheckInReservation records that the guest of a reservation has arrived
+func (m *postgresDBRepo) CheckInReservation(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update reservations set checked_in_at = $1, updated_at = $1 where id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CheckOutReservation records that the guest of a reservation has left
+func (m *postgresDBRepo) CheckOutReservation(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update reservations set checked_out_at = $1, updated_at = $1 where id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // AllRooms returns all rooms
 func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `select id, room_name, created_at, updated_at from rooms`
+	query := `select id, room_name, price, payment_policy, deposit_percent, blocks_version, max_guests, created_at, updated_at
+		from rooms order by id`
 
 	var rooms []models.Room
 
@@ -469,6 +1133,11 @@ func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 		err = rows.Scan(
 			&r.ID,
 			&r.RoomName,
+			&r.Price,
+			&r.PaymentPolicy,
+			&r.DepositPercent,
+			&r.BlocksVersion,
+			&r.MaxGuests,
 			&r.CreatedAt,
 			&r.UpdatedAt,
 		)
@@ -496,10 +1165,10 @@ func (m *postgresDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end tim
 	query := `
 		select id, coalesce(reservation_id, 0), restriction_id, room_id, start_date, end_date
 		from room_restrictions
-		where $1 < end_date and $2 >= start_date and room_id = $3`
+		where $1 < end_date and $2 >= start_date and room_id = $3 and restriction_id <> $4 and deleted_at is null`
 
 	var restrictions []models.RoomRestriction
-	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID, models.RestrictionHold)
 	if err != nil {
 		return restrictions, err
 	}
@@ -539,25 +1208,3146 @@ func (m *postgresDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
 	query := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at)
 				values ($1, $2, $3, $4, $5, $6)`
 
-	_, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, 2, time.Now(), time.Now())
+	_, err := m.DB.ExecContext(ctx, query, startDate, startDate.AddDate(0, 0, 1), id, models.RestrictionOwnerBlock, time.Now(), time.Now())
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return m.bumpBlocksVersion(ctx, m.DB, id)
 }
 
-// DeleteBlockByID deletes a room restriction
+// DeleteBlockByID moves an owner block to the trash
 func (m *postgresDBRepo) DeleteBlockByID(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `delete from room_restrictions where id = $1`
+	var roomID int
+	query := `update room_restrictions set deleted_at = $1
+			where id = $2 and restriction_id = $3 and deleted_at is null returning room_id`
+
+	err := m.DB.QueryRowContext(ctx, query, time.Now(), id, models.RestrictionOwnerBlock).Scan(&roomID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return m.bumpBlocksVersion(ctx, m.DB, roomID)
+}
+
+// UpdateStatusForReservation updates the status of a reservation by id
+func (m *postgresDBRepo) UpdateStatusForReservation(id int, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update reservations set status = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, status, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdatePendingReservationStatus moves a reservation which is still pending payment to status and reports whether
+// it was pending; a reservation cancelled this way gives up its room
+func (m *postgresDBRepo) UpdatePendingReservationStatus(id int, status string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	query := `update reservations set status = $1, version = version + 1, updated_at = $2
+			where id = $3 and status = $4`
+
+	result, err := tx.ExecContext(ctx, query, status, time.Now(), id, models.ReservationStatusPendingPayment)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if n == 0 {
+		return false, nil
+	}
+
+	if status == models.ReservationStatusCancelled {
+		_, err = tx.ExecContext(ctx, "update reservations set cancelled_at = $1 where id = $2", time.Now(), id)
+		if err != nil {
+			return false, err
+		}
+
+		_, err = tx.ExecContext(ctx, "delete from room_restrictions where reservation_id = $1", id)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// InsertPayment inserts a payment into the database
+func (m *postgresDBRepo) InsertPayment(p models.Payment) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into payments
+			 (reservation_id, provider, provider_ref, amount, status, message, created_at, updated_at)
+			 values
+			 ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.ReservationID,
+		p.Provider,
+		p.ProviderRef,
+		p.Amount,
+		p.Status,
+		p.Message,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdatePaymentStatus updates the status of the payments of a provider reference which are still authorized;
+// captured payments and refunds made under the same reference are left alone
+func (m *postgresDBRepo) UpdatePaymentStatus(ref, status, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update payments set status = $1, message = $2, updated_at = $3 where provider_ref = $4 and status = 'authorized'`
+
+	_, err := m.DB.ExecContext(ctx, query, status, message, time.Now(), ref)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPaymentsByProviderRef returns the payments made with one gateway transaction
+func (m *postgresDBRepo) GetPaymentsByProviderRef(ref string) ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select id, reservation_id, provider, provider_ref, amount, status, message, created_at, updated_at
+		from payments
+		where provider_ref = $1
+		order by id asc`
+
+	var payments []models.Payment
+
+	rows, err := m.DB.QueryContext(ctx, query, ref)
+	if err != nil {
+		return payments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Payment
+		err = rows.Scan(
+			&p.ID,
+			&p.ReservationID,
+			&p.Provider,
+			&p.ProviderRef,
+			&p.Amount,
+			&p.Status,
+			&p.Message,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+
+		if err != nil {
+			return payments, err
+		}
+
+		payments = append(payments, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return payments, err
+	}
+
+	if len(payments) == 0 {
+		return payments, sql.ErrNoRows
+	}
+
+	return payments, nil
+}
+
+// GetPaymentsByReservationID returns all payments of a reservation
+func (m *postgresDBRepo) GetPaymentsByReservationID(reservationID int) ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select id, reservation_id, provider, provider_ref, amount, status, message, created_at, updated_at
+		from payments
+		where reservation_id = $1
+		order by created_at asc`
+
+	var payments []models.Payment
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return payments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Payment
+		err = rows.Scan(
+			&p.ID,
+			&p.ReservationID,
+			&p.Provider,
+			&p.ProviderRef,
+			&p.Amount,
+			&p.Status,
+			&p.Message,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+
+		if err != nil {
+			return payments, err
+		}
+
+		payments = append(payments, p)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return payments, err
+	}
+
+	return payments, nil
+}
+
+// FailedRefunds returns the refunds the gateway failed to make, oldest first
+func (m *postgresDBRepo) FailedRefunds() ([]models.Payment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select id, reservation_id, provider, provider_ref, amount, status, message, created_at, updated_at
+		from payments
+		where status = 'refund_failed'
+		order by created_at asc`
+
+	var payments []models.Payment
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return payments, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.Payment
+		err = rows.Scan(
+			&p.ID,
+			&p.ReservationID,
+			&p.Provider,
+			&p.ProviderRef,
+			&p.Amount,
+			&p.Status,
+			&p.Message,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+
+		if err != nil {
+			return payments, err
+		}
+
+		payments = append(payments, p)
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return payments, err
+	}
+
+	return payments, nil
+}
+
+// UpdatePaymentByID updates the status and message of one payment
+func (m *postgresDBRepo) UpdatePaymentByID(id int, status, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update payments set status = $1, message = $2, updated_at = $3 where id = $4`
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	_, err := m.DB.ExecContext(ctx, query, status, message, time.Now(), id)
 	if err != nil {
 		return err
 	}
 
 	return nil
 }
+
+// GetReservationByAccessCode returns one reservation by the access code sent to the guest
+func (m *postgresDBRepo) GetReservationByAccessCode(code string) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+
+	row := m.DB.QueryRowContext(ctx, "select id from reservations where access_code = $1 and access_code <> '' and deleted_at is null", code)
+	err := row.Scan(&id)
+	if err != nil {
+		return models.Reservation{}, err
+	}
+
+	return m.GetReservationByID(id)
+}
+
+// GetCancellationPolicyByID returns a cancellation policy by id
+func (m *postgresDBRepo) GetCancellationPolicyByID(id int) (models.CancellationPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select id, name, description, full_refund_days, partial_refund_days, partial_refund_percent, created_at, updated_at
+		from cancellation_policies
+		where id = $1`
+
+	var p models.CancellationPolicy
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&p.ID,
+		&p.Name,
+		&p.Description,
+		&p.FullRefundDays,
+		&p.PartialRefundDays,
+		&p.PartialRefundPercent,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+// CancelReservation marks a reservation as cancelled with the refund it is owed and frees its room, returning
+// ErrAlreadyCancelled when it was cancelled in the meantime so the refund is only made once
+func (m *postgresDBRepo) CancelReservation(id, refundAmount int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `update reservations set status = $1, cancelled_at = $2, refund_amount = $3, updated_at = $4
+			where id = $5 and status <> $1`
+
+	result, err := tx.ExecContext(ctx, query, models.ReservationStatusCancelled, time.Now(), refundAmount, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrAlreadyCancelled
+	}
+
+	_, err = tx.ExecContext(ctx, "delete from room_restrictions where reservation_id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertInvoice stores an invoice with its lines under the next invoice number
+func (m *postgresDBRepo) InsertInvoice(inv models.Invoice) (models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return inv, err
+	}
+	defer tx.Rollback()
+
+	// numbers have to be sequential without gaps, so only one invoice is numbered at a time
+	_, err = tx.ExecContext(ctx, "lock table invoices in share row exclusive mode")
+	if err != nil {
+		return inv, err
+	}
+
+	err = tx.QueryRowContext(ctx, "select coalesce(max(number), 0) + 1 from invoices").Scan(&inv.Number)
+	if err != nil {
+		return inv, err
+	}
+
+	var originalID sql.NullInt64
+	if inv.OriginalInvoiceID > 0 {
+		originalID = sql.NullInt64{Int64: int64(inv.OriginalInvoiceID), Valid: true}
+	}
+
+	inv.IssuedAt = time.Now()
+
+	stmt := `insert into invoices (number, kind, reservation_id, original_invoice_id, bill_to_name,
+			bill_to_email, total, issued_at, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		inv.Number,
+		inv.Kind,
+		inv.ReservationID,
+		originalID,
+		inv.BillToName,
+		inv.BillToEmail,
+		inv.Total,
+		inv.IssuedAt,
+		time.Now(),
+		time.Now(),
+	).Scan(&inv.ID)
+	if err != nil {
+		return inv, err
+	}
+
+	stmt = `insert into invoice_lines (invoice_id, kind, description, quantity, unit_amount, amount,
+			created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	for i, l := range inv.Lines {
+		l.InvoiceID = inv.ID
+		err = tx.QueryRowContext(ctx, stmt,
+			l.InvoiceID,
+			l.Kind,
+			l.Description,
+			l.Quantity,
+			l.UnitAmount,
+			l.Amount,
+			time.Now(),
+			time.Now(),
+		).Scan(&l.ID)
+		if err != nil {
+			return inv, err
+		}
+		inv.Lines[i] = l
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return inv, err
+	}
+
+	return inv, nil
+}
+
+// GetInvoiceByID returns one invoice with its lines
+func (m *postgresDBRepo) GetInvoiceByID(id int) (models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var inv models.Invoice
+
+	query := `
+		select id, number, kind, reservation_id, coalesce(original_invoice_id, 0), bill_to_name,
+		bill_to_email, total, issued_at, created_at, updated_at
+		from invoices
+		where id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&inv.ID,
+		&inv.Number,
+		&inv.Kind,
+		&inv.ReservationID,
+		&inv.OriginalInvoiceID,
+		&inv.BillToName,
+		&inv.BillToEmail,
+		&inv.Total,
+		&inv.IssuedAt,
+		&inv.CreatedAt,
+		&inv.UpdatedAt,
+	)
+	if err != nil {
+		return inv, err
+	}
+
+	query = `
+		select id, invoice_id, kind, description, quantity, unit_amount, amount, created_at, updated_at
+		from invoice_lines
+		where invoice_id = $1
+		order by id`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return inv, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l models.InvoiceLine
+		err = rows.Scan(
+			&l.ID,
+			&l.InvoiceID,
+			&l.Kind,
+			&l.Description,
+			&l.Quantity,
+			&l.UnitAmount,
+			&l.Amount,
+			&l.CreatedAt,
+			&l.UpdatedAt,
+		)
+		if err != nil {
+			return inv, err
+		}
+		inv.Lines = append(inv.Lines, l)
+	}
+
+	if err = rows.Err(); err != nil {
+		return inv, err
+	}
+
+	return inv, nil
+}
+
+// GetInvoicesByReservationID returns the invoices and credit notes of a reservation, without lines
+func (m *postgresDBRepo) GetInvoicesByReservationID(reservationID int) ([]models.Invoice, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var invoices []models.Invoice
+
+	query := `
+		select id, number, kind, reservation_id, coalesce(original_invoice_id, 0), bill_to_name,
+		bill_to_email, total, issued_at, created_at, updated_at
+		from invoices
+		where reservation_id = $1
+		order by number`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return invoices, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var inv models.Invoice
+		err = rows.Scan(
+			&inv.ID,
+			&inv.Number,
+			&inv.Kind,
+			&inv.ReservationID,
+			&inv.OriginalInvoiceID,
+			&inv.BillToName,
+			&inv.BillToEmail,
+			&inv.Total,
+			&inv.IssuedAt,
+			&inv.CreatedAt,
+			&inv.UpdatedAt,
+		)
+		if err != nil {
+			return invoices, err
+		}
+		invoices = append(invoices, inv)
+	}
+
+	if err = rows.Err(); err != nil {
+		return invoices, err
+	}
+
+	return invoices, nil
+}
+
+// AllTaxFeeRules returns all tax and fee rules
+func (m *postgresDBRepo) AllTaxFeeRules() ([]models.TaxFeeRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rules []models.TaxFeeRule
+
+	query := `
+		select id, name, kind, basis, amount, starts_on, ends_on, created_at, updated_at
+		from tax_fee_rules
+		order by kind desc, name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule models.TaxFeeRule
+		var startsOn, endsOn sql.NullTime
+		err = rows.Scan(
+			&rule.ID,
+			&rule.Name,
+			&rule.Kind,
+			&rule.Basis,
+			&rule.Amount,
+			&startsOn,
+			&endsOn,
+			&rule.CreatedAt,
+			&rule.UpdatedAt,
+		)
+		if err != nil {
+			return rules, err
+		}
+		rule.StartsOn = startsOn.Time
+		rule.EndsOn = endsOn.Time
+		rules = append(rules, rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+// GetTaxFeeRuleByID returns one tax or fee rule by id
+func (m *postgresDBRepo) GetTaxFeeRuleByID(id int) (models.TaxFeeRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rule models.TaxFeeRule
+	var startsOn, endsOn sql.NullTime
+
+	query := `
+		select id, name, kind, basis, amount, starts_on, ends_on, created_at, updated_at
+		from tax_fee_rules
+		where id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&rule.ID,
+		&rule.Name,
+		&rule.Kind,
+		&rule.Basis,
+		&rule.Amount,
+		&startsOn,
+		&endsOn,
+		&rule.CreatedAt,
+		&rule.UpdatedAt,
+	)
+	if err != nil {
+		return rule, err
+	}
+	rule.StartsOn = startsOn.Time
+	rule.EndsOn = endsOn.Time
+
+	return rule, nil
+}
+
+// InsertTaxFeeRule inserts a tax or fee rule into the database
+func (m *postgresDBRepo) InsertTaxFeeRule(rule models.TaxFeeRule) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into tax_fee_rules (name, kind, basis, amount, starts_on, ends_on, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		rule.Name,
+		rule.Kind,
+		rule.Basis,
+		rule.Amount,
+		nullDate(rule.StartsOn),
+		nullDate(rule.EndsOn),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateTaxFeeRule updates a tax or fee rule; charges already on reservations keep their amounts
+func (m *postgresDBRepo) UpdateTaxFeeRule(rule models.TaxFeeRule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update tax_fee_rules set name = $1, kind = $2, basis = $3, amount = $4, starts_on = $5, ends_on = $6,
+			updated_at = $7 where id = $8`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		rule.Name,
+		rule.Kind,
+		rule.Basis,
+		rule.Amount,
+		nullDate(rule.StartsOn),
+		nullDate(rule.EndsOn),
+		time.Now(),
+		rule.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteTaxFeeRule deletes a tax or fee rule by id
+func (m *postgresDBRepo) DeleteTaxFeeRule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "delete from tax_fee_rules where id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ChargeTotals returns the taxes and fees charged on reservations which were not cancelled, grouped by description
+func (m *postgresDBRepo) ChargeTotals(start, end time.Time) ([]models.ReservationCharge, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var totals []models.ReservationCharge
+
+	query := `
+		select c.kind, c.description, sum(c.quantity), sum(c.amount)
+		from reservation_charges c
+		left join reservations r on (c.reservation_id = r.id)
+		where r.status <> $1 and r.start_date >= $2 and r.start_date < $3 and r.deleted_at is null
+		group by c.kind, c.description
+		order by c.kind desc, c.description`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.ReservationStatusCancelled, start, end)
+	if err != nil {
+		return totals, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.ReservationCharge
+		err = rows.Scan(&c.Kind, &c.Description, &c.Quantity, &c.Amount)
+		if err != nil {
+			return totals, err
+		}
+		totals = append(totals, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return totals, err
+	}
+
+	return totals, nil
+}
+
+// nullDate stores a zero time as null
+func nullDate(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// InsertBooking checks that all rooms of a booking are free and stores the booking with its reservations
+// in one transaction; if one room is taken nothing is stored and ErrRoomNotAvailable is returned. The hold
+// with holdID is the guest's own, it does not count as taking a room and is released once the booking is stored
+func (m *postgresDBRepo) InsertBooking(b models.Booking, holdID int) (models.Booking, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return b, err
+	}
+	defer tx.Rollback()
+
+	// keep other checkouts from taking a room between the check and the insert
+	_, err = tx.ExecContext(ctx, "lock table room_restrictions in share row exclusive mode")
+	if err != nil {
+		return b, err
+	}
+
+	// the guest's own hold does not keep them from booking
+	query := `select count(id) from room_restrictions
+			where room_id = $1 and $2 < end_date and $3 > start_date and (expires_at is null or expires_at > $4) and deleted_at is null
+			and id <> $5`
+
+	for _, res := range b.Reservations {
+		var numRows int
+		err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate, time.Now(), holdID).Scan(&numRows)
+		if err != nil {
+			return b, err
+		}
+		if numRows > 0 {
+			return b, repository.ErrRoomNotAvailable
+		}
+	}
+
+	stmt := `insert into bookings (first_name, last_name, email, phone, access_code, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		b.FirstName,
+		b.LastName,
+		b.Email,
+		b.Phone,
+		b.AccessCode,
+		time.Now(),
+		time.Now(),
+	).Scan(&b.ID)
+	if err != nil {
+		return b, err
+	}
+
+	stmt = `insert into room_restrictions 
+			 (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
+			 values
+			 ($1, $2, $3, $4, $5, $6, $7)`
+
+	for i, res := range b.Reservations {
+		res.BookingID = b.ID
+		res.ID, err = insertReservation(ctx, tx, res)
+		if err != nil {
+			return b, err
+		}
+
+		_, err = tx.ExecContext(ctx, stmt,
+			res.StartDate,
+			res.EndDate,
+			res.RoomID,
+			res.ID,
+			time.Now(),
+			time.Now(),
+			models.RestrictionReservation,
+		)
+		if err != nil {
+			return b, err
+		}
+
+		b.Reservations[i] = res
+	}
+
+	if holdID > 0 {
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where id = $1 and restriction_id = $2`,
+			holdID, models.RestrictionHold)
+		if err != nil {
+			return b, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return b, err
+	}
+
+	return b, nil
+}
+
+// ImportReservations inserts reservations with their room restrictions in one transaction; when a room is taken,
+// by an existing stay or an earlier row, or a row cannot be stored, nothing is inserted and a RowError is returned
+func (m *postgresDBRepo) ImportReservations(reservations []models.Reservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "lock table room_restrictions in share row exclusive mode")
+	if err != nil {
+		return err
+	}
+
+	query := `select count(id) from room_restrictions
+			where room_id = $1 and $2 < end_date and $3 > start_date and (expires_at is null or expires_at > $4) and deleted_at is null`
+
+	stmt := `insert into room_restrictions 
+			 (start_date, end_date, room_id, reservation_id, created_at, updated_at, restriction_id)
+			 values
+			 ($1, $2, $3, $4, $5, $6, $7)`
+
+	for i, res := range reservations {
+		var numRows int
+		err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate, time.Now()).Scan(&numRows)
+		if err != nil {
+			return &repository.RowError{Index: i, Err: err}
+		}
+		if numRows > 0 {
+			return &repository.RowError{Index: i, Err: repository.ErrRoomNotAvailable}
+		}
+
+		res.ID, err = insertReservation(ctx, tx, res)
+		if err != nil {
+			return &repository.RowError{Index: i, Err: err}
+		}
+
+		_, err = tx.ExecContext(ctx, stmt,
+			res.StartDate,
+			res.EndDate,
+			res.RoomID,
+			res.ID,
+			time.Now(),
+			time.Now(),
+			models.RestrictionReservation,
+		)
+		if err != nil {
+			return &repository.RowError{Index: i, Err: err}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetBookingByAccessCode returns a booking with all of its reservations
+func (m *postgresDBRepo) GetBookingByAccessCode(code string) (models.Booking, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var b models.Booking
+
+	query := `
+		select id, first_name, last_name, email, phone, access_code, created_at, updated_at
+		from bookings
+		where access_code = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, code)
+	err := row.Scan(
+		&b.ID,
+		&b.FirstName,
+		&b.LastName,
+		&b.Email,
+		&b.Phone,
+		&b.AccessCode,
+		&b.CreatedAt,
+		&b.UpdatedAt,
+	)
+	if err != nil {
+		return b, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, "select id from reservations where booking_id = $1 and deleted_at is null order by start_date, id", b.ID)
+	if err != nil {
+		return b, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return b, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return b, err
+	}
+
+	for _, id := range ids {
+		res, err := m.GetReservationByID(id)
+		if err != nil {
+			return b, err
+		}
+		b.Reservations = append(b.Reservations, res)
+	}
+
+	return b, nil
+}
+
+// InsertHold holds a room for the dates of a restriction until it expires; if the room is already
+// reserved, blocked or held by someone else ErrRoomNotAvailable is returned
+func (m *postgresDBRepo) InsertHold(r models.RoomRestriction) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "lock table room_restrictions in share row exclusive mode")
+	if err != nil {
+		return 0, err
+	}
+
+	var numRows int
+	query := `select count(id) from room_restrictions
+			where room_id = $1 and $2 < end_date and $3 > start_date and (expires_at is null or expires_at > $4) and deleted_at is null`
+
+	err = tx.QueryRowContext(ctx, query, r.RoomID, r.StartDate, r.EndDate, time.Now()).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	var newID int
+	stmt := `insert into room_restrictions
+			 (start_date, end_date, room_id, restriction_id, expires_at, created_at, updated_at)
+			 values
+			 ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		r.StartDate,
+		r.EndDate,
+		r.RoomID,
+		models.RestrictionHold,
+		r.ExpiresAt,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// ConvertHold turns a hold which has not expired yet into the restriction of a reservation;
+// a hold for another room or other dates than the reservation's is not converted
+func (m *postgresDBRepo) ConvertHold(holdID int, res models.Reservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update room_restrictions
+			set reservation_id = $1, restriction_id = $4, expires_at = null, updated_at = $2
+			where id = $3 and restriction_id = $5 and expires_at > $2
+			and room_id = $6 and start_date = $7 and end_date = $8`
+
+	result, err := m.DB.ExecContext(ctx, stmt,
+		res.ID,
+		time.Now(),
+		holdID,
+		models.RestrictionReservation,
+		models.RestrictionHold,
+		res.RoomID,
+		res.StartDate,
+		res.EndDate,
+	)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrHoldExpired
+	}
+
+	return nil
+}
+
+// DeleteHoldByID releases a hold; restrictions which are not holds are left alone
+func (m *postgresDBRepo) DeleteHoldByID(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from room_restrictions where id = $1 and restriction_id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, id, models.RestrictionHold)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpiredHolds deletes all holds which have expired and returns how many there were
+func (m *postgresDBRepo) DeleteExpiredHolds() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from room_restrictions where restriction_id = $1 and expires_at <= $2`
+
+	result, err := m.DB.ExecContext(ctx, query, models.RestrictionHold, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// InsertWaitlistEntry puts a guest on the waitlist
+func (m *postgresDBRepo) InsertWaitlistEntry(e models.WaitlistEntry) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into waitlist_entries
+			(first_name, last_name, email, start_date, end_date, guests, status, token, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		e.FirstName,
+		e.LastName,
+		e.Email,
+		e.StartDate,
+		e.EndDate,
+		e.Guests,
+		models.WaitlistStatusWaiting,
+		e.Token,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// OpenWaitlistEntries returns the entries still waiting and those holding an offer which has not expired,
+// for stays which have not started yet, first come first
+func (m *postgresDBRepo) OpenWaitlistEntries() ([]models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var entries []models.WaitlistEntry
+
+	query := `
+		select id, first_name, last_name, email, start_date, end_date, guests, status, token,
+		coalesce(notified_at, '0001-01-01'), coalesce(offer_expires_at, '0001-01-01'), created_at, updated_at
+		from waitlist_entries
+		where start_date > $1
+		and (status = $2 or (status = $3 and offer_expires_at > $1))
+		order by created_at asc, id asc`
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now(), models.WaitlistStatusWaiting, models.WaitlistStatusNotified)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.WaitlistEntry
+		err = rows.Scan(
+			&e.ID,
+			&e.FirstName,
+			&e.LastName,
+			&e.Email,
+			&e.StartDate,
+			&e.EndDate,
+			&e.Guests,
+			&e.Status,
+			&e.Token,
+			&e.NotifiedAt,
+			&e.OfferExpiresAt,
+			&e.CreatedAt,
+			&e.UpdatedAt,
+		)
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
+
+// GetWaitlistEntryByToken returns the waitlist entry a booking link was sent for
+func (m *postgresDBRepo) GetWaitlistEntryByToken(token string) (models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var e models.WaitlistEntry
+
+	query := `
+		select id, first_name, last_name, email, start_date, end_date, guests, status, token,
+		coalesce(notified_at, '0001-01-01'), coalesce(offer_expires_at, '0001-01-01'), created_at, updated_at
+		from waitlist_entries
+		where token = $1 and token <> ''`
+
+	row := m.DB.QueryRowContext(ctx, query, token)
+	err := row.Scan(
+		&e.ID,
+		&e.FirstName,
+		&e.LastName,
+		&e.Email,
+		&e.StartDate,
+		&e.EndDate,
+		&e.Guests,
+		&e.Status,
+		&e.Token,
+		&e.NotifiedAt,
+		&e.OfferExpiresAt,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+	)
+	if err != nil {
+		return e, err
+	}
+
+	return e, nil
+}
+
+// MarkWaitlistEntryNotified records that a booking link valid until offerExpiresAt was sent
+func (m *postgresDBRepo) MarkWaitlistEntryNotified(id int, offerExpiresAt time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update waitlist_entries set status = $1, notified_at = $2, offer_expires_at = $3, updated_at = $2 where id = $4`
+
+	_, err := m.DB.ExecContext(ctx, query, models.WaitlistStatusNotified, time.Now(), offerExpiresAt, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ExpireWaitlistOffers marks the offers whose booking link ran out unused as expired, returning how many did
+func (m *postgresDBRepo) ExpireWaitlistOffers() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update waitlist_entries set status = $1, updated_at = $2 where status = $3 and offer_expires_at <= $2`
+
+	result, err := m.DB.ExecContext(ctx, query, models.WaitlistStatusExpired, time.Now(), models.WaitlistStatusNotified)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(n), nil
+}
+
+// UpdateWaitlistEntryStatus updates the status of a waitlist entry by id
+func (m *postgresDBRepo) UpdateWaitlistEntryStatus(id int, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update waitlist_entries set status = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, status, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllGuests returns all guests with their number of stays, most recent first
+func (m *postgresDBRepo) AllGuests() ([]models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var guests []models.Guest
+
+	query := `
+		select g.id, g.first_name, g.last_name, g.email, g.phone, g.flag, g.created_at, g.updated_at,
+			count(r.id) filter (where r.status <> $1 and r.deleted_at is null)
+		from guests g
+		left join reservations r on (r.guest_id = g.id)
+		group by g.id
+		order by g.updated_at desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.ReservationStatusCancelled)
+	if err != nil {
+		return guests, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g models.Guest
+		err = rows.Scan(
+			&g.ID,
+			&g.FirstName,
+			&g.LastName,
+			&g.Email,
+			&g.Phone,
+			&g.Flag,
+			&g.CreatedAt,
+			&g.UpdatedAt,
+			&g.Stays,
+		)
+		if err != nil {
+			return guests, err
+		}
+		guests = append(guests, g)
+	}
+
+	if err = rows.Err(); err != nil {
+		return guests, err
+	}
+
+	return guests, nil
+}
+
+// EachGuest calls fn for every guest, by last name, reading them one at a time
+func (m *postgresDBRepo) EachGuest(fn func(models.Guest) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	query := `
+		select g.id, g.first_name, g.last_name, g.email, g.phone, g.notes, g.preferences, g.flag, g.created_at, g.updated_at,
+			count(r.id) filter (where r.status <> $1 and r.deleted_at is null)
+		from guests g
+		left join reservations r on (r.guest_id = g.id)
+		group by g.id
+		order by g.last_name, g.first_name, g.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.ReservationStatusCancelled)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var g models.Guest
+		err = rows.Scan(
+			&g.ID,
+			&g.FirstName,
+			&g.LastName,
+			&g.Email,
+			&g.Phone,
+			&g.Notes,
+			&g.Preferences,
+			&g.Flag,
+			&g.CreatedAt,
+			&g.UpdatedAt,
+			&g.Stays,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = fn(g)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetGuestByID returns a guest with all of their reservations, latest arrival first
+func (m *postgresDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var g models.Guest
+
+	query := `
+		select id, first_name, last_name, email, phone, notes, preferences, flag, sms_opt_out, created_at, updated_at
+		from guests
+		where id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&g.ID,
+		&g.FirstName,
+		&g.LastName,
+		&g.Email,
+		&g.Phone,
+		&g.Notes,
+		&g.Preferences,
+		&g.Flag,
+		&g.SMSOptOut,
+		&g.CreatedAt,
+		&g.UpdatedAt,
+	)
+	if err != nil {
+		return g, err
+	}
+
+	query = `
+		select r.id, r.start_date, r.end_date, r.status, r.total_amount, r.guests, r.room_id, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where r.guest_id = $1 and r.deleted_at is null
+		order by r.start_date desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return g, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var res models.Reservation
+		err = rows.Scan(
+			&res.ID,
+			&res.StartDate,
+			&res.EndDate,
+			&res.Status,
+			&res.TotalAmount,
+			&res.Guests,
+			&res.RoomID,
+			&res.Room.ID,
+			&res.Room.RoomName,
+		)
+		if err != nil {
+			return g, err
+		}
+		g.Reservations = append(g.Reservations, res)
+		if res.Status != models.ReservationStatusCancelled {
+			g.Stays++
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return g, err
+	}
+
+	return g, nil
+}
+
+// GetGuestByEmail returns the guest with an email, without their reservations
+func (m *postgresDBRepo) GetGuestByEmail(email string) (models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var g models.Guest
+
+	query := `
+		select id, first_name, last_name, email, phone, notes, preferences, flag, sms_opt_out, created_at, updated_at
+		from guests
+		where email = lower($1)`
+
+	row := m.DB.QueryRowContext(ctx, query, email)
+	err := row.Scan(
+		&g.ID,
+		&g.FirstName,
+		&g.LastName,
+		&g.Email,
+		&g.Phone,
+		&g.Notes,
+		&g.Preferences,
+		&g.Flag,
+		&g.SMSOptOut,
+		&g.CreatedAt,
+		&g.UpdatedAt,
+	)
+	if err != nil {
+		return g, err
+	}
+
+	return g, nil
+}
+
+// UpdateGuest updates the notes, preferences, flag and text message opt-out of a guest
+func (m *postgresDBRepo) UpdateGuest(g models.Guest) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update guests set notes = $1, preferences = $2, flag = $3, sms_opt_out = $4, updated_at = $5 where id = $6`
+
+	_, err := m.DB.ExecContext(ctx, query, g.Notes, g.Preferences, g.Flag, g.SMSOptOut, time.Now(), g.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// splitTags splits the comma separated tags aggregated by a query
+func splitTags(tags string) []string {
+	if tags == "" {
+		return nil
+	}
+	return strings.Split(tags, ",")
+}
+
+// GetNotesByReservationID returns the internal notes on a reservation with their authors, oldest first
+func (m *postgresDBRepo) GetNotesByReservationID(reservationID int) ([]models.ReservationNote, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var notes []models.ReservationNote
+
+	query := `
+		select n.id, n.reservation_id, coalesce(n.user_id, 0), coalesce(u.first_name || ' ' || u.last_name, ''),
+			n.body, n.created_at, n.updated_at
+		from reservation_notes n
+		left join users u on (n.user_id = u.id)
+		where n.reservation_id = $1
+		order by n.created_at asc, n.id asc`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return notes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n models.ReservationNote
+		err = rows.Scan(
+			&n.ID,
+			&n.ReservationID,
+			&n.UserID,
+			&n.Author,
+			&n.Body,
+			&n.CreatedAt,
+			&n.UpdatedAt,
+		)
+		if err != nil {
+			return notes, err
+		}
+		notes = append(notes, n)
+	}
+
+	if err = rows.Err(); err != nil {
+		return notes, err
+	}
+
+	return notes, nil
+}
+
+// InsertReservationNote adds an internal note to a reservation
+func (m *postgresDBRepo) InsertReservationNote(n models.ReservationNote) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID sql.NullInt64
+	if n.UserID > 0 {
+		userID = sql.NullInt64{Int64: int64(n.UserID), Valid: true}
+	}
+
+	var newID int
+	stmt := `insert into reservation_notes (reservation_id, user_id, body, created_at, updated_at)
+			values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, n.ReservationID, userID, n.Body, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// SetReservationTags replaces the tags of a reservation
+func (m *postgresDBRepo) SetReservationTags(reservationID int, tags []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "delete from reservation_tags where reservation_id = $1", reservationID)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into reservation_tags (reservation_id, tag, created_at, updated_at) values ($1, $2, $3, $4)`
+
+	for _, tag := range tags {
+		_, err = tx.ExecContext(ctx, stmt, reservationID, tag, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GenerateHousekeepingTasks adds the cleaning tasks of a day: rooms vacated that day, and rooms of guests
+// staying on which are serviced every serviceEvery nights; tasks which exist already are kept
+func (m *postgresDBRepo) GenerateHousekeepingTasks(day time.Time, serviceEvery int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	departures := `
+		insert into housekeeping_tasks (room_id, reservation_id, day, kind, status, created_at, updated_at)
+		select r.room_id, r.id, $1::date, $2, $3, $4, $4
+		from reservations r
+		where r.end_date = $1::date and r.status <> $5 and r.deleted_at is null
+		on conflict (room_id, day, kind) do nothing`
+
+	_, err = tx.ExecContext(ctx, departures, day, models.HousekeepingTaskDeparture, models.HousekeepingStatusDirty,
+		time.Now(), models.ReservationStatusCancelled)
+	if err != nil {
+		return err
+	}
+
+	if serviceEvery > 0 {
+		services := `
+			insert into housekeeping_tasks (room_id, reservation_id, day, kind, status, created_at, updated_at)
+			select r.room_id, r.id, $1::date, $2, $3, $4, $4
+			from reservations r
+			where r.start_date < $1::date and r.end_date > $1::date and r.status <> $5 and r.deleted_at is null
+				and ($1::date - r.start_date) % $6 = 0
+			on conflict (room_id, day, kind) do nothing`
+
+		_, err = tx.ExecContext(ctx, services, day, models.HousekeepingTaskService, models.HousekeepingStatusDirty,
+			time.Now(), models.ReservationStatusCancelled, serviceEvery)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// HousekeepingTasksByDay returns the cleaning tasks of a day in room order
+func (m *postgresDBRepo) HousekeepingTasksByDay(day time.Time) ([]models.HousekeepingTask, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select t.id, t.room_id, coalesce(t.reservation_id, 0), t.day, t.kind, t.status, t.created_at, t.updated_at,
+			rm.id, rm.room_name
+		from housekeeping_tasks t
+		left join rooms rm on (t.room_id = rm.id)
+		where t.day = $1::date
+		order by rm.room_name, t.id`
+
+	var tasks []models.HousekeepingTask
+	rows, err := m.DB.QueryContext(ctx, query, day)
+	if err != nil {
+		return tasks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.HousekeepingTask
+		err = rows.Scan(
+			&t.ID,
+			&t.RoomID,
+			&t.ReservationID,
+			&t.Day,
+			&t.Kind,
+			&t.Status,
+			&t.CreatedAt,
+			&t.UpdatedAt,
+			&t.Room.ID,
+			&t.Room.RoomName,
+		)
+		if err != nil {
+			return tasks, err
+		}
+		tasks = append(tasks, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return tasks, err
+	}
+
+	return tasks, nil
+}
+
+// UpsertHousekeepingTask adds a cleaning task, or sets the status of the task of the same room, day and kind
+func (m *postgresDBRepo) UpsertHousekeepingTask(t models.HousekeepingTask) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reservationID interface{}
+	if t.ReservationID > 0 {
+		reservationID = t.ReservationID
+	}
+
+	stmt := `
+		insert into housekeeping_tasks (room_id, reservation_id, day, kind, status, created_at, updated_at)
+		values ($1, $2, $3::date, $4, $5, $6, $6)
+		on conflict (room_id, day, kind) do update set status = excluded.status, updated_at = excluded.updated_at`
+
+	_, err := m.DB.ExecContext(ctx, stmt, t.RoomID, reservationID, t.Day, t.Kind, t.Status, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateHousekeepingTaskStatus sets the status of a cleaning task
+func (m *postgresDBRepo) UpdateHousekeepingTaskStatus(id int, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update housekeeping_tasks set status = $1, updated_at = $2 where id = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, status, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// HousekeepingStatuses returns the status of the latest cleaning task of each room up to a day, by room id;
+// rooms which never had one are missing
+func (m *postgresDBRepo) HousekeepingStatuses(day time.Time) (map[int]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select distinct on (room_id) room_id, status
+		from housekeeping_tasks
+		where day <= $1::date
+		order by room_id, day desc, updated_at desc`
+
+	statuses := make(map[int]string)
+	rows, err := m.DB.QueryContext(ctx, query, day)
+	if err != nil {
+		return statuses, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var roomID int
+		var status string
+		err = rows.Scan(&roomID, &status)
+		if err != nil {
+			return statuses, err
+		}
+		statuses[roomID] = status
+	}
+
+	if err = rows.Err(); err != nil {
+		return statuses, err
+	}
+
+	return statuses, nil
+}
+
+// InsertOutOfOrder takes a room out of order for a period; it fails with ErrRoomNotAvailable when the room is booked or blocked then
+func (m *postgresDBRepo) InsertOutOfOrder(r models.RoomRestriction) (int, error) {
+	return m.insertFreeRestriction(r, models.RestrictionOutOfOrder)
+}
+
+// InsertBlock blocks a room for the owner over a period; it fails with ErrRoomNotAvailable when the room is booked or blocked then
+func (m *postgresDBRepo) InsertBlock(r models.RoomRestriction) (int, error) {
+	return m.insertFreeRestriction(r, models.RestrictionOwnerBlock)
+}
+
+// insertFreeRestriction inserts a restriction if nothing else is in the room during its dates
+func (m *postgresDBRepo) insertFreeRestriction(r models.RoomRestriction, restrictionID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "lock table room_restrictions in share row exclusive mode")
+	if err != nil {
+		return 0, err
+	}
+
+	var numRows int
+	query := `select count(id) from room_restrictions
+			where room_id = $1 and $2 < end_date and $3 > start_date and (expires_at is null or expires_at > $4) and deleted_at is null`
+
+	err = tx.QueryRowContext(ctx, query, r.RoomID, r.StartDate, r.EndDate, time.Now()).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+	if numRows > 0 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+
+	var newID int
+	stmt := `insert into room_restrictions
+			 (start_date, end_date, room_id, reservation_id, restriction_id, reason, assigned_to, status, created_at, updated_at)
+			 values
+			 ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		r.StartDate,
+		r.EndDate,
+		r.RoomID,
+		nullID(r.ReservationID),
+		restrictionID,
+		r.Reason,
+		r.AssignedTo,
+		r.Status,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	if restrictionID == models.RestrictionOwnerBlock {
+		err = m.bumpBlocksVersion(ctx, tx, r.RoomID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateOutOfOrder changes an out-of-order period; new dates must not clash with anything else in the room
+func (m *postgresDBRepo) UpdateOutOfOrder(r models.RoomRestriction) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "lock table room_restrictions in share row exclusive mode")
+	if err != nil {
+		return err
+	}
+
+	var numRows int
+	query := `select count(id) from room_restrictions
+			where room_id = $1 and $2 < end_date and $3 > start_date and (expires_at is null or expires_at > $4) and deleted_at is null and id <> $5`
+
+	err = tx.QueryRowContext(ctx, query, r.RoomID, r.StartDate, r.EndDate, time.Now(), r.ID).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomNotAvailable
+	}
+
+	stmt := `update room_restrictions
+			set start_date = $1, end_date = $2, room_id = $3, reason = $4, assigned_to = $5, status = $6, updated_at = $7
+			where id = $8 and restriction_id = $9 and deleted_at is null`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		r.StartDate,
+		r.EndDate,
+		r.RoomID,
+		r.Reason,
+		r.AssignedTo,
+		r.Status,
+		time.Now(),
+		r.ID,
+		models.RestrictionOutOfOrder,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetOutOfOrderByID returns an out-of-order period with its room
+func (m *postgresDBRepo) GetOutOfOrderByID(id int) (models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select rr.id, rr.start_date, rr.end_date, rr.room_id, rr.restriction_id, rr.reason, rr.assigned_to, rr.status,
+			rr.created_at, rr.updated_at, rm.id, rm.room_name
+		from room_restrictions rr
+		left join rooms rm on (rr.room_id = rm.id)
+		where rr.id = $1 and rr.restriction_id = $2 and rr.deleted_at is null`
+
+	var r models.RoomRestriction
+	err := m.DB.QueryRowContext(ctx, query, id, models.RestrictionOutOfOrder).Scan(
+		&r.ID,
+		&r.StartDate,
+		&r.EndDate,
+		&r.RoomID,
+		&r.RestrictionID,
+		&r.Reason,
+		&r.AssignedTo,
+		&r.Status,
+		&r.CreatedAt,
+		&r.UpdatedAt,
+		&r.Room.ID,
+		&r.Room.RoomName,
+	)
+	if err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
+// OutOfOrderPeriods returns the out-of-order periods which end after a day, earliest first
+func (m *postgresDBRepo) OutOfOrderPeriods(from time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select rr.id, rr.start_date, rr.end_date, rr.room_id, rr.restriction_id, rr.reason, rr.assigned_to, rr.status,
+			rr.created_at, rr.updated_at, rm.id, rm.room_name
+		from room_restrictions rr
+		left join rooms rm on (rr.room_id = rm.id)
+		where rr.restriction_id = $1 and rr.end_date > $2 and rr.deleted_at is null
+		order by rr.start_date, rm.room_name`
+
+	var periods []models.RoomRestriction
+	rows, err := m.DB.QueryContext(ctx, query, models.RestrictionOutOfOrder, from)
+	if err != nil {
+		return periods, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err = rows.Scan(
+			&r.ID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.RoomID,
+			&r.RestrictionID,
+			&r.Reason,
+			&r.AssignedTo,
+			&r.Status,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+			&r.Room.ID,
+			&r.Room.RoomName,
+		)
+		if err != nil {
+			return periods, err
+		}
+		periods = append(periods, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return periods, err
+	}
+
+	return periods, nil
+}
+
+// DeleteOutOfOrder puts a room back in service by moving its out-of-order period to the trash;
+// restrictions which are not out-of-order periods are left alone
+func (m *postgresDBRepo) DeleteOutOfOrder(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update room_restrictions set deleted_at = $1, updated_at = $1 where id = $2 and restriction_id = $3 and deleted_at is null`
+
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id, models.RestrictionOutOfOrder)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateBlocks adds one-night owner blocks to a room and moves some of its blocks to the trash in one transaction.
+// It returns ErrVersionConflict when the blocks of the room changed since version was read, and
+// ErrRoomNotAvailable when a night to block is taken; in both cases nothing is stored.
+func (m *postgresDBRepo) UpdateBlocks(roomID, version int, add []time.Time, remove []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		`update rooms set blocks_version = blocks_version + 1, updated_at = $1 where id = $2 and blocks_version = $3`,
+		time.Now(), roomID, version)
+	if err != nil {
+		return err
+	}
+	err = versionChecked(result)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range remove {
+		_, err = tx.ExecContext(ctx, `update room_restrictions set deleted_at = $1
+			where id = $2 and room_id = $3 and restriction_id = $4 and deleted_at is null`,
+			time.Now(), id, roomID, models.RestrictionOwnerBlock)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(add) > 0 {
+		_, err = tx.ExecContext(ctx, "lock table room_restrictions in share row exclusive mode")
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, day := range add {
+		var numRows int
+		query := `select count(id) from room_restrictions
+			where room_id = $1 and $2 < end_date and $3 > start_date and (expires_at is null or expires_at > $4) and deleted_at is null`
+
+		err = tx.QueryRowContext(ctx, query, roomID, day, day.AddDate(0, 0, 1), time.Now()).Scan(&numRows)
+		if err != nil {
+			return err
+		}
+		if numRows > 0 {
+			return repository.ErrRoomNotAvailable
+		}
+
+		stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $5)`
+
+		_, err = tx.ExecContext(ctx, stmt, day, day.AddDate(0, 0, 1), roomID, models.RestrictionOwnerBlock, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// execer runs statements on the database or inside a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// bumpBlocksVersion marks the blocks of a room as changed, so calendar forms read before are rejected
+func (m *postgresDBRepo) bumpBlocksVersion(ctx context.Context, db execer, roomID int) error {
+	_, err := db.ExecContext(ctx, `update rooms set blocks_version = blocks_version + 1 where id = $1`, roomID)
+	return err
+}
+
+// versionChecked returns ErrVersionConflict when an update guarded by a version column changed no row
+func versionChecked(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrVersionConflict
+	}
+	return nil
+}
+
+// TrashedReservations returns the reservations in the trash, most recently deleted first
+func (m *postgresDBRepo) TrashedReservations() ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select r.id, r.first_name, r.last_name, r.start_date, r.end_date, r.room_id, r.status, r.deleted_at,
+			rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where r.deleted_at is not null
+		order by r.deleted_at desc, r.id`
+
+	var reservations []models.Reservation
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Reservation
+		err = rows.Scan(
+			&r.ID,
+			&r.FirstName,
+			&r.LastName,
+			&r.StartDate,
+			&r.EndDate,
+			&r.RoomID,
+			&r.Status,
+			&r.DeletedAt,
+			&r.Room.ID,
+			&r.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
+
+// TrashedBlocks returns the owner blocks and out-of-order periods in the trash, most recently deleted first
+func (m *postgresDBRepo) TrashedBlocks() ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		select rr.id, rr.start_date, rr.end_date, rr.room_id, rr.restriction_id, rr.deleted_at, rm.id, rm.room_name,
+			coalesce(rs.restriction_name, '')
+		from room_restrictions rr
+		left join rooms rm on (rr.room_id = rm.id)
+		left join restrictions rs on (rr.restriction_id = rs.id)
+		where rr.restriction_id in ($1, $2) and rr.deleted_at is not null
+		order by rr.deleted_at desc, rr.id`
+
+	var blocks []models.RoomRestriction
+	rows, err := m.DB.QueryContext(ctx, query, models.RestrictionOwnerBlock, models.RestrictionOutOfOrder)
+	if err != nil {
+		return blocks, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.RoomRestriction
+		err = rows.Scan(
+			&r.ID,
+			&r.StartDate,
+			&r.EndDate,
+			&r.RoomID,
+			&r.RestrictionID,
+			&r.DeletedAt,
+			&r.Room.ID,
+			&r.Room.RoomName,
+			&r.Restriction.RestrictionName,
+		)
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return blocks, err
+	}
+
+	return blocks, nil
+}
+
+// RestoreReservation takes a reservation out of the trash, returning ErrRoomNotAvailable
+// when its room was booked or blocked for its dates in the meantime
+func (m *postgresDBRepo) RestoreReservation(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "lock table room_restrictions in share row exclusive mode")
+	if err != nil {
+		return err
+	}
+
+	err = restoreRestrictions(ctx, tx, `reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `update reservations set deleted_at = null, updated_at = $1 where id = $2`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RestoreBlock takes an owner block or out-of-order period out of the trash, returning ErrRoomNotAvailable
+// when its room was booked or blocked for its dates in the meantime
+func (m *postgresDBRepo) RestoreBlock(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "lock table room_restrictions in share row exclusive mode")
+	if err != nil {
+		return err
+	}
+
+	err = restoreRestrictions(ctx, tx, `id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	var roomID, restrictionID int
+	err = tx.QueryRowContext(ctx, `select room_id, restriction_id from room_restrictions where id = $1`, id).Scan(&roomID, &restrictionID)
+	if err != nil {
+		return err
+	}
+
+	if restrictionID == models.RestrictionOwnerBlock {
+		err = m.bumpBlocksVersion(ctx, tx, roomID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// restoreRestrictions takes the trashed room restrictions matching where out of the trash,
+// unless anything else now occupies their rooms during their dates
+func restoreRestrictions(ctx context.Context, tx *sql.Tx, where string, id int) error {
+	query := `
+		select count(o.id)
+		from room_restrictions rr
+		join room_restrictions o on (o.room_id = rr.room_id and o.id <> rr.id
+			and rr.start_date < o.end_date and rr.end_date > o.start_date
+			and (o.expires_at is null or o.expires_at > $2) and o.deleted_at is null)
+		where rr.deleted_at is not null and rr.` + where
+
+	var numRows int
+	err := tx.QueryRowContext(ctx, query, id, time.Now()).Scan(&numRows)
+	if err != nil {
+		return err
+	}
+	if numRows > 0 {
+		return repository.ErrRoomNotAvailable
+	}
+
+	_, err = tx.ExecContext(ctx, `update room_restrictions set deleted_at = null, updated_at = $2 where `+where, id, time.Now())
+	return err
+}
+
+// PurgeTrash deletes for good the reservations and blocks which went to the trash before the given time.
+// Reservations which were invoiced or paid are part of the books and stay in the trash
+func (m *postgresDBRepo) PurgeTrash(before time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `delete from room_restrictions where deleted_at < $1 and reservation_id is null`, before)
+	if err != nil {
+		return 0, err
+	}
+	blocks, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	result, err = tx.ExecContext(ctx, `
+		delete from reservations r
+		where r.deleted_at < $1
+			and not exists (select 1 from invoices i where i.reservation_id = r.id)
+			and not exists (select 1 from payments p where p.reservation_id = r.id)`, before)
+	if err != nil {
+		return 0, err
+	}
+	reservations, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(blocks + reservations), nil
+}
+
+// RunBulkAction applies an action to the reservations of a batch in one transaction and records the batch.
+// Items which are not ok are left alone; an item which cannot be applied is rolled back on its own and marked failed
+func (m *postgresDBRepo) RunBulkAction(b models.BulkAction) (models.BulkAction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return b, err
+	}
+	defer tx.Rollback()
+
+	var userID sql.NullInt64
+	if b.UserID > 0 {
+		userID = sql.NullInt64{Int64: int64(b.UserID), Valid: true}
+	}
+
+	b.CreatedAt = time.Now()
+	b.UpdatedAt = b.CreatedAt
+
+	stmt := `insert into bulk_actions (user_id, action, detail, created_at, updated_at) values ($1, $2, $3, $4, $5) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt, userID, b.Action, b.Detail, b.CreatedAt, b.UpdatedAt).Scan(&b.ID)
+	if err != nil {
+		return b, err
+	}
+
+	stmt = `insert into bulk_action_items (bulk_action_id, reservation_id, ok, message, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6)`
+
+	for i, item := range b.Items {
+		if item.OK {
+			_, err = tx.ExecContext(ctx, "savepoint bulk_item")
+			if err != nil {
+				return b, err
+			}
+
+			failure, err := applyBulkAction(ctx, tx, b, item.ReservationID)
+			if err != nil {
+				failure = err.Error()
+			}
+
+			if failure != "" {
+				_, err = tx.ExecContext(ctx, "rollback to savepoint bulk_item")
+				item.OK = false
+				item.Message = failure
+			} else {
+				_, err = tx.ExecContext(ctx, "release savepoint bulk_item")
+			}
+			if err != nil {
+				return b, err
+			}
+			b.Items[i] = item
+		}
+
+		_, err = tx.ExecContext(ctx, stmt, b.ID, item.ReservationID, item.OK, item.Message, b.CreatedAt, b.UpdatedAt)
+		if err != nil {
+			return b, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return b, err
+	}
+
+	return b, nil
+}
+
+// applyBulkAction applies the action of a batch to one reservation, returning why it could not be applied.
+// The reservation is locked first, so the checks hold until the batch commits, and its version is bumped so
+// edit forms opened before the batch can't save over it
+func applyBulkAction(ctx context.Context, tx *sql.Tx, b models.BulkAction, reservationID int) (string, error) {
+	var status string
+	err := tx.QueryRowContext(ctx, `select status from reservations where id = $1 and deleted_at is null for update`,
+		reservationID).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "Reservation not found", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch b.Action {
+	case models.BulkActionProcess:
+		_, err = tx.ExecContext(ctx, `update reservations set processed = 1, version = version + 1, updated_at = $1 where id = $2`,
+			time.Now(), reservationID)
+
+	case models.BulkActionCancel:
+		if status == models.ReservationStatusCancelled {
+			return "Already cancelled", nil
+		}
+
+		// a bulk cancellation refunds nothing, so paid reservations go through the cancellation policy one by one;
+		// an authorized payment is about to be captured and counts as paid
+		var paid int
+		query := `select coalesce(sum(case when status = 'refunded' then -amount else amount end), 0)
+				from payments where reservation_id = $1 and status in ('authorized', 'captured', 'refunded')`
+
+		err = tx.QueryRowContext(ctx, query, reservationID).Scan(&paid)
+		if err != nil {
+			return "", err
+		}
+		if paid > 0 {
+			return "Paid reservations are cancelled one at a time, so the guest is refunded", nil
+		}
+
+		query = `update reservations set status = $1, cancelled_at = $2, refund_amount = 0, version = version + 1, updated_at = $2
+				where id = $3`
+
+		_, err = tx.ExecContext(ctx, query, models.ReservationStatusCancelled, time.Now(), reservationID)
+		if err != nil {
+			return "", err
+		}
+		_, err = tx.ExecContext(ctx, "delete from room_restrictions where reservation_id = $1", reservationID)
+
+	case models.BulkActionTag:
+		stmt := `insert into reservation_tags (reservation_id, tag, created_at, updated_at)
+				select $1, $2, $3, $3
+				where not exists (select 1 from reservation_tags where reservation_id = $1 and tag = $2)`
+
+		for _, tag := range strings.Split(b.Detail, ",") {
+			_, err = tx.ExecContext(ctx, stmt, reservationID, tag, time.Now())
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return "", err
+}
+
+// GetBulkActionByID returns a batch of a bulk action with the outcome for each of its reservations
+func (m *postgresDBRepo) GetBulkActionByID(id int) (models.BulkAction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var b models.BulkAction
+
+	query := `
+		select a.id, coalesce(a.user_id, 0), coalesce(u.first_name || ' ' || u.last_name, ''), a.action, a.detail,
+			a.created_at, a.updated_at
+		from bulk_actions a
+		left join users u on (a.user_id = u.id)
+		where a.id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&b.ID,
+		&b.UserID,
+		&b.Author,
+		&b.Action,
+		&b.Detail,
+		&b.CreatedAt,
+		&b.UpdatedAt,
+	)
+	if err != nil {
+		return b, err
+	}
+
+	query = `
+		select coalesce(i.reservation_id, 0), coalesce(r.first_name || ' ' || r.last_name, ''), i.ok, i.message
+		from bulk_action_items i
+		left join reservations r on (i.reservation_id = r.id)
+		where i.bulk_action_id = $1
+		order by i.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return b, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var item models.BulkActionItem
+		err = rows.Scan(
+			&item.ReservationID,
+			&item.Guest,
+			&item.OK,
+			&item.Message,
+		)
+		if err != nil {
+			return b, err
+		}
+		b.Items = append(b.Items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return b, err
+	}
+
+	return b, nil
+}
+
+// RecentBulkActions returns the latest batches of bulk actions, newest first, with their outcomes
+func (m *postgresDBRepo) RecentBulkActions(limit int) ([]models.BulkAction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var batches []models.BulkAction
+
+	query := `
+		select a.id, coalesce(a.user_id, 0), coalesce(u.first_name || ' ' || u.last_name, ''), a.action, a.detail,
+			a.created_at, a.updated_at, coalesce(i.reservation_id, 0), i.ok, i.message
+		from (select * from bulk_actions order by created_at desc, id desc limit $1) a
+		left join users u on (a.user_id = u.id)
+		join bulk_action_items i on (i.bulk_action_id = a.id)
+		order by a.created_at desc, a.id desc, i.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return batches, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b models.BulkAction
+		var item models.BulkActionItem
+		err = rows.Scan(
+			&b.ID,
+			&b.UserID,
+			&b.Author,
+			&b.Action,
+			&b.Detail,
+			&b.CreatedAt,
+			&b.UpdatedAt,
+			&item.ReservationID,
+			&item.OK,
+			&item.Message,
+		)
+		if err != nil {
+			return batches, err
+		}
+
+		if len(batches) == 0 || batches[len(batches)-1].ID != b.ID {
+			batches = append(batches, b)
+		}
+		last := &batches[len(batches)-1]
+		last.Items = append(last.Items, item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return batches, err
+	}
+
+	return batches, nil
+}
+
+// AllMessageTemplates returns the saved message templates by name
+func (m *postgresDBRepo) AllMessageTemplates() ([]models.MessageTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var templates []models.MessageTemplate
+
+	query := `select id, name, subject, body, created_at, updated_at from message_templates order by name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return templates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t models.MessageTemplate
+		err = rows.Scan(
+			&t.ID,
+			&t.Name,
+			&t.Subject,
+			&t.Body,
+			&t.CreatedAt,
+			&t.UpdatedAt,
+		)
+		if err != nil {
+			return templates, err
+		}
+		templates = append(templates, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return templates, err
+	}
+
+	return templates, nil
+}
+
+// GetMessageTemplateByID returns one message template by id
+func (m *postgresDBRepo) GetMessageTemplateByID(id int) (models.MessageTemplate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var t models.MessageTemplate
+
+	query := `select id, name, subject, body, created_at, updated_at from message_templates where id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&t.ID,
+		&t.Name,
+		&t.Subject,
+		&t.Body,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	)
+	if err != nil {
+		return t, err
+	}
+
+	return t, nil
+}
+
+// InsertMessageTemplate inserts a message template into the database
+func (m *postgresDBRepo) InsertMessageTemplate(t models.MessageTemplate) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into message_templates (name, subject, body, created_at, updated_at)
+			values ($1, $2, $3, $4, $5) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, t.Name, t.Subject, t.Body, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateMessageTemplate updates a message template; messages already sent are kept as they were
+func (m *postgresDBRepo) UpdateMessageTemplate(t models.MessageTemplate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update message_templates set name = $1, subject = $2, body = $3, updated_at = $4 where id = $5`
+
+	_, err := m.DB.ExecContext(ctx, stmt, t.Name, t.Subject, t.Body, time.Now(), t.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteMessageTemplate deletes a message template by id
+func (m *postgresDBRepo) DeleteMessageTemplate(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, "delete from message_templates where id = $1", id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertReservationMessage records an email sent to the guest of a reservation
+func (m *postgresDBRepo) InsertReservationMessage(msg models.ReservationMessage) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userID sql.NullInt64
+	if msg.UserID > 0 {
+		userID = sql.NullInt64{Int64: int64(msg.UserID), Valid: true}
+	}
+
+	var newID int
+	stmt := `insert into reservation_messages (reservation_id, user_id, recipient, subject, body, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		msg.ReservationID,
+		userID,
+		msg.Recipient,
+		msg.Subject,
+		msg.Body,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetMessagesByReservationID returns the emails sent to the guest of a reservation, oldest first
+func (m *postgresDBRepo) GetMessagesByReservationID(reservationID int) ([]models.ReservationMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var messages []models.ReservationMessage
+
+	query := `
+		select rm.id, rm.reservation_id, coalesce(rm.user_id, 0), coalesce(u.first_name || ' ' || u.last_name, ''),
+			rm.recipient, rm.subject, rm.body, rm.created_at, rm.updated_at
+		from reservation_messages rm
+		left join users u on (rm.user_id = u.id)
+		where rm.reservation_id = $1
+		order by rm.created_at asc, rm.id asc`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return messages, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg models.ReservationMessage
+		err = rows.Scan(
+			&msg.ID,
+			&msg.ReservationID,
+			&msg.UserID,
+			&msg.Author,
+			&msg.Recipient,
+			&msg.Subject,
+			&msg.Body,
+			&msg.CreatedAt,
+			&msg.UpdatedAt,
+		)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return messages, err
+	}
+
+	return messages, nil
+}
+
+// AddInboundMessage adds a message from a guest to their conversation and returns its id. The conversation is the one
+// with the token, else the latest about the reservation, of the guest or from the email; a new one is started otherwise.
+// A message whose message id was seen before is skipped and 0 returned
+func (m *postgresDBRepo) AddInboundMessage(c models.Conversation, msg models.ConversationMessage) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var messageID sql.NullString
+	if msg.MessageID != "" {
+		messageID = sql.NullString{String: msg.MessageID, Valid: true}
+
+		var seen int
+		err = tx.QueryRowContext(ctx, `select count(id) from conversation_messages where message_id = $1`, msg.MessageID).Scan(&seen)
+		if err != nil {
+			return 0, err
+		}
+		if seen > 0 {
+			return 0, nil
+		}
+	}
+
+	lookups := []struct {
+		where string
+		value interface{}
+		use   bool
+	}{
+		{"token = $1", c.Token, c.Token != ""},
+		{"reservation_id = $1", c.ReservationID, c.ReservationID > 0},
+		{"guest_id = $1", c.GuestID, c.GuestID > 0},
+		{"email = lower($1)", c.Email, true},
+	}
+
+	var id int
+	for _, l := range lookups {
+		if !l.use {
+			continue
+		}
+		err = tx.QueryRowContext(ctx, `select id from conversations where `+l.where+` order by last_message_at desc limit 1`,
+			l.value).Scan(&id)
+		if err == nil {
+			break
+		}
+		if err != sql.ErrNoRows {
+			return 0, err
+		}
+	}
+
+	now := time.Now()
+	if id == 0 {
+		stmt := `insert into conversations (token, guest_id, reservation_id, name, email, subject, last_message_at,
+				created_at, updated_at)
+				values ($1, $2, $3, $4, lower($5), $6, $7, $7, $7) returning id`
+
+		err = tx.QueryRowContext(ctx, stmt, c.Token, nullID(c.GuestID), nullID(c.ReservationID), c.Name, c.Email, c.Subject,
+			now).Scan(&id)
+	} else {
+		stmt := `update conversations set guest_id = coalesce(guest_id, $1), reservation_id = coalesce(reservation_id, $2),
+				last_message_at = $3, updated_at = $3 where id = $4`
+
+		_, err = tx.ExecContext(ctx, stmt, nullID(c.GuestID), nullID(c.ReservationID), now, id)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `insert into conversation_messages (conversation_id, direction, body, message_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $5)`
+
+	_, err = tx.ExecContext(ctx, stmt, id, models.ConversationMessageIn, msg.Body, messageID, now)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// nullID stores ids which are not set as null
+func nullID(id int) sql.NullInt64 {
+	if id > 0 {
+		return sql.NullInt64{Int64: int64(id), Valid: true}
+	}
+	return sql.NullInt64{}
+}
+
+// AllConversations returns the conversations with guests, latest first, with the number of their unread messages
+func (m *postgresDBRepo) AllConversations() ([]models.Conversation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var conversations []models.Conversation
+
+	query := `
+		select c.id, c.token, coalesce(c.guest_id, 0), coalesce(c.reservation_id, 0), c.name, c.email, c.subject,
+			c.last_message_at, c.created_at, c.updated_at,
+			(select count(cm.id) from conversation_messages cm
+				where cm.conversation_id = c.id and cm.direction = $1 and cm.read_at is null)
+		from conversations c
+		order by c.last_message_at desc, c.id desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.ConversationMessageIn)
+	if err != nil {
+		return conversations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c models.Conversation
+		err = rows.Scan(
+			&c.ID,
+			&c.Token,
+			&c.GuestID,
+			&c.ReservationID,
+			&c.Name,
+			&c.Email,
+			&c.Subject,
+			&c.LastMessageAt,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+			&c.Unread,
+		)
+		if err != nil {
+			return conversations, err
+		}
+		conversations = append(conversations, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return conversations, err
+	}
+
+	return conversations, nil
+}
+
+// GetConversationByID returns a conversation with its messages, oldest first
+func (m *postgresDBRepo) GetConversationByID(id int) (models.Conversation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var c models.Conversation
+
+	query := `
+		select id, token, coalesce(guest_id, 0), coalesce(reservation_id, 0), name, email, subject, last_message_at,
+			created_at, updated_at
+		from conversations
+		where id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&c.ID,
+		&c.Token,
+		&c.GuestID,
+		&c.ReservationID,
+		&c.Name,
+		&c.Email,
+		&c.Subject,
+		&c.LastMessageAt,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
+	if err != nil {
+		return c, err
+	}
+
+	query = `
+		select cm.id, cm.conversation_id, cm.direction, coalesce(cm.user_id, 0),
+			coalesce(u.first_name || ' ' || u.last_name, ''), cm.body, coalesce(cm.message_id, ''), cm.read_at,
+			cm.created_at, cm.updated_at
+		from conversation_messages cm
+		left join users u on (cm.user_id = u.id)
+		where cm.conversation_id = $1
+		order by cm.created_at asc, cm.id asc`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return c, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg models.ConversationMessage
+		var readAt sql.NullTime
+		err = rows.Scan(
+			&msg.ID,
+			&msg.ConversationID,
+			&msg.Direction,
+			&msg.UserID,
+			&msg.Author,
+			&msg.Body,
+			&msg.MessageID,
+			&readAt,
+			&msg.CreatedAt,
+			&msg.UpdatedAt,
+		)
+		if err != nil {
+			return c, err
+		}
+		msg.ReadAt = readAt.Time
+		if msg.Direction == models.ConversationMessageIn && msg.ReadAt.IsZero() {
+			c.Unread++
+		}
+		c.Messages = append(c.Messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return c, err
+	}
+
+	return c, nil
+}
+
+// MarkConversationRead marks the messages of a conversation as read
+func (m *postgresDBRepo) MarkConversationRead(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update conversation_messages set read_at = $1, updated_at = $1 where conversation_id = $2 and read_at is null`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// InsertConversationReply adds a reply by staff to a conversation
+func (m *postgresDBRepo) InsertConversationReply(msg models.ConversationMessage) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	var newID int
+	stmt := `insert into conversation_messages (conversation_id, direction, user_id, body, read_at, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $5, $5) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt, msg.ConversationID, models.ConversationMessageOut, nullID(msg.UserID), msg.Body,
+		now).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update conversations set last_message_at = $1, updated_at = $1 where id = $2`,
+		now, msg.ConversationID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// InsertSMSMessage records a text message sent to the guest of a reservation
+func (m *postgresDBRepo) InsertSMSMessage(msg models.SMSMessage) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into sms_messages (reservation_id, kind, phone, body, provider, ref, status, error, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		msg.ReservationID,
+		msg.Kind,
+		msg.Phone,
+		msg.Body,
+		msg.Provider,
+		msg.Ref,
+		msg.Status,
+		msg.Error,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateSMSStatus records what a provider reported later about a text message it took, by its reference;
+// messages the provider refused right away keep their failure
+func (m *postgresDBRepo) UpdateSMSStatus(provider, ref, status, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update sms_messages set status = $1, error = $2, updated_at = $3
+			where provider = $4 and ref = $5 and ref <> '' and status <> 'failed'`
+
+	_, err := m.DB.ExecContext(ctx, query, status, message, time.Now(), provider, ref)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetSMSMessagesByReservationID returns the text messages sent to the guest of a reservation, oldest first
+func (m *postgresDBRepo) GetSMSMessagesByReservationID(reservationID int) ([]models.SMSMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var messages []models.SMSMessage
+
+	query := `
+		select id, reservation_id, kind, phone, body, provider, ref, status, error, created_at, updated_at
+		from sms_messages
+		where reservation_id = $1
+		order by created_at asc, id asc`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return messages, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg models.SMSMessage
+		err = rows.Scan(
+			&msg.ID,
+			&msg.ReservationID,
+			&msg.Kind,
+			&msg.Phone,
+			&msg.Body,
+			&msg.Provider,
+			&msg.Ref,
+			&msg.Status,
+			&msg.Error,
+			&msg.CreatedAt,
+			&msg.UpdatedAt,
+		)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+
+	if err = rows.Err(); err != nil {
+		return messages, err
+	}
+
+	return messages, nil
+}
+
+// ReservationsDueForReminder returns the confirmed reservations arriving between from and to which have a phone
+// number, whose guest did not opt out of text messages and which were not reminded yet; a reminder the provider
+// refused or could not deliver is sent again
+func (m *postgresDBRepo) ReservationsDueForReminder(from, to time.Time) ([]models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date, r.room_id, r.status,
+			r.access_code, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		left join guests g on (r.guest_id = g.id)
+		where r.status = $1 and r.deleted_at is null and r.phone <> ''
+			and r.start_date between $2 and $3
+			and coalesce(g.sms_opt_out, false) = false
+			and not exists (select 1 from sms_messages s where s.reservation_id = r.id and s.kind = $4 and s.status in ('sent', 'delivered'))
+		order by r.start_date, r.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.ReservationStatusConfirmed, from, to, models.SMSKindReminder)
+	if err != nil {
+		return reservations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r models.Reservation
+		err = rows.Scan(
+			&r.ID,
+			&r.FirstName,
+			&r.LastName,
+			&r.Email,
+			&r.Phone,
+			&r.StartDate,
+			&r.EndDate,
+			&r.RoomID,
+			&r.Status,
+			&r.AccessCode,
+			&r.Room.ID,
+			&r.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, r)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
+}
@@ -0,0 +1,66 @@
+package dbrepo
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/yj-matmul/bookings/internal/models"
+)
+
+// likeRegexp turns an ilike pattern with backslash as its escape character into the regexp postgres matches it with
+func likeRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?i)^")
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '%':
+			b.WriteString(".*")
+		case c == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+var searchEscapeTests = []struct {
+	search  string
+	matches []string
+	misses  []string
+}{
+	{"smith", []string{"John Smith"}, []string{"John Smyth"}},
+	{"%", []string{"100% sure"}, []string{"John Smith"}},
+	{"_", []string{"john_smith@here.com"}, []string{"john.smith@here.com"}},
+	{"j_hn", []string{"j_hn@here.com"}, []string{"john@here.com"}},
+	{`a\b`, []string{`a\b@here.com`}, []string{"ab@here.com"}},
+}
+
+func TestReservationConditionsSearch(t *testing.T) {
+	for _, e := range searchEscapeTests {
+		where, args := reservationConditions(models.ReservationFilter{Search: e.search})
+		if !strings.Contains(where, `ilike $1 escape '\'`) || len(args) != 1 {
+			t.Fatalf("search %q: unexpected conditions %s %v", e.search, where, args)
+		}
+
+		re := likeRegexp(args[0].(string))
+		for _, s := range e.matches {
+			if !re.MatchString(s) {
+				t.Errorf("search %q: expected to match %q", e.search, s)
+			}
+		}
+		for _, s := range e.misses {
+			if re.MatchString(s) {
+				t.Errorf("search %q: did not expect to match %q", e.search, s)
+			}
+		}
+	}
+}
@@ -2,9 +2,12 @@ package dbrepo
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/yj-matmul/bookings/internal/models"
+	"github.com/yj-matmul/bookings/internal/repository"
 )
 
 func (m *testDBRepo) AllUsers() bool {
@@ -20,11 +23,14 @@ func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
 	return 1, nil
 }
 
-// InsertRoomRestirction inserts a room restriction into the database
+// InsertRoomRestriction inserts a room restriction if the room is still free
 func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
-	// if the room id is 10001, then fail; otherwise pass
-	if r.RoomID == 10001 {
+	// if the room id is 10001, then fail; room 1000 was taken in the meantime
+	switch r.RoomID {
+	case 10001:
 		return errors.New("some error")
+	case 1000:
+		return repository.ErrRoomNotAvailable
 	}
 	return nil
 }
@@ -34,6 +40,10 @@ func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roo
 	if roomID == 10000 {
 		return false, errors.New("some error")
 	}
+	// every room is taken in 2049
+	if start.Year() == 2049 {
+		return false, nil
+	}
 	return true, nil
 }
 
@@ -53,8 +63,9 @@ func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mode
 	}
 
 	rooms = append(rooms, models.Room{
-		ID:       1,
-		RoomName: "General's Quarters",
+		ID:        1,
+		RoomName:  "General's Quarters",
+		MaxGuests: 2,
 	})
 
 	return rooms, nil
@@ -67,6 +78,17 @@ func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 		return room, errors.New("some error")
 	}
 
+	// room 2 has to be paid in advance
+	if id == 2 {
+		room = models.Room{
+			ID:             2,
+			RoomName:       "Major's Suite",
+			Price:          22000,
+			PaymentPolicy:  models.PaymentPolicyPrepay,
+			DepositPercent: 100,
+		}
+	}
+
 	return room, nil
 }
 
@@ -76,8 +98,11 @@ func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
 	return u, nil
 }
 
-// UpdateUser updates a user in the database
+// UpdateUser updates a user in the database; version 1000 is stale
 func (m *testDBRepo) UpdateUser(u models.User) error {
+	if u.Version == 1000 {
+		return repository.ErrVersionConflict
+	}
 	return nil
 }
 
@@ -96,10 +121,107 @@ func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, erro
 
 // AllReservations returns a slice of all reservations
 func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
-	var reservations []models.Reservation
+	layout := "2006-01-02"
+	start, _ := time.Parse(layout, "2050-01-02")
+
+	reservations := []models.Reservation{
+		{ID: 1, LastName: "Smith", StartDate: start, EndDate: start.AddDate(0, 0, 2), Tags: []string{"late-arrival", "vip"}},
+		{ID: 2, LastName: "Doe", StartDate: start, EndDate: start.AddDate(0, 0, 1), Tags: []string{"allergy"}},
+	}
 	return reservations, nil
 }
 
+// SearchReservations returns the reservations matching a filter; only the search, tag and id filters are applied
+func (m *testDBRepo) SearchReservations(f models.ReservationFilter) ([]models.Reservation, int, error) {
+	// room 10000 fails
+	if f.RoomID == 10000 {
+		return nil, 0, errors.New("some error")
+	}
+
+	all, _ := m.AllReservations()
+
+	var reservations []models.Reservation
+	for _, r := range all {
+		if f.Search != "" && !strings.Contains(strings.ToLower(r.FirstName+" "+r.LastName+" "+r.Email), strings.ToLower(f.Search)) {
+			continue
+		}
+		if len(f.IDs) > 0 {
+			selected := false
+			for _, id := range f.IDs {
+				selected = selected || id == r.ID
+			}
+			if !selected {
+				continue
+			}
+		}
+		if f.Tag != "" {
+			tagged := false
+			for _, t := range r.Tags {
+				tagged = tagged || t == f.Tag
+			}
+			if !tagged {
+				continue
+			}
+		}
+		reservations = append(reservations, r)
+	}
+	return reservations, len(reservations), nil
+}
+
+// RoomStats returns the totals of two rooms; periods before 2000 fail
+func (m *testDBRepo) RoomStats(start, end time.Time) ([]models.RoomStats, error) {
+	if start.Year() < 2000 {
+		return nil, errors.New("some error")
+	}
+
+	nights := int(end.Sub(start).Hours() / 24)
+	stats := []models.RoomStats{
+		{RoomID: 1, RoomName: "General's Quarters", AvailableNights: nights, OccupiedNights: 10, Revenue: 150000,
+			Bookings: 4, Cancellations: 1, LeadDays: 30, BookedNights: 9},
+		{RoomID: 2, RoomName: "Major's Suite", AvailableNights: nights, OccupiedNights: 5, Revenue: 110000,
+			Bookings: 2, LeadDays: 10, BookedNights: 5, OutOfOrderNights: 10},
+	}
+
+	// earlier periods did half as well
+	if start.Before(time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)) {
+		for i := range stats {
+			stats[i].OccupiedNights /= 2
+			stats[i].Revenue /= 2
+		}
+	}
+	return stats, nil
+}
+
+// EachReservation calls fn for the reservations matching a filter
+func (m *testDBRepo) EachReservation(f models.ReservationFilter, fn func(models.Reservation) error) error {
+	reservations, _, err := m.SearchReservations(f)
+	if err != nil {
+		return err
+	}
+	for _, r := range reservations {
+		err = fn(r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EachMovement calls fn for an arrival and a departure
+func (m *testDBRepo) EachMovement(start, end time.Time, fn func(kind string, r models.Reservation) error) error {
+	// ranges starting in 2000 fail
+	if start.Year() == 2000 {
+		return errors.New("some error")
+	}
+
+	all, _ := m.AllReservations()
+	err := fn("arrival", all[0])
+	if err != nil {
+		return err
+	}
+	return fn("departure", all[1])
+}
+
 // AllNewReservations returns a slice of all new reservations
 func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
 	var reservations []models.Reservation
@@ -109,16 +231,47 @@ func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
 // GetReservationByID returns one reservation by ID
 func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
 	var res models.Reservation
+	res.Version = 1
 	return res, nil
 }
 
-// UpdateUser updates a reservation in the database
+// UpdateReservation updates a reservation in the database; version 1000 is stale
 func (m *testDBRepo) UpdateReservation(r models.Reservation) error {
+	if r.Version == 1000 {
+		return repository.ErrVersionConflict
+	}
 	return nil
 }
 
-// DeleteReservation deletes one reservation by id
+// MoveReservation changes the dates and room of a reservation
+func (m *testDBRepo) MoveReservation(r models.Reservation) error {
+	// version 1000 is stale, room 10000 fails, room 10001 is taken for the new dates
+	if r.Version == 1000 {
+		return repository.ErrVersionConflict
+	}
+	// room 2 is not free, so a move to it which was not priced again fails
+	if r.RoomID == 2 && r.TotalAmount == 0 {
+		return errors.New("move was not priced")
+	}
+	if r.RoomID == 10000 {
+		return errors.New("some error")
+	}
+	if r.RoomID == 10001 {
+		return repository.ErrRoomNotAvailable
+	}
+	return nil
+}
+
+// DeleteReservation moves one reservation by id to the trash; id 1000 fails
 func (m *testDBRepo) DeleteReservation(id int) error {
+	if id == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// DiscardReservation deletes a reservation which could not get its room
+func (m *testDBRepo) DiscardReservation(id int) error {
 	return nil
 }
 
@@ -127,12 +280,29 @@ func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
 	return nil
 }
 
+// CheckInReservation records that the guest of a reservation has arrived; id 1000 fails
+func (m *testDBRepo) CheckInReservation(id int) error {
+	if id == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// CheckOutReservation records that the guest of a reservation has left; id 1000 fails
+func (m *testDBRepo) CheckOutReservation(id int) error {
+	if id == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
 // AllRooms returns all rooms
 func (m *testDBRepo) AllRooms() ([]models.Room, error) {
 	var rooms []models.Room
 	rooms = append(rooms, models.Room{
-		ID:       1,
-		RoomName: "General's Quarters",
+		ID:            1,
+		RoomName:      "General's Quarters",
+		BlocksVersion: 1,
 	})
 	return rooms, nil
 }
@@ -151,15 +321,17 @@ func (m *testDBRepo) GetRestrictionsForRoomByDate(roomID int, start, end time.Ti
 		EndDate:       endDate1,
 		RoomID:        1,
 		ReservationID: 1,
-		RestrictionID: 1,
+		RestrictionID: models.RestrictionReservation,
 	}, models.RoomRestriction{
 		ID:            1,
 		StartDate:     startDate2,
 		EndDate:       endDate2,
 		RoomID:        1,
 		ReservationID: 0,
-		RestrictionID: 2,
+		RestrictionID: models.RestrictionOwnerBlock,
 	})
+	outOfOrder, _ := m.OutOfOrderPeriods(time.Time{})
+	restrictions = append(restrictions, outOfOrder...)
 	return restrictions, nil
 }
 
@@ -168,7 +340,879 @@ func (m *testDBRepo) InsertBlockForRoom(id int, startDate time.Time) error {
 	return nil
 }
 
-// DeleteBlockByID deletes a room restriction
+// DeleteBlockByID moves an owner block to the trash
 func (m *testDBRepo) DeleteBlockByID(id int) error {
 	return nil
 }
+
+// UpdateStatusForReservation updates the status of a reservation by id
+func (m *testDBRepo) UpdateStatusForReservation(id int, status string) error {
+	return nil
+}
+
+// UpdatePendingReservationStatus moves a reservation which is still pending payment to status
+func (m *testDBRepo) UpdatePendingReservationStatus(id int, status string) (bool, error) {
+	if id == 10000 {
+		return false, errors.New("some error")
+	}
+	return true, nil
+}
+
+// InsertPayment inserts a payment into the database
+func (m *testDBRepo) InsertPayment(p models.Payment) (int, error) {
+	if p.ReservationID == 10000 {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// UpdatePaymentStatus updates the status of a payment by its provider reference
+func (m *testDBRepo) UpdatePaymentStatus(ref, status, message string) error {
+	return nil
+}
+
+// GetPaymentsByProviderRef returns the payments made with one gateway transaction
+func (m *testDBRepo) GetPaymentsByProviderRef(ref string) ([]models.Payment, error) {
+	var payments []models.Payment
+	if ref == "unknown" {
+		return payments, errors.New("some error")
+	}
+
+	// the reservation of fake_10000 can't be updated; fake_captured was captured already and has a failed refund
+	p := models.Payment{
+		ID:            1,
+		ReservationID: 1,
+		Provider:      "fake",
+		ProviderRef:   ref,
+		Status:        "authorized",
+	}
+	switch ref {
+	case "fake_10000":
+		p.ReservationID = 10000
+	case "fake_captured":
+		p.ReservationID = 10000
+		p.Status = "captured"
+		payments = append(payments, p)
+		p.ID = 2
+		p.Amount = -5000
+		p.Status = "failed"
+	}
+
+	payments = append(payments, p)
+	return payments, nil
+}
+
+// GetPaymentsByReservationID returns all payments of a reservation
+func (m *testDBRepo) GetPaymentsByReservationID(reservationID int) ([]models.Payment, error) {
+	var payments []models.Payment
+	// reservation 1002 was paid through a transaction the gateway doesn't know, so its refund fails
+	if reservationID == 1002 {
+		payments = append(payments, models.Payment{
+			ID:            1,
+			ReservationID: 1002,
+			Provider:      "fake",
+			ProviderRef:   "fake_unknown",
+			Amount:        15000,
+			Status:        "captured",
+		})
+	}
+	return payments, nil
+}
+
+// FailedRefunds returns the refunds the gateway failed to make
+func (m *testDBRepo) FailedRefunds() ([]models.Payment, error) {
+	var payments []models.Payment
+	payments = append(payments, models.Payment{
+		ID:            2,
+		ReservationID: 1002,
+		Provider:      "fake",
+		ProviderRef:   "fake_unknown",
+		Amount:        5000,
+		Status:        "refund_failed",
+	})
+	return payments, nil
+}
+
+// UpdatePaymentByID updates the status and message of one payment
+func (m *testDBRepo) UpdatePaymentByID(id int, status, message string) error {
+	return nil
+}
+
+// GetReservationByAccessCode returns one reservation by the access code sent to the guest
+func (m *testDBRepo) GetReservationByAccessCode(code string) (models.Reservation, error) {
+	var res models.Reservation
+	if code == "unknown" {
+		return res, errors.New("some error")
+	}
+
+	start, _ := time.Parse("2006-01-02", "2050-01-02")
+	res = models.Reservation{
+		ID:          1,
+		FirstName:   "John",
+		LastName:    "Smith",
+		StartDate:   start,
+		EndDate:     start.AddDate(0, 0, 1),
+		RoomID:      1,
+		Room:        models.Room{ID: 1, RoomName: "General's Quarters", CancellationPolicyID: 2},
+		Status:      models.ReservationStatusConfirmed,
+		TotalAmount: 15000,
+		AccessCode:  code,
+	}
+
+	switch code {
+	case "cancelled":
+		res.Status = models.ReservationStatusCancelled
+	case "cancelling":
+		res.ID = 1001
+	}
+
+	return res, nil
+}
+
+// GetCancellationPolicyByID returns a cancellation policy by id
+func (m *testDBRepo) GetCancellationPolicyByID(id int) (models.CancellationPolicy, error) {
+	var p models.CancellationPolicy
+	if id != 2 {
+		return p, errors.New("some error")
+	}
+
+	p = models.CancellationPolicy{
+		ID:                   2,
+		Name:                 "moderate",
+		FullRefundDays:       5,
+		PartialRefundDays:    1,
+		PartialRefundPercent: 50,
+	}
+	return p, nil
+}
+
+// CancelReservation marks a reservation as cancelled and frees its room
+func (m *testDBRepo) CancelReservation(id, refundAmount int) error {
+	// reservation 1001 was cancelled by someone else in the meantime
+	if id == 1001 {
+		return repository.ErrAlreadyCancelled
+	}
+	return nil
+}
+
+// InsertInvoice stores an invoice with its lines under the next invoice number
+func (m *testDBRepo) InsertInvoice(inv models.Invoice) (models.Invoice, error) {
+	if inv.ReservationID == 10000 {
+		return inv, errors.New("some error")
+	}
+	inv.ID = 1
+	inv.Number = 1
+	inv.IssuedAt = time.Now()
+	return inv, nil
+}
+
+// GetInvoiceByID returns one invoice with its lines
+func (m *testDBRepo) GetInvoiceByID(id int) (models.Invoice, error) {
+	var inv models.Invoice
+	if id > 2 {
+		return inv, errors.New("some error")
+	}
+
+	inv = models.Invoice{
+		ID:            id,
+		Number:        id,
+		Kind:          models.InvoiceKindInvoice,
+		ReservationID: 1,
+		BillToName:    "John Smith",
+		Total:         15000,
+		IssuedAt:      time.Now(),
+		Lines: []models.InvoiceLine{
+			{ID: 1, InvoiceID: id, Kind: models.InvoiceLineNights, Description: "General's Quarters", Quantity: 1, UnitAmount: 15000, Amount: 15000},
+		},
+	}
+
+	// invoice 2 belongs to another reservation
+	if id == 2 {
+		inv.ReservationID = 2
+	}
+
+	return inv, nil
+}
+
+// GetInvoicesByReservationID returns the invoices and credit notes of a reservation, without lines
+func (m *testDBRepo) GetInvoicesByReservationID(reservationID int) ([]models.Invoice, error) {
+	var invoices []models.Invoice
+
+	// reservation 1 has an open invoice
+	if reservationID == 1 {
+		invoices = append(invoices, models.Invoice{
+			ID:            1,
+			Number:        1,
+			Kind:          models.InvoiceKindInvoice,
+			ReservationID: 1,
+			Total:         15000,
+			IssuedAt:      time.Now(),
+		})
+	}
+
+	return invoices, nil
+}
+
+// AllTaxFeeRules returns all tax and fee rules
+func (m *testDBRepo) AllTaxFeeRules() ([]models.TaxFeeRule, error) {
+	rules := []models.TaxFeeRule{
+		{ID: 1, Name: "VAT", Kind: models.InvoiceLineTax, Basis: models.TaxFeeBasisPercent, Amount: 1000},
+		{ID: 2, Name: "Tourist tax", Kind: models.InvoiceLineTax, Basis: models.TaxFeeBasisPerGuestNight, Amount: 250},
+		{ID: 3, Name: "Cleaning fee", Kind: models.InvoiceLineFee, Basis: models.TaxFeeBasisPerStay, Amount: 3000},
+	}
+	return rules, nil
+}
+
+// GetTaxFeeRuleByID returns one tax or fee rule by id
+func (m *testDBRepo) GetTaxFeeRuleByID(id int) (models.TaxFeeRule, error) {
+	var rule models.TaxFeeRule
+	if id < 1 || id > 3 {
+		return rule, errors.New("some error")
+	}
+
+	rules, _ := m.AllTaxFeeRules()
+	return rules[id-1], nil
+}
+
+// InsertTaxFeeRule inserts a tax or fee rule into the database
+func (m *testDBRepo) InsertTaxFeeRule(rule models.TaxFeeRule) (int, error) {
+	return 4, nil
+}
+
+// UpdateTaxFeeRule updates a tax or fee rule; charges already on reservations keep their amounts
+func (m *testDBRepo) UpdateTaxFeeRule(rule models.TaxFeeRule) error {
+	return nil
+}
+
+// DeleteTaxFeeRule deletes a tax or fee rule by id
+func (m *testDBRepo) DeleteTaxFeeRule(id int) error {
+	return nil
+}
+
+// ChargeTotals returns the taxes and fees charged on reservations which were not cancelled, grouped by description
+func (m *testDBRepo) ChargeTotals(start, end time.Time) ([]models.ReservationCharge, error) {
+	totals := []models.ReservationCharge{
+		{Kind: models.InvoiceLineTax, Description: "Tourist tax", Quantity: 4, Amount: 1000},
+	}
+	return totals, nil
+}
+
+// InsertBooking checks that all rooms of a booking are free and stores the booking with its reservations
+func (m *testDBRepo) InsertBooking(b models.Booking, holdID int) (models.Booking, error) {
+	for i, res := range b.Reservations {
+		// room 10000 fails, room 10001 has been taken in the meantime
+		switch res.RoomID {
+		case 10000:
+			return b, errors.New("some error")
+		case 10001:
+			return b, repository.ErrRoomNotAvailable
+		}
+		b.Reservations[i].ID = i + 1
+		b.Reservations[i].BookingID = 1
+	}
+
+	b.ID = 1
+	return b, nil
+}
+
+// ImportReservations stores imported reservations
+func (m *testDBRepo) ImportReservations(reservations []models.Reservation) error {
+	// guests called Taken find their room gone, guests called Fail cannot be stored
+	for i, res := range reservations {
+		if res.LastName == "Taken" {
+			return &repository.RowError{Index: i, Err: repository.ErrRoomNotAvailable}
+		}
+		if res.LastName == "Fail" {
+			return &repository.RowError{Index: i, Err: errors.New("some error")}
+		}
+	}
+	return nil
+}
+
+// GetBookingByAccessCode returns a booking with all of its reservations
+func (m *testDBRepo) GetBookingByAccessCode(code string) (models.Booking, error) {
+	var b models.Booking
+	if code == "unknown" {
+		return b, errors.New("some error")
+	}
+
+	start, _ := time.Parse("2006-01-02", "2050-01-02")
+	b = models.Booking{
+		ID:         1,
+		FirstName:  "John",
+		LastName:   "Smith",
+		Email:      "john@smith.com",
+		AccessCode: code,
+	}
+
+	for i, name := range []string{"General's Quarters", "Major's Suite"} {
+		b.Reservations = append(b.Reservations, models.Reservation{
+			ID:          i + 1,
+			FirstName:   b.FirstName,
+			LastName:    b.LastName,
+			Email:       b.Email,
+			StartDate:   start,
+			EndDate:     start.AddDate(0, 0, 1),
+			RoomID:      i + 1,
+			Room:        models.Room{ID: i + 1, RoomName: name},
+			Status:      models.ReservationStatusConfirmed,
+			TotalAmount: 15000,
+			AccessCode:  fmt.Sprintf("%s-%d", code, i+1),
+			BookingID:   1,
+		})
+	}
+
+	// in booking "partly-cancelled" the second room has been cancelled already
+	if code == "partly-cancelled" {
+		b.Reservations[1].Status = models.ReservationStatusCancelled
+	}
+
+	return b, nil
+}
+
+// InsertHold holds a room until the hold expires
+func (m *testDBRepo) InsertHold(r models.RoomRestriction) (int, error) {
+	// room 10000 fails, room 10001 is held by someone else
+	if r.RoomID == 10000 {
+		return 0, errors.New("some error")
+	}
+	if r.RoomID == 10001 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+	return 1, nil
+}
+
+// ConvertHold turns a hold into the restriction of a reservation
+func (m *testDBRepo) ConvertHold(holdID int, res models.Reservation) error {
+	// hold 1 is on room 10001, hold 2 has expired
+	if holdID == 2 || (holdID == 1 && res.RoomID != 10001) {
+		return repository.ErrHoldExpired
+	}
+	return nil
+}
+
+// DeleteHoldByID releases a hold
+func (m *testDBRepo) DeleteHoldByID(id int) error {
+	return nil
+}
+
+// DeleteExpiredHolds deletes all expired holds
+func (m *testDBRepo) DeleteExpiredHolds() (int, error) {
+	return 0, nil
+}
+
+// InsertWaitlistEntry puts a guest on the waitlist
+func (m *testDBRepo) InsertWaitlistEntry(e models.WaitlistEntry) (int, error) {
+	// fail when the guest's email is fail@here.com
+	if e.Email == "fail@here.com" {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// OpenWaitlistEntries returns the open waitlist entries
+func (m *testDBRepo) OpenWaitlistEntries() ([]models.WaitlistEntry, error) {
+	layout := "2006-01-02"
+	start, _ := time.Parse(layout, "2050-01-02")
+	soldOut, _ := time.Parse(layout, "2021-08-11")
+
+	return []models.WaitlistEntry{
+		{ID: 1, Email: "first@here.com", StartDate: start, EndDate: start.AddDate(0, 0, 2), Guests: 2,
+			Status: models.WaitlistStatusWaiting, Token: "first"},
+		{ID: 2, Email: "sold-out@here.com", StartDate: soldOut, EndDate: soldOut.AddDate(0, 0, 1), Guests: 1,
+			Status: models.WaitlistStatusWaiting, Token: "sold-out"},
+		{ID: 3, Email: "party@here.com", StartDate: start.AddDate(0, 0, 10), EndDate: start.AddDate(0, 0, 12), Guests: 6,
+			Status: models.WaitlistStatusWaiting, Token: "party"},
+	}, nil
+}
+
+// GetWaitlistEntryByToken returns the waitlist entry a booking link was sent for
+func (m *testDBRepo) GetWaitlistEntryByToken(token string) (models.WaitlistEntry, error) {
+	layout := "2006-01-02"
+	start, _ := time.Parse(layout, "2050-01-02")
+	e := models.WaitlistEntry{
+		ID:             1,
+		Email:          "first@here.com",
+		StartDate:      start,
+		EndDate:        start.AddDate(0, 0, 2),
+		Guests:         2,
+		Status:         models.WaitlistStatusNotified,
+		Token:          token,
+		OfferExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	switch token {
+	case "unknown":
+		return e, errors.New("some error")
+	case "expired":
+		e.OfferExpiresAt = time.Now().Add(-time.Hour)
+	case "booked":
+		e.Status = models.WaitlistStatusBooked
+	case "party":
+		e.Guests = 6
+	}
+
+	return e, nil
+}
+
+// MarkWaitlistEntryNotified records that a booking link was sent
+func (m *testDBRepo) MarkWaitlistEntryNotified(id int, offerExpiresAt time.Time) error {
+	return nil
+}
+
+// ExpireWaitlistOffers marks the offers which ran out unused as expired
+func (m *testDBRepo) ExpireWaitlistOffers() (int, error) {
+	return 1, nil
+}
+
+// UpdateWaitlistEntryStatus updates the status of a waitlist entry by id
+func (m *testDBRepo) UpdateWaitlistEntryStatus(id int, status string) error {
+	return nil
+}
+
+// AllGuests returns all guests
+func (m *testDBRepo) AllGuests() ([]models.Guest, error) {
+	return []models.Guest{
+		{ID: 1, FirstName: "John", LastName: "Smith", Email: "john@smith.com", Flag: models.GuestFlagVIP, Stays: 2},
+		{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@doe.com", Flag: models.GuestFlagDoNotRent, Stays: 1},
+	}, nil
+}
+
+// EachGuest calls fn for every guest
+func (m *testDBRepo) EachGuest(fn func(models.Guest) error) error {
+	guests, _ := m.AllGuests()
+	for _, g := range guests {
+		err := fn(g)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetGuestByID returns a guest with their reservations
+func (m *testDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	if id < 1 || id > 2 {
+		return models.Guest{}, errors.New("some error")
+	}
+
+	layout := "2006-01-02"
+	past, _ := time.Parse(layout, "2021-08-11")
+	upcoming, _ := time.Parse(layout, "2050-01-02")
+
+	g := models.Guest{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		Flag:      models.GuestFlagVIP,
+		Notes:     "Celebrates anniversary in August",
+		Reservations: []models.Reservation{
+			{ID: 2, StartDate: upcoming, EndDate: upcoming.AddDate(0, 0, 2), Status: models.ReservationStatusConfirmed,
+				Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+			{ID: 1, StartDate: past, EndDate: past.AddDate(0, 0, 1), Status: models.ReservationStatusConfirmed,
+				Room: models.Room{ID: 2, RoomName: "Major's Suite"}},
+		},
+		Stays: 2,
+	}
+	if id == 2 {
+		g.ID = 2
+		g.FirstName = "Jane"
+		g.LastName = "Doe"
+		g.Email = "jane@doe.com"
+		g.Flag = models.GuestFlagDoNotRent
+		g.SMSOptOut = true
+		g.Reservations = g.Reservations[1:]
+		g.Stays = 1
+	}
+
+	return g, nil
+}
+
+// GetGuestByEmail returns the guest with an email
+func (m *testDBRepo) GetGuestByEmail(email string) (models.Guest, error) {
+	switch email {
+	case "john@smith.com":
+		return models.Guest{ID: 1, Email: email, Flag: models.GuestFlagVIP}, nil
+	case "jane@doe.com":
+		return models.Guest{ID: 2, Email: email, Flag: models.GuestFlagDoNotRent, SMSOptOut: true}, nil
+	}
+	return models.Guest{}, errors.New("some error")
+}
+
+// UpdateGuest updates a guest
+func (m *testDBRepo) UpdateGuest(g models.Guest) error {
+	return nil
+}
+
+// GetNotesByReservationID returns the internal notes on a reservation
+func (m *testDBRepo) GetNotesByReservationID(reservationID int) ([]models.ReservationNote, error) {
+	return []models.ReservationNote{
+		{ID: 1, ReservationID: reservationID, UserID: 1, Author: "Admin User", Body: "Arrives after midnight"},
+	}, nil
+}
+
+// InsertReservationNote adds an internal note to a reservation
+func (m *testDBRepo) InsertReservationNote(n models.ReservationNote) (int, error) {
+	// fail for reservation 10000
+	if n.ReservationID == 10000 {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// SetReservationTags replaces the tags of a reservation
+func (m *testDBRepo) SetReservationTags(reservationID int, tags []string) error {
+	return nil
+}
+
+// GenerateHousekeepingTasks adds the cleaning tasks of a day; days in 2000 fail
+func (m *testDBRepo) GenerateHousekeepingTasks(day time.Time, serviceEvery int) error {
+	if day.Year() == 2000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// HousekeepingTasksByDay returns a departure cleaning of room 1
+func (m *testDBRepo) HousekeepingTasksByDay(day time.Time) ([]models.HousekeepingTask, error) {
+	return []models.HousekeepingTask{
+		{ID: 1, RoomID: 1, ReservationID: 1, Day: day, Kind: models.HousekeepingTaskDeparture,
+			Status: models.HousekeepingStatusDirty, Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+	}, nil
+}
+
+// UpsertHousekeepingTask adds or updates a cleaning task; room 10000 fails
+func (m *testDBRepo) UpsertHousekeepingTask(t models.HousekeepingTask) error {
+	if t.RoomID == 10000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// UpdateHousekeepingTaskStatus sets the status of a cleaning task; id 1000 fails
+func (m *testDBRepo) UpdateHousekeepingTaskStatus(id int, status string) error {
+	if id == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// HousekeepingStatuses returns room 1 as dirty on 2050-01-02 and inspected on other days
+func (m *testDBRepo) HousekeepingStatuses(day time.Time) (map[int]string, error) {
+	if day.Format("2006-01-02") == "2050-01-02" {
+		return map[int]string{1: models.HousekeepingStatusDirty}, nil
+	}
+	return map[int]string{1: models.HousekeepingStatusInspected}, nil
+}
+
+// InsertOutOfOrder takes a room out of order; room 10000 fails and room 10001 is booked
+func (m *testDBRepo) InsertOutOfOrder(r models.RoomRestriction) (int, error) {
+	if r.RoomID == 10000 {
+		return 0, errors.New("some error")
+	}
+	if r.RoomID == 10001 {
+		return 0, repository.ErrRoomNotAvailable
+	}
+	return 1, nil
+}
+
+// InsertBlock blocks a room for the owner; room 10000 fails and room 10001 is booked
+func (m *testDBRepo) InsertBlock(r models.RoomRestriction) (int, error) {
+	return m.InsertOutOfOrder(r)
+}
+
+// UpdateOutOfOrder changes an out-of-order period; room 10000 fails and room 10001 is booked
+func (m *testDBRepo) UpdateOutOfOrder(r models.RoomRestriction) error {
+	_, err := m.InsertOutOfOrder(r)
+	return err
+}
+
+// GetOutOfOrderByID returns an out-of-order period of room 1; id 1000 fails
+func (m *testDBRepo) GetOutOfOrderByID(id int) (models.RoomRestriction, error) {
+	if id == 1000 {
+		return models.RoomRestriction{}, errors.New("some error")
+	}
+	periods, _ := m.OutOfOrderPeriods(time.Time{})
+	r := periods[0]
+	r.ID = id
+	return r, nil
+}
+
+// OutOfOrderPeriods returns a leaking shower in room 1; days in 2000 fail
+func (m *testDBRepo) OutOfOrderPeriods(from time.Time) ([]models.RoomRestriction, error) {
+	if from.Year() == 2000 {
+		return nil, errors.New("some error")
+	}
+	layout := "2006-01-02"
+	start, _ := time.Parse(layout, "2050-01-06")
+	end, _ := time.Parse(layout, "2050-01-09")
+	return []models.RoomRestriction{
+		{ID: 2, StartDate: start, EndDate: end, RoomID: 1, RestrictionID: models.RestrictionOutOfOrder,
+			Reason: "Leaking shower", AssignedTo: "Bob", Status: models.OutOfOrderStatusOpen,
+			Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+	}, nil
+}
+
+// DeleteOutOfOrder puts a room back in service; id 1000 fails
+func (m *testDBRepo) DeleteOutOfOrder(id int) error {
+	if id == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// UpdateBlocks changes the blocks of a room; any version but 1 is stale, room 10000 fails and room 10001 is booked
+func (m *testDBRepo) UpdateBlocks(roomID, version int, add []time.Time, remove []int) error {
+	if version != 1 {
+		return repository.ErrVersionConflict
+	}
+	if roomID == 10000 {
+		return errors.New("some error")
+	}
+	if roomID == 10001 {
+		return repository.ErrRoomNotAvailable
+	}
+	return nil
+}
+
+// TrashedReservations returns one reservation deleted a day ago
+func (m *testDBRepo) TrashedReservations() ([]models.Reservation, error) {
+	layout := "2006-01-02"
+	start, _ := time.Parse(layout, "2050-01-02")
+	end, _ := time.Parse(layout, "2050-01-03")
+	return []models.Reservation{{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		StartDate: start,
+		EndDate:   end,
+		RoomID:    1,
+		DeletedAt: time.Now().AddDate(0, 0, -1),
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+	}}, nil
+}
+
+// TrashedBlocks returns one owner block deleted a day ago
+func (m *testDBRepo) TrashedBlocks() ([]models.RoomRestriction, error) {
+	layout := "2006-01-02"
+	start, _ := time.Parse(layout, "2050-01-06")
+	end, _ := time.Parse(layout, "2050-01-07")
+	return []models.RoomRestriction{{
+		ID:            3,
+		StartDate:     start,
+		EndDate:       end,
+		RoomID:        1,
+		RestrictionID: models.RestrictionOwnerBlock,
+		DeletedAt:     time.Now().AddDate(0, 0, -1),
+		Room:          models.Room{ID: 1, RoomName: "General's Quarters"},
+		Restriction:   models.Restriction{ID: models.RestrictionOwnerBlock, RestrictionName: "Owner block"},
+	}}, nil
+}
+
+// RestoreReservation takes a reservation out of the trash; id 1000 fails and id 1001 is booked over
+func (m *testDBRepo) RestoreReservation(id int) error {
+	if id == 1000 {
+		return errors.New("some error")
+	}
+	if id == 1001 {
+		return repository.ErrRoomNotAvailable
+	}
+	return nil
+}
+
+// RestoreBlock takes an owner block out of the trash; id 1000 fails and id 1001 is booked over
+func (m *testDBRepo) RestoreBlock(id int) error {
+	return m.RestoreReservation(id)
+}
+
+// PurgeTrash deletes nothing
+func (m *testDBRepo) PurgeTrash(before time.Time) (int, error) {
+	return 0, nil
+}
+
+// RunBulkAction records a batch as id 1; reservation 1000 fails the whole batch and reservation 1001 fails on its own
+func (m *testDBRepo) RunBulkAction(b models.BulkAction) (models.BulkAction, error) {
+	for i, item := range b.Items {
+		if item.ReservationID == 1000 {
+			return b, errors.New("some error")
+		}
+		if item.OK && item.ReservationID == 1001 {
+			b.Items[i].OK = false
+			b.Items[i].Message = "Already cancelled"
+		}
+		// reservation 1002 was paid
+		if item.OK && item.ReservationID == 1002 && b.Action == models.BulkActionCancel {
+			b.Items[i].OK = false
+			b.Items[i].Message = "Paid reservations are cancelled one at a time, so the guest is refunded"
+		}
+	}
+	b.ID = 1
+	b.CreatedAt = time.Now()
+	return b, nil
+}
+
+// GetBulkActionByID returns a cancellation of two reservations, one of which failed; id 1000 fails
+func (m *testDBRepo) GetBulkActionByID(id int) (models.BulkAction, error) {
+	if id == 1000 {
+		return models.BulkAction{}, errors.New("some error")
+	}
+	return models.BulkAction{
+		ID:        id,
+		UserID:    1,
+		Author:    "Admin User",
+		Action:    models.BulkActionCancel,
+		CreatedAt: time.Now(),
+		Items: []models.BulkActionItem{
+			{ReservationID: 1, Guest: "John Smith", OK: true},
+			{ReservationID: 2, Guest: "Jane Doe", Message: "Already cancelled"},
+		},
+	}, nil
+}
+
+// RecentBulkActions returns the batch of GetBulkActionByID
+func (m *testDBRepo) RecentBulkActions(limit int) ([]models.BulkAction, error) {
+	b, _ := m.GetBulkActionByID(1)
+	return []models.BulkAction{b}, nil
+}
+
+// AllMessageTemplates returns two message templates
+func (m *testDBRepo) AllMessageTemplates() ([]models.MessageTemplate, error) {
+	templates := []models.MessageTemplate{
+		{ID: 1, Name: "Directions", Subject: "How to find us", Body: "Dear {first_name},\nturn left at the lighthouse."},
+		{ID: 2, Name: "Parking", Subject: "Parking for {room}", Body: "Dear {first_name},\nthe car park is closed on {arrival}."},
+	}
+	return templates, nil
+}
+
+// GetMessageTemplateByID returns one message template by id; ids other than 1 and 2 fail
+func (m *testDBRepo) GetMessageTemplateByID(id int) (models.MessageTemplate, error) {
+	var t models.MessageTemplate
+	if id < 1 || id > 2 {
+		return t, errors.New("some error")
+	}
+
+	templates, _ := m.AllMessageTemplates()
+	return templates[id-1], nil
+}
+
+// InsertMessageTemplate inserts a message template into the database
+func (m *testDBRepo) InsertMessageTemplate(t models.MessageTemplate) (int, error) {
+	return 3, nil
+}
+
+// UpdateMessageTemplate updates a message template
+func (m *testDBRepo) UpdateMessageTemplate(t models.MessageTemplate) error {
+	return nil
+}
+
+// DeleteMessageTemplate deletes a message template by id; id 1000 fails
+func (m *testDBRepo) DeleteMessageTemplate(id int) error {
+	if id == 1000 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// InsertReservationMessage records an email sent to a guest; reservation 10000 fails
+func (m *testDBRepo) InsertReservationMessage(msg models.ReservationMessage) (int, error) {
+	if msg.ReservationID == 10000 {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// GetMessagesByReservationID returns one email sent to the guest
+func (m *testDBRepo) GetMessagesByReservationID(reservationID int) ([]models.ReservationMessage, error) {
+	return []models.ReservationMessage{
+		{ID: 1, ReservationID: reservationID, UserID: 1, Author: "Admin User", Recipient: "john@smith.com",
+			Subject: "How to find us", Body: "Dear John,\nturn left at the lighthouse.", CreatedAt: time.Now()},
+	}, nil
+}
+
+// AddInboundMessage adds a message from a guest to a conversation; guests named "fail" fail
+func (m *testDBRepo) AddInboundMessage(c models.Conversation, msg models.ConversationMessage) (int, error) {
+	if c.Name == "fail" {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// AllConversations returns the conversation of GetConversationByID
+func (m *testDBRepo) AllConversations() ([]models.Conversation, error) {
+	c, _ := m.GetConversationByID(1)
+	return []models.Conversation{c}, nil
+}
+
+// GetConversationByID returns one conversation with a question and a reply; id 1000 fails
+func (m *testDBRepo) GetConversationByID(id int) (models.Conversation, error) {
+	if id == 1000 {
+		return models.Conversation{}, errors.New("some error")
+	}
+
+	return models.Conversation{
+		ID:            id,
+		Token:         "0a1b2c",
+		GuestID:       1,
+		ReservationID: 1,
+		Name:          "John Smith",
+		Email:         "john@smith.com",
+		Subject:       "Parking",
+		Unread:        1,
+		LastMessageAt: time.Now(),
+		Messages: []models.ConversationMessage{
+			{ID: 1, ConversationID: id, Direction: models.ConversationMessageIn, Body: "Is there parking?",
+				CreatedAt: time.Now()},
+			{ID: 2, ConversationID: id, Direction: models.ConversationMessageOut, UserID: 1, Author: "Admin User",
+				Body: "Yes, behind the house.", CreatedAt: time.Now()},
+		},
+	}, nil
+}
+
+// MarkConversationRead marks the messages of a conversation as read
+func (m *testDBRepo) MarkConversationRead(id int) error {
+	return nil
+}
+
+// InsertConversationReply adds a reply to a conversation; conversation 1001 fails
+func (m *testDBRepo) InsertConversationReply(msg models.ConversationMessage) (int, error) {
+	if msg.ConversationID == 1001 {
+		return 0, errors.New("some error")
+	}
+	return 3, nil
+}
+
+// InsertSMSMessage records a text message sent to a guest; reservation 10000 fails
+func (m *testDBRepo) InsertSMSMessage(msg models.SMSMessage) (int, error) {
+	if msg.ReservationID == 10000 {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// UpdateSMSStatus records a delivery report; ref fail fails
+func (m *testDBRepo) UpdateSMSStatus(provider, ref, status, message string) error {
+	if ref == "fail" {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// GetSMSMessagesByReservationID returns one confirmation sent to the guest
+func (m *testDBRepo) GetSMSMessagesByReservationID(reservationID int) ([]models.SMSMessage, error) {
+	return []models.SMSMessage{
+		{ID: 1, ReservationID: reservationID, Kind: models.SMSKindConfirmation, Phone: "+15555550111",
+			Body: "Your reservation is confirmed.", Provider: "fake", Ref: "fake_sms_1", Status: "sent", CreatedAt: time.Now()},
+	}, nil
+}
+
+// ReservationsDueForReminder returns two reservations arriving tomorrow, one of them with a number which can't be texted
+func (m *testDBRepo) ReservationsDueForReminder(from, to time.Time) ([]models.Reservation, error) {
+	start := from.AddDate(0, 0, 1)
+	return []models.Reservation{
+		{ID: 1, FirstName: "John", LastName: "Smith", Phone: "(555) 555-0111", StartDate: start, EndDate: start.AddDate(0, 0, 2),
+			RoomID: 1, Room: models.Room{ID: 1, RoomName: "General's Quarters"}, Status: models.ReservationStatusConfirmed},
+		{ID: 2, FirstName: "Jane", LastName: "Doe", Phone: "+15005550001", StartDate: start, EndDate: start.AddDate(0, 0, 1),
+			RoomID: 2, Room: models.Room{ID: 2, RoomName: "Major's Suite"}, Status: models.ReservationStatusConfirmed},
+	}, nil
+}
@@ -1,11 +1,40 @@
 package repository
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/yj-matmul/bookings/internal/models"
 )
 
+// ErrRoomNotAvailable is returned when a room of a booking was taken in the meantime
+var ErrRoomNotAvailable = errors.New("room is not available")
+
+// ErrHoldExpired is returned when a hold is converted after it expired or was swept
+var ErrHoldExpired = errors.New("hold has expired")
+
+// ErrAlreadyCancelled is returned when a reservation was cancelled by someone else in the meantime
+var ErrAlreadyCancelled = errors.New("reservation is already cancelled")
+
+// ErrVersionConflict is returned when a record was changed by someone else since it was read
+var ErrVersionConflict = errors.New("record was changed in the meantime")
+
+// RowError tells which of the rows given to a bulk insert failed; Index counts from zero
+type RowError struct {
+	Index int
+	Err   error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the reason the row failed
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
 type DatabaseRepo interface {
 	AllUsers() bool
 	InsertReservation(res models.Reservation) (int, error)
@@ -20,12 +49,111 @@ type DatabaseRepo interface {
 
 	AllReservations() ([]models.Reservation, error)
 	AllNewReservations() ([]models.Reservation, error)
+	SearchReservations(f models.ReservationFilter) ([]models.Reservation, int, error)
+	RoomStats(start, end time.Time) ([]models.RoomStats, error)
+	EachReservation(f models.ReservationFilter, fn func(models.Reservation) error) error
+	EachMovement(start, end time.Time, fn func(kind string, r models.Reservation) error) error
 	GetReservationByID(id int) (models.Reservation, error)
 	UpdateReservation(r models.Reservation) error
+	MoveReservation(r models.Reservation) error
 	DeleteReservation(id int) error
+	DiscardReservation(id int) error
 	UpdateProcessedForReservation(id, processed int) error
+	CheckInReservation(id int) error
+	CheckOutReservation(id int) error
 	AllRooms() ([]models.Room, error)
 	GetRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
 	InsertBlockForRoom(id int, startDate time.Time) error
 	DeleteBlockByID(id int) error
+	InsertBlock(r models.RoomRestriction) (int, error)
+	InsertOutOfOrder(r models.RoomRestriction) (int, error)
+	UpdateOutOfOrder(r models.RoomRestriction) error
+	GetOutOfOrderByID(id int) (models.RoomRestriction, error)
+	OutOfOrderPeriods(from time.Time) ([]models.RoomRestriction, error)
+	DeleteOutOfOrder(id int) error
+
+	UpdateStatusForReservation(id int, status string) error
+	UpdatePendingReservationStatus(id int, status string) (bool, error)
+	InsertPayment(p models.Payment) (int, error)
+	UpdatePaymentStatus(ref, status, message string) error
+	GetPaymentsByProviderRef(ref string) ([]models.Payment, error)
+	GetPaymentsByReservationID(reservationID int) ([]models.Payment, error)
+	FailedRefunds() ([]models.Payment, error)
+	UpdatePaymentByID(id int, status, message string) error
+
+	GetReservationByAccessCode(code string) (models.Reservation, error)
+	GetCancellationPolicyByID(id int) (models.CancellationPolicy, error)
+	CancelReservation(id, refundAmount int) error
+
+	InsertInvoice(inv models.Invoice) (models.Invoice, error)
+	GetInvoiceByID(id int) (models.Invoice, error)
+	GetInvoicesByReservationID(reservationID int) ([]models.Invoice, error)
+
+	AllTaxFeeRules() ([]models.TaxFeeRule, error)
+	GetTaxFeeRuleByID(id int) (models.TaxFeeRule, error)
+	InsertTaxFeeRule(rule models.TaxFeeRule) (int, error)
+	UpdateTaxFeeRule(rule models.TaxFeeRule) error
+	DeleteTaxFeeRule(id int) error
+	ChargeTotals(start, end time.Time) ([]models.ReservationCharge, error)
+
+	InsertBooking(b models.Booking, holdID int) (models.Booking, error)
+	ImportReservations(reservations []models.Reservation) error
+	GetBookingByAccessCode(code string) (models.Booking, error)
+
+	InsertHold(r models.RoomRestriction) (int, error)
+	ConvertHold(holdID int, res models.Reservation) error
+	DeleteHoldByID(id int) error
+	DeleteExpiredHolds() (int, error)
+
+	InsertWaitlistEntry(e models.WaitlistEntry) (int, error)
+	OpenWaitlistEntries() ([]models.WaitlistEntry, error)
+	GetWaitlistEntryByToken(token string) (models.WaitlistEntry, error)
+	MarkWaitlistEntryNotified(id int, offerExpiresAt time.Time) error
+	ExpireWaitlistOffers() (int, error)
+	UpdateWaitlistEntryStatus(id int, status string) error
+
+	AllGuests() ([]models.Guest, error)
+	EachGuest(fn func(models.Guest) error) error
+	GetGuestByID(id int) (models.Guest, error)
+	GetGuestByEmail(email string) (models.Guest, error)
+	UpdateGuest(g models.Guest) error
+
+	GetNotesByReservationID(reservationID int) ([]models.ReservationNote, error)
+	InsertReservationNote(n models.ReservationNote) (int, error)
+	SetReservationTags(reservationID int, tags []string) error
+
+	GenerateHousekeepingTasks(day time.Time, serviceEvery int) error
+	HousekeepingTasksByDay(day time.Time) ([]models.HousekeepingTask, error)
+	UpsertHousekeepingTask(t models.HousekeepingTask) error
+	UpdateHousekeepingTaskStatus(id int, status string) error
+	HousekeepingStatuses(day time.Time) (map[int]string, error)
+	UpdateBlocks(roomID, version int, add []time.Time, remove []int) error
+	TrashedReservations() ([]models.Reservation, error)
+	TrashedBlocks() ([]models.RoomRestriction, error)
+	RestoreReservation(id int) error
+	RestoreBlock(id int) error
+	PurgeTrash(before time.Time) (int, error)
+
+	RunBulkAction(b models.BulkAction) (models.BulkAction, error)
+	GetBulkActionByID(id int) (models.BulkAction, error)
+	RecentBulkActions(limit int) ([]models.BulkAction, error)
+
+	AllMessageTemplates() ([]models.MessageTemplate, error)
+	GetMessageTemplateByID(id int) (models.MessageTemplate, error)
+	InsertMessageTemplate(t models.MessageTemplate) (int, error)
+	UpdateMessageTemplate(t models.MessageTemplate) error
+	DeleteMessageTemplate(id int) error
+	InsertReservationMessage(msg models.ReservationMessage) (int, error)
+	GetMessagesByReservationID(reservationID int) ([]models.ReservationMessage, error)
+
+	AddInboundMessage(c models.Conversation, msg models.ConversationMessage) (int, error)
+	AllConversations() ([]models.Conversation, error)
+	GetConversationByID(id int) (models.Conversation, error)
+	MarkConversationRead(id int) error
+	InsertConversationReply(msg models.ConversationMessage) (int, error)
+
+	InsertSMSMessage(msg models.SMSMessage) (int, error)
+	GetSMSMessagesByReservationID(reservationID int) ([]models.SMSMessage, error)
+	UpdateSMSStatus(provider, ref, status, message string) error
+	ReservationsDueForReminder(from, to time.Time) ([]models.Reservation, error)
 }
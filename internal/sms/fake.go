@@ -0,0 +1,212 @@
+package sms
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SignatureHeader is the header which carries the signature of a fake delivery report
+const SignatureHeader = "X-Fake-SMS-Signature"
+
+// UndeliverableNumber is a number the fake provider refuses to send to
+const UndeliverableNumber = "+15005550001"
+
+// UnreachableNumber is a number the fake provider takes messages for, then reports them undelivered
+const UnreachableNumber = "+15005550002"
+
+// FakeProvider is an SMSSender which talks to a FakeServer over HTTP and asks it to post
+// delivery reports, signed with Secret, to ReportURL
+type FakeProvider struct {
+	BaseURL   string
+	Secret    string
+	ReportURL string
+	Client    *http.Client
+}
+
+// NewFakeProvider creates a fake provider for the stand-in running at baseURL
+func NewFakeProvider(baseURL, secret, reportURL string) *FakeProvider {
+	return &FakeProvider{
+		BaseURL:   baseURL,
+		Secret:    secret,
+		ReportURL: reportURL,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type sendRequest struct {
+	To        string `json:"to"`
+	Body      string `json:"body"`
+	ReportURL string `json:"report_url,omitempty"`
+}
+
+// Name returns the name of the provider
+func (p *FakeProvider) Name() string {
+	return "fake"
+}
+
+// Send sends a text message to a number in E.164 format
+func (p *FakeProvider) Send(to, body string) (Result, error) {
+	var result Result
+
+	payload, err := json.Marshal(sendRequest{To: to, Body: body, ReportURL: p.ReportURL})
+	if err != nil {
+		return result, err
+	}
+
+	resp, err := p.Client.Post(p.BaseURL+"/v1/messages", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return result, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return result, fmt.Errorf("sms provider returned %d: %s", resp.StatusCode, result.Message)
+	}
+
+	return result, nil
+}
+
+// VerifyReport checks the signature of a delivery report and decodes it
+func (p *FakeProvider) VerifyReport(r *http.Request) (Result, error) {
+	var result Result
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return result, err
+	}
+
+	expected := Sign(p.Secret, body)
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get(SignatureHeader))) {
+		return result, ErrInvalidSignature
+	}
+
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// FakeServer is a local stand-in for an SMS provider. It reports the delivery of every message it takes
+// ReportDelay later, like a real provider hears back from the network some time after taking a message
+type FakeServer struct {
+	Secret      string
+	ReportDelay time.Duration
+
+	mu       sync.Mutex
+	next     int
+	messages []FakeMessage
+	mux      *http.ServeMux
+}
+
+// FakeMessage is a text message the fake server accepted
+type FakeMessage struct {
+	Ref  string
+	To   string
+	Body string
+}
+
+// NewFakeServer creates a stand-in for an SMS provider which signs its delivery reports with secret
+func NewFakeServer(secret string) *FakeServer {
+	s := &FakeServer{
+		Secret:      secret,
+		ReportDelay: time.Second,
+		mux:         http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/v1/messages", s.send)
+
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Messages returns the text messages accepted so far
+func (s *FakeServer) Messages() []FakeMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]FakeMessage(nil), s.messages...)
+}
+
+func (s *FakeServer) send(w http.ResponseWriter, r *http.Request) {
+	var req sendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.To == "" || req.Body == "" {
+		writeResult(w, http.StatusBadRequest, Result{Status: StatusFailed, Message: "invalid request"})
+		return
+	}
+
+	if _, err := E164(req.To, ""); err != nil || req.To == UndeliverableNumber {
+		writeResult(w, http.StatusBadRequest, Result{Status: StatusFailed, Message: "undeliverable number"})
+		return
+	}
+
+	s.mu.Lock()
+	s.next++
+	msg := FakeMessage{Ref: fmt.Sprintf("fake_sms_%d", s.next), To: req.To, Body: req.Body}
+	s.messages = append(s.messages, msg)
+	s.mu.Unlock()
+
+	writeResult(w, http.StatusOK, Result{Ref: msg.Ref, Status: StatusSent})
+
+	if req.ReportURL != "" {
+		report := Result{Ref: msg.Ref, Status: StatusDelivered}
+		if req.To == UnreachableNumber {
+			report = Result{Ref: msg.Ref, Status: StatusUndelivered, Message: "unreachable"}
+		}
+		go func() {
+			time.Sleep(s.ReportDelay)
+			s.sendReport(req.ReportURL, report)
+		}()
+	}
+}
+
+// sendReport posts a signed delivery report to url
+func (s *FakeServer) sendReport(url string, report Result) error {
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(s.Secret, body))
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("delivery report was not accepted: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func writeResult(w http.ResponseWriter, status int, result Result) {
+	out, _ := json.Marshal(result)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
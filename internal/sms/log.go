@@ -0,0 +1,42 @@
+package sms
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// LogSender is an SMSSender for development which writes text messages to a log instead of sending them
+type LogSender struct {
+	Log *log.Logger
+
+	mu   sync.Mutex
+	next int
+}
+
+// NewLogSender creates a sender which writes to l
+func NewLogSender(l *log.Logger) *LogSender {
+	return &LogSender{Log: l}
+}
+
+// Name returns the name of the provider
+func (s *LogSender) Name() string {
+	return "log"
+}
+
+// Send writes the text message to the log
+func (s *LogSender) Send(to, body string) (Result, error) {
+	s.mu.Lock()
+	s.next++
+	ref := fmt.Sprintf("log_%d", s.next)
+	s.mu.Unlock()
+
+	s.Log.Printf("sms %s to %s: %s", ref, to, body)
+	return Result{Ref: ref, Status: StatusSent}, nil
+}
+
+// VerifyReport refuses every report, as logged messages are never delivered
+func (s *LogSender) VerifyReport(r *http.Request) (Result, error) {
+	return Result{}, ErrNoReports
+}
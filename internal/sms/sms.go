@@ -0,0 +1,79 @@
+package sms
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// statuses of a text message; a sent message becomes delivered or undelivered when the provider reports back
+const (
+	StatusSent        = "sent"
+	StatusFailed      = "failed"
+	StatusDelivered   = "delivered"
+	StatusUndelivered = "undelivered"
+)
+
+// ErrInvalidPhone is returned for a phone number which can't be written in E.164
+var ErrInvalidPhone = errors.New("invalid phone number")
+
+// ErrInvalidSignature is returned for a delivery report which was not signed by the provider
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// ErrNoReports is returned by providers which do not report deliveries
+var ErrNoReports = errors.New("provider does not report deliveries")
+
+// SMSSender is the interface every SMS provider has to implement. Send returns what the provider said when it
+// took the message; whether the message reached the phone comes later, in a report checked by VerifyReport
+type SMSSender interface {
+	Name() string
+	Send(to, body string) (Result, error)
+	VerifyReport(r *http.Request) (Result, error)
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Result is what a provider reports for a text message
+type Result struct {
+	Ref     string `json:"ref"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// E164 writes a phone number in E.164 format, like +15555550111. Numbers given without an international
+// prefix (+ or 00) are taken to be in the country with the calling code countryCode, without their trunk 0
+func E164(phone, countryCode string) (string, error) {
+	phone = strings.TrimSpace(phone)
+	phone = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "", "/", "").Replace(phone)
+
+	var digits string
+	switch {
+	case strings.HasPrefix(phone, "+"):
+		digits = phone[1:]
+	case strings.HasPrefix(phone, "00"):
+		digits = phone[2:]
+	case countryCode != "":
+		digits = strings.TrimPrefix(countryCode, "+") + strings.TrimPrefix(phone, "0")
+	default:
+		return "", ErrInvalidPhone
+	}
+
+	if len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return "", ErrInvalidPhone
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return "", ErrInvalidPhone
+		}
+	}
+
+	return "+" + digits, nil
+}
@@ -0,0 +1,139 @@
+package sms
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var e164Tests = []struct {
+	phone       string
+	countryCode string
+	expected    string
+	valid       bool
+}{
+	{"+1 (555) 555-0111", "", "+15555550111", true},
+	{"0044 20 7946 0018", "1", "+442079460018", true},
+	{"555.555.0111", "1", "+15555550111", true},
+	{"020 7946 0018", "44", "+442079460018", true},
+	{"010-1234-5678", "+82", "+821012345678", true},
+	{"555-555-0111", "", "", false},
+	{"+1 555", "", "", false},
+	{"+1 555 CALL NOW", "", "", false},
+	{"+0 555 555 0111", "", "", false},
+	{"+1 555 555 0111 555 555", "", "", false},
+}
+
+func TestE164(t *testing.T) {
+	for _, e := range e164Tests {
+		got, err := E164(e.phone, e.countryCode)
+		if e.valid && (err != nil || got != e.expected) {
+			t.Errorf("expected %q to be %s, got %q (%v)", e.phone, e.expected, got, err)
+		}
+		if !e.valid && err != ErrInvalidPhone {
+			t.Errorf("expected %q to be invalid, got %q", e.phone, got)
+		}
+	}
+}
+
+func TestFakeProvider(t *testing.T) {
+	server := NewFakeServer("secret")
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	var provider SMSSender = NewFakeProvider(srv.URL, "secret", "")
+
+	result, err := provider.Send("+15555550111", "See you tomorrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != StatusSent || result.Ref == "" {
+		t.Errorf("expected a sent message with a ref, got %+v", result)
+	}
+
+	result, err = provider.Send(UndeliverableNumber, "See you tomorrow")
+	if err == nil || result.Status != StatusFailed {
+		t.Errorf("expected the undeliverable number to fail, got %+v", result)
+	}
+
+	messages := server.Messages()
+	if len(messages) != 1 || messages[0].To != "+15555550111" || messages[0].Body != "See you tomorrow" {
+		t.Errorf("unexpected messages %+v", messages)
+	}
+}
+
+func TestLogSender(t *testing.T) {
+	var buf bytes.Buffer
+	var sender SMSSender = NewLogSender(log.New(&buf, "", 0))
+
+	result, err := sender.Send("+15555550111", "See you tomorrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != StatusSent {
+		t.Errorf("expected status %s, got %s", StatusSent, result.Status)
+	}
+	if !strings.Contains(buf.String(), "+15555550111: See you tomorrow") {
+		t.Errorf("expected the message in the log, got %q", buf.String())
+	}
+
+	req := httptest.NewRequest("POST", "/sms/reports", strings.NewReader(`{"ref":"log_1","status":"delivered"}`))
+	if _, err := sender.VerifyReport(req); err != ErrNoReports {
+		t.Errorf("expected no delivery reports from the log, got %v", err)
+	}
+}
+
+func TestFakeReports(t *testing.T) {
+	reports := make(chan Result, 2)
+	var provider *FakeProvider
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report, err := provider.VerifyReport(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		reports <- report
+	}))
+	defer receiver.Close()
+
+	server := NewFakeServer("secret")
+	server.ReportDelay = 0
+	srv := httptest.NewServer(server)
+	defer srv.Close()
+
+	provider = NewFakeProvider(srv.URL, "secret", receiver.URL)
+
+	delivered, err := provider.Send("+15555550111", "See you tomorrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unreachable, err := provider.Send(UnreachableNumber, "See you tomorrow")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		select {
+		case report := <-reports:
+			got[report.Ref] = report.Status
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected two delivery reports")
+		}
+	}
+
+	if got[delivered.Ref] != StatusDelivered || got[unreachable.Ref] != StatusUndelivered {
+		t.Errorf("unexpected delivery reports %v", got)
+	}
+
+	forged := httptest.NewRequest("POST", "/sms/reports", strings.NewReader(`{"ref":"x","status":"delivered"}`))
+	forged.Header.Set(SignatureHeader, Sign("wrong", []byte(`{"ref":"x","status":"delivered"}`)))
+	if _, err := provider.VerifyReport(forged); err != ErrInvalidSignature {
+		t.Errorf("expected a forged report to be refused, got %v", err)
+	}
+}
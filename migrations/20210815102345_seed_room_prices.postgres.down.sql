@@ -0,0 +1 @@
+UPDATE public.rooms SET price = 0, payment_policy = 'none', deposit_percent = 0;
\ No newline at end of file
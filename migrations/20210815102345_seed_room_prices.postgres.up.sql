@@ -0,0 +1,2 @@
+UPDATE public.rooms SET price = 15000, payment_policy = 'deposit', deposit_percent = 20 WHERE room_name = 'General''s Quarters';
+UPDATE public.rooms SET price = 22000, payment_policy = 'prepay', deposit_percent = 100 WHERE room_name = 'Major''s Suite';
\ No newline at end of file
@@ -0,0 +1 @@
+delete from cancellation_policies;
\ No newline at end of file
@@ -0,0 +1,4 @@
+INSERT INTO public.cancellation_policies (name,description,full_refund_days,partial_refund_days,partial_refund_percent,created_at,updated_at) VALUES
+	 ('flexible','Full refund up to 1 day before arrival',1,0,0,'2021-08-18 00:00:00','2021-08-18 00:00:00'),
+	 ('moderate','Full refund up to 5 days before arrival, 50% up to 1 day before arrival',5,1,50,'2021-08-18 00:00:00','2021-08-18 00:00:00'),
+	 ('strict','Full refund up to 14 days before arrival, 50% up to 7 days before arrival',14,7,50,'2021-08-18 00:00:00','2021-08-18 00:00:00');
\ No newline at end of file
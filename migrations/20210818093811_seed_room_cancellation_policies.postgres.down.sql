@@ -0,0 +1 @@
+UPDATE public.rooms SET cancellation_policy_id = null;
\ No newline at end of file
@@ -0,0 +1,2 @@
+UPDATE public.rooms SET cancellation_policy_id = (SELECT id FROM cancellation_policies WHERE name = 'moderate') WHERE room_name = 'General''s Quarters';
+UPDATE public.rooms SET cancellation_policy_id = (SELECT id FROM cancellation_policies WHERE name = 'strict') WHERE room_name = 'Major''s Suite';
\ No newline at end of file
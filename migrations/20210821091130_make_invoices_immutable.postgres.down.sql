@@ -0,0 +1,3 @@
+drop trigger if exists invoice_lines_immutable on invoice_lines;
+drop trigger if exists invoices_immutable on invoices;
+drop function if exists prevent_invoice_update();
\ No newline at end of file
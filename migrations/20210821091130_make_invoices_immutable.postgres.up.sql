@@ -0,0 +1,11 @@
+create or replace function prevent_invoice_update() returns trigger as $$
+begin
+    raise exception 'issued invoices can not be changed, issue a credit note instead';
+end;
+$$ language plpgsql;
+
+create trigger invoices_immutable before update on invoices
+    for each row execute procedure prevent_invoice_update();
+
+create trigger invoice_lines_immutable before update on invoice_lines
+    for each row execute procedure prevent_invoice_update();
\ No newline at end of file
@@ -0,0 +1 @@
+delete from tax_fee_rules;
\ No newline at end of file
@@ -0,0 +1,4 @@
+INSERT INTO public.tax_fee_rules (name,kind,basis,amount,starts_on,ends_on,created_at,updated_at) VALUES
+	 ('VAT','tax','percent',1000,NULL,NULL,'2021-08-24 00:00:00','2021-08-24 00:00:00'),
+	 ('Tourist tax','tax','per_guest_night',250,NULL,NULL,'2021-08-24 00:00:00','2021-08-24 00:00:00'),
+	 ('Cleaning fee','fee','per_stay',3000,NULL,NULL,'2021-08-24 00:00:00','2021-08-24 00:00:00');
\ No newline at end of file
@@ -0,0 +1,2 @@
+INSERT INTO public.restrictions (id,restriction_name,created_at,updated_at) VALUES
+	 (3,'Hold','2021-08-30 00:00:00','2021-08-30 00:00:00');
\ No newline at end of file
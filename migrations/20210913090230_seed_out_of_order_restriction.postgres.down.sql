@@ -0,0 +1,2 @@
+delete from room_restrictions where restriction_id = 4;
+delete from restrictions where id = 4;
\ No newline at end of file
@@ -0,0 +1,3 @@
+drop trigger if exists invoice_lines_undeletable on invoice_lines;
+drop trigger if exists invoices_undeletable on invoices;
+drop function if exists prevent_invoice_delete();
\ No newline at end of file
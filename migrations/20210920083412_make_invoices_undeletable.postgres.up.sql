@@ -0,0 +1,11 @@
+create or replace function prevent_invoice_delete() returns trigger as $$
+begin
+    raise exception 'issued invoices can not be deleted, issue a credit note instead';
+end;
+$$ language plpgsql;
+
+create trigger invoices_undeletable before delete on invoices
+    for each row execute procedure prevent_invoice_delete();
+
+create trigger invoice_lines_undeletable before delete on invoice_lines
+    for each row execute procedure prevent_invoice_delete();
\ No newline at end of file
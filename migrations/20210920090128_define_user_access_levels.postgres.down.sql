@@ -0,0 +1,3 @@
+alter table users drop constraint if exists users_access_level_check;
+
+comment on column users.access_level is null;
\ No newline at end of file
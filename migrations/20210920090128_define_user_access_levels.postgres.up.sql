@@ -0,0 +1,3 @@
+comment on column users.access_level is 'access level of the user: 1 staff, 3 admin, 5 housekeeper';
+
+alter table users add constraint users_access_level_check check (access_level in (1, 3, 5));
\ No newline at end of file
@@ -0,0 +1 @@
+UPDATE public.rooms SET max_guests = 2;
\ No newline at end of file
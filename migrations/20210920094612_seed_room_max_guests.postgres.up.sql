@@ -0,0 +1,2 @@
+UPDATE public.rooms SET max_guests = 2 WHERE room_name = 'General''s Quarters';
+UPDATE public.rooms SET max_guests = 4 WHERE room_name = 'Major''s Suite';
\ No newline at end of file